@@ -0,0 +1,133 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// annotatedSuffix replaces the extension of a document in the name of its annotated copy
+const annotatedSuffix = ".annotated.md"
+
+// annotationRx matches the annotation written after a code block by an earlier run
+var annotationRx = regexp.MustCompile(`^\s*<!-- shelldoc: .* -->\s*$`)
+
+// annotate executes the documents and writes the result of every code block and the time of the run as an HTML
+// comment after it, into a copy of the document, or into the document itself if inPlace is true
+// It returns the overall return code of the execution.
+func annotate(files []string, inPlace bool) (int, error) {
+	returnCode := returnSuccess
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		started := time.Now()
+		results, err := performInteractions(context.Background(), file)
+		if err != nil {
+			return returnError, err
+		}
+		returnCode = max(results.ReturnCode, returnCode)
+		info, err := os.Stat(file)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to annotate %s: %v", file, err)
+		}
+		data, format, err := readDocument(file)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to annotate %s: %v", file, err)
+		}
+		document, err := tokenizer.ParseDocument(data)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to annotate %s: %v", file, err)
+		}
+		annotated, count := annotateBlocks(data, document.Blocks, results.Interactions, started)
+		target := file
+		if !inPlace {
+			target = annotatedPath(file)
+		}
+		if err := writeDocument(target, annotated, format, info.Mode()); err != nil {
+			return returnError, fmt.Errorf("unable to annotate %s: %v", file, err)
+		}
+		fmt.Fprintf(console(), "SHELLDOC: annotated %d code blocks in \"%s\"\n", count, target)
+	}
+	return returnCode, nil
+}
+
+// annotatedPath returns the path of the annotated copy of a document, next to it
+func annotatedPath(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + annotatedSuffix
+}
+
+// annotateBlocks writes an annotation after every code block with executed interactions, replacing the annotation
+// of an earlier run
+// Code blocks whose interactions were not executed keep their annotations. It returns the annotated document and the
+// number of annotated code blocks.
+func annotateBlocks(data []byte, blocks []*tokenizer.Block, interactions []*tokenizer.Interaction, started time.Time) ([]byte, int) {
+	byLine := make(map[int]*tokenizer.Interaction)
+	for _, interaction := range interactions {
+		byLine[interaction.Line] = interaction
+	}
+	lines := strings.Split(string(data), "\n")
+	count := 0
+	// annotate from the end of the document, so that the line numbers of the remaining code blocks stay valid
+	for index := len(blocks) - 1; index >= 0; index-- {
+		block := blocks[index]
+		var executed []*tokenizer.Interaction
+		for _, interaction := range block.Interactions {
+			if result, ok := byLine[interaction.Line]; ok && result.ResultCode != tokenizer.NewInteraction {
+				executed = append(executed, result)
+			}
+		}
+		if len(executed) == 0 || block.EndLine < 1 || block.EndLine > len(lines) {
+			continue
+		}
+		position := block.EndLine // the line after the code block
+		if block.Fenced && position < len(lines) {
+			position++ // after the closing fence
+		}
+		annotation := fmt.Sprintf("<!-- shelldoc: %s at %s -->", blockResult(executed), started.UTC().Format(time.RFC3339))
+		if position < len(lines) && annotationRx.MatchString(lines[position]) {
+			lines[position] = annotation
+		} else {
+			lines = append(lines[:position], append([]string{annotation}, lines[position:]...)...)
+		}
+		count++
+	}
+	return []byte(strings.Join(lines, "\n")), count
+}
+
+// blockResult returns the result of a code block, which is the most severe result of its interactions
+// It is ERROR if an interaction could not be executed, FAIL if one failed, SKIPPED if all were skipped, and PASS
+// otherwise. Quarantined failures do not fail the code block, like they do not fail the run.
+func blockResult(interactions []*tokenizer.Interaction) string {
+	failed, skipped := false, 0
+	for _, interaction := range interactions {
+		switch {
+		case interaction.ResultCode == tokenizer.ResultExecutionError:
+			return "ERROR"
+		case interaction.HasFailure() && !interaction.Quarantined():
+			failed = true
+		case interaction.ResultCode == tokenizer.ResultSkipped:
+			skipped++
+		}
+	}
+	if failed {
+		return "FAIL"
+	}
+	if skipped == len(interactions) {
+		return "SKIPPED"
+	}
+	return "PASS"
+}
@@ -0,0 +1,135 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// defaultPromptPattern matches prompts like "$ ", "# " or "user@host:~/src$ " at the beginning of a line
+const defaultPromptPattern = `^\S*[$#] `
+
+// castHeader is the first line of an asciinema recording
+type castHeader struct {
+	Version int    `json:"version"`
+	Title   string `json:"title"`
+}
+
+// escapeSequenceRx matches the terminal control sequences that are removed from recorded output: CSI sequences like
+// colors and cursor movements, OSC sequences like window titles, and two character escape sequences
+var escapeSequenceRx = regexp.MustCompile("\x1b\\[[0-?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(\x07|\x1b\\\\)|\x1b[@-Z\\\\-_]")
+
+// readCast reads an asciinema recording in the version 2 or 3 format and returns its title and the terminal output
+// The input typed into the terminal is recorded as part of the output, since it is echoed by the terminal.
+func readCast(r io.Reader) (string, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", "", fmt.Errorf("unable to read the recording: %v", err)
+		}
+		return "", "", fmt.Errorf("the recording is empty")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return "", "", fmt.Errorf("invalid asciinema header: %v", err)
+	}
+	if header.Version != 2 && header.Version != 3 {
+		return "", "", fmt.Errorf("unsupported asciinema format version %d, only versions 2 and 3 are supported", header.Version)
+	}
+	var output strings.Builder
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return "", "", fmt.Errorf("invalid asciinema event in line %d: %s", line, scanner.Text())
+		}
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if code == "o" {
+			output.WriteString(data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("unable to read the recording: %v", err)
+	}
+	return header.Title, output.String(), nil
+}
+
+// cleanTerminalOutput removes the control sequences from terminal output and returns the lines as they were displayed
+// Carriage returns and backspaces overwrite the characters before them.
+func cleanTerminalOutput(output string) []string {
+	output = escapeSequenceRx.ReplaceAllString(output, "")
+	output = strings.Replace(output, "\r\n", "\n", -1)
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		var displayed []rune
+		for _, char := range line {
+			switch {
+			case char == '\r':
+				displayed = displayed[:0]
+			case char == '\b':
+				if len(displayed) > 0 {
+					displayed = displayed[:len(displayed)-1]
+				}
+			case char == '\t' || char >= ' ' && char != 0x7f:
+				displayed = append(displayed, char)
+			}
+		}
+		lines = append(lines, string(displayed))
+	}
+	for len(lines) > 0 && len(strings.TrimSpace(lines[len(lines)-1])) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// parseSession splits the lines of a terminal session into commands and their output
+// Lines that start with a prompt matching promptRx contain a command, the lines before the first prompt are ignored.
+// Prompts without a command and the final exit are dropped. The exit codes are not known, they are assumed to be zero.
+func parseSession(lines []string, promptRx *regexp.Regexp) []recordedCommand {
+	var commands []recordedCommand
+	var current *recordedCommand
+	for _, line := range lines {
+		if prompt := promptRx.FindString(line); len(prompt) > 0 {
+			current = nil
+			command := strings.TrimSpace(line[len(prompt):])
+			if len(command) > 0 && command != "exit" {
+				commands = append(commands, recordedCommand{command: command})
+				current = &commands[len(commands)-1]
+			}
+			continue
+		}
+		if current != nil {
+			current.output = append(current.output, strings.TrimRight(line, " \t"))
+		}
+	}
+	return commands
+}
+
+// importCast converts an asciinema recording into the commands of a document and returns them with the title of the
+// recording
+func importCast(r io.Reader, prompt string) (string, []recordedCommand, error) {
+	promptRx, err := regexp.Compile(prompt)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid prompt pattern \"%s\": %v", prompt, err)
+	}
+	title, output, err := readCast(r)
+	if err != nil {
+		return "", nil, err
+	}
+	commands := parseSession(cleanTerminalOutput(output), promptRx)
+	if len(commands) == 0 {
+		return "", nil, fmt.Errorf("no commands found in the recording, check the prompt pattern \"%s\"", prompt)
+	}
+	return title, commands, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+// cacheDirectory returns the directory the results are cached in, $SHELLDOC_CACHE if it is set
+func cacheDirectory() (string, error) {
+	if directory := os.Getenv("SHELLDOC_CACHE"); len(directory) > 0 {
+		return directory, nil
+	}
+	directory, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the cache directory, set $SHELLDOC_CACHE: %v", err)
+	}
+	return filepath.Join(directory, "shelldoc"), nil
+}
+
+// buildVersion identifies the build of shelldoc, so that results cached by other builds are not used
+// Binaries built from a module or a clean checkout are identified by their version, others by a hash of the binary.
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version := info.Main.Version
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				version += " " + setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if version != "(devel)" && !modified {
+			return version
+		}
+	}
+	executable, err := os.Executable()
+	if err == nil {
+		var file *os.File
+		if file, err = os.Open(executable); err == nil {
+			defer file.Close()
+			hash := sha256.New()
+			if _, err = io.Copy(hash, file); err == nil {
+				return hex.EncodeToString(hash.Sum(nil))
+			}
+		}
+	}
+	log.Printf("Unable to identify the build of shelldoc, cached results may be outdated: %v", err)
+	return "unknown"
+}
+
+// defaultStateFile is the file --incremental records the code blocks that passed in, if no file is specified
+const defaultStateFile = ".shelldoc-state.json"
+
+// environmentFingerprint describes the backend and the search path, which decide which programs the commands execute
+func environmentFingerprint() string {
+	return fmt.Sprintf("%q %q", options.backend, os.Getenv("PATH"))
+}
+
+// incrementalState returns the state used by --incremental, or nil if it is not enabled
+func incrementalState() *runner.Incremental {
+	if len(options.incremental) == 0 {
+		return nil
+	}
+	return &runner.Incremental{File: options.incremental, Version: buildVersion(), Fingerprint: environmentFingerprint()}
+}
+
+// resultCache returns the cache used by --cached, or nil if it is not enabled
+func resultCache() (*runner.Cache, error) {
+	if !options.cached {
+		return nil, nil
+	}
+	directory, err := cacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return &runner.Cache{
+		Directory:   directory,
+		Version:     buildVersion(),
+		Fingerprint: environmentFingerprint(),
+	}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultChangedRef is the base of the comparison if --changed-only is specified without a ref
+// Comparing against HEAD finds the documents modified in the working tree or the index, as needed by pre-commit hooks.
+const defaultChangedRef = "HEAD"
+
+// changedDocuments asks git for the Markdown documents that differ from the merge base of ref and HEAD
+// The working tree is compared, so both committed and uncommitted changes are found. Deleted documents are not
+// returned. The paths are relative to the current directory, documents outside of it are ignored.
+func changedDocuments(ref string) ([]string, error) {
+	base, err := git("merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	output, err := git("diff", "--name-only", "--relative", "--diff-filter=d", strings.TrimSpace(base), "--")
+	if err != nil {
+		return nil, err
+	}
+	var documents []string
+	for _, file := range strings.Split(output, "\n") {
+		if isDocument(file) {
+			documents = append(documents, file)
+		}
+	}
+	return documents, nil
+}
+
+// selectChanged returns the files that are in changed, or all of changed if no files were specified
+func selectChanged(files, changed []string) []string {
+	if len(files) == 0 {
+		return changed
+	}
+	isChanged := make(map[string]bool)
+	for _, file := range changed {
+		isChanged[filepath.Clean(file)] = true
+	}
+	var selected []string
+	for _, file := range files {
+		if isChanged[filepath.Clean(file)] {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// isDocument returns true if the file has one of the Markdown file extensions
+func isDocument(file string) bool {
+	for _, extension := range documentExtensions {
+		if strings.HasSuffix(file, "."+extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// git executes a git command in the current directory and returns its output
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
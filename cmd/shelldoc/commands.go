@@ -0,0 +1,653 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/plugin"
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// exitCode is the return code of the program if the command did not return an error
+var exitCode = returnSuccess
+
+// rootCommand creates the shelldoc command and its subcommands
+// Without a subcommand, shelldoc executes the documents like the run command does.
+func rootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "shelldoc [flags] FILE...",
+		Short: "Test Unix shell commands in Markdown documentation",
+		Long: `shelldoc parses Markdown files, detects the code blocks in them, executes the shell
+commands in them and compares their output with the content of the code block.`,
+		Args:              cobra.ArbitraryArgs,
+		SilenceUsage:      true,
+		PersistentPreRunE: initialize,
+		RunE:              runCommand,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVarP(&options.shell, "shell", "s", "", "The shell to invoke (default: $SHELL).")
+	flags.StringVar(&options.shellCmd, "shell-cmd", "", "The command line that launches the shell, with arguments or wrappers, overrides --shell.")
+	flags.StringVar(&options.backend, "backend", "", "Execute the commands using the backend plugin shelldoc-backend-NAME instead of a local shell.")
+	flags.BoolVarP(&options.verbose, "verbose", "v", false, "Enable diagnostic log output.")
+	flags.StringVarP(&options.format, "format", "f", formatConsole, fmt.Sprintf("The output format (one of %s).", strings.Join(formats, ", ")))
+	flags.StringVarP(&options.configFile, "config", "c", defaultConfigFile, "The configuration file to load.")
+	flags.StringVarP(&options.profile, "profile", "p", "", "The profile in the configuration file to apply.")
+	flags.StringSliceVar(&options.languages, "languages", nil, "Only execute fenced code blocks in these languages (default: all).")
+	flags.StringVar(&options.encoding, "encoding", "", "The encoding of the documents, like latin1 or utf-16le (default: UTF-8, or UTF-16 if the document starts with a byte order mark).")
+	flags.StringVar(&options.locale, "locale", tokenizer.DefaultLocale, fmt.Sprintf("The language of the results and summaries, like de or $LANG (one of %s).", strings.Join(tokenizer.Locales(), ", ")))
+	flags.BoolVar(&options.keepCR, "keep-carriage-returns", false, "Keep Windows line breaks in the documents and carriage returns at the end of lines of output, instead of normalizing them to Unix line breaks.")
+	flags.StringSliceVar(&options.excludes, "exclude", nil, "Skip documents matching these file name patterns.")
+	flags.StringVarP(&options.run, "run", "r", "", "Only execute interactions whose caption, command or heading matches this regular expression.")
+	flags.BoolVar(&options.strict, "strict", false, "Fail if interactions have neither a caption nor a heading, or share their name with another interaction.")
+	flags.BoolVar(&options.coverage, "coverage", false, "Fail if shell code blocks are neither executed nor marked with shelldocskip or shelldocnotest.")
+	flags.StringVar(&options.cpuProfile, "cpuprofile", "", "Write a CPU profile to this file, for go tool pprof.")
+	flags.StringVar(&options.memProfile, "memprofile", "", "Write a memory profile to this file when the command finished, for go tool pprof.")
+	addRunFlags(root.Flags())
+
+	runCmd := &cobra.Command{
+		Use:   "run [flags] FILE...",
+		Short: "Execute the documents and report the results",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(options.changedOnly) > 0 {
+				return nil // the changed documents are executed if none are specified
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: runCommand,
+	}
+	addRunFlags(runCmd.Flags())
+
+	listCmd := &cobra.Command{
+		Use:   "list [flags] FILE...",
+		Short: "List the interactions in the documents without executing them",
+		Long: `List the interactions in the documents, with their location, heading, options and command,
+without executing them. The --run and --languages filters apply. Supported formats are console and json.
+With --graph, the execution order of the code blocks with commands is written as a Graphviz DOT or a
+Mermaid graph instead, including the setup code blocks that code blocks executed alone depend on.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: listCommand,
+	}
+	listCmd.Flags().StringVar(&options.graph, "graph", "", fmt.Sprintf("Write the execution order of the code blocks as a graph (one of %s).", strings.Join(graphFormats, ", ")))
+
+	extractCmd := &cobra.Command{
+		Use:   "extract [flags] FILE...",
+		Short: "Write the commands in the documents as a shell script",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  extractCommand,
+	}
+
+	updateCmd := &cobra.Command{
+		Use:   "update [flags] FILE...",
+		Short: "Execute the documents and replace mismatching expected responses with the actual output",
+		Long: `Execute the documents and replace the expected responses of interactions that do not match
+with the actual output of the commands. Interactions that fail because of their exit code, or that
+use an ellipsis or patterns, are not modified. Review the changes before committing them.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: updateCommand,
+	}
+
+	annotateCmd := &cobra.Command{
+		Use:   "annotate [flags] FILE...",
+		Short: "Execute the documents and record the result of every code block as an HTML comment after it",
+		Long: `Execute the documents and write the result of every code block and the time of the run as an HTML
+comment after it, like <!-- shelldoc: PASS at 2026-10-15T10:20:00Z -->, so that the repository records
+when each example was last verified. The annotations of earlier runs are replaced. The annotated
+copy is written next to the document, like README.annotated.md, or into the document with --in-place.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: annotateCommand,
+	}
+	annotateCmd.Flags().BoolVar(&options.inPlace, "in-place", false, "Annotate the documents themselves instead of writing annotated copies.")
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [flags] FILE...",
+		Short: "Execute the documents and show the changes update would make as a unified diff",
+		Long: `Execute the documents and write the changes update would make to the expected responses as a
+unified diff, without modifying the documents. The diff can be reviewed, or applied using patch -p0.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: diffCommand,
+	}
+
+	stepCmd := &cobra.Command{
+		Use:   "step [flags] FILE",
+		Short: "Execute the interactions in a document one at a time",
+		Long: `Execute the interactions in a document one at a time. Each interaction is shown before it is
+executed, and can be run, edited or skipped. After it ran, the actual output and the result are shown,
+and the output of a mismatching interaction can be accepted as the new expected response. The edited
+commands and accepted responses are written back to the document if confirmed at the end.`,
+		Args: cobra.ExactArgs(1),
+		RunE: stepCommand,
+	}
+
+	lintCmd := &cobra.Command{
+		Use:   "lint [flags] FILE...",
+		Short: "Check the documents for mistakes without executing them",
+		Long: `Check the documents for mistakes without executing them, like invalid or unknown options in the
+info strings of fenced code blocks, and lines in code blocks that are ignored because they precede
+the first command. The problems are reported as FILE:LINE: MESSAGE.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: lintCommand,
+	}
+
+	fmtCmd := &cobra.Command{
+		Use:   "fmt [flags] FILE...",
+		Short: "Rewrite the code blocks in the documents in canonical form",
+		Long: `Rewrite the code blocks in the documents in canonical form: commands use the $ prompt followed by
+a single space, trailing whitespace is removed from commands and expected responses, and the shelldoc
+options in the info strings of fenced code blocks are sorted. With --check, the documents are not
+modified, and the command fails if one of them is not formatted canonically.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: fmtCommand,
+	}
+	fmtCmd.Flags().BoolVar(&options.check, "check", false, "List the documents that are not formatted canonically and fail, instead of rewriting them.")
+
+	initCmd := &cobra.Command{
+		Use:   "init [flags] [DIRECTORY]",
+		Short: "Create an example document and a starter configuration file",
+		Long: `Create an example document that demonstrates commands, expected output and the shelldoc options,
+and a starter .shelldoc.yaml configuration file, in the specified or the current directory.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: initCommand,
+	}
+	initCmd.Flags().BoolVar(&options.force, "force", false, "Overwrite existing files.")
+	initCmd.Flags().StringVar(&options.exampleFile, "document", defaultExampleFile, "The name of the example document.")
+
+	pluginsCmd := &cobra.Command{
+		Use:   "plugins",
+		Short: "List the plugins found in $PATH",
+		Long: `List the reporter, matcher and backend plugins found in $PATH. Plugins are executables named
+shelldoc-KIND-NAME. Reporters are used with --report NAME=FILE, matchers with the shelldocmatcher=NAME
+option of a code block, and backends with --backend NAME.`,
+		Args: cobra.NoArgs,
+		RunE: pluginsCommand,
+	}
+
+	serveCmd := &cobra.Command{
+		Use:   "serve [flags]",
+		Short: "Execute documents on request through an HTTP API, with a web UI showing the results",
+		Long: `Run an HTTP server that executes documents on request. POST /api/runs with {"paths": [...]}
+executes the documents at these paths on the server, directories are searched for Markdown documents,
+or with {"document": "..."} the submitted document. GET /api/runs/ID returns the status and the results
+of a run, and GET /api/runs lists all runs. The web UI at / shows the results of every document. The
+server executes the commands it is sent, so only expose it to trusted users.`,
+		Args: cobra.NoArgs,
+		RunE: serveCommand,
+	}
+	serveCmd.Flags().StringVar(&options.listen, "listen", defaultListenAddress, "The address the server listens on.")
+	serveCmd.Flags().StringVar(&options.token, "token", "", "The token runs are submitted with (default: generated and printed at startup).")
+	serveCmd.Flags().BoolVar(&options.noSkips, "no-skips", false, "Treat skipped interactions as failures.")
+	serveCmd.Flags().DurationVar(&options.timeout, "timeout", 0, "The time a command may take before it is terminated, for example 30s (default: no timeout).")
+	serveCmd.Flags().DurationVar(&options.fileTimeout, "file-timeout", 0, "The time all commands in a document may take, for example 10m (default: no timeout).")
+
+	lspCmd := &cobra.Command{
+		Use:   "lsp",
+		Short: "Run a language server for editors on standard input and output",
+		Long: `Run a Language Server Protocol server on standard input and output, for editors that support it.
+The problems found by lint are reported as diagnostics while editing. Code lenses execute the
+document or a single code block, after which failing interactions are reported as diagnostics, and
+hovering over a command shows its actual output.`,
+		Args: cobra.NoArgs,
+		RunE: lspCommand,
+	}
+	lspCmd.Flags().DurationVar(&options.timeout, "timeout", 0, "The time a command may take before it is terminated, for example 30s (default: no timeout).")
+
+	recordCmd := &cobra.Command{
+		Use:   "record [flags] [FILE]",
+		Short: "Record a shell session as a document that can be tested",
+		Long: `Start a shell and record the commands entered, one per line, with their output, until the input ends
+or exit is entered. The session is written as a Markdown document to FILE, or to standard output,
+with every command on a $ line followed by its output as the expected response. Failing commands are
+placed in code blocks that expect their exit code. The commands are not executed on a terminal, so
+full screen programs like editors cannot be recorded.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: recordCommand,
+	}
+	recordCmd.Flags().StringVar(&options.title, "title", defaultRecordingTitle, "The title of the recorded document.")
+	recordCmd.Flags().BoolVar(&options.force, "force", false, "Overwrite an existing file.")
+
+	importCmd := &cobra.Command{
+		Use:   "import [flags] CAST [FILE]",
+		Short: "Convert an asciinema recording into a document that can be tested",
+		Long: `Convert an asciinema recording (a .cast file) into a Markdown document, written to FILE or to
+standard output. Lines that start with a prompt matching --prompt contain the commands, the lines
+after them are their expected output. Colors and other terminal control sequences are removed. The
+recording does not contain the exit codes, review the document and add the shelldocexitcode option
+to commands that are expected to fail.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: importCommand,
+	}
+	importCmd.Flags().StringVar(&options.title, "title", defaultRecordingTitle, "The title of the document (default: the title of the recording).")
+	importCmd.Flags().StringVar(&options.prompt, "prompt", defaultPromptPattern, "The regular expression matching the prompts before the commands.")
+	importCmd.Flags().BoolVar(&options.force, "force", false, "Overwrite an existing file.")
+
+	selfcheckCmd := &cobra.Command{
+		Use:   "selfcheck [flags]",
+		Short: "Verify that shelldoc works with the local installation and shell",
+		Long: `Execute a set of sample documents that are built into shelldoc, covering its options and built-in
+matchers, with the configured shell. If they do not all pass, the shell or the environment is not
+compatible with shelldoc, and the failing interactions show which features are affected.`,
+		Args: cobra.NoArgs,
+		RunE: selfcheckCommand,
+	}
+
+	mergeCmd := &cobra.Command{
+		Use:   "merge FILE...",
+		Short: "Combine the result files of several runs into one report",
+		Long: `Combine result files written with --report json=FILE or --report junit=FILE, for example by sharded
+or matrix CI jobs, into one report in the same format, written to standard output. Documents and
+interactions that appear in several files are reported once, with their most severe result, and the
+totals are computed again. All files need to be in the same format.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: mergeCommand,
+	}
+
+	compareCmd := &cobra.Command{
+		Use:   "compare BEFORE AFTER",
+		Short: "List the interactions whose results differ between two result files",
+		Long: `List the interactions whose results differ between two result files written with --report json=FILE,
+for example by the runs before and after a change. Interactions that fail in the second run but did
+not fail in the first are regressions, which fail the command, interactions that no longer fail are
+fixes. Documents and interactions are identified like by merge.`,
+		Args: cobra.ExactArgs(2),
+		RunE: compareCommand,
+	}
+
+	historyCmd := &cobra.Command{
+		Use:   "history [flags] QUERY [FILE[:LINE]]",
+		Short: "Query the results of the runs recorded with --history",
+		Long: fmt.Sprintf(`Query the results of the runs recorded with --history, optionally restricted to a document or the
+interactions at a line of it. The queries are:
+
+  %s       the number of failed interactions in every run, and where they are
+  %s      the average duration of every interaction, the slowest first
+  %s  the run and commit since which the interactions that fail in their latest run fail`, historyFailures, historyDurations, historyFirstFailure),
+		Args: cobra.RangeArgs(1, 2),
+		RunE: historyCommand,
+	}
+	historyCmd.Flags().StringVar(&options.history, "history", "", "The history file to query (default: "+defaultHistoryFile+").")
+
+	root.AddCommand(runCmd, listCmd, extractCmd, updateCmd, annotateCmd, diffCmd, stepCmd, lintCmd, fmtCmd, initCmd, pluginsCmd, serveCmd, lspCmd, recordCmd, importCmd, selfcheckCmd, mergeCmd, compareCmd, historyCmd)
+	for _, cmd := range []*cobra.Command{root, runCmd, listCmd, extractCmd, updateCmd, annotateCmd, diffCmd, stepCmd, lintCmd, fmtCmd} {
+		registerCompletions(cmd)
+	}
+	return root
+}
+
+// addRunFlags adds the flags that control the execution of the documents
+// They are needed for both the root command and the run command.
+func addRunFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&options.quiet, "quiet", "q", false, "Print nothing, neither the progress output nor notes or errors, only the exit code, the reports and the output formats tell the result.")
+	flags.StringArrayVar(&options.reports, "report", nil, fmt.Sprintf("Also write the results to a file, specified as FORMAT=FILE (FORMAT is one of %s), can be repeated.", strings.Join(reportFormats, ", ")))
+	flags.StringArrayVar(&options.webhooks, "webhook", nil, fmt.Sprintf("Notify a webhook when the run fails, specified as KIND=URL (KIND is one of %s), can be repeated.", strings.Join(webhookKinds, ", ")))
+	flags.StringVar(&options.github, "github", "", "Post the results to GitHub as a commit status or a check run with annotations (one of status, check), the token is read from $GITHUB_TOKEN.")
+	flags.StringVar(&options.githubRepo, "github-repo", "", "The GitHub repository for --github, as OWNER/NAME (default: $GITHUB_REPOSITORY).")
+	flags.StringVar(&options.githubSHA, "github-sha", "", "The commit for --github (default: $GITHUB_SHA).")
+	flags.StringVar(&options.transcripts, "transcripts", "", "Write a transcript of the shell session of every document to this directory.")
+	flags.StringVar(&options.snapshotDir, "snapshot-dir", "", "Write the actual output of every interaction to a file in this directory.")
+	flags.StringVar(&options.captions, "caption-template", "", fmt.Sprintf("Describe interactions without a caption in the progress output using this template, with the placeholders {%s}.", strings.Join(tokenizer.CaptionPlaceholders, "}, {")))
+	flags.StringVar(&options.color, "color", colorAuto, "Highlight the differing characters of mismatched lines with colors, instead of marking them with carets (one of auto, always, never).")
+	flags.StringVar(&options.otelEndpoint, "otel-endpoint", "", "Send OpenTelemetry traces to this OTLP/HTTP endpoint URL.")
+	flags.IntVar(&options.atLine, "at-line", 0, "Only execute the code block at this line of the document, after the setup code blocks before it.")
+	flags.StringVar(&options.failFast, "fail-fast", "", "Stop after the first failure, in the current document or the whole run (one of document, run).")
+	flags.Lookup("fail-fast").NoOptDefVal = failFastDocument
+	flags.BoolVarP(&options.watch, "watch", "w", false, "Watch the documents and execute them again whenever they change.")
+	flags.StringVar(&options.shard, "shard", "", "Only execute the documents in this shard, specified as INDEX/TOTAL like 2/5, to split the documents across parallel CI jobs.")
+	flags.StringVar(&options.shuffle, "shuffle", shuffleOff, "Execute the documents in random order (one of off, on or an integer seed).")
+	flags.Lookup("shuffle").NoOptDefVal = shuffleOn
+	flags.IntVar(&options.count, "count", 1, "Execute the whole run this many times and report flaky interactions.")
+	flags.IntVar(&options.maxFailures, "max-failures", 0, "Abort the run after this many failed interactions (default: unlimited).")
+	flags.BoolVar(&options.noSkips, "no-skips", false, "Treat skipped interactions as failures.")
+	flags.IntVar(&options.maxOutput, "max-output", shell.DefaultMaxOutput, "The number of bytes of the output of a command that is kept, the first and last lines of longer output are kept.")
+	flags.DurationVar(&options.inputGrace, "input-grace", shell.DefaultInputGrace, "The time a command may wait for input before it fails, its standard input is empty (0 disables it, only supported on Linux).")
+	flags.StringVar(&options.spillOutput, "spill-output", "", "Write the whole output of commands that exceed --max-output to files in this directory.")
+	flags.BoolVar(&options.failOnLimit, "fail-on-output-limit", false, "Terminate commands whose output exceeds --max-output and fail them, instead of keeping the first and last lines.")
+	flags.StringVar(&options.incremental, "incremental", "", "Only execute the code blocks that changed since they passed, recorded in this file (default: "+defaultStateFile+").")
+	flags.Lookup("incremental").NoOptDefVal = defaultStateFile
+	flags.StringVar(&options.history, "history", "", "Append the results of the run to this history file, for the history command (default: "+defaultHistoryFile+").")
+	flags.Lookup("history").NoOptDefVal = defaultHistoryFile
+	flags.BoolVar(&options.cached, "cached", false, "Do not execute documents again that passed before and did not change, report their cached results.")
+	flags.BoolVar(&options.reuse, "reuse-sessions", false, "Reuse the shell of a finished document for the next one, after resetting its working directory and environment.")
+	flags.StringVar(&options.leaks, "check-leaks", "", "Report the environment variables, directory changes and background jobs a document leaks into the shell (warn), or also fail it (fail).")
+	flags.Lookup("check-leaks").NoOptDefVal = leaksWarn
+	flags.BoolVar(&options.shareSession, "share-session", false, "Execute the documents in one shell session in the given order, every document continues where the one before it ended.")
+	flags.DurationVar(&options.timeout, "timeout", 0, "The time a command may take before it is terminated, for example 30s (default: no timeout).")
+	flags.DurationVar(&options.fileTimeout, "file-timeout", 0, "The time all commands in a document may take, for example 10m (default: no timeout).")
+	flags.DurationVar(&options.delay, "delay", 0, "Pause between the commands of a document, for example 500ms.")
+	flags.BoolVar(&options.reproducible, "deterministic", false, "Set SOURCE_DATE_EPOCH, TZ, COLUMNS, LINES and fixed HOME and TMPDIR directories, so that the output of the commands is reproducible.")
+	flags.IntVar(&options.columns, "columns", 0, "The terminal width set in COLUMNS for the commands, for tools that wrap their output to it.")
+	flags.IntVar(&options.lines, "lines", 0, "The terminal height set in LINES for the commands.")
+	flags.BoolVar(&options.faketime, "faketime", false, "Preload libfaketime in deterministic mode, so that the clock of the commands is fixed.")
+	flags.StringArrayVar(&options.toolVersions, "tool-version", nil, "The version of a tool for the expected responses that depend on it, specified as NAME=VERSION, can be repeated (default: probed with --version).")
+	flags.StringArrayVar(&options.variables, "var", nil, "Override a variable defined in the front matter of the documents, specified as NAME=VALUE, can be repeated.")
+	flags.StringVar(&options.changedOnly, "changed-only", "", "Only execute the documents that differ from this git ref (default: HEAD), all changed documents if none are specified.")
+	flags.Lookup("changed-only").NoOptDefVal = defaultChangedRef
+}
+
+// initialize sets up logging and applies the configuration file before any command is executed
+func initialize(cmd *cobra.Command, args []string) error {
+	cmd.SilenceErrors = options.quiet
+	initializeLogging()
+	if err := startProfiling(); err != nil {
+		return err
+	}
+	config, err := loadConfig(options.configFile, cmd.Flags().Changed("config"))
+	if err != nil {
+		return err
+	}
+	if config, err = config.withProfile(options.profile); err != nil {
+		return err
+	}
+	applyConfig(config, cmd.Flags())
+	supportedFormats := formats
+	if cmd.Name() == "list" {
+		supportedFormats = listFormats
+	}
+	if err := validateFormat(options.format, supportedFormats); err != nil {
+		return err
+	}
+	for _, spec := range options.reports {
+		if _, err := parseReport(spec); err != nil {
+			return err
+		}
+	}
+	if _, err := toolVersions(); err != nil {
+		return err
+	}
+	if _, err := documentVariables(); err != nil {
+		return err
+	}
+	if err := tokenizer.ValidateCaptionTemplate(options.captions); err != nil {
+		return err
+	}
+	if err := tokenizer.SetLocale(options.locale); err != nil {
+		return err
+	}
+	if err := applyDeterministic(); err != nil {
+		return err
+	}
+	if err := applyTerminalSize(); err != nil {
+		return err
+	}
+	for _, spec := range options.webhooks {
+		if _, err := parseWebhook(spec); err != nil {
+			return err
+		}
+	}
+	if len(options.github) > 0 {
+		if _, err := newGitHubReporter(options.github, options.githubRepo, options.githubSHA); err != nil {
+			return err
+		}
+	}
+	return compileRunPattern(options.run)
+}
+
+// runCommand executes the documents, repeatedly if --watch is specified
+func runCommand(cmd *cobra.Command, args []string) error {
+	if len(options.failFast) > 0 && options.failFast != failFastDocument && options.failFast != failFastRun {
+		return fmt.Errorf("invalid value \"%s\" for --fail-fast, use %s or %s", options.failFast, failFastDocument, failFastRun)
+	}
+	if len(options.leaks) > 0 && options.leaks != leaksWarn && options.leaks != leaksFail {
+		return fmt.Errorf("invalid value \"%s\" for --check-leaks, use %s or %s", options.leaks, leaksWarn, leaksFail)
+	}
+	if options.color != colorAuto && options.color != colorAlways && options.color != colorNever {
+		return fmt.Errorf("invalid value \"%s\" for --color, use %s, %s or %s", options.color, colorAuto, colorAlways, colorNever)
+	}
+	if options.atLine < 0 || (options.atLine > 0 && len(args) != 1) {
+		return fmt.Errorf("--at-line needs a positive line number and exactly one document")
+	}
+	if options.shareSession && (options.reuse || options.cached || len(options.incremental) > 0 || options.shuffle != shuffleOff || len(options.shard) > 0) {
+		return fmt.Errorf("--share-session depends on the order of the documents and cannot be combined with --reuse-sessions, --cached, --incremental, --shuffle or --shard")
+	}
+	files := args
+	if len(options.shard) > 0 {
+		index, total, err := parseShard(options.shard)
+		if err != nil {
+			return err
+		}
+		files = shardDocuments(files, index, total)
+		if len(files) == 0 {
+			fmt.Fprintf(console(), "SHELLDOC: no documents in shard %s\n", options.shard)
+			return nil
+		}
+	}
+	if len(options.changedOnly) > 0 {
+		changed, err := changedDocuments(options.changedOnly)
+		if err != nil {
+			return err
+		}
+		files = selectChanged(files, changed)
+		if len(files) == 0 {
+			fmt.Fprintf(console(), "SHELLDOC: no documents changed since %s\n", options.changedOnly)
+			return nil
+		}
+	}
+	if options.shuffle != shuffleOff {
+		seed, err := shuffleSeed(options.shuffle)
+		if err != nil {
+			return err
+		}
+		files = shuffle(files, seed)
+		message := fmt.Sprintf("SHELLDOC: shuffling the documents, use --shuffle=%d to repeat this order\n", seed)
+		if options.format == formatConsole {
+			fmt.Fprint(console(), message)
+		} else if !options.quiet {
+			fmt.Fprint(os.Stderr, message) // keep the seed visible in machine readable formats
+		}
+	}
+	if err := checkShell(); err != nil {
+		return err
+	}
+	shutdownTracing, err := initializeTracing(options.otelEndpoint)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+	if options.watch {
+		return watch(files)
+	}
+	exitCode = run(files)
+	return nil
+}
+
+// listCommand lists the interactions in the documents
+func listCommand(cmd *cobra.Command, args []string) error {
+	if len(options.graph) > 0 {
+		return writeGraph(os.Stdout, options.graph, args)
+	}
+	return list(os.Stdout, options.format, args)
+}
+
+// extractCommand writes the commands in the documents as a shell script
+func extractCommand(cmd *cobra.Command, args []string) error {
+	return extract(os.Stdout, args)
+}
+
+// updateCommand executes the documents and updates the expected responses
+func updateCommand(cmd *cobra.Command, args []string) error {
+	code, err := update(args)
+	exitCode = code
+	return err
+}
+
+// annotateCommand executes the documents and records the results of the code blocks in them
+func annotateCommand(cmd *cobra.Command, args []string) error {
+	code, err := annotate(args, options.inPlace)
+	exitCode = code
+	return err
+}
+
+// diffCommand executes the documents and shows the differences between the expected responses and the output
+func diffCommand(cmd *cobra.Command, args []string) error {
+	options.quiet = true
+	code, err := diff(os.Stdout, args)
+	exitCode = code
+	return err
+}
+
+// stepCommand executes the interactions in a document interactively
+func stepCommand(cmd *cobra.Command, args []string) error {
+	return step(os.Stdin, os.Stdout, args[0])
+}
+
+// lintCommand checks the documents and fails if problems were found
+func lintCommand(cmd *cobra.Command, args []string) error {
+	problems, err := lint(os.Stdout, args)
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		exitCode = returnFailure
+	}
+	return nil
+}
+
+// fmtCommand formats the documents, or checks if they are formatted canonically
+func fmtCommand(cmd *cobra.Command, args []string) error {
+	changed, err := formatDocuments(args, options.check)
+	if err != nil {
+		return err
+	}
+	if options.check && len(changed) > 0 {
+		for _, file := range changed {
+			fmt.Println(file)
+		}
+		exitCode = returnFailure
+	}
+	return nil
+}
+
+// lspCommand runs the language server until the editor disconnects
+func lspCommand(cmd *cobra.Command, args []string) error {
+	options.quiet = true // standard output is used for the protocol
+	return newLSPServer(os.Stdin, os.Stdout).run()
+}
+
+// serveCommand runs the HTTP server
+func serveCommand(cmd *cobra.Command, args []string) error {
+	return serve(options.listen, options.token)
+}
+
+// pluginsCommand lists the plugins of every kind
+func pluginsCommand(cmd *cobra.Command, args []string) error {
+	for _, kind := range []string{plugin.KindReporter, plugin.KindMatcher, plugin.KindBackend} {
+		for _, name := range plugin.List(kind) {
+			fmt.Printf("%s\t%s\n", kind, name)
+		}
+	}
+	return nil
+}
+
+// historyCommand answers a query about the recorded runs
+func historyCommand(cmd *cobra.Command, args []string) error {
+	path := options.history
+	if len(path) == 0 {
+		path = defaultHistoryFile // the flag is shared with the run flags, it cannot have a default value
+	}
+	var spec string
+	if len(args) > 1 {
+		spec = args[1]
+	}
+	return queryHistory(os.Stdout, path, args[0], spec)
+}
+
+// mergeCommand combines the result files and writes them to standard output
+func mergeCommand(cmd *cobra.Command, args []string) error {
+	return mergeReports(os.Stdout, args)
+}
+
+// compareCommand lists the changes between two result files, it fails if interactions regressed
+func compareCommand(cmd *cobra.Command, args []string) error {
+	code, err := compareReports(os.Stdout, args[0], args[1])
+	exitCode = code
+	return err
+}
+
+// selfcheckCommand executes the built-in sample documents to verify the installation
+func selfcheckCommand(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results, err := selfcheck(ctx)
+	if err != nil {
+		return err
+	}
+	if results.ReturnCode != returnSuccess {
+		fmt.Println("SHELLDOC: self check failed, the shell or the environment is not fully compatible")
+		exitCode = results.ReturnCode
+		return nil
+	}
+	fmt.Println("SHELLDOC: self check passed")
+	return nil
+}
+
+// recordCommand records a shell session and writes it as a document
+func recordCommand(cmd *cobra.Command, args []string) error {
+	var file string
+	if len(args) > 0 {
+		file = args[0]
+		if _, err := os.Stat(file); err == nil && !options.force {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", file) // before the session, not after it
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	commands, err := record(ctx, os.Stdin, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if err := saveRecording(file, options.title, commands); err != nil {
+		return err
+	}
+	if len(file) > 0 && file != "-" {
+		fmt.Fprintf(os.Stderr, "SHELLDOC: recorded %d commands in %s\n", len(commands), file)
+	}
+	return nil
+}
+
+// importCommand converts an asciinema recording into a document
+func importCommand(cmd *cobra.Command, args []string) error {
+	var file string
+	if len(args) > 1 {
+		file = args[1]
+		if _, err := os.Stat(file); err == nil && !options.force {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", file)
+		}
+	}
+	cast, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", args[0], err)
+	}
+	defer cast.Close()
+	title, commands, err := importCast(cast, options.prompt)
+	if err != nil {
+		return fmt.Errorf("unable to import %s: %v", args[0], err)
+	}
+	if len(title) == 0 || cmd.Flags().Changed("title") {
+		title = options.title
+	}
+	return saveRecording(file, title, commands)
+}
+
+// initCommand creates the example document and the configuration file
+func initCommand(cmd *cobra.Command, args []string) error {
+	directory := "."
+	if len(args) > 0 {
+		directory = args[0]
+	}
+	created, err := scaffold(directory, options.exampleFile, options.force)
+	if err != nil {
+		return err
+	}
+	for _, path := range created {
+		fmt.Printf("SHELLDOC: created %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// readResultFile reads a result file written with --report json=FILE
+func readResultFile(file string) (runner.Result, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return runner.Result{}, fmt.Errorf("unable to read result file: %v", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return runner.Result{}, fmt.Errorf("%s: only JSON result files can be compared, not JUnit reports", file)
+	}
+	result, err := runner.ReadJSON(bytes.NewReader(data))
+	if err != nil {
+		return runner.Result{}, fmt.Errorf("%s: %v", file, err)
+	}
+	return result, nil
+}
+
+// compareReports lists the interactions whose results differ between two result files, and returns returnFailure if
+// interactions regressed
+func compareReports(w io.Writer, before, after string) (int, error) {
+	earlier, err := readResultFile(before)
+	if err != nil {
+		return returnError, err
+	}
+	later, err := readResultFile(after)
+	if err != nil {
+		return returnError, err
+	}
+	changes := runner.Compare(earlier, later)
+	regressions, fixes := 0, 0
+	for _, change := range changes {
+		label := "CHANGED"
+		if change.Regression() {
+			label = "REGRESSION"
+			regressions++
+		} else if change.Fix() {
+			label = "FIXED"
+			fixes++
+		}
+		interaction := change.After
+		if interaction == nil {
+			interaction = change.Before
+		}
+		fmt.Fprintf(w, " %s: %s:%d: %s: %s -> %s\n", label, change.File, interaction.Line, interaction.Name(), describeResult(change.Before, "new"), describeResult(change.After, "removed"))
+	}
+	fmt.Fprintf(w, "SHELLDOC: %d interactions changed, %d regressions, %d fixes\n", len(changes), regressions, fixes)
+	if regressions > 0 {
+		return returnFailure, nil
+	}
+	return returnSuccess, nil
+}
+
+// describeResult returns the name of the result code of an interaction, or missing if the interaction is nil
+func describeResult(interaction *tokenizer.Interaction, missing string) string {
+	if interaction == nil {
+		return missing
+	}
+	return interaction.ResultCode.String()
+}
@@ -0,0 +1,118 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/spf13/cobra"
+)
+
+// documentExtensions are the file extensions offered when completing document arguments
+var documentExtensions = []string{"md", "markdown"}
+
+// registerCompletions adds the dynamic completions for the arguments and flags of a command
+// The completion command provided by cobra generates the scripts for bash, zsh, fish and PowerShell, which
+// call back into shelldoc for these. Completions for inherited flags are registered with the parent command
+// already, registering them again fails harmlessly.
+func registerCompletions(cmd *cobra.Command) {
+	cmd.ValidArgsFunction = completeDocuments
+	fixed := map[string][]string{
+		"format":      formats,
+		"fail-fast":   {failFastDocument, failFastRun},
+		"check-leaks": {leaksWarn, leaksFail},
+		"color":       {colorAuto, colorAlways, colorNever},
+		"locale":      tokenizer.Locales(),
+		"shuffle":     {shuffleOn, shuffleOff},
+	}
+	for name, values := range fixed {
+		if cmd.Flag(name) == nil {
+			continue
+		}
+		cmd.RegisterFlagCompletionFunc(name, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp))
+	}
+	if cmd.Flag("languages") != nil {
+		cmd.RegisterFlagCompletionFunc("languages", completeLanguages)
+		cmd.RegisterFlagCompletionFunc("run", completeHeadings)
+		cmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+		cmd.RegisterFlagCompletionFunc("config", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+		})
+	}
+}
+
+// completeDocuments offers Markdown files as arguments
+func completeDocuments(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return documentExtensions, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeLanguages offers the languages of the fenced code blocks in the documents
+func completeLanguages(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	languages := make(map[string]bool)
+	for _, interaction := range completionInteractions(args) {
+		if len(interaction.Language) > 0 {
+			languages[interaction.Language] = true
+		}
+	}
+	return sortedKeys(languages), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeHeadings offers patterns for --run that select the sections of the documents by their heading
+func completeHeadings(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	headings := make(map[string]bool)
+	for _, interaction := range completionInteractions(args) {
+		if len(interaction.Heading) > 0 {
+			headings["^"+regexp.QuoteMeta(interaction.Heading)+"$"] = true
+		}
+	}
+	return sortedKeys(headings), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProfiles offers the profiles defined in the configuration file
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	profiles := make(map[string]bool)
+	if config, err := loadConfig(options.configFile, false); err == nil {
+		for name := range config.Profiles {
+			profiles[name] = true
+		}
+	}
+	return sortedKeys(profiles), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionInteractions tokenizes the documents already on the command line, or the Markdown files in the
+// current directory if there are none
+// Unreadable files are ignored, completion should never fail.
+func completionInteractions(files []string) []*tokenizer.Interaction {
+	if len(files) == 0 {
+		for _, extension := range documentExtensions {
+			matches, _ := filepath.Glob("*." + extension)
+			files = append(files, matches...)
+		}
+	}
+	var interactions []*tokenizer.Interaction
+	for _, file := range files {
+		data, _, err := readDocument(file)
+		if err != nil {
+			continue
+		}
+		visitor := tokenizer.NewInteractionVisitor()
+		tokenizer.Tokenize(data, visitor)
+		interactions = append(interactions, visitor.Interactions...)
+	}
+	return interactions
+}
+
+// sortedKeys returns the keys of the set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
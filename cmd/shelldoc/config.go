@@ -0,0 +1,326 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultConfigFile is loaded from the current directory if no configuration file is specified
+const defaultConfigFile = ".shelldoc.yaml"
+
+// Settings contains the options that can be specified in the configuration file, and in each of its profiles
+type Settings struct {
+	Shell        string            `yaml:"shell"`
+	ShellCmd     string            `yaml:"shell-cmd"`
+	Backend      string            `yaml:"backend"`
+	Format       string            `yaml:"format"`
+	Reports      []string          `yaml:"reports"`
+	Transcripts  string            `yaml:"transcripts"`
+	SnapshotDir  string            `yaml:"snapshot-dir"`
+	Captions     string            `yaml:"caption-template"`
+	Webhooks     []string          `yaml:"webhooks"`
+	OtelEndpoint string            `yaml:"otel-endpoint"`
+	Languages    []string          `yaml:"languages"`
+	Excludes     []string          `yaml:"excludes"`
+	Env          map[string]string `yaml:"env"`
+	ToolVersions []string          `yaml:"tool-versions"`
+	Determinism  bool              `yaml:"deterministic"`
+	Faketime     bool              `yaml:"faketime"`
+	NoSkips      bool              `yaml:"no-skips"`
+	Timeout      time.Duration     `yaml:"timeout"`
+	FileTimeout  time.Duration     `yaml:"file-timeout"`
+	Delay        time.Duration     `yaml:"delay"`
+	Columns      int               `yaml:"columns"`
+	Lines        int               `yaml:"lines"`
+	BeforeRun    string            `yaml:"before-run"`
+	AfterRun     string            `yaml:"after-run"`
+	Strict       bool              `yaml:"strict"`
+	Coverage     bool              `yaml:"coverage"`
+	Leaks        string            `yaml:"check-leaks"`
+	Encoding     string            `yaml:"encoding"`
+	Locale       string            `yaml:"locale"`
+	KeepCR       bool              `yaml:"keep-carriage-returns"`
+}
+
+// Config contains the project-level defaults read from the configuration file
+// Options specified on the command line take precedence over the configuration file.
+type Config struct {
+	Settings `yaml:",inline"`
+	// Profiles contains named settings for different environments, which override the defaults when selected
+	Profiles map[string]Settings `yaml:"profiles"`
+}
+
+// loadConfig reads the configuration file
+// A missing file is only an error if the file was explicitly requested by the user.
+func loadConfig(filename string, explicit bool) (Config, error) {
+	var config Config
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("unable to read configuration file %s: %v", filename, err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("unable to parse configuration file %s: %v", filename, err)
+	}
+	log.Printf("Using configuration file %s.", filename)
+	return config, nil
+}
+
+// withProfile returns the configuration with the settings of the named profile applied
+// The settings specified in the profile override the defaults, the environment variables are merged.
+// An empty name selects no profile.
+func (config Config) withProfile(name string) (Config, error) {
+	if len(name) == 0 {
+		return config, nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		var names []string
+		for key := range config.Profiles {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return config, fmt.Errorf("unknown profile \"%s\", the configuration defines %v", name, names)
+	}
+	log.Printf("Using profile %s.", name)
+	if len(profile.Shell) > 0 {
+		config.Shell = profile.Shell
+	}
+	if len(profile.ShellCmd) > 0 {
+		config.ShellCmd = profile.ShellCmd
+	}
+	if len(profile.Backend) > 0 {
+		config.Backend = profile.Backend
+	}
+	if len(profile.Format) > 0 {
+		config.Format = profile.Format
+	}
+	if len(profile.Reports) > 0 {
+		config.Reports = profile.Reports
+	}
+	if len(profile.Webhooks) > 0 {
+		config.Webhooks = profile.Webhooks
+	}
+	if len(profile.Transcripts) > 0 {
+		config.Transcripts = profile.Transcripts
+	}
+	if len(profile.SnapshotDir) > 0 {
+		config.SnapshotDir = profile.SnapshotDir
+	}
+	if len(profile.Captions) > 0 {
+		config.Captions = profile.Captions
+	}
+	if len(profile.OtelEndpoint) > 0 {
+		config.OtelEndpoint = profile.OtelEndpoint
+	}
+	if len(profile.Languages) > 0 {
+		config.Languages = profile.Languages
+	}
+	if len(profile.Excludes) > 0 {
+		config.Excludes = profile.Excludes
+	}
+	if len(profile.ToolVersions) > 0 {
+		config.ToolVersions = profile.ToolVersions
+	}
+	if profile.Determinism {
+		config.Determinism = profile.Determinism
+	}
+	if profile.Faketime {
+		config.Faketime = profile.Faketime
+	}
+	if profile.NoSkips {
+		config.NoSkips = profile.NoSkips
+	}
+	if profile.Timeout > 0 {
+		config.Timeout = profile.Timeout
+	}
+	if profile.FileTimeout > 0 {
+		config.FileTimeout = profile.FileTimeout
+	}
+	if profile.Delay > 0 {
+		config.Delay = profile.Delay
+	}
+	if profile.Columns > 0 {
+		config.Columns = profile.Columns
+	}
+	if profile.Lines > 0 {
+		config.Lines = profile.Lines
+	}
+	if profile.Strict {
+		config.Strict = profile.Strict
+	}
+	if profile.Coverage {
+		config.Coverage = profile.Coverage
+	}
+	if len(profile.Leaks) > 0 {
+		config.Leaks = profile.Leaks
+	}
+	if profile.KeepCR {
+		config.KeepCR = profile.KeepCR
+	}
+	if len(profile.Encoding) > 0 {
+		config.Encoding = profile.Encoding
+	}
+	if len(profile.Locale) > 0 {
+		config.Locale = profile.Locale
+	}
+	if len(profile.BeforeRun) > 0 {
+		config.BeforeRun = profile.BeforeRun
+	}
+	if len(profile.AfterRun) > 0 {
+		config.AfterRun = profile.AfterRun
+	}
+	env := make(map[string]string)
+	for key, value := range config.Env {
+		env[key] = value
+	}
+	for key, value := range profile.Env {
+		env[key] = value
+	}
+	config.Env = env
+	return config, nil
+}
+
+// applyConfig sets the options that have not been specified on the command line to the values from the configuration
+func applyConfig(config Config, flags *pflag.FlagSet) {
+	if !flags.Changed("shell") && len(config.Shell) > 0 {
+		options.shell = config.Shell
+	}
+	if !flags.Changed("shell-cmd") && len(config.ShellCmd) > 0 {
+		options.shellCmd = config.ShellCmd
+	}
+	if !flags.Changed("backend") && len(config.Backend) > 0 {
+		options.backend = config.Backend
+	}
+	if !flags.Changed("format") && len(config.Format) > 0 {
+		options.format = config.Format
+	}
+	if !flags.Changed("report") && len(config.Reports) > 0 {
+		options.reports = config.Reports
+	}
+	if !flags.Changed("webhook") && len(config.Webhooks) > 0 {
+		options.webhooks = config.Webhooks
+	}
+	if !flags.Changed("transcripts") && len(config.Transcripts) > 0 {
+		options.transcripts = config.Transcripts
+	}
+	if !flags.Changed("snapshot-dir") && len(config.SnapshotDir) > 0 {
+		options.snapshotDir = config.SnapshotDir
+	}
+	if !flags.Changed("caption-template") && len(config.Captions) > 0 {
+		options.captions = config.Captions
+	}
+	if !flags.Changed("otel-endpoint") && len(config.OtelEndpoint) > 0 {
+		options.otelEndpoint = config.OtelEndpoint
+	}
+	if !flags.Changed("languages") && len(config.Languages) > 0 {
+		options.languages = config.Languages
+	}
+	if !flags.Changed("exclude") && len(config.Excludes) > 0 {
+		options.excludes = config.Excludes
+	}
+	if !flags.Changed("tool-version") && len(config.ToolVersions) > 0 {
+		options.toolVersions = config.ToolVersions
+	}
+	if !flags.Changed("deterministic") && config.Determinism {
+		options.reproducible = config.Determinism
+	}
+	if !flags.Changed("faketime") && config.Faketime {
+		options.faketime = config.Faketime
+	}
+	if !flags.Changed("no-skips") && config.NoSkips {
+		options.noSkips = config.NoSkips
+	}
+	if !flags.Changed("timeout") && config.Timeout > 0 {
+		options.timeout = config.Timeout
+	}
+	if !flags.Changed("file-timeout") && config.FileTimeout > 0 {
+		options.fileTimeout = config.FileTimeout
+	}
+	if !flags.Changed("delay") && config.Delay > 0 {
+		options.delay = config.Delay
+	}
+	if !flags.Changed("columns") && config.Columns > 0 {
+		options.columns = config.Columns
+	}
+	if !flags.Changed("lines") && config.Lines > 0 {
+		options.lines = config.Lines
+	}
+	if !flags.Changed("strict") && config.Strict {
+		options.strict = config.Strict
+	}
+	if !flags.Changed("coverage") && config.Coverage {
+		options.coverage = config.Coverage
+	}
+	if !flags.Changed("check-leaks") && len(config.Leaks) > 0 {
+		options.leaks = config.Leaks
+	}
+	if !flags.Changed("keep-carriage-returns") && config.KeepCR {
+		options.keepCR = config.KeepCR
+	}
+	if !flags.Changed("encoding") && len(config.Encoding) > 0 {
+		options.encoding = config.Encoding
+	}
+	if !flags.Changed("locale") && len(config.Locale) > 0 {
+		options.locale = config.Locale
+	}
+	options.beforeRun = config.BeforeRun
+	options.afterRun = config.AfterRun
+	options.env = config.Env
+}
+
+// environment returns the additional environment variables for the shell in KEY=value form, sorted by key
+func environment() []string {
+	var env []string
+	for key, value := range options.env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// toolVersions returns the versions of tools specified in NAME=VERSION form, nil if none are specified
+func toolVersions() (map[string]string, error) {
+	var versions map[string]string
+	for _, spec := range options.toolVersions {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid tool version \"%s\", use NAME=VERSION", spec)
+		}
+		if versions == nil {
+			versions = make(map[string]string)
+		}
+		versions[parts[0]] = parts[1]
+	}
+	return versions, nil
+}
+
+// documentVariables parses the variables specified on the command line, which override those of the documents
+func documentVariables() (map[string]string, error) {
+	var variables map[string]string
+	for _, spec := range options.variables {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid variable \"%s\", use NAME=VALUE", spec)
+		}
+		if variables == nil {
+			variables = make(map[string]string)
+		}
+		variables[parts[0]] = parts[1]
+	}
+	return variables, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+const (
+	// typingDelay is the average time between two typed characters in a demo
+	typingDelay = 60 * time.Millisecond
+	// maxOutputDelay limits the time a command seems to take in a demo, so that slow commands do not stall it
+	maxOutputDelay = 2 * time.Second
+	// readingDelay is the pause after the output of a command, before the next command is typed
+	readingDelay = 1500 * time.Millisecond
+)
+
+// checkDemo returns an error if one of the documents did not pass
+// Demos are only exported from successful runs, since a demo of a failing tutorial is not much use.
+func checkDemo(documents []runner.DocumentResult) error {
+	for _, document := range documents {
+		if document.ReturnCode != runner.ReturnSuccess {
+			return fmt.Errorf("%s did not pass, demos are only exported from successful runs", document.File)
+		}
+	}
+	return nil
+}
+
+// demoInteractions returns the interactions of a document that are shown in a demo, skipped ones are left out
+func demoInteractions(document runner.DocumentResult) []*tokenizer.Interaction {
+	var interactions []*tokenizer.Interaction
+	for _, interaction := range document.Interactions {
+		if interaction.ResultCode != tokenizer.ResultSkipped && interaction.ResultCode != tokenizer.NewInteraction {
+			interactions = append(interactions, interaction)
+		}
+	}
+	return interactions
+}
+
+// castWriter writes the events of an asciinema recording, keeping track of the time
+type castWriter struct {
+	w     io.Writer
+	clock time.Duration
+	err   error
+}
+
+// output writes an output event after the delay
+func (cast *castWriter) output(delay time.Duration, data string) {
+	cast.clock += delay
+	encoded, err := json.Marshal([]interface{}{json.Number(strconv.FormatFloat(cast.clock.Seconds(), 'f', 3, 64)), "o", data})
+	if err == nil {
+		_, err = fmt.Fprintf(cast.w, "%s\n", encoded)
+	}
+	if cast.err == nil {
+		cast.err = err
+	}
+}
+
+// writeAsciinemaReport writes the interactions of the run as an asciinema recording in the version 2 format
+// The commands are typed with a slightly varying delay between the characters. The output appears after the time the
+// command took, but at most after maxOutputDelay. The random variation is seeded, so the recording is reproducible.
+func writeAsciinemaReport(w io.Writer, documents []runner.DocumentResult) error {
+	if err := checkDemo(documents); err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]interface{}{"version": 2, "width": 80, "height": 24, "title": "shelldoc"})
+	if err != nil {
+		return fmt.Errorf("unable to write asciinema report: %v", err)
+	}
+	fmt.Fprintf(w, "%s\n", header)
+	random := rand.New(rand.NewSource(1))
+	cast := castWriter{w: w}
+	var pause time.Duration
+	for _, document := range documents {
+		for _, interaction := range demoInteractions(document) {
+			cast.output(pause, "$ ")
+			for _, char := range interaction.Cmd {
+				cast.output(typingDelay/2+time.Duration(random.Int63n(int64(typingDelay))), string(char))
+			}
+			cast.output(typingDelay, "\r\n")
+			if len(interaction.Output) > 0 {
+				delay := interaction.Duration
+				if delay > maxOutputDelay {
+					delay = maxOutputDelay
+				}
+				cast.output(delay, strings.Join(interaction.Output, "\r\n")+"\r\n")
+			}
+			pause = readingDelay
+		}
+	}
+	if cast.err != nil {
+		return fmt.Errorf("unable to write asciinema report: %v", cast.err)
+	}
+	return nil
+}
+
+// demoScriptHeader defines the pe function that types a command and executes it, like demo-magic does
+const demoScriptHeader = `#!/usr/bin/env bash
+# A demo of documentation tested by shelldoc, in the style of demo-magic.
+# Every command is typed out, and executed when Enter is pressed.
+# Set NO_WAIT=1 to execute the commands right away, and TYPE_SPEED to the
+# delay between two typed characters, in seconds.
+TYPE_SPEED=${TYPE_SPEED:-0.06}
+
+pe() {
+	printf '$ '
+	local i
+	for ((i = 0; i < ${#1}; i++)); do
+		printf '%s' "${1:i:1}"
+		sleep "$TYPE_SPEED"
+	done
+	[ -n "$NO_WAIT" ] || read -rs
+	echo
+	eval "$1"
+}
+`
+
+// writeDemoReport writes the interactions of the run as a bash script that replays them with simulated typing
+// Every document is replayed in a subshell, like it is executed in a shell of its own by shelldoc.
+func writeDemoReport(w io.Writer, documents []runner.DocumentResult) error {
+	if err := checkDemo(documents); err != nil {
+		return err
+	}
+	var builder strings.Builder
+	builder.WriteString(demoScriptHeader)
+	for _, document := range documents {
+		fmt.Fprintf(&builder, "\n# %s\n(\n", document.File)
+		for _, interaction := range demoInteractions(document) {
+			fmt.Fprintf(&builder, "\tpe %s\n", shellQuote(interaction.Cmd))
+		}
+		builder.WriteString(")\n")
+	}
+	if _, err := io.WriteString(w, builder.String()); err != nil {
+		return fmt.Errorf("unable to write demo report: %v", err)
+	}
+	return nil
+}
+
+// shellQuote quotes text as a single argument for a POSIX shell
+func shellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,114 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// deterministicEpoch is the time the commands are told it is in deterministic mode, 2000-01-01 00:00:00 UTC
+const deterministicEpoch = 946684800
+
+// faketimeLibraries are the locations libfaketime is looked up in, as glob patterns
+var faketimeLibraries = []string{
+	"/usr/lib/*/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+	"/opt/homebrew/lib/faketime/libfaketime.1.dylib",
+	"/usr/local/lib/faketime/libfaketime.1.dylib",
+}
+
+// deterministicEnvironment returns the environment variables that make the output of the commands reproducible
+// HOME and TMPDIR are set to fixed directories below the temporary directory, which are emptied first. If faketime is
+// true, libfaketime is preloaded to set the clock to the same time as SOURCE_DATE_EPOCH.
+func deterministicEnvironment(faketime bool) (map[string]string, error) {
+	base := filepath.Join(os.TempDir(), "shelldoc-deterministic")
+	if err := os.RemoveAll(base); err != nil {
+		return nil, fmt.Errorf("unable to prepare the deterministic environment: %v", err)
+	}
+	env := map[string]string{
+		"SOURCE_DATE_EPOCH": strconv.Itoa(deterministicEpoch),
+		"TZ":                "UTC",
+		"COLUMNS":           "80",
+		"LINES":             "24",
+		"HOME":              filepath.Join(base, "home"),
+		"TMPDIR":            filepath.Join(base, "tmp"),
+	}
+	for _, directory := range []string{env["HOME"], env["TMPDIR"]} {
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return nil, fmt.Errorf("unable to prepare the deterministic environment: %v", err)
+		}
+	}
+	if faketime {
+		library := findFaketime()
+		if len(library) == 0 {
+			return nil, fmt.Errorf("unable to find libfaketime, install it or run without --faketime")
+		}
+		if runtime.GOOS == "darwin" {
+			env["DYLD_INSERT_LIBRARIES"] = library
+			env["DYLD_FORCE_FLAT_NAMESPACE"] = "1"
+		} else {
+			env["LD_PRELOAD"] = library
+		}
+		env["FAKETIME"] = "@2000-01-01 00:00:00"
+	}
+	return env, nil
+}
+
+// findFaketime returns the path of libfaketime, or an empty string if it is not installed
+func findFaketime() string {
+	for _, pattern := range faketimeLibraries {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+// applyTerminalSize sets COLUMNS and LINES in the environment variables of the shell to the terminal size given with
+// --columns and --lines
+// They take precedence over the deterministic environment and the configuration file.
+func applyTerminalSize() error {
+	if options.columns < 0 || options.lines < 0 {
+		return fmt.Errorf("--columns and --lines need a positive number")
+	}
+	env := make(map[string]string)
+	for key, value := range options.env {
+		env[key] = value
+	}
+	if options.columns > 0 {
+		env["COLUMNS"] = strconv.Itoa(options.columns)
+	}
+	if options.lines > 0 {
+		env["LINES"] = strconv.Itoa(options.lines)
+	}
+	options.env = env
+	return nil
+}
+
+// applyDeterministic adds the deterministic environment to the environment variables of the shell, if deterministic
+// mode is enabled
+// Variables from the configuration file take precedence, so that projects can adjust the defaults.
+func applyDeterministic() error {
+	if !options.reproducible {
+		if options.faketime {
+			return fmt.Errorf("--faketime needs --deterministic")
+		}
+		return nil
+	}
+	env, err := deterministicEnvironment(options.faketime)
+	if err != nil {
+		return err
+	}
+	for key, value := range options.env {
+		env[key] = value
+	}
+	options.env = env
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diff executes the documents and writes a unified diff of the changes update would make to w
+// The documents are not modified. It returns the overall return code of the execution.
+func diff(w io.Writer, files []string) (int, error) {
+	returnCode := returnSuccess
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		results, err := performInteractions(context.Background(), file)
+		if err != nil {
+			return returnError, err
+		}
+		returnCode = max(results.ReturnCode, returnCode)
+		data, _, err := readDocument(file)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to read %s: %v", file, err)
+		}
+		updated, count := updateResponses(data, results.Interactions)
+		if count == 0 {
+			continue
+		}
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(data)),
+			B:        difflib.SplitLines(string(updated)),
+			FromFile: file,
+			ToFile:   file,
+			Context:  diffContext,
+		})
+		if err != nil {
+			return returnError, fmt.Errorf("unable to compare %s: %v", file, err)
+		}
+		fmt.Fprint(w, text)
+	}
+	return returnCode, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// runPattern selects the interactions to execute, all interactions are executed if it is nil
+var runPattern *regexp.Regexp
+
+// compileRunPattern compiles the --run pattern, an empty pattern selects all interactions
+func compileRunPattern(pattern string) error {
+	runPattern = nil
+	if len(pattern) == 0 {
+		return nil
+	}
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid --run pattern \"%s\": %v", pattern, err)
+	}
+	runPattern = rx
+	return nil
+}
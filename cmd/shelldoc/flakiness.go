@@ -0,0 +1,88 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// outcomes counts how often an interaction passed and failed over repeated runs
+type outcomes struct {
+	file        string
+	interaction *tokenizer.Interaction
+	passed      int
+	failed      int
+}
+
+// isFlaky returns true if the interaction passed in some runs and failed in others
+func (o *outcomes) isFlaky() bool {
+	return o.passed > 0 && o.failed > 0
+}
+
+// collectOutcomes aggregates the results of the interactions over repeated runs
+// Interactions are identified by their document and their ID, or their position in the document if they have none,
+// since every run tokenizes the documents again. Interactions that were not executed or skipped in a run are not
+// counted for it.
+func collectOutcomes(repetitions [][]runner.DocumentResult) []*outcomes {
+	var result []*outcomes
+	index := make(map[string]*outcomes)
+	for _, documents := range repetitions {
+		for _, document := range documents {
+			for position, interaction := range document.Interactions {
+				if interaction.ResultCode == tokenizer.NewInteraction || interaction.ResultCode == tokenizer.ResultSkipped {
+					continue
+				}
+				key := fmt.Sprintf("%s#%d", document.File, position)
+				if len(interaction.ID) > 0 {
+					key = fmt.Sprintf("%s#%s", document.File, interaction.ID)
+				}
+				entry, ok := index[key]
+				if !ok {
+					entry = &outcomes{file: document.File, interaction: interaction}
+					index[key] = entry
+					result = append(result, entry)
+				}
+				if interaction.HasFailure() || interaction.ResultCode == tokenizer.ResultExecutionError {
+					entry.failed++
+				} else {
+					entry.passed++
+				}
+			}
+		}
+	}
+	return result
+}
+
+// writeFlakinessReport lists the interactions that did not consistently pass or fail over repeated runs
+func writeFlakinessReport(w io.Writer, repetitions [][]runner.DocumentResult) {
+	all := collectOutcomes(repetitions)
+	flaky := 0
+	for _, entry := range all {
+		if entry.isFlaky() {
+			flaky++
+			fmt.Fprintf(w, " FLAKY: %s:%d: %s passed %d of %d times\n", entry.file, entry.interaction.Line, entry.interaction.Name(), entry.passed, entry.passed+entry.failed)
+		}
+	}
+	fmt.Fprintf(w, "SHELLDOC: %d runs, %d of %d interactions flaky\n", len(repetitions), flaky, len(all))
+}
+
+// writeQuarantineReport lists the interactions in code blocks with the shelldocflaky option, so that they are not
+// forgotten
+func writeQuarantineReport(w io.Writer, repetitions [][]runner.DocumentResult) {
+	quarantined := 0
+	for _, entry := range collectOutcomes(repetitions) {
+		if issue, ok := entry.interaction.Attributes[tokenizer.FlakyOption]; ok {
+			quarantined++
+			fmt.Fprintf(w, " QUARANTINED: %s:%d: %s (%s) passed %d of %d times\n", entry.file, entry.interaction.Line, entry.interaction.Name(), issue, entry.passed, entry.passed+entry.failed)
+		}
+	}
+	if quarantined > 0 {
+		fmt.Fprintf(w, "SHELLDOC: %d interactions are quarantined as flaky, their failures do not fail the run\n", quarantined)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// fenceMarker starts and ends a fenced code block
+const fenceMarker = "```"
+
+// formatDocuments rewrites the documents in canonical form and returns the documents that were not canonical
+// If check is true, the documents are only checked, but not modified.
+func formatDocuments(files []string, check bool) ([]string, error) {
+	var changed []string
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return changed, fmt.Errorf("unable to format %s: %v", file, err)
+		}
+		data, format, err := readDocument(file)
+		if err != nil {
+			return changed, fmt.Errorf("unable to format %s: %v", file, err)
+		}
+		formatted := formatDocument(data)
+		if bytes.Equal(data, formatted) {
+			continue
+		}
+		changed = append(changed, file)
+		if check {
+			continue
+		}
+		if err := writeDocument(file, formatted, format, info.Mode()); err != nil {
+			return changed, fmt.Errorf("unable to format %s: %v", file, err)
+		}
+		fmt.Fprintf(console(), "SHELLDOC: formatted \"%s\"\n", file)
+	}
+	return changed, nil
+}
+
+// formatDocument returns the document in canonical form
+// Commands use the $ prompt followed by a single space, trailing whitespace is removed from commands and expected
+// responses, and the shelldoc options in the info strings of fenced code blocks are sorted and separated by single
+// spaces. Everything outside of the code blocks remains untouched.
+func formatDocument(data []byte) []byte {
+	visitor := tokenizer.NewInteractionVisitor()
+	tokenizer.Tokenize(data, visitor)
+	lines := strings.Split(string(data), "\n")
+	for _, interaction := range visitor.Interactions {
+		if interaction.Line < 1 || interaction.Line > len(lines) {
+			continue
+		}
+		command := interaction.Line - 1
+		end, found := locateResponse(lines, interaction)
+		if !found {
+			log.Printf("not formatting the response of \"%s\" in line %d, unable to locate it", interaction.Cmd, interaction.Line)
+			end = command + 1
+		}
+		lines[command] = indentationOf(lines[command]) + "$ " + interaction.Cmd
+		for position := command + 1; position < end; position++ {
+			lines[position] = strings.TrimRight(lines[position], " \t")
+		}
+	}
+	inFence := false
+	for index, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), fenceMarker) {
+			continue
+		}
+		if inFence {
+			lines[index] = strings.TrimRight(line, " \t")
+		} else {
+			lines[index] = formatInfoString(line)
+		}
+		inFence = !inFence
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// formatInfoString returns the opening line of a fenced code block in canonical form
+// Other attributes in the braces keep their order and precede the shelldoc options.
+func formatInfoString(line string) string {
+	indentation := indentationOf(line)
+	info := strings.TrimSpace(line)
+	rest := strings.TrimLeft(info, "`")
+	marker := info[:len(info)-len(rest)]
+	open := strings.Index(rest, "{")
+	close := strings.LastIndex(rest, "}")
+	if open < 0 || close < open {
+		return indentation + marker + strings.Join(strings.Fields(rest), " ")
+	}
+	var attributes, options []string
+	for _, element := range strings.Fields(rest[open+1 : close]) {
+		if strings.HasPrefix(element, "shelldoc") {
+			options = append(options, element)
+		} else {
+			attributes = append(attributes, element)
+		}
+	}
+	sort.Strings(options)
+	words := strings.Fields(rest[:open])
+	words = append(words, "{"+strings.Join(append(attributes, options...), " ")+"}")
+	words = append(words, strings.Fields(rest[close+1:])...)
+	return indentation + marker + strings.Join(words, " ")
+}
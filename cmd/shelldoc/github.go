@@ -0,0 +1,197 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+// The ways the results are posted to GitHub
+const (
+	githubStatus = "status"
+	githubCheck  = "check"
+)
+
+// defaultGitHubAPI is used if $GITHUB_API_URL is not set, it is set by GitHub Actions, also for GitHub Enterprise
+const defaultGitHubAPI = "https://api.github.com"
+
+// githubContext is the name of the commit status and the check run
+const githubContext = "shelldoc"
+
+// maxGitHubAnnotations is the number of annotations GitHub accepts in one request, more are added in further requests
+const maxGitHubAnnotations = 50
+
+// githubAnnotation marks the line of a failing interaction in a check run
+type githubAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title"`
+	Message         string `json:"message"`
+}
+
+// githubCheckOutput is the output of a check run
+type githubCheckOutput struct {
+	Title       string             `json:"title"`
+	Summary     string             `json:"summary"`
+	Annotations []githubAnnotation `json:"annotations"`
+}
+
+// githubReporter posts the results as a commit status or a check run of a commit
+// The token is read from $GITHUB_TOKEN, so that it does not appear in the command line.
+type githubReporter struct {
+	mode  string
+	api   string
+	repo  string
+	sha   string
+	token string
+}
+
+// newGitHubReporter creates the reporter selected by --github
+// The repository and the commit default to those of the GitHub Actions workflow.
+func newGitHubReporter(mode, repo, sha string) (githubReporter, error) {
+	if mode != githubStatus && mode != githubCheck {
+		return githubReporter{}, fmt.Errorf("invalid value \"%s\" for --github, use %s or %s", mode, githubStatus, githubCheck)
+	}
+	reporter := githubReporter{mode: mode, api: os.Getenv("GITHUB_API_URL"), repo: repo, sha: sha, token: os.Getenv("GITHUB_TOKEN")}
+	if len(reporter.api) == 0 {
+		reporter.api = defaultGitHubAPI
+	}
+	if len(reporter.repo) == 0 {
+		reporter.repo = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if len(reporter.sha) == 0 {
+		reporter.sha = os.Getenv("GITHUB_SHA")
+	}
+	if len(reporter.token) == 0 {
+		return githubReporter{}, fmt.Errorf("--github needs a token in $GITHUB_TOKEN")
+	}
+	if strings.Count(reporter.repo, "/") != 1 || len(reporter.sha) == 0 {
+		return githubReporter{}, fmt.Errorf("--github needs the repository as OWNER/NAME and the commit, using --github-repo and --github-sha")
+	}
+	return reporter, nil
+}
+
+// Report posts the results to GitHub
+func (reporter githubReporter) Report(result runner.Result) error {
+	summary := summarize(result)
+	description := fmt.Sprintf("%d tests, %d failures, %d execution errors", summary.Tests, summary.Failures, summary.Errors)
+	if reporter.mode == githubStatus {
+		state := "success"
+		switch result.ReturnCode {
+		case runner.ReturnFailure:
+			state = "failure"
+		case runner.ReturnError:
+			state = "error"
+		}
+		_, err := reporter.post(http.MethodPost, fmt.Sprintf("/repos/%s/statuses/%s", reporter.repo, reporter.sha), map[string]string{
+			"state":       state,
+			"description": description,
+			"context":     githubContext,
+		})
+		return err
+	}
+	conclusion := "success"
+	if result.ReturnCode != runner.ReturnSuccess {
+		conclusion = "failure"
+	}
+	annotations := githubAnnotations(summary.Failing)
+	batch := annotations
+	if len(batch) > maxGitHubAnnotations {
+		batch = batch[:maxGitHubAnnotations]
+	}
+	output := githubCheckOutput{Title: fmt.Sprintf("shelldoc: %s", summary.Verdict), Summary: description, Annotations: batch}
+	response, err := reporter.post(http.MethodPost, fmt.Sprintf("/repos/%s/check-runs", reporter.repo), map[string]interface{}{
+		"name":         githubContext,
+		"head_sha":     reporter.sha,
+		"status":       "completed",
+		"conclusion":   conclusion,
+		"completed_at": time.Now().UTC().Format(time.RFC3339),
+		"output":       output,
+	})
+	if err != nil {
+		return err
+	}
+	var checkRun struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(response, &checkRun); err != nil {
+		return fmt.Errorf("unable to read the check run created on GitHub: %v", err)
+	}
+	for index := maxGitHubAnnotations; index < len(annotations); index += maxGitHubAnnotations {
+		output.Annotations = annotations[index:]
+		if len(output.Annotations) > maxGitHubAnnotations {
+			output.Annotations = output.Annotations[:maxGitHubAnnotations]
+		}
+		if _, err := reporter.post(http.MethodPatch, fmt.Sprintf("/repos/%s/check-runs/%d", reporter.repo, checkRun.ID), map[string]interface{}{"output": output}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// githubAnnotations annotates the lines of the failing interactions
+// The paths of the documents need to be relative to the root of the repository, shelldoc is usually run there.
+func githubAnnotations(failing []webhookFailure) []githubAnnotation {
+	var annotations []githubAnnotation
+	for _, failure := range failing {
+		message := fmt.Sprintf("%s: %s", failure.Result, failure.Command)
+		if len(failure.Comment) > 0 {
+			message = fmt.Sprintf("%s (%s)", message, failure.Comment)
+		}
+		line := failure.Line
+		if line < 1 {
+			line = 1
+		}
+		annotations = append(annotations, githubAnnotation{
+			Path:            strings.TrimPrefix(filepath.ToSlash(filepath.Clean(failure.File)), "./"),
+			StartLine:       line,
+			EndLine:         line,
+			AnnotationLevel: "failure",
+			Title:           failure.Result,
+			Message:         message,
+		})
+	}
+	return annotations
+}
+
+// post sends a request to the GitHub API and returns the body of the response
+func (reporter githubReporter) post(method, path string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to post the results to GitHub: %v", err)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(reporter.api, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to post the results to GitHub: %v", err)
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", "Bearer "+reporter.token)
+	request.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: webhookTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to post the results to GitHub: %v", err)
+	}
+	defer response.Body.Close()
+	var buffer bytes.Buffer
+	if _, err := buffer.ReadFrom(response.Body); err != nil {
+		return nil, fmt.Errorf("unable to post the results to GitHub: %v", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("unable to post the results to GitHub: %s: %s", response.Status, strings.TrimSpace(buffer.String()))
+	}
+	return buffer.Bytes(), nil
+}
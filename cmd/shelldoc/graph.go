@@ -0,0 +1,157 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+const (
+	graphDot     = "dot"
+	graphMermaid = "mermaid"
+)
+
+// graphFormats lists the formats the execution graph can be written in
+var graphFormats = []string{graphDot, graphMermaid}
+
+// graphNode is a code block with commands in the execution graph
+type graphNode struct {
+	id    string
+	label string
+	setup bool
+}
+
+// graphEdge connects two code blocks, dependencies are edges from a setup code block to a code block that needs it
+type graphEdge struct {
+	from, to   string
+	dependency bool
+}
+
+// blockGraph returns the code blocks with commands of a document and the edges between them
+// The code blocks are executed in order, so every code block follows the one before it. When a single code block is
+// executed, using --at-line or --incremental, the setup code blocks before it are executed first, which is shown as a
+// dependency unless the setup code block directly precedes it.
+func blockGraph(prefix string, document *tokenizer.Document) ([]graphNode, []graphEdge) {
+	var nodes []graphNode
+	var edges []graphEdge
+	var setups []string
+	for _, block := range document.Blocks {
+		if len(block.Interactions) == 0 {
+			continue
+		}
+		node := graphNode{id: fmt.Sprintf("%s_%d", prefix, block.Line), label: fmt.Sprintf("%d: %s", block.Line, block.Heading)}
+		if len(block.Heading) == 0 {
+			node.label = fmt.Sprintf("%d: %s", block.Line, block.Interactions[0].Cmd)
+		}
+		_, node.setup = block.Options[tokenizer.SetupOption]
+		if node.setup {
+			node.label += " (setup)"
+		}
+		var previous string
+		if len(nodes) > 0 {
+			previous = nodes[len(nodes)-1].id
+			edges = append(edges, graphEdge{from: previous, to: node.id})
+		}
+		for _, setup := range setups {
+			if setup != previous {
+				edges = append(edges, graphEdge{from: setup, to: node.id, dependency: true})
+			}
+		}
+		if node.setup {
+			setups = append(setups, node.id)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, edges
+}
+
+// writeGraph writes the execution order of the code blocks in the documents as a Graphviz DOT or a Mermaid graph
+// Every document is a cluster (or subgraph) of the code blocks with commands in it.
+func writeGraph(w io.Writer, format string, files []string) error {
+	if err := validateFormat(format, graphFormats); err != nil {
+		return err
+	}
+	if format == graphDot {
+		fmt.Fprintln(w, "digraph shelldoc {")
+	} else {
+		fmt.Fprintln(w, "flowchart TD")
+	}
+	for index, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		data, _, err := readDocument(file)
+		if err != nil {
+			return fmt.Errorf("unable to read input data: %v", err)
+		}
+		document, err := tokenizer.ParseDocument(data)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %v", file, err)
+		}
+		nodes, edges := blockGraph(fmt.Sprintf("d%d", index), document)
+		if format == graphDot {
+			writeDotCluster(w, index, file, nodes, edges)
+		} else {
+			writeMermaidSubgraph(w, index, file, nodes, edges)
+		}
+	}
+	if format == graphDot {
+		fmt.Fprintln(w, "}")
+	}
+	return nil
+}
+
+// writeDotCluster writes the code blocks of a document as a cluster of a DOT graph, setup code blocks are bold and
+// dependencies dashed
+func writeDotCluster(w io.Writer, index int, file string, nodes []graphNode, edges []graphEdge) {
+	fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%s;\n", index, strconv.Quote(file))
+	for _, node := range nodes {
+		style := ""
+		if node.setup {
+			style = ", style=bold"
+		}
+		fmt.Fprintf(w, "    %s [shape=box, label=%s%s];\n", node.id, strconv.Quote(node.label), style)
+	}
+	for _, edge := range edges {
+		style := ""
+		if edge.dependency {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(w, "    %s -> %s%s;\n", edge.from, edge.to, style)
+	}
+	fmt.Fprintln(w, "  }")
+}
+
+// writeMermaidSubgraph writes the code blocks of a document as a subgraph of a Mermaid flowchart, setup code blocks
+// have rounded corners and dependencies are dotted
+func writeMermaidSubgraph(w io.Writer, index int, file string, nodes []graphNode, edges []graphEdge) {
+	quote := func(text string) string {
+		return `"` + strings.Replace(text, `"`, "#quot;", -1) + `"`
+	}
+	fmt.Fprintf(w, "  subgraph d%d [%s]\n", index, quote(file))
+	for _, node := range nodes {
+		if node.setup {
+			fmt.Fprintf(w, "    %s(%s)\n", node.id, quote(node.label))
+		} else {
+			fmt.Fprintf(w, "    %s[%s]\n", node.id, quote(node.label))
+		}
+	}
+	for _, edge := range edges {
+		arrow := "-->"
+		if edge.dependency {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(w, "    %s %s %s\n", edge.from, arrow, edge.to)
+	}
+	fmt.Fprintln(w, "  end")
+}
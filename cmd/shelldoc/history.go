@@ -0,0 +1,271 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// defaultHistoryFile is the file --history records the runs in, if no file is specified
+const defaultHistoryFile = ".shelldoc-history.jsonl"
+
+const (
+	historyFailures     = "failures"
+	historyDurations    = "durations"
+	historyFirstFailure = "first-failure"
+)
+
+// historyQueries lists the queries supported by the history command
+var historyQueries = []string{historyFailures, historyDurations, historyFirstFailure}
+
+// historyRun is the record of a run in the history file, which contains one JSON object per line
+type historyRun struct {
+	Time         time.Time            `json:"time"`
+	Commit       string               `json:"commit,omitempty"`
+	Interactions []historyInteraction `json:"interactions"`
+}
+
+// historyInteraction is the result of an interaction in a recorded run
+type historyInteraction struct {
+	ID       string               `json:"id,omitempty"`
+	File     string               `json:"file"`
+	Line     int                  `json:"line"`
+	Name     string               `json:"name"`
+	Result   tokenizer.ResultCode `json:"result"`
+	Failed   bool                 `json:"failed,omitempty"`
+	Duration time.Duration        `json:"duration"`
+}
+
+// location returns the position of the interaction in FILE:LINE form
+func (interaction historyInteraction) location() string {
+	return fmt.Sprintf("%s:%d", interaction.File, interaction.Line)
+}
+
+// key identifies the interaction across runs by its ID
+// Interactions recorded without an ID are identified by their location and name, since the interactions asserting
+// on the state of the shell share the line of their code block.
+func (interaction historyInteraction) key() string {
+	if len(interaction.ID) > 0 {
+		return interaction.File + " " + interaction.ID
+	}
+	return interaction.location() + " " + interaction.Name
+}
+
+// executed returns true if the interaction was executed in the run
+func (interaction historyInteraction) executed() bool {
+	return interaction.Result != tokenizer.NewInteraction && interaction.Result != tokenizer.ResultSkipped
+}
+
+// historyReporter appends the results of every run to the history file
+type historyReporter struct {
+	path string
+}
+
+// Report appends the run to the history file, the commit is that of the git repository in the current directory
+func (reporter historyReporter) Report(result runner.Result) error {
+	run := historyRun{Time: time.Now().UTC()}
+	if commit, err := git("rev-parse", "HEAD"); err == nil {
+		run.Commit = strings.TrimSpace(commit)
+	}
+	for _, document := range result.Documents {
+		for _, interaction := range document.Interactions {
+			run.Interactions = append(run.Interactions, historyInteraction{
+				ID:       interaction.ID,
+				File:     document.File,
+				Line:     interaction.Line,
+				Name:     interaction.Name(),
+				Result:   interaction.ResultCode,
+				Failed:   isReported(interaction),
+				Duration: interaction.Duration,
+			})
+		}
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("unable to record the run in the history: %v", err)
+	}
+	file, err := os.OpenFile(reporter.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to record the run in the history: %v", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to record the run in the history: %v", err)
+	}
+	return file.Close()
+}
+
+// readHistory reads the runs recorded in the history file, in the order they were recorded
+func readHistory(path string) ([]historyRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the history: %v", err)
+	}
+	defer file.Close()
+	var runs []historyRun
+	decoder := json.NewDecoder(file)
+	for {
+		var run historyRun
+		if err := decoder.Decode(&run); err == io.EOF {
+			return runs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read the history %s: %v", path, err)
+		}
+		runs = append(runs, run)
+	}
+}
+
+// historySelector selects the interactions in a document, or at a line of it, from a FILE[:LINE] specification
+// An empty specification selects all interactions.
+func historySelector(spec string) func(historyInteraction) bool {
+	if len(spec) == 0 {
+		return func(historyInteraction) bool { return true }
+	}
+	file, line := spec, 0
+	if index := strings.LastIndex(spec, ":"); index >= 0 {
+		if number, err := strconv.Atoi(spec[index+1:]); err == nil && number > 0 {
+			file, line = spec[:index], number
+		}
+	}
+	file = filepath.Clean(file)
+	return func(interaction historyInteraction) bool {
+		return filepath.Clean(interaction.File) == file && (line == 0 || interaction.Line == line)
+	}
+}
+
+// queryHistory answers a query about the runs recorded in the history file and writes the answer to w
+// The failures query lists the failing interactions of every run, the durations query the average duration of every
+// interaction, and the first-failure query the run and commit since which every interaction fails, if it fails in the
+// latest run. The spec restricts the queries to a document or a line of it.
+func queryHistory(w io.Writer, path, query, spec string) error {
+	selected := historySelector(spec)
+	runs, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	switch query {
+	case historyFailures:
+		writeHistoryFailures(w, runs, selected)
+	case historyDurations:
+		writeHistoryDurations(w, runs, selected)
+	case historyFirstFailure:
+		writeHistoryFirstFailures(w, runs, selected)
+	default:
+		return fmt.Errorf("unknown query \"%s\", supported queries are %s", query, strings.Join(historyQueries, ", "))
+	}
+	return nil
+}
+
+// describeRun identifies a run by its time and commit
+func describeRun(run historyRun) string {
+	commit := run.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if len(commit) == 0 {
+		commit = "(no commit)"
+	}
+	return fmt.Sprintf("%s %s", run.Time.Local().Format("2006-01-02 15:04:05"), commit)
+}
+
+// writeHistoryFailures writes one line per run with the number of selected interactions that failed, followed by
+// their locations
+func writeHistoryFailures(w io.Writer, runs []historyRun, selected func(historyInteraction) bool) {
+	for _, run := range runs {
+		var executed int
+		var failed []string
+		for _, interaction := range run.Interactions {
+			if !selected(interaction) || !interaction.executed() {
+				continue
+			}
+			executed++
+			if interaction.Failed {
+				failed = append(failed, interaction.location())
+			}
+		}
+		if executed == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d of %d failed", describeRun(run), len(failed), executed)
+		if len(failed) > 0 {
+			fmt.Fprintf(w, ": %s", strings.Join(failed, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeHistoryDurations writes the average duration of every selected interaction over the runs it was executed in,
+// the slowest first
+func writeHistoryDurations(w io.Writer, runs []historyRun, selected func(historyInteraction) bool) {
+	type statistics struct {
+		interaction historyInteraction
+		total       time.Duration
+		count       int
+	}
+	var entries []*statistics
+	byKey := make(map[string]*statistics)
+	for _, run := range runs {
+		for _, interaction := range run.Interactions {
+			if !selected(interaction) || !interaction.executed() {
+				continue
+			}
+			entry, ok := byKey[interaction.key()]
+			if !ok {
+				entry = &statistics{interaction: interaction}
+				byKey[interaction.key()] = entry
+				entries = append(entries, entry)
+			}
+			entry.total += interaction.Duration
+			entry.count++
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].total/time.Duration(entries[i].count) > entries[j].total/time.Duration(entries[j].count)
+	})
+	for _, entry := range entries {
+		average := entry.total / time.Duration(entry.count)
+		fmt.Fprintf(w, "%10v in %d runs: %s: %s\n", average.Round(time.Millisecond), entry.count, entry.interaction.location(), entry.interaction.Name)
+	}
+}
+
+// writeHistoryFirstFailures writes, for every selected interaction that failed in the latest run it was executed in,
+// the first run of the failures since it last passed
+func writeHistoryFirstFailures(w io.Writer, runs []historyRun, selected func(historyInteraction) bool) {
+	var interactions []historyInteraction
+	since := make(map[string]int) // the index of the first failing run, or -1 if the interaction passed
+	for index, run := range runs {
+		for _, interaction := range run.Interactions {
+			if !selected(interaction) || !interaction.executed() {
+				continue
+			}
+			first, ok := since[interaction.key()]
+			if !ok {
+				interactions = append(interactions, interaction)
+			}
+			switch {
+			case !interaction.Failed:
+				since[interaction.key()] = -1
+			case !ok || first < 0:
+				since[interaction.key()] = index
+			}
+		}
+	}
+	for _, interaction := range interactions {
+		if first := since[interaction.key()]; first >= 0 {
+			fmt.Fprintf(w, "%s: %s: failing since %s\n", interaction.location(), interaction.Name, describeRun(runs[first]))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// The names of the hooks, as used in the configuration file
+const (
+	hookBeforeRun = "before-run"
+	hookAfterRun  = "after-run"
+)
+
+// runHook executes the command of a hook in a separate shell with the configured environment
+// An empty command is not executed. The output of the command is shown with the progress output, its error output is
+// shown unless everything is silenced with --quiet.
+func runHook(name, command string) error {
+	if len(command) == 0 {
+		return nil
+	}
+	args, err := newRunner().ShellCommand()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(console(), "SHELLDOC: running the %s hook\n", name)
+	cmd := exec.Command(args[0], append(args[1:], "-c", command)...)
+	cmd.Env = append(os.Environ(), environment()...)
+	cmd.Stdout = console()
+	if !options.quiet {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("the %s hook failed: %v", name, err)
+	}
+	return nil
+}
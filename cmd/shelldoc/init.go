@@ -0,0 +1,143 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultExampleFile is the name of the example document created by the init command
+const defaultExampleFile = "shelldoc-example.md"
+
+// exampleDocument demonstrates the syntax and the options understood by shelldoc
+// It is tested like any other document, so it needs to pass in a typical Unix environment.
+const exampleDocument = `# Example: tested documentation
+
+This document was generated by ` + "`shelldoc init`" + `. It shows how
+shell commands in Markdown are tested. Execute it using:
+
+    % shelldoc run shelldoc-example.md
+
+Lines in code blocks that start with a $ or a > are commands. The
+lines that follow are the output the command is expected to print.
+Lines that start with a % are shown to the reader, but not executed.
+
+## Setup
+
+All commands in a document are executed in the same shell, one after
+the other. The first code block creates a scratch directory that the
+following commands use:
+
+    $ export WORKDIR=$(mktemp -d)
+    $ cd "$WORKDIR"
+
+## Commands and expected output
+
+A command with an expected response:
+
+    $ echo "Hello World" > greeting.txt
+    $ cat greeting.txt
+    Hello World
+
+An ellipsis accepts any output from that point on:
+
+    $ printf 'one\ntwo\nthree\n'
+    one
+    ...
+
+## Options
+
+Options are specified in the info string of fenced code blocks. This
+command is expected to exit with exit code 2:
+
+` + "```shell {shelldocexitcode=2}" + `
+$ (exit 2)
+` + "```" + `
+
+The exit code of this command does not matter:
+
+` + "```shell {shelldocwhatever}" + `
+$ grep -q Goodbye greeting.txt
+` + "```" + `
+
+This command is skipped, the value of the option is the reason:
+
+` + "```shell {shelldocskip=needs-network-access}" + `
+$ curl https://example.com
+` + "```" + `
+
+This command needs to finish within ten seconds:
+
+` + "```shell {shelldoctimeout=10s}" + `
+$ sleep 1
+` + "```" + `
+
+## Teardown
+
+The last code block removes the scratch directory again:
+
+    $ cd / && rm -rf "$WORKDIR"
+`
+
+// exampleConfig is a starter configuration file that documents the available options
+var exampleConfig = `# Configuration for shelldoc, loaded from the directory shelldoc is run in.
+# Options specified on the command line override these settings.
+
+# The shell that executes the commands (default: $SHELL).
+# shell: /bin/bash
+
+# The output format, one of ` + strings.Join(formats, ", ") + `.
+format: console
+
+# Only execute fenced code blocks in these languages. Code blocks that
+# do not specify a language are always executed.
+# languages: [shell, console]
+
+# Skip documents matching these patterns.
+# excludes: [CHANGELOG.md]
+
+# The time a command, and all commands in a document, may take.
+timeout: 1m
+file-timeout: 10m
+
+# Fail if interactions are skipped using the shelldocskip option.
+no-skips: false
+
+# Commands executed before the first and after the last document.
+# before-run: mkdir -p /tmp/shelldoc
+# after-run: rm -rf /tmp/shelldoc
+
+# Additional environment variables for the shell.
+env:
+  LC_ALL: C
+`
+
+// scaffold creates an example document and a starter configuration file in the directory and returns their paths
+// Existing files are only overwritten if force is true. Nothing is written if one of the files exists.
+func scaffold(directory, document string, force bool) ([]string, error) {
+	files := map[string]string{
+		filepath.Join(directory, document):          exampleDocument,
+		filepath.Join(directory, defaultConfigFile): exampleConfig,
+	}
+	var paths []string
+	for path := range files {
+		if _, err := os.Stat(path); err == nil && !force {
+			return nil, fmt.Errorf("%s already exists, use --force to overwrite it", path)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := ioutil.WriteFile(path, []byte(files[path]), 0644); err != nil {
+			return nil, fmt.Errorf("unable to create %s: %v", path, err)
+		}
+	}
+	return paths, nil
+}
@@ -5,9 +5,12 @@ package main
 // SPDX-License-Identifier: LGPL-3.0
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
 )
 
 // ReadInput reads either the files specified on the command line or stdin and returns the bytes.
@@ -32,3 +35,44 @@ func ReadInput(args []string) ([]byte, error) {
 	}
 	return result, nil
 }
+
+// documentFormat describes how a document is stored, so that it can be written back the same way
+type documentFormat struct {
+	// encoding is the encoding of the document, see tokenizer.Decode
+	encoding string
+	// crlf is true if the Windows line breaks of the document were normalized when it was read
+	crlf bool
+}
+
+// readDocument reads a document and converts it to UTF-8, using the encoding selected by --encoding or detected from
+// its byte order mark
+// Windows line breaks are normalized, unless --keep-carriage-returns is set.
+func readDocument(file string) ([]byte, documentFormat, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, documentFormat{}, err
+	}
+	data, encoding, err := tokenizer.Decode(data, options.encoding)
+	if err != nil {
+		return nil, documentFormat{}, err
+	}
+	format := documentFormat{encoding: encoding}
+	if !options.keepCR {
+		normalized := tokenizer.NormalizeLineEndings(data)
+		format.crlf = len(normalized) != len(data)
+		data = normalized
+	}
+	return data, format, nil
+}
+
+// writeDocument writes a document that was read by readDocument, with its original encoding and line breaks
+func writeDocument(file string, data []byte, format documentFormat, mode os.FileMode) error {
+	if format.crlf {
+		data = bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	}
+	encoded, err := tokenizer.Encode(data, format.encoding)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, encoded, mode)
+}
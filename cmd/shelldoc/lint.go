@@ -0,0 +1,50 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// lint checks the documents without executing them and writes the problems found to w
+// Code blocks that repeat earlier ones and setup code blocks that are never needed are reported as well, and in
+// strict mode and with --coverage, violations of the naming and the coverage policies. It returns the number of
+// problems found.
+func lint(w io.Writer, files []string) (int, error) {
+	count := 0
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		data, _, err := readDocument(file)
+		if err != nil {
+			return count, fmt.Errorf("unable to read input data: %v", err)
+		}
+		document, err := tokenizer.ParseDocument(data)
+		if err != nil {
+			return count, fmt.Errorf("unable to parse %s: %v", file, err)
+		}
+		diagnostics := append(document.Diagnostics, document.Redundancies()...)
+		if options.strict {
+			diagnostics = append(diagnostics, runner.NamingProblems(newRunner().Select(document.Interactions()))...)
+		}
+		if options.coverage {
+			diagnostics = append(diagnostics, runner.CoverageProblems(document, options.languages)...)
+		}
+		sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintf(w, "%s:%d: %s\n", file, diagnostic.Line, diagnostic.Message)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// listFormats lists the output formats supported in list mode
+var listFormats = []string{formatConsole, formatJSON}
+
+// listEntry describes a discovered interaction in list mode
+type listEntry struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Caption  string   `json:"caption,omitempty"`
+	Heading  string   `json:"heading,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Command  string   `json:"command"`
+}
+
+// tags returns the shelldoc attributes of the interaction in key or key=value form, sorted by key
+func tags(interaction *tokenizer.Interaction) []string {
+	var result []string
+	for key, value := range interaction.Attributes {
+		if len(value) > 0 {
+			key = fmt.Sprintf("%s=%s", key, value)
+		}
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// list writes the interactions discovered in the files without executing them
+func list(w io.Writer, format string, files []string) error {
+	entries := []listEntry{}
+	r := newRunner()
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		interactions, err := r.Discover(file)
+		if err != nil {
+			return err
+		}
+		for _, interaction := range interactions {
+			entries = append(entries, listEntry{file, interaction.Line, interaction.Caption, interaction.Heading, interaction.Language, tags(interaction), interaction.Cmd})
+		}
+	}
+	if format == formatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "LOCATION\tCAPTION\tHEADING\tTAGS\tCOMMAND")
+	for _, entry := range entries {
+		labels := strings.TrimSpace(strings.Join(append([]string{entry.Language}, entry.Tags...), " "))
+		fmt.Fprintf(writer, "%s:%d\t%s\t%s\t%s\t%s\n", entry.File, entry.Line, entry.Caption, entry.Heading, labels, entry.Command)
+	}
+	return writer.Flush()
+}
+
+// extract writes the commands in the documents as a shell script, each preceded by a comment with its location
+func extract(w io.Writer, files []string) error {
+	fmt.Fprintln(w, "#!/bin/sh")
+	r := newRunner()
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		interactions, err := r.Discover(file)
+		if err != nil {
+			return err
+		}
+		for _, interaction := range interactions {
+			fmt.Fprintf(w, "\n# %s:%d\n%s\n", file, interaction.Line, interaction.Cmd)
+		}
+	}
+	return nil
+}
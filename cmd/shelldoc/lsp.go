@@ -0,0 +1,434 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/plugin"
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// The commands offered by the code lenses of the language server
+const (
+	lspRunDocument = "shelldoc.runDocument"
+	lspRunBlock    = "shelldoc.runBlock"
+)
+
+// LSP diagnostic severities
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+)
+
+// lspMessage is a JSON-RPC request, notification or response
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspLensCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
+}
+
+type lspCodeLens struct {
+	Range   lspRange       `json:"range"`
+	Command lspLensCommand `json:"command"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// lspTextDocumentParams contains the parameters of the text document notifications and requests that are handled
+type lspTextDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+}
+
+type lspExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+// lspServer is a language server for Markdown documents tested by shelldoc
+// It reports the problems found by lint and the failures of the last execution as diagnostics, offers code lenses to
+// execute the document or a single code block, and shows the actual output of executed interactions on hover.
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+	// documents contains the text of the open documents by URI
+	documents map[string]string
+	// results contains the interactions of each document that have been executed
+	results map[string][]*tokenizer.Interaction
+	// runner discovers and executes the interactions, it is kept so that template functions have the same values in
+	// every parse of a document
+	runner *runner.Runner
+}
+
+// newLSPServer creates a language server that communicates using in and out
+func newLSPServer(in io.Reader, out io.Writer) *lspServer {
+	return &lspServer{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]string),
+		results:   make(map[string][]*tokenizer.Interaction),
+		runner:    newRunner(),
+	}
+}
+
+// run handles the messages of the client until it sends exit, or its input ends
+func (server *lspServer) run() error {
+	defer server.runner.Close()
+	for {
+		message, err := server.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+		result, rpcErr := server.handle(message)
+		if message.ID == nil {
+			continue // notifications are not answered
+		}
+		response := lspMessage{JSONRPC: "2.0", ID: message.ID, Result: result, Error: rpcErr}
+		if result == nil && rpcErr == nil {
+			response.Result = json.RawMessage("null")
+		}
+		if err := server.write(response); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the next message, which is preceded by a Content-Length header
+func (server *lspServer) read() (lspMessage, error) {
+	var message lspMessage
+	header, err := textproto.NewReader(server.in).ReadMIMEHeader()
+	if err != nil {
+		return message, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return message, fmt.Errorf("invalid Content-Length header: %v", err)
+	}
+	if length < 0 {
+		return message, fmt.Errorf("invalid Content-Length header: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(server.in, data); err != nil {
+		return message, err
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("invalid message: %v", err)
+	}
+	return message, nil
+}
+
+// write sends a message to the client
+func (server *lspServer) write(message lspMessage) error {
+	message.JSONRPC = "2.0"
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(server.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// notify sends a notification to the client
+func (server *lspServer) notify(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		log.Printf("Unable to encode %s notification: %v", method, err)
+		return
+	}
+	if err := server.write(lspMessage{Method: method, Params: data}); err != nil {
+		log.Printf("Unable to send %s notification: %v", method, err)
+	}
+}
+
+// handle dispatches a request or notification and returns the result of requests
+func (server *lspServer) handle(message lspMessage) (interface{}, *lspError) {
+	var params lspTextDocumentParams
+	switch message.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the full text is sent on every change
+				"hoverProvider":          true,
+				"codeLensProvider":       map[string]interface{}{},
+				"executeCommandProvider": map[string]interface{}{"commands": []string{lspRunDocument, lspRunBlock}},
+			},
+			"serverInfo": map[string]string{"name": "shelldoc"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose", "textDocument/codeLens", "textDocument/hover":
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &lspError{-32602, fmt.Sprintf("invalid parameters: %v", err)}
+		}
+	case "workspace/executeCommand":
+		var command lspExecuteCommandParams
+		if err := json.Unmarshal(message.Params, &command); err != nil {
+			return nil, &lspError{-32602, fmt.Sprintf("invalid parameters: %v", err)}
+		}
+		if err := server.executeCommand(command); err != nil {
+			return nil, &lspError{-32603, err.Error()}
+		}
+		return nil, nil
+	default:
+		if message.ID != nil {
+			return nil, &lspError{-32601, fmt.Sprintf("method %s is not supported", message.Method)}
+		}
+		return nil, nil
+	}
+	uri := params.TextDocument.URI
+	switch message.Method {
+	case "textDocument/didOpen":
+		server.documents[uri] = params.TextDocument.Text
+		server.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		if len(params.ContentChanges) > 0 {
+			server.documents[uri] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		}
+		server.publishDiagnostics(uri)
+	case "textDocument/didClose":
+		delete(server.documents, uri)
+		delete(server.results, uri)
+		server.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []lspDiagnostic{}})
+	case "textDocument/codeLens":
+		return server.codeLenses(uri), nil
+	case "textDocument/hover":
+		return server.hover(uri, params.Position.Line+1), nil
+	}
+	return nil, nil
+}
+
+// parse returns the structure of an open document and the interactions that would be executed, like those of a saved
+// document with the variables expanded and the variants of the expected responses selected
+func (server *lspServer) parse(uri string) (*tokenizer.Document, []*tokenizer.Interaction, error) {
+	text, ok := server.documents[uri]
+	if !ok {
+		return nil, nil, fmt.Errorf("the document %s is not open", uri)
+	}
+	return server.runner.DiscoverData(strings.TrimPrefix(uri, "file://"), []byte(text))
+}
+
+// codeLenses offers to execute the document, and every code block with interactions
+func (server *lspServer) codeLenses(uri string) []lspCodeLens {
+	lenses := []lspCodeLens{}
+	document, _, err := server.parse(uri)
+	if err != nil {
+		return lenses
+	}
+	if len(document.Interactions()) == 0 {
+		return lenses
+	}
+	lenses = append(lenses, lspCodeLens{lineRange(1), lspLensCommand{"▶ Run document", lspRunDocument, []interface{}{uri}}})
+	for _, block := range document.Blocks {
+		if len(block.Interactions) == 0 || block.Line < 1 {
+			continue
+		}
+		lenses = append(lenses, lspCodeLens{lineRange(block.Line), lspLensCommand{"▶ Run this block", lspRunBlock, []interface{}{uri, block.Line}}})
+	}
+	return lenses
+}
+
+// executeCommand executes the document or a code block, and publishes the results as diagnostics
+// A code block is executed in a new shell, only after the setup code blocks before it.
+func (server *lspServer) executeCommand(command lspExecuteCommandParams) error {
+	if len(command.Arguments) == 0 {
+		return fmt.Errorf("%s needs the URI of the document as its argument", command.Command)
+	}
+	var uri string
+	if err := json.Unmarshal(command.Arguments[0], &uri); err != nil {
+		return fmt.Errorf("invalid URI: %v", err)
+	}
+	document, interactions, err := server.parse(uri)
+	if err != nil {
+		return err
+	}
+	switch command.Command {
+	case lspRunDocument:
+	case lspRunBlock:
+		var line int
+		if len(command.Arguments) < 2 || json.Unmarshal(command.Arguments[1], &line) != nil {
+			return fmt.Errorf("%s needs the line of the code block as its second argument", command.Command)
+		}
+		interactions = selectedAt(document, interactions, line)
+	default:
+		return fmt.Errorf("unknown command %s", command.Command)
+	}
+	executed, err := server.executeInteractions(interactions)
+	server.merge(uri, executed)
+	server.publishDiagnostics(uri)
+	return err
+}
+
+// selectedAt returns the selected interactions of the code block at the line, and of the setup code blocks before it
+func selectedAt(document *tokenizer.Document, selected []*tokenizer.Interaction, line int) []*tokenizer.Interaction {
+	block := make(map[*tokenizer.Interaction]bool)
+	for _, interaction := range document.InteractionsAt(line) {
+		block[interaction] = true
+	}
+	var interactions []*tokenizer.Interaction
+	for _, interaction := range selected {
+		if block[interaction] {
+			interactions = append(interactions, interaction)
+		}
+	}
+	return interactions
+}
+
+// executeInteractions executes the interactions in order in a new shell and returns those that have been executed
+func (server *lspServer) executeInteractions(interactions []*tokenizer.Interaction) ([]*tokenizer.Interaction, error) {
+	shell, err := server.runner.StartShell()
+	if err != nil {
+		return nil, err
+	}
+	defer shell.Close()
+	var executed []*tokenizer.Interaction
+	for _, interaction := range interactions {
+		interaction.Timeout = options.timeout
+		interaction.KeepCarriageReturns = options.keepCR
+		if name, ok := interaction.Attributes[tokenizer.MatcherOption]; ok {
+			interaction.Matcher = plugin.NewMatcher(name)
+		}
+		interaction.ExecuteContext(context.Background(), shell)
+		executed = append(executed, interaction)
+		if interaction.TerminatedShell() {
+			break
+		}
+	}
+	return executed, nil
+}
+
+// merge records the executed interactions of a document, replacing earlier results for the same lines
+func (server *lspServer) merge(uri string, executed []*tokenizer.Interaction) {
+	lines := make(map[int]bool)
+	for _, interaction := range executed {
+		lines[interaction.Line] = true
+	}
+	results := executed
+	for _, interaction := range server.results[uri] {
+		if !lines[interaction.Line] {
+			results = append(results, interaction)
+		}
+	}
+	server.results[uri] = results
+}
+
+// current returns the executed interactions whose command is still in the same line of the document
+func (server *lspServer) current(uri string, document *tokenizer.Document) map[int]*tokenizer.Interaction {
+	commands := make(map[int]string)
+	for _, interaction := range document.Interactions() {
+		commands[interaction.Line] = interaction.Cmd
+	}
+	current := make(map[int]*tokenizer.Interaction)
+	for _, interaction := range server.results[uri] {
+		if command, ok := commands[interaction.Line]; ok && command == interaction.Cmd {
+			current[interaction.Line] = interaction
+		}
+	}
+	return current
+}
+
+// publishDiagnostics sends the problems found by lint and the failures of the executed interactions
+func (server *lspServer) publishDiagnostics(uri string) {
+	diagnostics := []lspDiagnostic{}
+	document, _, err := server.parse(uri)
+	if err != nil {
+		diagnostics = append(diagnostics, lspDiagnostic{lineRange(1), lspSeverityError, "shelldoc", err.Error()})
+	} else {
+		for _, diagnostic := range document.Diagnostics {
+			diagnostics = append(diagnostics, lspDiagnostic{lineRange(diagnostic.Line), lspSeverityWarning, "shelldoc", diagnostic.Message})
+		}
+		for line, interaction := range server.current(uri, document) {
+			if interaction.HasFailure() || interaction.ResultCode == tokenizer.ResultExecutionError {
+				diagnostics = append(diagnostics, lspDiagnostic{lineRange(line), lspSeverityError, "shelldoc", failureMessage(interaction.Result(), interaction)})
+			}
+		}
+	}
+	server.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// hover shows the result and the actual output of the interaction in the line, if it has been executed
+func (server *lspServer) hover(uri string, line int) *lspHover {
+	document, _, err := server.parse(uri)
+	if err != nil {
+		return nil
+	}
+	interaction, ok := server.current(uri, document)[line]
+	if !ok {
+		return nil
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "**%s** (exit code %d, %v)\n\n```\n", interaction.Result(), interaction.ExitCode, interaction.Duration.Round(time.Millisecond))
+	for _, output := range interaction.ComparedOutput() {
+		fmt.Fprintln(&text, output)
+	}
+	text.WriteString("```\n")
+	return &lspHover{lspMarkupContent{"markdown", text.String()}}
+}
+
+// lineRange returns the range of a line, which is specified starting at 1
+func lineRange(line int) lspRange {
+	line = max(line-1, 0)
+	return lspRange{lspPosition{line, 0}, lspPosition{line + 1, 0}}
+}
@@ -0,0 +1,118 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+// mergeReports reads result files written in the JSON or the JUnit format and writes them to w as one report in the
+// same format
+// All files need to be in the same format. JSON reports are merged using runner.Merge, JUnit reports by the names of
+// their test suites and test cases, keeping the most severe result of every test case.
+func mergeReports(w io.Writer, files []string) error {
+	var results []runner.Result
+	var reports []junitTestSuites
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read result file: %v", err)
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			var report junitTestSuites
+			if err := xml.Unmarshal(data, &report); err != nil {
+				return fmt.Errorf("unable to read JUnit report %s: %v", file, err)
+			}
+			reports = append(reports, report)
+		} else {
+			result, err := runner.ReadJSON(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			results = append(results, result)
+		}
+	}
+	if len(results) > 0 && len(reports) > 0 {
+		return errors.New("unable to merge JSON and JUnit reports, the result files need to be in the same format")
+	}
+	if len(reports) > 0 {
+		return encodeJUnitReport(w, mergeJUnitReports(reports))
+	}
+	return runner.WriteJSON(w, runner.Merge(results...))
+}
+
+// mergeJUnitReports combines JUnit reports, test suites and test cases with the same name are merged
+// The totals of the test suites and of the report are computed again from the merged test cases.
+func mergeJUnitReports(reports []junitTestSuites) junitTestSuites {
+	var merged junitTestSuites
+	suites := make(map[string]int)
+	cases := make(map[string]map[string]int)
+	for _, report := range reports {
+		for _, suite := range report.TestSuites {
+			position, ok := suites[suite.Name]
+			if !ok {
+				position = len(merged.TestSuites)
+				suites[suite.Name] = position
+				cases[suite.Name] = make(map[string]int)
+				merged.TestSuites = append(merged.TestSuites, junitTestSuite{Name: suite.Name})
+			}
+			target := &merged.TestSuites[position]
+			for _, testCase := range suite.TestCases {
+				index, ok := cases[suite.Name][testCase.Name]
+				if !ok {
+					cases[suite.Name][testCase.Name] = len(target.TestCases)
+					target.TestCases = append(target.TestCases, testCase)
+				} else if junitSeverity(testCase) > junitSeverity(target.TestCases[index]) {
+					target.TestCases[index] = testCase
+				}
+			}
+		}
+	}
+	for index := range merged.TestSuites {
+		suite := &merged.TestSuites[index]
+		var duration float64
+		for _, testCase := range suite.TestCases {
+			switch {
+			case testCase.Skipped != nil:
+				suite.Skipped++
+			case testCase.Error != nil:
+				suite.Errors++
+			case testCase.Failure != nil:
+				suite.Failures++
+			}
+			suite.Tests++
+			time, _ := strconv.ParseFloat(testCase.Time, 64)
+			duration += time
+		}
+		suite.Time = strconv.FormatFloat(duration, 'f', 3, 64)
+		merged.Tests += suite.Tests
+		merged.Failures += suite.Failures
+		merged.Errors += suite.Errors
+		merged.Skipped += suite.Skipped
+	}
+	return merged
+}
+
+// junitSeverity orders the results of JUnit test cases, skipped test cases have the lowest severity
+func junitSeverity(testCase junitTestCase) int {
+	switch {
+	case testCase.Error != nil:
+		return 3
+	case testCase.Failure != nil:
+		return 2
+	case testCase.Skipped != nil:
+		return 0
+	default:
+		return 1
+	}
+}
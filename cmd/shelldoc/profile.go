@@ -0,0 +1,63 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// cpuProfile is the file the CPU profile is written to while it is recorded
+var cpuProfile *os.File
+
+// startProfiling starts recording a CPU profile if --cpuprofile is specified
+func startProfiling() error {
+	if len(options.cpuProfile) == 0 || cpuProfile != nil {
+		return nil
+	}
+	file, err := os.Create(options.cpuProfile)
+	if err != nil {
+		return fmt.Errorf("unable to create the CPU profile: %v", err)
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to start the CPU profile: %v", err)
+	}
+	cpuProfile = file
+	return nil
+}
+
+// stopProfiling writes the CPU profile and the memory profile, if they were requested
+// It is called when the command finished, also if it failed. Problems are printed, they do not change the exit code.
+func stopProfiling() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write the CPU profile: %v\n", err)
+		}
+		cpuProfile = nil
+	}
+	if len(options.memProfile) > 0 {
+		if err := writeMemoryProfile(options.memProfile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// writeMemoryProfile writes a profile of the memory allocated since the program started to the file
+func writeMemoryProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create the memory profile: %v", err)
+	}
+	defer file.Close()
+	runtime.GC() // get up-to-date statistics
+	if err := pprof.Lookup("allocs").WriteTo(file, 0); err != nil {
+		return fmt.Errorf("unable to write the memory profile: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// defaultRecordingTitle is the title of recorded documents if none is specified
+const defaultRecordingTitle = "Recorded session"
+
+// recordedCommand is a command of a recorded session, with its output and exit code
+type recordedCommand struct {
+	command  string
+	output   []string
+	exitCode int
+}
+
+// record executes the commands read from in, one per line, in a shell and returns them with their output
+// The output of every command is echoed to out, followed by the prompt for the next command. The session ends at the
+// end of the input, or when exit is entered.
+func record(ctx context.Context, in io.Reader, out io.Writer) ([]recordedCommand, error) {
+	backend, err := newRunner().StartShell()
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+	var commands []recordedCommand
+	input := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "$ ")
+		if !input.Scan() {
+			fmt.Fprintln(out)
+			break
+		}
+		command := strings.TrimSpace(input.Text())
+		if command == "exit" {
+			break
+		}
+		if len(command) == 0 {
+			continue
+		}
+		output, _, rc, err := backend.Execute(ctx, command)
+		if err != nil {
+			return commands, fmt.Errorf("unable to record \"%s\": %v", command, err)
+		}
+		for _, line := range output {
+			fmt.Fprintln(out, line)
+		}
+		commands = append(commands, recordedCommand{command: command, output: output, exitCode: rc})
+	}
+	return commands, input.Err()
+}
+
+// writeRecording writes the commands as a Markdown document that shelldoc can execute
+// Consecutive successful commands share a code block. A command that failed gets a code block of its own, which
+// expects its exit code. Output lines that would be mistaken for commands are replaced by an ellipsis, together with
+// the rest of the output of the command.
+func writeRecording(w io.Writer, title string, commands []recordedCommand) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# %s\n", title)
+	open := false
+	closeBlock := func() {
+		if open {
+			builder.WriteString("```\n")
+			open = false
+		}
+	}
+	for _, command := range commands {
+		if command.exitCode != 0 {
+			closeBlock()
+			fmt.Fprintf(&builder, "\n```shell {%s=%d}\n", tokenizer.ExitCodeOption, command.exitCode)
+			open = true
+		} else if !open {
+			builder.WriteString("\n```shell\n")
+			open = true
+		}
+		fmt.Fprintf(&builder, "$ %s\n", command.command)
+		for _, line := range command.output {
+			if isPromptLine(line) {
+				builder.WriteString("...\n")
+				break
+			}
+			fmt.Fprintf(&builder, "%s\n", strings.TrimRight(line, " \t"))
+		}
+		if command.exitCode != 0 {
+			closeBlock()
+		}
+	}
+	closeBlock()
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// isPromptLine returns true if a line of output would be read as a command
+func isPromptLine(line string) bool {
+	trimmed := strings.TrimLeft(line, " \t")
+	return strings.HasPrefix(trimmed, "$") || strings.HasPrefix(trimmed, ">") || trimmed == "..."
+}
+
+// saveRecording writes the recorded document to the file, or to stdout if file is empty or "-"
+func saveRecording(file, title string, commands []recordedCommand) error {
+	if len(file) == 0 || file == "-" {
+		return writeRecording(os.Stdout, title, commands)
+	}
+	var builder strings.Builder
+	if err := writeRecording(&builder, title, commands); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(builder.String()), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", file, err)
+	}
+	return nil
+}
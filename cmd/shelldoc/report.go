@@ -0,0 +1,437 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/plugin"
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+const (
+	formatConsole    = "console"
+	formatCSV        = "csv"
+	formatGitLab     = "gitlab"
+	formatCheckstyle = "checkstyle"
+	formatJSON       = "json"
+	formatJUnit      = "junit"
+	formatAsciinema  = "asciinema"
+	formatDemo       = "demo"
+	// formatExitCode writes nothing, the result is only communicated by the exit code and the --report files
+	formatExitCode = "exitcode"
+)
+
+// formats lists the supported output formats
+var formats = []string{formatConsole, formatCSV, formatGitLab, formatCheckstyle, formatJUnit, formatJSON, formatAsciinema, formatDemo, formatExitCode}
+
+// reportFormats lists the formats that can be written to a file using --report
+var reportFormats = []string{formatCSV, formatGitLab, formatCheckstyle, formatJUnit, formatJSON, formatAsciinema, formatDemo}
+
+// validateFormat returns an error if the output format is not one of the supported formats
+func validateFormat(format string, supportedFormats []string) error {
+	for _, supported := range supportedFormats {
+		if format == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format \"%s\", supported formats are %s", format, strings.Join(supportedFormats, ", "))
+}
+
+// formatReporter writes the results in one of the output formats, or using a reporter plugin
+// The report is written to the writer if path is empty or "-", and to the file at path otherwise.
+type formatReporter struct {
+	format string
+	plugin bool
+	path   string
+	w      io.Writer
+}
+
+// Report writes the results of all documents in the format of the reporter
+func (reporter formatReporter) Report(result runner.Result) error {
+	if len(reporter.path) == 0 || reporter.path == "-" {
+		return reporter.write(reporter.w, result)
+	}
+	file, err := os.Create(reporter.path)
+	if err != nil {
+		return fmt.Errorf("unable to write %s report: %v", reporter.format, err)
+	}
+	if err := reporter.write(file, result); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// write writes the report to w
+func (reporter formatReporter) write(w io.Writer, result runner.Result) error {
+	if reporter.plugin {
+		return plugin.Reporter{Name: reporter.format, Output: w}.Report(result)
+	}
+	return writeReport(w, reporter.format, result.Documents)
+}
+
+// parseReport parses a --report specification in FORMAT=FILE form
+// FORMAT is one of the report formats, or the name of a reporter plugin.
+func parseReport(spec string) (formatReporter, error) {
+	elements := strings.SplitN(spec, "=", 2)
+	if len(elements) != 2 || len(elements[1]) == 0 {
+		return formatReporter{}, fmt.Errorf("invalid report \"%s\", use FORMAT=FILE", spec)
+	}
+	reporter := formatReporter{format: elements[0], path: elements[1], w: os.Stdout}
+	if err := validateFormat(elements[0], reportFormats); err != nil {
+		if _, pluginErr := plugin.Find(plugin.KindReporter, elements[0]); pluginErr != nil || elements[0] == formatConsole {
+			return formatReporter{}, fmt.Errorf("%v, or the name of a reporter plugin", err)
+		}
+		reporter.plugin = true
+	}
+	return reporter, nil
+}
+
+// reporters returns the reporters selected by --format, --report, --webhook, --history and --github
+// The console format is written while the interactions are executed, it does not need a reporter, and the exitcode
+// format writes nothing.
+func reporters() (runner.Reporters, error) {
+	var result runner.Reporters
+	if options.format != formatConsole && options.format != formatExitCode {
+		result = append(result, formatReporter{format: options.format, w: os.Stdout})
+	}
+	if options.atLine > 0 {
+		result = append(result, positionReporter{w: console()})
+	}
+	for _, spec := range options.reports {
+		reporter, err := parseReport(spec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, reporter)
+	}
+	for _, spec := range options.webhooks {
+		webhook, err := parseWebhook(spec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	if len(options.history) > 0 {
+		result = append(result, historyReporter{path: options.history})
+	}
+	if len(options.github) > 0 {
+		github, err := newGitHubReporter(options.github, options.githubRepo, options.githubSHA)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, github)
+	}
+	return result, nil
+}
+
+// positionReporter writes the result of every interaction with its position in the document
+// Editors can use it to jump to the interactions executed with --at-line.
+type positionReporter struct {
+	w io.Writer
+}
+
+// Report writes one FILE:LINE: RESULT line per interaction
+func (reporter positionReporter) Report(result runner.Result) error {
+	for _, document := range result.Documents {
+		for _, interaction := range document.Interactions {
+			fmt.Fprintf(reporter.w, "%s:%d: %s\n", document.File, interaction.Line, interaction.ResultCode)
+		}
+	}
+	return nil
+}
+
+// writeReport writes the results of all documents in the selected format
+// The console format is written while the interactions are executed, so there is nothing left to do for it.
+func writeReport(w io.Writer, format string, documents []runner.DocumentResult) error {
+	switch format {
+	case formatCSV:
+		return writeCSVReport(w, documents)
+	case formatGitLab:
+		return writeGitLabReport(w, documents)
+	case formatCheckstyle:
+		return writeCheckstyleReport(w, documents)
+	case formatJUnit:
+		return writeJUnitReport(w, documents)
+	case formatJSON:
+		return writeJSONReport(w, documents)
+	case formatAsciinema:
+		return writeAsciinemaReport(w, documents)
+	case formatDemo:
+		return writeDemoReport(w, documents)
+	default:
+		return nil
+	}
+}
+
+// writeCSVReport writes one row per interaction, the duration is specified in seconds
+// The ID column is last, so that consumers of the earlier columns are not affected.
+func writeCSVReport(w io.Writer, documents []runner.DocumentResult) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"file", "line", "caption", "command", "result", "duration", "id"})
+	for _, document := range documents {
+		for _, interaction := range document.Interactions {
+			writer.Write([]string{
+				document.File,
+				strconv.Itoa(interaction.Line),
+				interaction.Caption,
+				interaction.Cmd,
+				interaction.ResultCode.String(),
+				strconv.FormatFloat(interaction.Duration.Seconds(), 'f', 3, 64),
+				interaction.ID,
+			})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("unable to write CSV report: %v", err)
+	}
+	return nil
+}
+
+// isReported returns true if the interaction should be reported as a problem in the failure-only formats
+// Skipped interactions are problems if skipping is not allowed, quarantined interactions are not.
+func isReported(interaction *tokenizer.Interaction) bool {
+	if interaction.ResultCode == tokenizer.ResultSkipped {
+		return options.noSkips
+	}
+	if interaction.Quarantined() {
+		return false
+	}
+	return interaction.HasFailure() || interaction.ResultCode == tokenizer.ResultExecutionError
+}
+
+// failureMessage describes why an interaction failed, starting with the result
+// Machine-readable reports pass the ResultCode, which does not depend on the language, editors the localized Result.
+func failureMessage(result string, interaction *tokenizer.Interaction) string {
+	message := fmt.Sprintf("%s: %s", result, interaction.Cmd)
+	if len(interaction.Comment) > 0 {
+		message = fmt.Sprintf("%s (%s)", message, interaction.Comment)
+	}
+	return message
+}
+
+// gitLabIssue is an entry in a GitLab code quality report
+type gitLabIssue struct {
+	Description string         `json:"description"`
+	CheckName   string         `json:"check_name"`
+	Fingerprint string         `json:"fingerprint"`
+	Severity    string         `json:"severity"`
+	Location    gitLabLocation `json:"location"`
+}
+
+type gitLabLocation struct {
+	Path  string      `json:"path"`
+	Lines gitLabLines `json:"lines"`
+}
+
+type gitLabLines struct {
+	Begin int `json:"begin"`
+}
+
+// writeGitLabReport writes the failed interactions as a GitLab code quality report
+// The report is picked up by specifying it under artifacts:reports:codequality in .gitlab-ci.yml.
+func writeGitLabReport(w io.Writer, documents []runner.DocumentResult) error {
+	issues := []gitLabIssue{}
+	for _, document := range documents {
+		for _, interaction := range document.Interactions {
+			if !isReported(interaction) {
+				continue
+			}
+			fingerprint := sha1.Sum([]byte(fmt.Sprintf("%s:%d:%s", document.File, interaction.Line, interaction.Cmd)))
+			if len(interaction.ID) > 0 {
+				fingerprint = sha1.Sum([]byte(interaction.ID)) // stable if the line of the interaction changes
+			}
+			issues = append(issues, gitLabIssue{
+				Description: failureMessage(interaction.ResultCode.String(), interaction),
+				CheckName:   "shelldoc",
+				Fingerprint: fmt.Sprintf("%x", fingerprint),
+				Severity:    "major",
+				Location:    gitLabLocation{document.File, gitLabLines{interaction.Line}},
+			})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(issues); err != nil {
+		return fmt.Errorf("unable to write GitLab report: %v", err)
+	}
+	return nil
+}
+
+type checkstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// writeCheckstyleReport writes the failed interactions in the checkstyle XML format
+// Every document is listed, documents without failures have no error elements.
+func writeCheckstyleReport(w io.Writer, documents []runner.DocumentResult) error {
+	report := checkstyleReport{Version: "4.3"}
+	for _, document := range documents {
+		file := checkstyleFile{Name: document.File}
+		for _, interaction := range document.Interactions {
+			if !isReported(interaction) {
+				continue
+			}
+			file.Errors = append(file.Errors, checkstyleError{interaction.Line, "error", failureMessage(interaction.ResultCode.String(), interaction), "shelldoc"})
+		}
+		report.Files = append(report.Files, file)
+	}
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("unable to write checkstyle report: %v", err)
+	}
+	io.WriteString(w, "\n")
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure"`
+	Error      *junitMessage   `xml:"error"`
+	Skipped    *junitMessage   `xml:"skipped"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// seconds formats a duration in seconds, as used in the JUnit format
+func seconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+// writeJUnitReport writes the results in the JUnit XML format understood by most CI servers
+// Every document is a test suite, and every interaction a test case in it. Interactions that have not been executed
+// are reported as skipped.
+func writeJUnitReport(w io.Writer, documents []runner.DocumentResult) error {
+	report := junitTestSuites{}
+	for _, document := range documents {
+		suite := junitTestSuite{Name: document.File}
+		var duration time.Duration
+		for _, interaction := range document.Interactions {
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("line %d: %s", interaction.Line, interaction.Name()),
+				ClassName: document.File,
+				Time:      seconds(interaction.Duration),
+				SystemOut: strings.Join(interaction.Output, "\n"),
+			}
+			if len(interaction.ID) > 0 {
+				testCase.Properties = append(testCase.Properties, junitProperty{"shelldoc.id", interaction.ID})
+			}
+			duration += interaction.Duration
+			switch {
+			case interaction.ResultCode == tokenizer.NewInteraction:
+				testCase.Skipped = &junitMessage{Message: "not executed"}
+			case interaction.ResultCode == tokenizer.ResultSkipped && !options.noSkips:
+				testCase.Skipped = &junitMessage{Message: interaction.Comment}
+				if len(interaction.SkipReason) > 0 {
+					testCase.Properties = append(testCase.Properties, junitProperty{"shelldoc.skip_reason", string(interaction.SkipReason)})
+				}
+			case interaction.Quarantined():
+				testCase.Skipped = &junitMessage{fmt.Sprintf("quarantined as flaky (%s): %s", interaction.Attributes[tokenizer.FlakyOption], failureMessage(interaction.ResultCode.String(), interaction)), interaction.Cmd}
+			case interaction.ResultCode == tokenizer.ResultExecutionError:
+				testCase.Error = &junitMessage{failureMessage(interaction.ResultCode.String(), interaction), interaction.Cmd}
+			case isReported(interaction):
+				testCase.Failure = &junitMessage{failureMessage(interaction.ResultCode.String(), interaction), interaction.Cmd}
+			}
+			switch {
+			case testCase.Skipped != nil:
+				suite.Skipped++
+			case testCase.Error != nil:
+				suite.Errors++
+			case testCase.Failure != nil:
+				suite.Failures++
+			}
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suite.Time = seconds(duration)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.TestSuites = append(report.TestSuites, suite)
+	}
+	return encodeJUnitReport(w, report)
+}
+
+// encodeJUnitReport writes the JUnit XML document
+func encodeJUnitReport(w io.Writer, report junitTestSuites) error {
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("unable to write JUnit report: %v", err)
+	}
+	io.WriteString(w, "\n")
+	return nil
+}
+
+// writeJSONReport writes the results of all documents and interactions as JSON
+// The report uses the versioned schema of the runner package, and can be read using runner.ReadJSON.
+func writeJSONReport(w io.Writer, documents []runner.DocumentResult) error {
+	result := runner.Result{ReturnCode: runner.ReturnSuccess, Documents: documents}
+	for _, document := range documents {
+		result.ReturnCode = max(result.ReturnCode, document.ReturnCode)
+	}
+	return runner.WriteJSON(w, result)
+}
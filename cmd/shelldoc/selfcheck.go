@@ -0,0 +1,150 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+const (
+	// selfcheckTool is the name of the tool whose version the variants in the self check depend on
+	// Its version is set by the self check, so that the variants do not depend on the tools that are installed.
+	selfcheckTool = "shelldoc-selfcheck"
+	// selfcheckToolVersion is the version of the selfcheckTool
+	selfcheckToolVersion = "1.2.3"
+)
+
+// selfcheckDocument is one of the documents executed by the self check
+type selfcheckDocument struct {
+	name    string
+	content string
+}
+
+// selfcheckDocuments cover the features and the built-in matchers of shelldoc
+// They only use commands that are available in every POSIX environment, and need to pass with every supported shell.
+var selfcheckDocuments = []selfcheckDocument{
+	{"commands.md", `# Commands and expected responses
+
+` + "```shell {shelldocsetup}" + `
+$ export SELFCHECK=$(mktemp -d)
+$ cd "$SELFCHECK"
+` + "```" + `
+
+    $ echo "Hello World"
+    Hello World
+
+    $ printf 'one\ntwo\nthree\n'
+    one
+    ...
+
+    $ echo "Took 0.25s"
+    re:Took [0-9.]+s
+
+` + "```shell {shelldocscript}" + `
+$ for word in Hello World; do
+> echo $word
+> done
+Hello
+World
+` + "```" + `
+
+    $ cd / && rm -rf "$SELFCHECK"
+`},
+	{"options.md", `# Options
+
+` + "```shell {shelldocexitcode=2}" + `
+$ (exit 2)
+` + "```" + `
+
+` + "```shell {shelldocwhatever}" + `
+$ false
+` + "```" + `
+
+` + "```shell {shelldocempty}" + `
+$ true
+` + "```" + `
+
+` + "```shell {shelldoctimeout=10s}" + `
+$ sleep 0
+` + "```" + `
+
+` + "```shell {shelldocxfail=selfcheck}" + `
+$ echo Goodbye
+Hello
+` + "```" + `
+`},
+	{"assertions.md", `# State assertions
+
+` + "```shell {shelldocexpectenv=GREETING=Hello shelldocexpectcwd=selfcheck shelldocexpectfunction=greet}" + `
+$ export SELFCHECK=$(mktemp -d)
+$ mkdir "$SELFCHECK/selfcheck" && cd "$SELFCHECK/selfcheck"
+$ export GREETING=Hello
+$ greet() { echo "$GREETING"; }
+` + "```" + `
+
+` + "```shell {shelldocexpectfile=greeting.txt shelldocexpectfilecontains=greeting.txt:Hello shelldocexpectdirempty=empty}" + `
+$ greet > greeting.txt
+$ mkdir empty
+` + "```" + `
+
+    $ cd / && rm -rf "$SELFCHECK"
+`},
+	{"matchers.md", `# Built-in matchers
+
+` + "```shell {shelldocmatcher=http}" + `
+$ printf 'HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nDate: Thu, 15 Oct 2026 12:00:00 GMT\r\n\r\n{"status": "ok", "version": 3}\n'
+HTTP/1.1 200 OK
+Content-Type: application/json
+{"status": "ok"}
+` + "```" + `
+
+` + "```shell {shelldocmatcher=locale}" + `
+$ printf 'Total: %s EUR on %s\n' 1.234,56 15.10.2026
+Total: 1,234.56 EUR on 2026-10-15
+` + "```" + `
+`},
+	{"variants.md", `# Responses per platform and tool version
+
+    $ uname -s
+    ...
+    expect[linux]
+    Linux
+    expect[darwin]
+    Darwin
+    expect[freebsd]
+    FreeBSD
+
+    $ echo 1.2
+    expect[` + selfcheckTool + `>=1.2]
+    1.2
+    expect[` + selfcheckTool + `<1.2]
+    1.1
+`},
+}
+
+// selfcheck writes the embedded documents to a temporary directory and executes them with the configured shell
+func selfcheck(ctx context.Context) (runner.Result, error) {
+	directory, err := ioutil.TempDir("", "shelldoc-selfcheck")
+	if err != nil {
+		return runner.Result{}, fmt.Errorf("unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	var files []string
+	for _, document := range selfcheckDocuments {
+		path := filepath.Join(directory, document.name)
+		if err := ioutil.WriteFile(path, []byte(document.content), 0644); err != nil {
+			return runner.Result{}, fmt.Errorf("unable to write %s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+	options.toolVersions = append(options.toolVersions, selfcheckTool+"="+selfcheckToolVersion)
+	return newRunner().Run(ctx, files)
+}
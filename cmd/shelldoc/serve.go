@@ -0,0 +1,365 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// defaultListenAddress only accepts local connections, since the server executes the commands it is sent
+const defaultListenAddress = "localhost:8080"
+
+// The states of a run submitted to the server
+const (
+	runRunning  = "running"
+	runFinished = "finished"
+	runFailed   = "failed"
+)
+
+// runRequest submits a run, either the documents at the paths on the server, or the text of a document
+type runRequest struct {
+	Paths    []string `json:"paths,omitempty"`
+	Document string   `json:"document,omitempty"`
+}
+
+// serverRun is a run submitted to the server
+type serverRun struct {
+	ID       int            `json:"id"`
+	Status   string         `json:"status"`
+	Files    []string       `json:"files"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Result   *runner.Result `json:"result,omitempty"`
+}
+
+// server executes documents on request and keeps the results of all runs in memory
+type server struct {
+	mutex sync.Mutex
+	runs  []*serverRun
+	// directory receives the documents submitted as text
+	directory string
+	// address is the address the server listens on, requests for other hosts are rejected
+	address string
+	// token authorizes the submission of runs, as a bearer token in the Authorization header
+	token string
+}
+
+// serve runs the HTTP server until it fails
+// Runs are only accepted with the token, which is generated if it is empty, so that other web pages the browser of a
+// user visits cannot submit commands to execute.
+func serve(address, token string) error {
+	if len(token) == 0 {
+		var err error
+		if token, err = generateToken(); err != nil {
+			return err
+		}
+	}
+	directory, err := ioutil.TempDir("", "shelldoc-serve")
+	if err != nil {
+		return fmt.Errorf("unable to create a directory for submitted documents: %v", err)
+	}
+	defer os.RemoveAll(directory)
+	options.quiet = true
+	fmt.Printf("SHELLDOC: serving on http://%s/, submit runs with the header \"Authorization: Bearer %s\"\n", address, token)
+	return http.ListenAndServe(address, newServer(directory, address, token).handler())
+}
+
+// generateToken returns a random token that authorizes the submission of runs
+func generateToken() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("unable to generate a token: %v", err)
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// newServer creates a server for the listen address that stores the submitted documents in directory and accepts
+// runs submitted with token
+func newServer(directory, address, token string) *server {
+	return &server{directory: directory, address: address, token: token}
+}
+
+// handler returns the routes of the server
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/runs", s.handleRuns)
+	mux.HandleFunc("/api/runs/", s.handleRun)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin rejects requests for another host than the listen address, which a DNS rebinding attack sends, and
+// requests from web pages of another origin
+// Any host is accepted if the server listens on all interfaces.
+func (s *server) checkOrigin(r *http.Request) error {
+	host, _, err := net.SplitHostPort(s.address)
+	unspecified := err == nil && (len(host) == 0 || net.ParseIP(host) != nil && net.ParseIP(host).IsUnspecified())
+	if !unspecified && !strings.EqualFold(r.Host, s.address) {
+		return fmt.Errorf("the host %s is not the address of the server", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); len(origin) > 0 {
+		parsed, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(parsed.Host, r.Host) {
+			return fmt.Errorf("requests from %s are not accepted", origin)
+		}
+	}
+	return nil
+}
+
+// authorize checks that a run is submitted as JSON with the token of the server
+// Browsers send requests to other origins without asking only with other content types, like text/plain.
+func (s *server) authorize(r *http.Request) (int, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("runs need to be submitted as application/json")
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(s.token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return http.StatusUnauthorized, fmt.Errorf("runs need to be submitted with the token printed when the server started")
+	}
+	return http.StatusOK, nil
+}
+
+// handleRuns lists the runs (GET), or submits a new one (POST)
+func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		writeJSON(w, http.StatusOK, s.runs)
+	case http.MethodPost:
+		if status, err := s.authorize(r); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		var request runRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		run, err := s.submit(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRun returns the status and, once it finished, the results of a run
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run := s.lookup(strings.TrimPrefix(r.URL.Path, "/api/runs/"))
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, run)
+}
+
+// lookup returns the run with the given ID, or nil if there is none
+func (s *server) lookup(id string) *serverRun {
+	index, err := strconv.Atoi(id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil || index < 1 || index > len(s.runs) {
+		return nil
+	}
+	return s.runs[index-1]
+}
+
+// submit registers a run and executes it in the background
+func (s *server) submit(request runRequest) (serverRun, error) {
+	var files []string
+	for _, path := range request.Paths {
+		found, err := documentsIn(path)
+		if err != nil {
+			return serverRun{}, err
+		}
+		files = append(files, found...)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := len(s.runs) + 1
+	if len(request.Document) > 0 {
+		file := filepath.Join(s.directory, fmt.Sprintf("document-%d.md", id))
+		if err := ioutil.WriteFile(file, []byte(request.Document), 0644); err != nil {
+			return serverRun{}, fmt.Errorf("unable to store the document: %v", err)
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return serverRun{}, fmt.Errorf("no documents specified, submit paths or a document")
+	}
+	run := &serverRun{ID: id, Status: runRunning, Files: files, Started: time.Now()}
+	s.runs = append(s.runs, run)
+	go s.execute(run)
+	return *run, nil
+}
+
+// execute runs the documents and records the results
+func (s *server) execute(run *serverRun) {
+	result, err := newRunner().Run(context.Background(), run.Files)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	finished := time.Now()
+	run.Finished = &finished
+	run.Result = &result
+	run.Status = runFinished
+	if err != nil {
+		log.Printf("Run %d failed: %v", run.ID, err)
+		run.Status = runFailed
+		run.Error = err.Error()
+	}
+}
+
+// documentsIn returns the document at path, or the documents in the directory at path and its subdirectories
+func documentsIn(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isDocument(file) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// writeJSON writes value as the JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Unable to write the response: %v", err)
+	}
+}
+
+// highlightDifference returns the first expected line and line of output that differ if the interaction failed as a
+// mismatch, as HTML with the differing characters marked, and an empty string otherwise
+func highlightDifference(interaction *tokenizer.Interaction) template.HTML {
+	mismatch, ok := interaction.Err.(*tokenizer.MismatchError)
+	if !ok {
+		return ""
+	}
+	expected, actual, ok := mismatch.FirstDifference()
+	if !ok {
+		return ""
+	}
+	expectedSegments, actualSegments := tokenizer.DiffLine(expected, actual)
+	var result strings.Builder
+	for _, line := range []struct {
+		label    string
+		segments []tokenizer.Segment
+	}{{tokenizer.Translate(tokenizer.MessageExpectedLine), expectedSegments}, {tokenizer.Translate(tokenizer.MessageReceivedLine), actualSegments}} {
+		fmt.Fprintf(&result, "<pre>%s: ", line.label)
+		for _, segment := range line.segments {
+			if segment.Changed {
+				fmt.Fprintf(&result, "<mark>%s</mark>", template.HTMLEscapeString(segment.Text))
+			} else {
+				result.WriteString(template.HTMLEscapeString(segment.Text))
+			}
+		}
+		result.WriteString("</pre>")
+	}
+	return template.HTML(result.String())
+}
+
+// indexTemplate renders the web UI, which lists the runs with the verdict of every document, and the failed
+// interactions with the differences of mismatched lines
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{"verdict": runner.Verdict, "difference": highlightDifference}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>shelldoc</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+td, th { padding: 0.2em 1em; text-align: left; }
+.SUCCESS { color: green; } .FAILURE { color: red; } .ERROR { color: darkorange; }
+pre { margin: 0.2em 0; } mark { background: #fcc; }
+</style>
+</head>
+<body>
+<h1>shelldoc</h1>
+{{if not .}}<p>No runs have been submitted yet.</p>{{end}}
+{{range .}}
+<h2><a href="/api/runs/{{.ID}}">Run {{.ID}}</a>: {{.Status}}</h2>
+<p>Started {{.Started.Format "2006-01-02 15:04:05"}}{{with .Error}}, {{.}}{{end}}</p>
+{{with .Result}}
+<table>
+<tr><th>Document</th><th>Result</th><th>Tests</th><th>Successful</th><th>Failures</th><th>Errors</th><th>Skipped</th></tr>
+{{range .Documents}}<tr><td>{{.File}}</td><td class="{{verdict .ReturnCode}}">{{verdict .ReturnCode}}</td><td>{{.TestCount}}</td><td>{{.SuccessCount}}</td><td>{{.FailureCount}}</td><td>{{.ErrorCount}}</td><td>{{.SkipCount}}</td></tr>
+{{$file := .File}}{{range .Interactions}}{{if .HasFailure}}<tr><td colspan="7">{{$file}}:{{.Line}}: {{.Result}}: <code>{{.Cmd}}</code>{{difference .}}</td></tr>
+{{end}}{{end}}{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// handleIndex renders the web UI, the most recent run first
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var runs []serverRun
+	for index := len(s.runs) - 1; index >= 0; index-- {
+		runs = append(runs, *s.runs[index])
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, runs); err != nil {
+		log.Printf("Unable to render the index page: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseShard parses a --shard value in INDEX/TOTAL form, like 2/5, the index counts from 1
+func parseShard(value string) (int, int, error) {
+	elements := strings.SplitN(value, "/", 2)
+	if len(elements) == 2 {
+		index, indexErr := strconv.Atoi(elements[0])
+		total, totalErr := strconv.Atoi(elements[1])
+		if indexErr == nil && totalErr == nil && total > 0 && index > 0 && index <= total {
+			return index, total, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid value \"%s\" for --shard, use INDEX/TOTAL like 2/5, with INDEX between 1 and TOTAL", value)
+}
+
+// shardDocuments returns the documents in the shard with the given index out of total shards
+// Every document is assigned to a shard by a hash of its path, so that the shards of a CI pipeline that all specify
+// the same documents execute each of them exactly once, without coordinating. The assignment of a document does not
+// change when other documents are added or removed, but the shards may differ in size.
+func shardDocuments(files []string, index, total int) []string {
+	var result []string
+	for _, file := range files {
+		hash := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean(file))))
+		if binary.BigEndian.Uint64(hash[:8])%uint64(total) == uint64(index-1) {
+			result = append(result, file)
+		}
+	}
+	return result
+}
@@ -5,27 +5,111 @@ package main
 // SPDX-License-Identifier: GPL-3.0
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
+	"os/signal"
+	"time"
 
+	"github.com/endocode/shelldoc/pkg/plugin"
+	"github.com/endocode/shelldoc/pkg/runner"
 	"github.com/endocode/shelldoc/pkg/shell"
-	"github.com/endocode/shelldoc/pkg/tokenizer"
-	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
-	returnSuccess = iota
-	returnFailure
-	returnError
+	returnSuccess = runner.ReturnSuccess
+	returnFailure = runner.ReturnFailure
+	returnError   = runner.ReturnError
+)
+
+const (
+	failFastDocument = runner.FailFastDocument
+	failFastRun      = runner.FailFastRun
+	leaksWarn        = runner.LeaksWarn
+	leaksFail        = runner.LeaksFail
+)
+
+// The values of --color
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
 )
 
 // Options contains the context of a program invocation
 type Options struct {
-	shell   string // The shell to invoke
-	verbose bool   // Enable trace log output
+	shell        string            // The shell to invoke
+	shellCmd     string            // The command line that launches the shell, overrides shell
+	backend      string            // The backend plugin that executes the commands instead of a local shell
+	verbose      bool              // Enable trace log output
+	otelEndpoint string            // The OTLP/HTTP endpoint traces are sent to, tracing is disabled if empty
+	format       string            // The output format of the results
+	reports      []string          // Additional reports in FORMAT=FILE form
+	webhooks     []string          // Webhooks notified about failed runs, in KIND=URL form
+	github       string            // Post the results to GitHub as a commit status or a check run
+	githubRepo   string            // The GitHub repository the results are posted to, as OWNER/NAME
+	githubSHA    string            // The commit the results are posted for
+	transcripts  string            // The directory the transcripts of the shell sessions are written to
+	snapshotDir  string            // The directory the actual output of every interaction is written to
+	captions     string            // The template of the descriptions of interactions without a caption
+	color        string            // Highlight the differences of mismatched lines with colors (auto, always, never)
+	configFile   string            // The configuration file to load
+	profile      string            // The profile in the configuration file to apply
+	beforeRun    string            // The command to execute before the documents
+	afterRun     string            // The command to execute after the documents
+	strict       bool              // Enforce the naming policy for interactions
+	coverage     bool              // Enforce that every shell code block is executed or marked as not tested
+	languages    []string          // Only execute code blocks in these languages
+	encoding     string            // The encoding of the documents, detected if empty
+	locale       string            // The locale of the result messages and summaries
+	keepCR       bool              // Keep Windows line breaks in documents and output
+	excludes     []string          // Skip documents matching these patterns
+	env          map[string]string // Additional environment variables for the shell
+	toolVersions []string          // The versions of tools for the variants of expected responses, in NAME=VERSION form
+	variables    []string          // Override the variables of the documents, in NAME=VALUE form
+	reproducible bool              // Set up the environment of the shell so that the output is reproducible
+	faketime     bool              // Preload libfaketime in deterministic mode
+	run          string            // Only execute interactions with a name or heading matching this regular expression
+	atLine       int               // Only execute the code block at this line, and the setup code blocks before it
+	failFast     string            // Stop executing the document (or the whole run) after the first failure
+	watch        bool              // Execute the documents again whenever they change
+	shuffle      string            // Randomize the order of the documents, using this seed if it is an integer
+	shard        string            // Only execute the documents in this shard, in INDEX/TOTAL form
+	count        int               // Execute the whole run this many times
+	maxFailures  int               // Abort the run after this many failed interactions, unlimited if zero
+	noSkips      bool              // Treat skipped interactions as failures
+	reuse        bool              // Reuse the shells of finished documents for the following ones
+	shareSession bool              // Execute the documents in one shell session, in the order they are given
+	leaks        string            // Report (warn) or fail (fail) documents that leak state into the shell
+	cached       bool              // Use the cached results of unchanged documents that passed before
+	incremental  string            // The file recording the code blocks that passed, only changed ones are executed
+	history      string            // The file the results of every run are appended to, or that is queried
+	maxOutput    int               // The number of bytes of the output of a command that is kept
+	spillOutput  string            // The directory the whole output of commands exceeding maxOutput is written to
+	failOnLimit  bool              // Terminate commands exceeding maxOutput and fail them
+	inputGrace   time.Duration     // The time a command may wait for input, zero disables it
+	timeout      time.Duration     // The time a command may take, zero means no timeout
+	fileTimeout  time.Duration     // The time all commands in a document may take, zero means no timeout
+	delay        time.Duration     // The pause between the interactions of a document
+	columns      int               // The terminal width set in COLUMNS for the shell, unchanged if zero
+	lines        int               // The terminal height set in LINES for the shell, unchanged if zero
+	changedOnly  string            // Only execute the documents that differ from this git ref
+	force        bool              // Overwrite existing files when creating the example document
+	exampleFile  string            // The name of the example document
+	check        bool              // Only check if the documents are formatted canonically
+	inPlace      bool              // Annotate the documents themselves instead of copies
+	graph        string            // List the execution order of the code blocks as a graph in this format
+	quiet        bool              // Suppress the progress output, because the command writes its own, or with --quiet
+	listen       string            // The address the HTTP server listens on
+	token        string            // The token runs are submitted to the HTTP server with
+	title        string            // The title of recorded and imported documents
+	prompt       string            // The regular expression matching the prompts in imported recordings
+	cpuProfile   string            // The file a CPU profile is written to
+	memProfile   string            // The file a memory profile is written to
 }
 
 // global variables
@@ -38,24 +122,9 @@ func max(a, b int) int { // really, golang?
 	return b
 }
 
-func result(code int) string {
-	switch code {
-	case returnFailure:
-		return "FAILURE"
-	case returnError:
-		return "ERROR"
-	default:
-		return "SUCCESS"
-	}
-}
-
-type resultStats struct {
-	returncode, testCount, successCount, failureCount, errorCount int
-}
-
 func initializeLogging() {
 	// verbose essentially enables or disables log output:
-	if options.verbose {
+	if options.verbose && !options.quiet {
 		log.SetOutput(os.Stderr)
 	} else {
 		log.SetOutput(ioutil.Discard)
@@ -64,82 +133,181 @@ func initializeLogging() {
 	log.SetPrefix("Note: ")
 }
 
-func performInteractions(inputfile string) (resultStats, error) {
-	// detect shell
-	shellpath, err := shell.DetectShell(options.shell)
-	if err != nil {
-		return resultStats{}, err
+// console returns the writer for the human readable progress output
+// It is silenced if the results are written in a machine readable format.
+func console() io.Writer {
+	if options.format == formatConsole && !options.quiet {
+		return os.Stdout
 	}
+	return ioutil.Discard
+}
 
-	// start a background shell, it will run until the function ends
-	shell, err := shell.StartShell(shellpath)
-	if err != nil {
-		return resultStats{}, fmt.Errorf("unable to start shell: %v", err)
+// printError prints an error of the run with the progress output, unless everything is silenced with --quiet
+func printError(err error) {
+	if !options.quiet {
+		fmt.Println(err) // log may be disabled (see "verbose")
 	}
-	defer shell.Exit()
+}
 
-	// read input data
-	data, err := ReadInput([]string{inputfile})
-	if err != nil {
-		return resultStats{}, fmt.Errorf("unable to read input data: %v", err)
-	}
-	// run the input through the tokenizer
-	visitor := tokenizer.NewInteractionVisitor()
-	tokenizer.Tokenize(data, visitor)
-
-	// execute the interactions and verify the results:
-	fmt.Printf("SHELLDOC: doc-testing \"%s\" ...\n", inputfile)
-	results := resultStats{returnSuccess, 0, 0, 0, 0}
-	// construct the opener and closer format strings, since they depend on verbose mode
-	magnitude := int(math.Log10(float64(len(visitor.Interactions)))) + 1
-	openerLineEnding := "  : "
-	resultString := " "
-	if options.verbose {
-		openerLineEnding = "\n"
-		resultString = " <-- "
-	}
-	counterFormat := fmt.Sprintf("%%%ds", magnitude+2)
-	opener := fmt.Sprintf(" CMD %s: %%s%s", counterFormat, openerLineEnding)
-	closer := fmt.Sprintf("%s%%s\n", resultString)
-
-	for index, interaction := range visitor.Interactions {
-		results.testCount++
-		fmt.Printf(opener, fmt.Sprintf("(%d)", index+1), interaction.Describe())
-
-		if options.verbose {
-			fmt.Printf(" --> %s\n", interaction.Cmd)
-		}
-		if err := interaction.Execute(&shell); err != nil {
-			fmt.Printf(" --  ERROR: %v", err)
-			results.returncode = max(results.returncode, returnError)
-			results.errorCount++
-		}
-		fmt.Printf(closer, interaction.Result())
-		if interaction.HasFailure() {
-			results.returncode = max(results.returncode, returnFailure)
-			results.failureCount++
-		} else {
-			results.successCount++
-		}
+// useColor returns true if the differences of mismatched lines are highlighted with colors in the progress output
+// In auto mode, colors are used if the output is a terminal and the NO_COLOR environment variable is not set.
+func useColor() bool {
+	switch options.color {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
 	}
-	fmt.Printf("%s: %d tests (%d successful, %d failures, %d execution errors)\n", result(results.returncode), results.testCount, results.successCount, results.failureCount, results.errorCount)
-	return results, nil
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newRunner creates a runner configured by the command line options and the configuration file
+func newRunner() *runner.Runner {
+	cache, err := resultCache()
+	if err != nil && !options.quiet {
+		fmt.Fprintf(os.Stderr, "Not using cached results: %v\n", err)
+	}
+	var observers []runner.Observer
+	if len(options.transcripts) > 0 {
+		observers = append(observers, &transcriptObserver{directory: options.transcripts})
+	}
+	if len(options.snapshotDir) > 0 {
+		observers = append(observers, &snapshotObserver{directory: options.snapshotDir})
+	}
+	versions, _ := toolVersions()       // validated by initialize
+	variables, _ := documentVariables() // validated by initialize
+	inputGrace := options.inputGrace
+	if inputGrace <= 0 {
+		inputGrace = -1
+	}
+	var newBackend func() shell.Backend
+	if len(options.backend) > 0 {
+		newBackend = plugin.NewBackend(options.backend, environment()...)
+	}
+	return runner.New(runner.Options{
+		Shell:               options.shell,
+		ShellCommand:        options.shellCmd,
+		Env:                 environment(),
+		Languages:           options.languages,
+		Encoding:            options.encoding,
+		KeepCarriageReturns: options.keepCR,
+		ToolVersions:        versions,
+		Variables:           variables,
+		Run:                 runPattern,
+		AtLine:              options.atLine,
+		Excludes:            options.excludes,
+		FailFast:            options.failFast,
+		MaxFailures:         options.maxFailures,
+		NoSkips:             options.noSkips,
+		ReuseSessions:       options.reuse,
+		ShareSession:        options.shareSession,
+		Leaks:               options.leaks,
+		MaxOutput:           options.maxOutput,
+		SpillDirectory:      options.spillOutput,
+		FailOnOutputLimit:   options.failOnLimit,
+		InputGrace:          inputGrace,
+		Strict:              options.strict,
+		Coverage:            options.coverage,
+		Timeout:             options.timeout,
+		FileTimeout:         options.fileTimeout,
+		Delay:               options.delay,
+		CaptionTemplate:     options.captions,
+		Output:              console(),
+		Verbose:             options.verbose,
+		Color:               useColor(),
+		NewBackend:          newBackend,
+		Matchers:            plugin.NewMatcher,
+		Observers:           observers,
+		Cache:               cache,
+		Incremental:         incrementalState(),
+	})
+}
+
+// checkShell verifies that the shell exists before any document is executed, unless a backend plugin executes the
+// commands, and suggests how to select another one if it does not
+func checkShell() error {
+	if len(options.backend) > 0 {
+		return nil
+	}
+	_, err := runner.New(runner.Options{Shell: options.shell, ShellCommand: options.shellCmd}).ShellCommand()
+	if notFound, ok := err.(*shell.NotFoundError); ok {
+		return fmt.Errorf("%v\nSelect another shell with --shell or --shell-cmd, or with shell or shell-cmd in the configuration file", notFound)
+	}
+	return err
+}
+
+// performInteractions executes the interactions in a single document
+func performInteractions(ctx context.Context, inputfile string) (runner.DocumentResult, error) {
+	return newRunner().RunDocument(ctx, inputfile)
 }
 
 func main() {
-	pflag.StringVarP(&options.shell, "shell", "s", "", "The shell to invoke (default: $SHELL).")
-	pflag.BoolVarP(&options.verbose, "verbose", "v", false, "Enable diagnostic log output.")
-	pflag.Parse()
-	initializeLogging()
-	args := pflag.Args()
-	returnCode := returnSuccess
-	for _, file := range args {
-		results, err := performInteractions(file)
+	err := rootCommand().Execute()
+	stopProfiling()
+	if err != nil {
+		os.Exit(returnError) // the error has been printed already
+	}
+	os.Exit(exitCode)
+}
+
+// run executes the interactions in all files and returns the overall return code
+// An interrupt cancels the run, the command that is executing is terminated.
+// The before-run hook is executed first, and aborts the run if it fails. The after-run hook is always executed, a
+// failure of it is reported as an error.
+func run(files []string) (returnCode int) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, span := runner.Tracer().Start(ctx, "run")
+	defer span.End()
+	defer func() {
+		if err := runHook(hookAfterRun, options.afterRun); err != nil {
+			printError(err)
+			span.SetStatus(codes.Error, err.Error())
+			returnCode = max(returnCode, returnError)
+		}
+	}()
+	if err := runHook(hookBeforeRun, options.beforeRun); err != nil {
+		printError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return returnError
+	}
+	reports, err := reporters()
+	if err != nil {
+		printError(err)
+		return returnError
+	}
+	returnCode = returnSuccess
+	r := newRunner()
+	var documents []runner.DocumentResult
+	var repetitions [][]runner.DocumentResult
+	for repetition := 0; repetition < max(options.count, 1); repetition++ {
+		results, err := r.Run(ctx, files)
 		if err != nil {
-			fmt.Println(err) // log may be disabled (see "verbose")
-			os.Exit(returnError)
+			printError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return returnError
+		}
+		documents = append(documents, results.Documents...)
+		repetitions = append(repetitions, results.Documents)
+		returnCode = max(results.ReturnCode, returnCode)
+		if (options.failFast == failFastRun && returnCode != returnSuccess) || r.MaxFailuresReached() {
+			break
 		}
-		returnCode = max(results.returncode, returnCode)
 	}
-	os.Exit(returnCode)
+	if options.count > 1 {
+		writeFlakinessReport(console(), repetitions)
+	}
+	writeQuarantineReport(console(), repetitions)
+	if err := reports.Report(runner.Result{ReturnCode: returnCode, Documents: documents}); err != nil {
+		printError(err)
+		return returnError
+	}
+	if returnCode != returnSuccess {
+		span.SetStatus(codes.Error, runner.Verdict(returnCode))
+	}
+	return returnCode
 }
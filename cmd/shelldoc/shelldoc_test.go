@@ -5,33 +5,1304 @@ package main
 // SPDX-License-Identifier: Apache-2.0
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMain(m *testing.M) {
 	options.verbose = true
+	options.format = formatConsole
 	initializeLogging()
 	os.Exit(m.Run())
 }
 func TestHelloWorld(t *testing.T) {
-	results, err := performInteractions("../../pkg/tokenizer/samples/helloworld.md")
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
 	require.NoError(t, err, "The HelloWorld example should execute without errors.")
-	require.Equal(t, returnSuccess, results.returncode, "The expected return code is returnSuccess.")
-	require.Equal(t, 4, results.successCount, "There are three successful tests in the sample.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The expected return code is returnSuccess.")
+	require.Equal(t, 4, results.SuccessCount, "There are three successful tests in the sample.")
 }
 
 func TestHFailNoMatch(t *testing.T) {
-	results, err := performInteractions("../../pkg/tokenizer/samples/failnomatch.md")
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
 	require.NoError(t, err, "The HelloWorld example should execute without errors.")
-	require.Equal(t, returnFailure, results.returncode, "The expected return code is returnFailure.")
-	require.Equal(t, 1, results.failureCount, "There is one failing test in the sample.")
+	require.Equal(t, returnFailure, results.ReturnCode, "The expected return code is returnFailure.")
+	require.Equal(t, 1, results.FailureCount, "There is one failing test in the sample.")
 }
 
 func TestExitCodesOptions(t *testing.T) {
-	results, err := performInteractions("../../pkg/tokenizer/samples/options.md")
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/options.md")
 	require.NoError(t, err, "The HelloWorld example should execute without errors.")
-	require.Equal(t, returnSuccess, results.returncode, "The expected return code is returnFailure.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The expected return code is returnFailure.")
+}
+
+func TestCSVReport(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The HelloWorld example should execute without errors.")
+	var buffer bytes.Buffer
+	require.NoError(t, writeReport(&buffer, formatCSV, []runner.DocumentResult{results}), "Writing the CSV report should work.")
+	records, err := csv.NewReader(&buffer).ReadAll()
+	require.NoError(t, err, "The CSV report should be readable.")
+	require.Len(t, records, 5, "There is a header and one row for each of the four interactions.")
+	require.Equal(t, "line", records[0][1], "The second column contains the line number.")
+	require.Equal(t, "5", records[1][1], "The first command is in line 5.")
+	require.Equal(t, "echo $HELLOVAR", records[2][3], "The fourth column contains the command.")
+	require.Equal(t, "match", records[2][4], "The fifth column contains the result code.")
+}
+
+func TestGitLabAndCheckstyleReports(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The FailNoMatch example should execute without errors.")
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatGitLab, []runner.DocumentResult{results}), "Writing the GitLab report should work.")
+		var issues []gitLabIssue
+		require.NoError(t, json.Unmarshal(buffer.Bytes(), &issues), "The GitLab report should be valid JSON.")
+		require.Len(t, issues, 1, "There is one failing interaction in the sample.")
+		require.Equal(t, results.File, issues[0].Location.Path, "The issue refers to the document.")
+		require.NotZero(t, issues[0].Location.Lines.Begin, "The issue refers to the line of the command.")
+		require.True(t, strings.HasPrefix(issues[0].Description, "mismatch: "), "The issue starts with the result code.")
+	}
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatCheckstyle, []runner.DocumentResult{results}), "Writing the checkstyle report should work.")
+		var report checkstyleReport
+		require.NoError(t, xml.Unmarshal(buffer.Bytes(), &report), "The checkstyle report should be valid XML.")
+		require.Len(t, report.Files, 1, "There is one document in the report.")
+		require.Len(t, report.Files[0].Errors, 1, "There is one failing interaction in the sample.")
+	}
+}
+
+func TestJUnitAndJSONReports(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The FailNoMatch example should execute without errors.")
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatJUnit, []runner.DocumentResult{results}), "Writing the JUnit report should work.")
+		var report junitTestSuites
+		require.NoError(t, xml.Unmarshal(buffer.Bytes(), &report), "The JUnit report should be valid XML.")
+		require.Len(t, report.TestSuites, 1, "Every document is a test suite.")
+		require.Equal(t, results.TestCount, report.Tests, "Every interaction is a test case.")
+		require.Equal(t, 1, report.Failures, "There is one failing interaction in the sample.")
+	}
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatJSON, []runner.DocumentResult{results}), "Writing the JSON report should work.")
+		report, err := runner.ReadJSON(&buffer)
+		require.NoError(t, err, "The JSON report should be readable.")
+		require.Equal(t, runner.ReturnFailure, report.ReturnCode, "The run failed.")
+		require.Len(t, report.Documents, 1, "There is one document in the report.")
+		require.Len(t, report.Documents[0].Interactions, len(results.Interactions), "Every interaction is reported.")
+	}
+}
+
+func TestDemoReports(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The HelloWorld example should execute without errors.")
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatAsciinema, []runner.DocumentResult{results}), "Writing the asciinema recording should work.")
+		title, output, err := readCast(&buffer)
+		require.NoError(t, err, "The recording should be readable.")
+		require.Equal(t, "shelldoc", title, "The recording has a title.")
+		require.Contains(t, output, "$ echo World\r\nWorld\r\n", "The commands are typed and followed by their output.")
+	}
+	{
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, formatDemo, []runner.DocumentResult{results}), "Writing the demo script should work.")
+		require.Contains(t, buffer.String(), "\tpe 'echo Hello; echo World'\n", "Every command is replayed using pe.")
+		cmd := exec.Command("bash", "-c", buffer.String())
+		cmd.Env = append(os.Environ(), "NO_WAIT=1", "TYPE_SPEED=0")
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, "The demo script should run.")
+		require.Contains(t, string(output), "$ echo $HELLOVAR\nHello\n", "The demo script types and executes the commands.")
+	}
+	failed, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The FailNoMatch example should execute without errors.")
+	for _, format := range []string{formatAsciinema, formatDemo} {
+		require.Error(t, writeReport(ioutil.Discard, format, []runner.DocumentResult{results, failed}), "Demos are only exported from successful runs.")
+	}
+}
+
+func TestReporters(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	directory, err := ioutil.TempDir("", "shelldoc")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	junit := filepath.Join(directory, "results.xml")
+	options.format = formatConsole
+	options.reports = []string{"junit=" + junit, "json=" + filepath.Join(directory, "results.json")}
+	reports, err := reporters()
+	require.NoError(t, err, "Valid reports should be accepted.")
+	require.Len(t, reports, 2, "The console format does not need a reporter.")
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The HelloWorld example should execute without errors.")
+	require.NoError(t, reports.Report(runner.Result{Documents: []runner.DocumentResult{results}}), "Writing the reports should work.")
+	data, err := ioutil.ReadFile(junit)
+	require.NoError(t, err, "The JUnit report was written.")
+	require.Contains(t, string(data), "<testsuites", "The file contains the JUnit report.")
+	for _, spec := range []string{"junit", "junit=", "console=out.txt", "html=out.html"} {
+		_, err := parseReport(spec)
+		require.Error(t, err, "Invalid reports are rejected.")
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	saved, stdout := options, os.Stdout
+	defer func() { options, os.Stdout = saved, stdout }()
+	options.format, options.quiet, options.reports = formatExitCode, true, nil
+	options.beforeRun = "echo before; echo failing >&2"
+	reports, err := reporters()
+	require.NoError(t, err, "The exitcode format is valid.")
+	require.Empty(t, reports, "The exitcode format does not need a reporter.")
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err, "Creating a pipe should work.")
+	os.Stdout = writer
+	returnCode := run([]string{"../../pkg/tokenizer/samples/failnomatch.md"})
+	writer.Close()
+	output, err := ioutil.ReadAll(reader)
+	require.NoError(t, err, "Reading the output should work.")
+	require.Equal(t, returnFailure, returnCode, "The exit code tells the result.")
+	require.Empty(t, string(output), "Nothing is printed.")
+}
+
+func TestTracing(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.quiet = true
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+	global := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(global)
+
+	require.Equal(t, returnFailure, run([]string{"../../pkg/tokenizer/samples/failnomatch.md"}), "The sample fails.")
+	spans := recorder.Ended()
+	require.Len(t, spans, 3, "The run, the document and the interaction are traced.")
+	interaction, document, run := spans[0], spans[1], spans[2]
+	require.Equal(t, []string{"interaction", "document", "run"}, []string{interaction.Name(), document.Name(), run.Name()}, "The spans are named after what they trace.")
+	require.Equal(t, runner.TracerName, run.InstrumentationScope().Name, "The spans are created by the shelldoc tracer.")
+	require.Equal(t, run.SpanContext().SpanID(), document.Parent().SpanID(), "The document is part of the run.")
+	require.Equal(t, document.SpanContext().SpanID(), interaction.Parent().SpanID(), "The interaction is part of the document.")
+	require.Contains(t, document.Attributes(), attribute.String("shelldoc.file", "../../pkg/tokenizer/samples/failnomatch.md"), "The document span records the file.")
+	require.Subset(t, interaction.Attributes(), []attribute.KeyValue{
+		attribute.String("shelldoc.command", "echo No"),
+		attribute.String("shelldoc.result", "mismatch"),
+		attribute.Int("shelldoc.rc", 0),
+	}, "The interaction span records the command, the result code and the exit code.")
+	require.Equal(t, codes.Error, interaction.Status().Code, "The failed interaction is marked as an error.")
+	require.Equal(t, "mismatch", interaction.Status().Description, "The error status names the result code.")
+}
+
+func TestTranscripts(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	directory, err := ioutil.TempDir("", "shelldoc-transcripts")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "stderr.md")
+	require.NoError(t, ioutil.WriteFile(document, []byte("    $ echo out; echo oops >&2\n    out\n"), 0644), "Writing the document should work.")
+	options.transcripts = filepath.Join(directory, "transcripts")
+	results, err := performInteractions(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors.")
+	require.Equal(t, []string{"oops"}, results.Interactions[0].Stderr, "The error output is recorded.")
+	data, err := ioutil.ReadFile(transcriptPath(options.transcripts, document))
+	require.NoError(t, err, "The transcript was written.")
+	require.Contains(t, string(data), "$ echo out; echo oops >&2\nstdout: out\nstderr: oops\n", "The transcript contains the command with its output and error output.")
+	require.Contains(t, string(data), "exit code 0 after", "The transcript contains the exit code.")
+	require.Equal(t, filepath.Join("out", "pkg_README.md.transcript"), transcriptPath("out", "../pkg/README.md"), "The path of the document is part of the file name.")
+}
+
+func TestSnapshots(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	directory, err := ioutil.TempDir("", "shelldoc-snapshots")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "outputs.md")
+	content := "    $ printf 'one\\ntwo\\n'; echo oops >&2\n    one\n    ...\n\n    $ true\n\n```shell {shelldocskip}\n$ echo skipped\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work.")
+	options.snapshotDir = filepath.Join(directory, "out")
+	results, err := performInteractions(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The document should pass.")
+	path := snapshotPath(options.snapshotDir, document, results.Interactions[0])
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "The snapshot was written.")
+	require.Equal(t, "one\ntwo\n", string(data), "The snapshot contains the full output.")
+	data, err = ioutil.ReadFile(strings.TrimSuffix(path, ".out") + ".stderr")
+	require.NoError(t, err, "The error output was written.")
+	require.Equal(t, "oops\n", string(data), "The error output is written next to the output.")
+	data, err = ioutil.ReadFile(snapshotPath(options.snapshotDir, document, results.Interactions[1]))
+	require.NoError(t, err, "Commands without output have a snapshot.")
+	require.Empty(t, data, "The snapshot of a command without output is empty.")
+	_, err = os.Stat(snapshotPath(options.snapshotDir, document, results.Interactions[2]))
+	require.True(t, os.IsNotExist(err), "Skipped interactions have no snapshot.")
+	require.Equal(t, filepath.Join("out", "docs_guide.md", "line-7.out"), snapshotPath("out", "docs/guide.md", &tokenizer.Interaction{Line: 7}), "Interactions without an ID are named by their line.")
+}
+
+func TestWebhooks(t *testing.T) {
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload), "The notification should be JSON.")
+		received = append(received, payload)
+	}))
+	defer server.Close()
+	failed, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The FailNoMatch example should execute without errors.")
+	run := runner.Result{ReturnCode: failed.ReturnCode, Documents: []runner.DocumentResult{failed}}
+	for _, kind := range webhookKinds {
+		webhook, err := parseWebhook(kind + "=" + server.URL)
+		require.NoError(t, err, "Valid webhooks should be accepted.")
+		require.NoError(t, webhook.Report(run), "Notifying the webhook should work.")
+		require.NoError(t, webhook.Report(runner.Result{ReturnCode: returnSuccess}), "Successful runs are not reported.")
+	}
+	require.Len(t, received, 3, "Every webhook is notified once about the failed run.")
+	require.Contains(t, received[0]["text"], "failnomatch.md:", "The Slack message lists the failing interactions.")
+	require.Equal(t, "shelldoc: FAILURE", received[1]["title"], "The Teams message has a title.")
+	require.Equal(t, "FAILURE", received[2]["verdict"], "The JSON payload contains the verdict.")
+	require.Len(t, received[2]["failing"], 1, "The JSON payload contains the failing interaction.")
+
+	for _, spec := range []string{"slack", "slack=", "irc=https://example.com"} {
+		_, err := parseWebhook(spec)
+		require.Error(t, err, "Invalid webhooks are rejected.")
+	}
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	require.Error(t, webhookReporter{kind: webhookJSON, url: broken.URL}.Report(run), "A failing webhook is an error.")
+}
+
+func TestGitHub(t *testing.T) {
+	type request struct {
+		method, path, authorization string
+		body                        map[string]interface{}
+	}
+	var received []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body), "The request should be JSON.")
+		received = append(received, request{r.Method, r.URL.Path, r.Header.Get("Authorization"), body})
+		fmt.Fprint(w, `{"id": 42}`)
+	}))
+	defer server.Close()
+	os.Setenv("GITHUB_API_URL", server.URL)
+	defer os.Unsetenv("GITHUB_API_URL")
+	os.Setenv("GITHUB_TOKEN", "secret")
+	defer os.Unsetenv("GITHUB_TOKEN")
+
+	failed, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The FailNoMatch example should execute without errors.")
+	run := runner.Result{ReturnCode: failed.ReturnCode, Documents: []runner.DocumentResult{failed}}
+	status, err := newGitHubReporter(githubStatus, "owner/repo", "abc123")
+	require.NoError(t, err, "A complete configuration should be accepted.")
+	require.NoError(t, status.Report(run), "Posting the commit status should work.")
+	check, err := newGitHubReporter(githubCheck, "owner/repo", "abc123")
+	require.NoError(t, err, "A complete configuration should be accepted.")
+	for index := 0; index < 60; index++ {
+		run.Documents = append(run.Documents, failed)
+	}
+	require.NoError(t, check.Report(run), "Creating the check run should work.")
+
+	require.Len(t, received, 3, "The commit status is posted, the check run is created and then updated with more annotations.")
+	require.Equal(t, "/repos/owner/repo/statuses/abc123", received[0].path, "The commit status is posted for the commit.")
+	require.Equal(t, "failure", received[0].body["state"], "The commit status reports the failure.")
+	require.Equal(t, "Bearer secret", received[0].authorization, "The token is sent.")
+	require.Equal(t, "/repos/owner/repo/check-runs", received[1].path, "The check run is created.")
+	require.Equal(t, "failure", received[1].body["conclusion"], "The check run fails.")
+	output := received[1].body["output"].(map[string]interface{})
+	require.Len(t, output["annotations"], maxGitHubAnnotations, "The check run is created with the first annotations.")
+	annotation := output["annotations"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "../../pkg/tokenizer/samples/failnomatch.md", annotation["path"], "The annotation refers to the document.")
+	require.Equal(t, http.MethodPatch, received[2].method, "The remaining annotations are added to the check run.")
+	require.Equal(t, "/repos/owner/repo/check-runs/42", received[2].path, "The check run created before is updated.")
+
+	_, err = newGitHubReporter("comment", "owner/repo", "abc123")
+	require.Error(t, err, "Unknown modes are rejected.")
+	_, err = newGitHubReporter(githubCheck, "repo", "abc123")
+	require.Error(t, err, "The repository needs an owner.")
+	os.Unsetenv("GITHUB_TOKEN")
+	_, err = newGitHubReporter(githubCheck, "owner/repo", "abc123")
+	require.Error(t, err, "A token is required.")
+}
+
+func TestConfigFile(t *testing.T) {
+	_, err := loadConfig("does-not-exist.yaml", false)
+	require.NoError(t, err, "A missing default configuration file is not an error.")
+	_, err = loadConfig("does-not-exist.yaml", true)
+	require.Error(t, err, "A missing explicitly specified configuration file is an error.")
+
+	file, err := ioutil.TempFile("", "shelldoc-config")
+	require.NoError(t, err, "Creating a temporary file should work.")
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("shell: /bin/sh\nformat: csv\nlanguages: [shell]\nenv:\n  GREETING: Hello\n")
+	require.NoError(t, err, "Writing the configuration should work.")
+	file.Close()
+	config, err := loadConfig(file.Name(), true)
+	require.NoError(t, err, "The configuration file should be parsed.")
+	require.Equal(t, "/bin/sh", config.Shell, "The shell is read from the configuration.")
+	require.Equal(t, map[string]string{"GREETING": "Hello"}, config.Env, "The environment is read from the configuration.")
+
+	saved := options
+	defer func() { options = saved }()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVarP(&options.format, "format", "f", formatConsole, "")
+	require.NoError(t, flags.Parse([]string{"--format=gitlab"}), "Parsing the flags should work.")
+	applyConfig(config, flags)
+	require.Equal(t, formatGitLab, options.format, "The command line overrides the configuration file.")
+	require.Equal(t, "/bin/sh", options.shell, "The configuration file sets options not specified on the command line.")
+	require.Equal(t, []string{"GREETING=Hello"}, environment(), "The environment is passed on in KEY=value form.")
+}
+
+func TestProfiles(t *testing.T) {
+	file, err := ioutil.TempFile("", "shelldoc-config")
+	require.NoError(t, err, "Creating a temporary file should work.")
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("shell: /bin/sh\ntimeout: 1m\nenv:\n  GREETING: Hello\n  NAME: World\nprofiles:\n  ci:\n    timeout: 5m\n    env:\n      NAME: CI\n")
+	require.NoError(t, err, "Writing the configuration should work.")
+	file.Close()
+	config, err := loadConfig(file.Name(), true)
+	require.NoError(t, err, "The configuration file should be parsed.")
+
+	unchanged, err := config.withProfile("")
+	require.NoError(t, err, "Not selecting a profile is fine.")
+	require.Equal(t, time.Minute, unchanged.Timeout, "Without a profile, the defaults apply.")
+	ci, err := config.withProfile("ci")
+	require.NoError(t, err, "Selecting a defined profile should work.")
+	require.Equal(t, 5*time.Minute, ci.Timeout, "The profile overrides the defaults.")
+	require.Equal(t, "/bin/sh", ci.Shell, "Settings not specified in the profile keep their defaults.")
+	require.Equal(t, map[string]string{"GREETING": "Hello", "NAME": "CI"}, ci.Env, "The environment variables are merged.")
+	require.Equal(t, "World", config.Env["NAME"], "Applying a profile does not modify the configuration.")
+	_, err = config.withProfile("docker")
+	require.Error(t, err, "Selecting an unknown profile is an error.")
+}
+
+func TestHooks(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-hooks")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	saved := options
+	defer func() { options = saved }()
+	after := filepath.Join(directory, "after")
+
+	options.beforeRun = "touch " + filepath.Join(directory, "before")
+	options.afterRun = "touch " + after
+	require.Equal(t, returnSuccess, run([]string{"../../pkg/tokenizer/samples/helloworld.md"}), "The run succeeds.")
+	require.FileExists(t, filepath.Join(directory, "before"), "The before-run hook was executed.")
+	require.FileExists(t, after, "The after-run hook was executed.")
+
+	require.NoError(t, os.Remove(after), "Removing the marker file should work.")
+	options.beforeRun = "false"
+	require.Equal(t, returnError, run([]string{"../../pkg/tokenizer/samples/helloworld.md"}), "A failing before-run hook aborts the run.")
+	require.FileExists(t, after, "The after-run hook is executed even if the run was aborted.")
+
+	options.beforeRun = ""
+	options.afterRun = "false"
+	require.Equal(t, returnError, run([]string{"../../pkg/tokenizer/samples/helloworld.md"}), "A failing after-run hook fails the run.")
+}
+
+func TestDeterministic(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.faketime = true
+	require.Error(t, applyDeterministic(), "--faketime needs --deterministic.")
+	options.faketime = false
+	options.reproducible = true
+	options.env = map[string]string{"COLUMNS": "120"}
+	require.NoError(t, applyDeterministic(), "Preparing the deterministic environment should work.")
+	require.Equal(t, "946684800", options.env["SOURCE_DATE_EPOCH"], "The build date is fixed.")
+	require.Equal(t, "120", options.env["COLUMNS"], "The configured environment takes precedence.")
+	require.DirExists(t, options.env["HOME"], "The home directory is created.")
+	require.DirExists(t, options.env["TMPDIR"], "The temporary directory is created.")
+	require.Contains(t, environment(), "TZ=UTC", "The time zone is fixed.")
+}
+
+func TestTerminalSize(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	configured := map[string]string{"COLUMNS": "80", "LINES": "24"}
+	options.env, options.columns, options.lines = configured, 132, 0
+	require.NoError(t, applyTerminalSize(), "Setting the terminal size should work.")
+	require.Equal(t, "132", options.env["COLUMNS"], "--columns takes precedence over the environment.")
+	require.Equal(t, "24", options.env["LINES"], "LINES is kept without --lines.")
+	require.Equal(t, "80", configured["COLUMNS"], "The configuration is not changed.")
+	options.columns = -1
+	require.Error(t, applyTerminalSize(), "Negative sizes are rejected.")
+}
+
+func TestShellCommand(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.shellCmd = "env 'GREETING=Hello World' /bin/sh"
+	args, err := newRunner().ShellCommand()
+	require.NoError(t, err, "The shell command should be accepted.")
+	require.Equal(t, []string{"env", "GREETING=Hello World", "/bin/sh"}, args, "The shell command is split into arguments.")
+	shell, err := newRunner().StartShell()
+	require.NoError(t, err, "Starting the shell through the wrapper should work.")
+	defer shell.Close()
+	output, _, _, err := shell.Execute(context.Background(), "echo $GREETING")
+	require.NoError(t, err, "The command should execute.")
+	require.Equal(t, []string{"Hello World"}, output, "The shell was launched using the shell command.")
+	options.shellCmd = "'unterminated"
+	_, err = newRunner().ShellCommand()
+	require.Error(t, err, "Invalid shell commands are rejected.")
+}
+
+func TestMissingShell(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.shell, options.shellCmd = "/nonexistent/bash", ""
+	err := checkShell()
+	require.Error(t, err, "A missing shell is detected before the documents are executed.")
+	require.Contains(t, err.Error(), "\"/nonexistent/bash\" does not exist", "The missing shell is named.")
+	require.Contains(t, err.Error(), "--shell", "The flags that select another shell are suggested.")
+	options.shell, options.shellCmd = "", "nonexistent-wrapper /bin/sh"
+	require.Error(t, checkShell(), "A missing program in the shell command is detected.")
+	options.shellCmd, options.backend = "", "shelldoc-backend-nonexistent"
+	options.shell = "/nonexistent/bash"
+	require.NoError(t, checkShell(), "Backend plugins do not need a shell.")
+}
+
+func TestChangedOnly(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-changed")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	working, err := os.Getwd()
+	require.NoError(t, err, "Unable to determine the working directory.")
+	require.NoError(t, os.Chdir(directory), "Changing into the temporary directory should work.")
+	defer os.Chdir(working)
+
+	for _, file := range []string{"unchanged.md", "changed.md", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("# "+file+"\n"), 0644), "Writing the file should work.")
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=shelldoc", "-c", "user.email=shelldoc@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		_, err := git(args...)
+		require.NoError(t, err, "Setting up the git repository should work.")
+	}
+	for _, file := range []string{"changed.md", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("modified\n"), 0644), "Modifying the file should work.")
+	}
+
+	changed, err := changedDocuments(defaultChangedRef)
+	require.NoError(t, err, "Asking git for the changed documents should work.")
+	require.Equal(t, []string{"changed.md"}, changed, "Only modified Markdown documents are returned.")
+	require.Equal(t, changed, selectChanged(nil, changed), "Without files, all changed documents are selected.")
+	require.Equal(t, []string{"./changed.md"}, selectChanged([]string{"unchanged.md", "./changed.md"}, changed), "The specified files are filtered.")
+	_, err = changedDocuments("no-such-ref")
+	require.Error(t, err, "Unknown refs are an error.")
+}
+
+func TestRunPattern(t *testing.T) {
+	defer compileRunPattern("")
+	require.Error(t, compileRunPattern("(unbalanced"), "Invalid regular expressions are rejected.")
+	require.NoError(t, compileRunPattern("^Farewell$"), "The pattern is a valid regular expression.")
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/headings.md")
+	require.NoError(t, err, "The Headings example should execute without errors.")
+	require.Equal(t, 1, results.TestCount, "Only the interaction in the Farewell section is executed.")
+	require.Equal(t, "echo Goodbye", results.Interactions[0].Cmd, "The selected interaction is the one in the Farewell section.")
+
+	require.NoError(t, compileRunPattern("Hello"), "The pattern is a valid regular expression.")
+	results, err = performInteractions(context.Background(), "../../pkg/tokenizer/samples/headings.md")
+	require.NoError(t, err, "The Headings example should execute without errors.")
+	require.Equal(t, 1, results.TestCount, "The pattern also matches the command.")
+}
+
+func TestAtLine(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.atLine = 19
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/setup.md")
+	require.NoError(t, err, "The Setup example should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The code block passes after the setup code block.")
+	require.Equal(t, 2, results.TestCount, "Only the setup code block and the code block at the line are executed.")
+	var buffer bytes.Buffer
+	require.NoError(t, positionReporter{w: &buffer}.Report(runner.Result{Documents: []runner.DocumentResult{results}}), "Reporting the positions should work.")
+	require.Equal(t, "../../pkg/tokenizer/samples/setup.md:6: match\n../../pkg/tokenizer/samples/setup.md:19: match\n", buffer.String(), "Every interaction is reported with its position.")
+}
+
+func TestFailFast(t *testing.T) {
+	defer func() { options.failFast = "" }()
+	options.failFast = failFastDocument
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/failfast.md")
+	require.NoError(t, err, "The FailFast example should execute without errors.")
+	require.Equal(t, returnFailure, results.ReturnCode, "The expected return code is returnFailure.")
+	require.Equal(t, 2, results.TestCount, "The execution stops after the second interaction.")
+	require.Equal(t, tokenizer.NewInteraction, results.Interactions[2].ResultCode, "The third interaction is not executed.")
+}
+
+func TestList(t *testing.T) {
+	var buffer bytes.Buffer
+	require.NoError(t, list(&buffer, formatJSON, []string{"../../pkg/tokenizer/samples/options.md"}), "Listing the interactions should work.")
+	var entries []listEntry
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &entries), "The list should be valid JSON.")
+	require.Len(t, entries, 2, "There are two interactions in the sample.")
+	require.Equal(t, "false", entries[0].Command, "The first command is false.")
+	require.Equal(t, []string{"shelldocwhatever"}, entries[0].Tags, "The first interaction accepts any exit code.")
+	require.Equal(t, []string{"shelldocexitcode=2"}, entries[1].Tags, "The second interaction expects exit code 2.")
+}
+
+func TestGraph(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-graph")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "graph.md")
+	content := "# Graph\n\n```shell {shelldocsetup}\n$ cd /tmp\n```\n\n    $ ls\n\n```shell {shelldocsetup}\n$ export A=1\n```\n\n    $ echo $A\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work.")
+
+	var buffer bytes.Buffer
+	require.NoError(t, writeGraph(&buffer, graphDot, []string{document}), "Writing the DOT graph should work.")
+	graph := buffer.String()
+	require.True(t, strings.HasPrefix(graph, "digraph shelldoc {\n"), "The DOT graph is a directed graph.")
+	require.Contains(t, graph, "d0_4 [shape=box, label=\"4: Graph (setup)\", style=bold];\n", "Setup code blocks are marked.")
+	require.Contains(t, graph, "d0_4 -> d0_7;\n", "The code blocks are executed in order.")
+	require.Contains(t, graph, "d0_4 -> d0_13 [style=dashed];\n", "Code blocks depend on the setup code blocks before them.")
+	require.NotContains(t, graph, "d0_10 -> d0_13 [style=dashed]", "The dependency on the preceding setup code block is not repeated.")
+
+	buffer.Reset()
+	require.NoError(t, writeGraph(&buffer, graphMermaid, []string{document}), "Writing the Mermaid graph should work.")
+	require.Contains(t, buffer.String(), "    d0_4 -.-> d0_10\n", "Dependencies are dotted in Mermaid graphs.")
+	require.Error(t, writeGraph(&buffer, "svg", []string{document}), "Unknown graph formats are rejected.")
+}
+
+func TestShuffle(t *testing.T) {
+	files := []string{"a.md", "b.md", "c.md", "d.md", "e.md", "f.md", "g.md", "h.md"}
+	_, err := shuffleSeed("sometimes")
+	require.Error(t, err, "The shuffle value needs to be on, off or an integer.")
+	seed, err := shuffleSeed("42")
+	require.NoError(t, err, "An integer is a valid seed.")
+	require.Equal(t, int64(42), seed, "The seed is used as specified.")
+	shuffled := shuffle(files, seed)
+	require.Equal(t, shuffled, shuffle(files, seed), "The same seed results in the same order.")
+	require.ElementsMatch(t, files, shuffled, "Shuffling does not add or remove documents.")
+	require.Equal(t, "a.md", files[0], "The original order is not modified.")
+}
+
+func TestWatchEvents(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-watch")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	other := filepath.Join(directory, "other.md")
+	for _, file := range []string{document, other} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("    $ true\n"), 0644), "Writing the document should work.")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	require.NoError(t, err, "Creating a watcher should work.")
+	defer watcher.Close()
+	specified := directory + "/./document.md"
+	watched, err := watchDocuments(watcher, []string{specified})
+	require.NoError(t, err, "Watching the documents should work.")
+
+	// changes returns the documents changed by the events received until the watcher is quiet
+	changes := func() []string {
+		var documents []string
+		for {
+			select {
+			case event := <-watcher.Events:
+				if file, ok := changedDocument(watched, event); ok {
+					documents = append(documents, file)
+				}
+			case err := <-watcher.Errors:
+				require.NoError(t, err, "Watching the documents should work.")
+			case <-time.After(500 * time.Millisecond):
+				return documents
+			}
+		}
+	}
+	require.NoError(t, ioutil.WriteFile(document, []byte("    $ false\n"), 0644), "Writing the document should work.")
+	changed := changes()
+	require.NotEmpty(t, changed, "Writing the document changes it.")
+	require.Equal(t, specified, changed[0], "The document is reported as it was specified.")
+	require.NoError(t, ioutil.WriteFile(other, []byte("    $ false\n"), 0644), "Writing the other document should work.")
+	require.Empty(t, changes(), "Documents that are not watched do not change.")
+	replacement := filepath.Join(directory, ".document.md.swp")
+	require.NoError(t, ioutil.WriteFile(replacement, []byte("    $ true\n"), 0644), "Writing the replacement should work.")
+	require.NoError(t, os.Rename(replacement, document), "Replacing the document should work.")
+	require.Equal(t, []string{specified}, changes(), "Replacing the document changes it.")
+	require.NoError(t, os.Remove(document), "Removing the document should work.")
+	require.Empty(t, changes(), "Removing the document does not change it.")
+}
+
+func TestShard(t *testing.T) {
+	for _, value := range []string{"2", "0/5", "6/5", "1/0", "a/b", "-1/5"} {
+		_, _, err := parseShard(value)
+		require.Error(t, err, "Invalid shards are rejected.")
+	}
+	index, total, err := parseShard("2/5")
+	require.NoError(t, err, "INDEX/TOTAL is a valid shard.")
+	require.Equal(t, []int{2, 5}, []int{index, total}, "The shard is used as specified.")
+	var files []string
+	for number := 0; number < 50; number++ {
+		files = append(files, fmt.Sprintf("docs/document-%d.md", number))
+	}
+	var sharded []string
+	for index := 1; index <= 5; index++ {
+		shard := shardDocuments(files, index, 5)
+		require.Equal(t, shard, shardDocuments(files, index, 5), "The shards are deterministic.")
+		sharded = append(sharded, shard...)
+	}
+	require.ElementsMatch(t, files, sharded, "Every document is in exactly one shard.")
+	for index := 1; index <= 5; index++ {
+		require.Len(t, shardDocuments([]string{"./docs//document-7.md"}, index, 5), len(shardDocuments([]string{"docs/document-7.md"}, index, 5)), "Paths are compared in their clean form.")
+	}
+	require.Equal(t, files, shardDocuments(files, 1, 1), "A single shard contains all documents.")
+}
+
+func TestFlakiness(t *testing.T) {
+	passing := &tokenizer.Interaction{Cmd: "true", ResultCode: tokenizer.ResultMatch}
+	failing := &tokenizer.Interaction{Cmd: "true", ResultCode: tokenizer.ResultMismatch}
+	stable := &tokenizer.Interaction{Cmd: "false", ResultCode: tokenizer.ResultError}
+	repetitions := [][]runner.DocumentResult{
+		{{File: "a.md", Interactions: []*tokenizer.Interaction{passing, stable}}},
+		{{File: "a.md", Interactions: []*tokenizer.Interaction{failing, stable}}},
+		{{File: "a.md", Interactions: []*tokenizer.Interaction{passing, stable}}},
+	}
+	all := collectOutcomes(repetitions)
+	require.Len(t, all, 2, "There are two distinct interactions.")
+	require.True(t, all[0].isFlaky(), "The first interaction passed twice and failed once.")
+	require.Equal(t, 2, all[0].passed, "The first interaction passed twice.")
+	require.False(t, all[1].isFlaky(), "The second interaction failed consistently.")
+}
+
+func TestQuarantineReport(t *testing.T) {
+	flaky := &tokenizer.Interaction{Cmd: "curl example.com", Line: 7, ResultCode: tokenizer.ResultMismatch, Attributes: map[string]string{tokenizer.FlakyOption: "https://example.com/issues/42"}}
+	stable := &tokenizer.Interaction{Cmd: "true", ResultCode: tokenizer.ResultMatch}
+	var buffer bytes.Buffer
+	writeQuarantineReport(&buffer, [][]runner.DocumentResult{{{File: "a.md", Interactions: []*tokenizer.Interaction{flaky, stable}}}})
+	require.Contains(t, buffer.String(), " QUARANTINED: a.md:7: curl example.com (https://example.com/issues/42) passed 0 of 1 times\n", "Quarantined interactions are listed with their issue.")
+	require.Contains(t, buffer.String(), "1 interactions are quarantined", "Quarantined interactions are counted.")
+	require.False(t, isReported(flaky), "Quarantined failures are not reported as problems.")
+	buffer.Reset()
+	writeQuarantineReport(&buffer, [][]runner.DocumentResult{{{File: "a.md", Interactions: []*tokenizer.Interaction{stable}}}})
+	require.Empty(t, buffer.String(), "Nothing is written without quarantined interactions.")
+}
+
+func TestMerge(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-merge")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	passed := runner.DocumentResult{File: "a.md", Interactions: []*tokenizer.Interaction{{Cmd: "true", Line: 3, ResultCode: tokenizer.ResultMatch}}}
+	failed := runner.DocumentResult{File: "a.md", ReturnCode: returnFailure, Interactions: []*tokenizer.Interaction{{Cmd: "true", Line: 3, ResultCode: tokenizer.ResultMismatch}}}
+	other := runner.DocumentResult{File: "b.md", Interactions: []*tokenizer.Interaction{{Cmd: "false", Line: 5, ResultCode: tokenizer.ResultMatch}}}
+	write := func(name, format string, documents ...runner.DocumentResult) string {
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, format, documents), "Writing the report should work.")
+		path := filepath.Join(directory, name)
+		require.NoError(t, ioutil.WriteFile(path, buffer.Bytes(), 0644), "Writing the result file should work.")
+		return path
+	}
+
+	var buffer bytes.Buffer
+	require.NoError(t, mergeReports(&buffer, []string{write("1.json", formatJSON, passed), write("2.json", formatJSON, failed, other)}), "Merging JSON reports should work.")
+	result, err := runner.ReadJSON(&buffer)
+	require.NoError(t, err, "The merged JSON report is valid.")
+	require.Len(t, result.Documents, 2, "Every document is reported once.")
+	require.Equal(t, 1, result.Documents[0].FailureCount, "The failure of the second run is kept.")
+	require.Equal(t, returnFailure, result.ReturnCode, "The merged run fails.")
+
+	buffer.Reset()
+	require.NoError(t, mergeReports(&buffer, []string{write("1.xml", formatJUnit, passed), write("2.xml", formatJUnit, failed, other)}), "Merging JUnit reports should work.")
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(buffer.Bytes(), &report), "The merged JUnit report is valid.")
+	require.Len(t, report.TestSuites, 2, "Every document is reported once.")
+	require.Equal(t, 2, report.Tests, "The test cases are counted once.")
+	require.Equal(t, 1, report.Failures, "The failure of the second run is kept.")
+
+	require.Error(t, mergeReports(&buffer, []string{write("3.json", formatJSON, passed), write("3.xml", formatJUnit, passed)}), "JSON and JUnit reports cannot be merged.")
+}
+
+func TestCompare(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-compare")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	write := func(name, format string, results ...tokenizer.ResultCode) string {
+		document := runner.DocumentResult{File: "a.md"}
+		for index, result := range results {
+			document.Interactions = append(document.Interactions, &tokenizer.Interaction{Cmd: fmt.Sprintf("echo %d", index), Line: 3 + 2*index, ResultCode: result})
+		}
+		var buffer bytes.Buffer
+		require.NoError(t, writeReport(&buffer, format, []runner.DocumentResult{document}), "Writing the report should work.")
+		path := filepath.Join(directory, name)
+		require.NoError(t, ioutil.WriteFile(path, buffer.Bytes(), 0644), "Writing the result file should work.")
+		return path
+	}
+	before := write("before.json", formatJSON, tokenizer.ResultMatch, tokenizer.ResultMismatch)
+	after := write("after.json", formatJSON, tokenizer.ResultMismatch, tokenizer.ResultMatch, tokenizer.ResultMatch)
+
+	var buffer bytes.Buffer
+	code, err := compareReports(&buffer, before, after)
+	require.NoError(t, err, "Comparing result files should work.")
+	require.Equal(t, returnFailure, code, "Regressions fail the comparison.")
+	require.Equal(t, " REGRESSION: a.md:3: echo 0: match -> mismatch\n FIXED: a.md:5: echo 1: mismatch -> match\n CHANGED: a.md:7: echo 2: new -> match\nSHELLDOC: 3 interactions changed, 1 regressions, 1 fixes\n", buffer.String(), "The changes are listed.")
+	code, err = compareReports(ioutil.Discard, after, write("fixed.json", formatJSON, tokenizer.ResultMatch))
+	require.NoError(t, err, "Comparing result files should work.")
+	require.Equal(t, returnSuccess, code, "Fixes and removed interactions do not fail the comparison.")
+	_, err = compareReports(ioutil.Discard, before, write("after.xml", formatJUnit, tokenizer.ResultMatch))
+	require.Error(t, err, "JUnit reports cannot be compared.")
+}
+
+func TestHistory(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-history")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, defaultHistoryFile)
+	run := func(results ...tokenizer.ResultCode) {
+		document := runner.DocumentResult{File: "a.md"}
+		for index, result := range results {
+			document.Interactions = append(document.Interactions, &tokenizer.Interaction{Cmd: fmt.Sprintf("echo %d", index), Line: 3 + 2*index, ResultCode: result, Duration: time.Duration(index+1) * time.Second})
+		}
+		require.NoError(t, historyReporter{path: path}.Report(runner.Result{Documents: []runner.DocumentResult{document}}), "Recording the run should work.")
+	}
+	run(tokenizer.ResultMatch, tokenizer.ResultMatch)
+	run(tokenizer.ResultMatch, tokenizer.ResultMismatch)
+	run(tokenizer.ResultMismatch, tokenizer.ResultMismatch)
+
+	var buffer bytes.Buffer
+	require.NoError(t, queryHistory(&buffer, path, historyFailures, ""), "Querying the failures should work.")
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 3, "Every run is listed.")
+	require.Contains(t, lines[0], "0 of 2 failed", "The first run passed.")
+	require.Contains(t, lines[2], "2 of 2 failed: a.md:3, a.md:5", "The failing interactions are listed.")
+
+	buffer.Reset()
+	require.NoError(t, queryHistory(&buffer, path, historyFailures, "a.md:3"), "Querying the failures of a line should work.")
+	require.Contains(t, buffer.String(), "1 of 1 failed", "Only the interaction at the line is counted.")
+
+	buffer.Reset()
+	require.NoError(t, queryHistory(&buffer, path, historyDurations, ""), "Querying the durations should work.")
+	require.True(t, strings.HasPrefix(strings.TrimSpace(buffer.String()), "2s in 3 runs: a.md:5: echo 1"), "The slowest interaction is listed first.")
+
+	buffer.Reset()
+	require.NoError(t, queryHistory(&buffer, path, historyFirstFailure, ""), "Querying the first failures should work.")
+	runs, err := readHistory(path)
+	require.NoError(t, err, "Reading the history should work.")
+	require.Contains(t, buffer.String(), "a.md:3: echo 0: failing since "+describeRun(runs[2]), "The first interaction fails since the last run.")
+	require.Contains(t, buffer.String(), "a.md:5: echo 1: failing since "+describeRun(runs[1]), "The second interaction fails since the second run.")
+
+	require.Error(t, queryHistory(&buffer, path, "whatever", ""), "Unknown queries are rejected.")
+	require.Error(t, queryHistory(&buffer, filepath.Join(directory, "missing"), historyFailures, ""), "A missing history is reported.")
+}
+
+func TestExtract(t *testing.T) {
+	var buffer bytes.Buffer
+	require.NoError(t, extract(&buffer, []string{"../../pkg/tokenizer/samples/helloworld.md"}), "Extracting the commands should work.")
+	script := buffer.String()
+	require.Contains(t, script, "# ../../pkg/tokenizer/samples/helloworld.md:5\nexport HELLOVAR=Hello\n", "Every command is preceded by its location.")
+	require.Contains(t, script, "\necho Hello; echo World\n", "The last command is extracted.")
+}
+
+func TestLint(t *testing.T) {
+	var buffer bytes.Buffer
+	count, err := lint(&buffer, []string{"../../pkg/tokenizer/samples/lint.md", "../../pkg/tokenizer/samples/helloworld.md"})
+	require.NoError(t, err, "Linting the documents should work.")
+	require.Equal(t, 5, count, "There are five problems in the documents.")
+	require.Contains(t, buffer.String(), "../../pkg/tokenizer/samples/lint.md:11: unknown option shelldocfoo\n", "Problems are reported with their location.")
+	require.Contains(t, buffer.String(), "../../pkg/tokenizer/samples/lint.md:12: the commands of this code block are identical to those of the code block in line 6\n", "Repeated code blocks are reported.")
+	require.NotContains(t, buffer.String(), "helloworld.md", "Documents without problems are not reported.")
+}
+
+func TestFormat(t *testing.T) {
+	document := "# Title  \n\n    >   echo Hello   \n    Hello  \n\n```shell   {shelldocwhatever .numbered  shelldocexitcode=1}  \n> false\n```  \n"
+	formatted := string(formatDocument([]byte(document)))
+	require.Equal(t, "# Title  \n\n    $ echo Hello\n    Hello\n\n```shell {.numbered shelldocexitcode=1 shelldocwhatever}\n$ false\n```\n", formatted, "The code blocks are formatted canonically, the text is untouched.")
+	require.Equal(t, formatted, string(formatDocument([]byte(formatted))), "Formatting is idempotent.")
+
+	changed, err := formatDocuments([]string{"../../pkg/tokenizer/samples/fenced.md"}, true)
+	require.NoError(t, err, "Checking the document should work.")
+	require.Equal(t, []string{"../../pkg/tokenizer/samples/fenced.md"}, changed, "The sample uses the > prompt, it is not formatted canonically.")
+}
+
+func TestStrict(t *testing.T) {
+	interactions := []*tokenizer.Interaction{
+		{Cmd: "ls", Line: 3},
+		{Cmd: "ls", Heading: "Listing", Line: 7},
+		{Cmd: "ls", Heading: "Listing", Line: 9},
+		{Cmd: "ls", Heading: "Listing", Caption: "List again", Line: 11},
+	}
+	problems := runner.NamingProblems(interactions)
+	require.Equal(t, 2, len(problems), "The missing context and the duplicate name are reported.")
+	require.Equal(t, 3, problems[0].Line, "The interaction without caption and heading is reported.")
+	require.Equal(t, 9, problems[1].Line, "The second interaction with the same name is reported.")
+	require.Contains(t, problems[1].Message, "line 7", "The duplicate refers to the first interaction with the name.")
+
+	headings, err := newRunner().Discover("../../pkg/tokenizer/samples/headings.md")
+	require.NoError(t, err, "Unable to read sample data file.")
+	require.Empty(t, runner.NamingProblems(headings), "The headings sample follows the naming policy.")
+
+	saved := options
+	defer func() { options = saved }()
+	options.strict = true
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/strict.md")
+	require.NoError(t, err, "The document should execute without errors.")
+	require.Equal(t, 2, results.SuccessCount, "The interactions themselves succeed.")
+	require.Equal(t, returnFailure, results.ReturnCode, "The document violates the naming policy.")
+	var buffer bytes.Buffer
+	count, err := lint(&buffer, []string{"../../pkg/tokenizer/samples/strict.md"})
+	require.NoError(t, err, "Linting the document should work.")
+	require.Equal(t, 4, count, "Lint reports the violations in strict mode and the repeated code block.")
+}
+
+func TestUpdate(t *testing.T) {
+	data, err := ioutil.ReadFile("../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "Unable to read sample data file.")
+	file, err := ioutil.TempFile("", "shelldoc-update")
+	require.NoError(t, err, "Creating a temporary file should work.")
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	require.NoError(t, err, "Writing the document should work.")
+	file.Close()
+
+	code, err := update([]string{file.Name()})
+	require.NoError(t, err, "Updating the document should work.")
+	require.Equal(t, returnFailure, code, "The document failed before it was updated.")
+	updated, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read the updated document.")
+	require.Contains(t, string(updated), "    $ echo No\n    No\n", "The expected response was replaced with the indented output.")
+	results, err := performInteractions(context.Background(), file.Name())
+	require.NoError(t, err, "The updated document should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The updated document passes.")
+}
+
+func TestAnnotate(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-annotate")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	file := filepath.Join(directory, "document.md")
+	content := "# Annotated\n\n```shell\n$ echo a\na\n```\n\n    $ echo b\n    c\n\n```go\nfunc main()\n```\n"
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0644), "Writing the document should work.")
+
+	code, err := annotate([]string{file}, false)
+	require.NoError(t, err, "Annotating the document should work.")
+	require.Equal(t, returnFailure, code, "The exit code is that of the run.")
+	unchanged, err := ioutil.ReadFile(file)
+	require.NoError(t, err, "Unable to read the document.")
+	require.Equal(t, content, string(unchanged), "The document is not modified without --in-place.")
+	annotated, err := ioutil.ReadFile(filepath.Join(directory, "document.annotated.md"))
+	require.NoError(t, err, "The annotated copy is written next to the document.")
+	require.Regexp(t, "```\n<!-- shelldoc: PASS at [0-9T:Z-]+ -->\n\n    \\$ echo b\n    c\n<!-- shelldoc: FAIL at ", string(annotated), "Every executed code block is annotated with its result.")
+	require.Contains(t, string(annotated), "func main()\n```\n", "Code blocks without interactions are not annotated.")
+
+	for run := 0; run < 2; run++ {
+		_, err = annotate([]string{file}, true)
+		require.NoError(t, err, "Annotating the document in place should work.")
+	}
+	inPlace, err := ioutil.ReadFile(file)
+	require.NoError(t, err, "Unable to read the annotated document.")
+	require.Equal(t, 2, strings.Count(string(inPlace), "<!-- shelldoc: "), "The annotations of earlier runs are replaced.")
+	results, err := performInteractions(context.Background(), file)
+	require.NoError(t, err, "The annotated document should execute without errors.")
+	require.Equal(t, 2, results.TestCount, "The annotations do not change the interactions.")
+}
+
+func TestUpdateNoFinalNewline(t *testing.T) {
+	file, err := ioutil.TempFile("", "shelldoc-update")
+	require.NoError(t, err, "Creating a temporary file should work.")
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("    $ printf Hello\n    Hi\n")
+	require.NoError(t, err, "Writing the document should work.")
+	file.Close()
+
+	code, err := update([]string{file.Name()})
+	require.NoError(t, err, "Updating the document should work.")
+	require.Equal(t, returnFailure, code, "The document failed before it was updated.")
+	updated, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read the updated document.")
+	require.Equal(t, "    $ printf Hello\n    Hello\n    "+tokenizer.NoNewlineMarker+"\n", string(updated), "The missing line break is recorded in the expected response.")
+	results, err := performInteractions(context.Background(), file.Name())
+	require.NoError(t, err, "The updated document should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The updated document passes.")
+}
+
+func TestDiff(t *testing.T) {
+	file := "../../pkg/tokenizer/samples/failnomatch.md"
+	original, err := ioutil.ReadFile(file)
+	require.NoError(t, err, "Unable to read sample data file.")
+	var buffer bytes.Buffer
+	code, err := diff(&buffer, []string{file, "../../pkg/tokenizer/samples/helloworld.md"})
+	require.NoError(t, err, "Comparing the documents should work.")
+	require.Equal(t, returnFailure, code, "The document with the mismatch fails.")
+	require.Contains(t, buffer.String(), "--- "+file+"\n+++ "+file+"\n", "The diff names the document.")
+	require.Contains(t, buffer.String(), "\n+    No\n", "The actual output is added.")
+	require.NotContains(t, buffer.String(), "helloworld.md", "Documents without changes are not part of the diff.")
+	unchanged, err := ioutil.ReadFile(file)
+	require.NoError(t, err, "Unable to read sample data file.")
+	require.Equal(t, original, unchanged, "The document is not modified.")
+}
+
+func TestStep(t *testing.T) {
+	data, err := ioutil.ReadFile("../../pkg/tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "Unable to read sample data file.")
+	file, err := ioutil.TempFile("", "shelldoc-step")
+	require.NoError(t, err, "Creating a temporary file should work.")
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	require.NoError(t, err, "Writing the document should work.")
+	file.Close()
+
+	var output bytes.Buffer
+	// edit the command, accept its output and write the correction back
+	input := strings.NewReader("e\necho Maybe\ny\ny\n")
+	require.NoError(t, step(input, &output, file.Name()), "Stepping through the document should work.")
+	require.Contains(t, output.String(), "[1/1] ", "The interaction is shown before it is executed.")
+	require.Contains(t, output.String(), "> Maybe\n", "The actual output is shown.")
+	updated, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err, "Unable to read the updated document.")
+	require.Contains(t, string(updated), "    $ echo Maybe\n    Maybe\n", "The edited command and the accepted output were written back.")
+}
+
+func TestCompletion(t *testing.T) {
+	documents := []string{"../../pkg/tokenizer/samples/headings.md", "../../pkg/tokenizer/samples/options.md"}
+	languages, _ := completeLanguages(nil, documents, "")
+	require.Equal(t, []string{"shell"}, languages, "The options sample uses fenced code blocks in the shell language.")
+	headings, _ := completeHeadings(nil, documents, "")
+	require.Contains(t, headings, "^Farewell$", "The headings are offered as anchored patterns.")
+	require.Contains(t, headings, "^Farewell in French$", "Every heading is offered.")
+}
+
+func TestSkip(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/skip.md")
+	require.NoError(t, err, "The Skip example should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "Skipped interactions do not fail the test.")
+	require.Equal(t, 1, results.SuccessCount, "One interaction is executed.")
+	require.Equal(t, 2, results.SkipCount, "Two interactions are skipped.")
+	require.Equal(t, "SKIPPED (requires-network)", results.Interactions[1].Result(), "The reason for skipping is reported.")
+	require.Equal(t, tokenizer.SkipRequested, results.Interactions[1].SkipReason, "The kind of skip is recorded.")
+	var buffer bytes.Buffer
+	require.NoError(t, writeReport(&buffer, formatJUnit, []runner.DocumentResult{results}), "Writing the JUnit report should work.")
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(buffer.Bytes(), &report), "The JUnit report should be valid XML.")
+	skipped := report.TestSuites[0].TestCases[1]
+	require.NotNil(t, skipped.Skipped, "The skipped interaction is a skipped test case.")
+	require.Contains(t, skipped.Properties, junitProperty{"shelldoc.skip_reason", "requested"}, "The kind of skip is a property of the test case.")
+
+	defer func() { options.noSkips = false }()
+	options.noSkips = true
+	results, err = performInteractions(context.Background(), "../../pkg/tokenizer/samples/skip.md")
+	require.NoError(t, err, "The Skip example should execute without errors.")
+	require.Equal(t, returnFailure, results.ReturnCode, "Skipped interactions fail the test with --no-skips.")
+	require.Equal(t, 2, results.FailureCount, "Both skipped interactions are counted as failures.")
+}
+
+func TestTimeouts(t *testing.T) {
+	results, err := performInteractions(context.Background(), "../../pkg/tokenizer/samples/timeout.md")
+	require.NoError(t, err, "The Timeout example should execute without errors.")
+	require.Equal(t, returnFailure, results.ReturnCode, "Timeouts are failures.")
+	require.Equal(t, 2, results.TestCount, "The third interaction is not executed after the timeout.")
+	require.Equal(t, tokenizer.ResultTimeout, results.Interactions[1].ResultCode, "The second interaction timed out.")
+
+	defer func() { options.fileTimeout = 0 }()
+	options.fileTimeout = time.Nanosecond
+	results, err = performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The HelloWorld example should execute without errors.")
+	require.Equal(t, returnFailure, results.ReturnCode, "The document did not finish in time.")
+	require.Zero(t, results.TestCount, "No interactions are executed after the document timeout.")
+}
+
+func TestProfiling(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	directory, err := ioutil.TempDir("", "shelldoc-profiles")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	options.cpuProfile = filepath.Join(directory, "cpu.pprof")
+	options.memProfile = filepath.Join(directory, "mem.pprof")
+	require.NoError(t, startProfiling(), "Starting the CPU profile should work.")
+	_, err = performInteractions(context.Background(), "../../pkg/tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The document should execute without errors.")
+	stopProfiling()
+	for _, profile := range []string{options.cpuProfile, options.memProfile} {
+		info, err := os.Stat(profile)
+		require.NoError(t, err, "The profile was written.")
+		require.NotZero(t, info.Size(), "The profile is not empty.")
+	}
+	options.cpuProfile = filepath.Join(directory, "missing", "cpu.pprof")
+	require.Error(t, startProfiling(), "A profile that cannot be created is an error.")
+}
+
+func TestRecord(t *testing.T) {
+	var output bytes.Buffer
+	commands, err := record(context.Background(), strings.NewReader("export NAME=World\n\necho Hello $NAME\n(exit 3)\necho '$ not a command'\nexit\necho after exit\n"), &output)
+	require.NoError(t, err, "Recording the session should work.")
+	require.Len(t, commands, 4, "Empty lines and the commands after exit are not recorded.")
+	require.Contains(t, output.String(), "$ Hello World", "The output is echoed after the prompt.")
+	require.Equal(t, 3, commands[2].exitCode, "The exit code of the commands is recorded.")
+
+	var document bytes.Buffer
+	require.NoError(t, writeRecording(&document, "Greeting", commands), "Writing the document should work.")
+	require.Equal(t, "# Greeting\n\n```shell\n$ export NAME=World\n$ echo Hello $NAME\nHello World\n```\n\n```shell {shelldocexitcode=3}\n$ (exit 3)\n```\n\n```shell\n$ echo '$ not a command'\n...\n```\n", document.String(), "Failing commands get a code block of their own.")
+
+	directory, err := ioutil.TempDir("", "shelldoc-record")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	file := filepath.Join(directory, "recorded.md")
+	require.NoError(t, saveRecording(file, "Greeting", commands), "Saving the document should work.")
+	results, err := performInteractions(context.Background(), file)
+	require.NoError(t, err, "The recorded document should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The recorded document passes.")
+	require.Equal(t, 4, results.SuccessCount, "All recorded commands pass.")
+}
+
+func TestImportCast(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24, "title": "Greeting"}
+[0.1, "o", "Welcome\r\n\u001b]0;user@host: ~\u0007\u001b[01;32muser@host\u001b[00m:~$ "]
+[0.5, "o", "echo Hello\r\n"]
+[0.6, "o", "Hello\r\n"]
+[0.7, "i", "ignored"]
+[0.8, "o", "user@host:~$ printf 'ab\\bc\\n'\r\nac\r\nuser@host:~$ \r\n"]
+[1.0, "o", "user@host:~$ exit\r\n"]
+`
+	title, commands, err := importCast(strings.NewReader(cast), defaultPromptPattern)
+	require.NoError(t, err, "Importing the recording should work.")
+	require.Equal(t, "Greeting", title, "The title of the recording is used.")
+	require.Equal(t, []recordedCommand{
+		{command: "echo Hello", output: []string{"Hello"}},
+		{command: "printf 'ab\\bc\\n'", output: []string{"ac"}},
+	}, commands, "The commands and their cleaned output are imported, empty prompts and exit are dropped.")
+
+	_, _, err = importCast(strings.NewReader(`{"version": 1}`), defaultPromptPattern)
+	require.Error(t, err, "Only version 2 and 3 recordings are supported.")
+	_, _, err = importCast(strings.NewReader(cast), "^> ")
+	require.Error(t, err, "A recording without commands is an error.")
+}
+
+func TestInit(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-init")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	created, err := scaffold(directory, defaultExampleFile, false)
+	require.NoError(t, err, "Creating the example should work.")
+	require.Len(t, created, 2, "The example document and the configuration file are created.")
+	_, err = scaffold(directory, defaultExampleFile, false)
+	require.Error(t, err, "Existing files are not overwritten.")
+	_, err = scaffold(directory, defaultExampleFile, true)
+	require.NoError(t, err, "Existing files are overwritten with --force.")
+
+	_, err = loadConfig(filepath.Join(directory, defaultConfigFile), true)
+	require.NoError(t, err, "The starter configuration file is valid.")
+	for _, format := range formats {
+		require.Contains(t, exampleConfig, " "+format, "The starter configuration file lists all output formats.")
+	}
+	results, err := performInteractions(context.Background(), filepath.Join(directory, defaultExampleFile))
+	require.NoError(t, err, "The example document should execute without errors.")
+	require.Equal(t, returnSuccess, results.ReturnCode, "The example document passes.")
+	require.Equal(t, 1, results.SkipCount, "The example document demonstrates skipping.")
+}
+
+func TestSelfcheck(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	results, err := selfcheck(context.Background())
+	require.NoError(t, err, "The self check should execute without errors.")
+	require.Len(t, results.Documents, len(selfcheckDocuments), "All sample documents are executed.")
+	for _, document := range results.Documents {
+		require.Equal(t, returnSuccess, document.ReturnCode, "The sample documents pass.")
+		require.Zero(t, document.SkipCount, "No interactions of the sample documents are skipped.")
+	}
+}
+
+func TestServe(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.quiet = true
+	directory, err := ioutil.TempDir("", "shelldoc")
+	require.NoError(t, err, "Creating a temporary directory should work.")
+	defer os.RemoveAll(directory)
+	handler := newServer(directory, "", "secret")
+	server := httptest.NewServer(handler.handler())
+	defer server.Close()
+	handler.address = server.Listener.Addr().String()
+	post := func(contentType, token, origin, body string) *http.Response {
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/api/runs", strings.NewReader(body))
+		require.NoError(t, err, "Creating the request should work.")
+		request.Header.Set("Content-Type", contentType)
+		if len(token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		if len(origin) > 0 {
+			request.Header.Set("Origin", origin)
+		}
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err, "Submitting a run should work.")
+		return response
+	}
+	marker := filepath.Join(directory, "pwned")
+	attack := `{"document": "    $ touch ` + marker + `\n"}`
+	for _, test := range []struct {
+		contentType, token, origin string
+		status                     int
+	}{
+		{"text/plain", "secret", "http://evil.example", http.StatusForbidden},
+		{"text/plain", "secret", "", http.StatusUnsupportedMediaType},
+		{"application/json", "secret", "http://evil.example", http.StatusForbidden},
+		{"application/json", "", "", http.StatusUnauthorized},
+		{"application/json", "guessed", "", http.StatusUnauthorized},
+	} {
+		response := post(test.contentType, test.token, test.origin, attack)
+		response.Body.Close()
+		require.Equal(t, test.status, response.StatusCode, "Runs from other origins, not sent as JSON or without the token are refused.")
+	}
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/api/runs", nil)
+	require.NoError(t, err, "Creating the request should work.")
+	request.Host = "rebound.example:" + strings.Split(handler.address, ":")[1]
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err, "Requesting the runs should work.")
+	response.Body.Close()
+	require.Equal(t, http.StatusForbidden, response.StatusCode, "Requests for another host are refused.")
+	require.NoFileExists(t, marker, "The refused documents were not executed.")
+
+	response = post("application/json", "secret", server.URL, `{"paths": ["../../pkg/tokenizer/samples/helloworld.md"], "document": "    $ echo No\n    Yes\n"}`)
+	require.Equal(t, http.StatusAccepted, response.StatusCode, "The run is accepted.")
+	var run serverRun
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&run), "The response describes the run.")
+	response.Body.Close()
+	require.Len(t, run.Files, 2, "The path and the submitted document are executed.")
+	for run.Status == runRunning {
+		time.Sleep(10 * time.Millisecond)
+		response, err = http.Get(fmt.Sprintf("%s/api/runs/%d", server.URL, run.ID))
+		require.NoError(t, err, "Polling the run should work.")
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&run), "The response describes the run.")
+		response.Body.Close()
+	}
+	require.Equal(t, runFinished, run.Status, "The run finished.")
+	require.Equal(t, runner.ReturnFailure, run.Result.ReturnCode, "The submitted document fails.")
+	require.Equal(t, runner.ReturnSuccess, run.Result.Documents[0].ReturnCode, "The document on the server passes.")
+
+	response, err = http.Get(server.URL + "/")
+	require.NoError(t, err, "The web UI should be served.")
+	page, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	require.Contains(t, string(page), "helloworld.md", "The web UI lists the documents.")
+	require.Contains(t, string(page), "FAILURE", "The web UI shows the results.")
+
+	response = post("application/json", "secret", "", `{}`)
+	response.Body.Close()
+	require.Equal(t, http.StatusBadRequest, response.StatusCode, "Runs without documents are rejected.")
+	response, err = http.Get(server.URL + "/api/runs/42")
+	require.NoError(t, err, "Requesting an unknown run should work.")
+	response.Body.Close()
+	require.Equal(t, http.StatusNotFound, response.StatusCode, "Unknown runs are not found.")
+}
+
+func TestLanguageServer(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.quiet = true
+	const uri = "file:///tmp/example.md"
+	text := "# Example\n\n    $ echo No\n    Yes\n"
+	session := newLSPSession(t)
+	session.send(1, "initialize", map[string]interface{}{})
+	session.send(0, "textDocument/didOpen", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": text}})
+	session.send(2, "textDocument/codeLens", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}})
+	session.send(3, "workspace/executeCommand", map[string]interface{}{"command": lspRunDocument, "arguments": []interface{}{uri}})
+	session.send(4, "textDocument/hover", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}, "position": map[string]int{"line": 2, "character": 6}})
+	messages := session.run()
+	require.Len(t, messages, 6, "There are four responses and two diagnostics notifications.")
+	require.Equal(t, "textDocument/publishDiagnostics", messages[1].Method, "Opening a document publishes diagnostics.")
+	lenses, _ := json.Marshal(messages[2].Result)
+	require.Contains(t, string(lenses), lspRunBlock, "A code lens executes the code block.")
+	require.Equal(t, "textDocument/publishDiagnostics", messages[3].Method, "Executing the document publishes diagnostics.")
+	require.Contains(t, string(messages[3].Params), "FAIL (mismatch)", "The failing interaction is reported.")
+	require.Contains(t, string(messages[3].Params), `"line":2`, "The failure is reported in the line of the command.")
+	hover, _ := json.Marshal(messages[5].Result)
+	require.Contains(t, string(hover), "No", "Hovering shows the actual output.")
+}
+
+func TestLanguageServerVariables(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.quiet = true
+	options.variables = []string{"name=World"}
+	const uri = "file:///tmp/variables.md"
+	text := "---\nvariables:\n  greeting: Hello\n---\n\n    $ echo {{greeting}} {{name}}\n    Hello World\n\n```shell\n$ echo {{greeting}} again\nHello again\n```\n"
+	session := newLSPSession(t)
+	session.send(0, "textDocument/didOpen", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": text}})
+	session.send(1, "workspace/executeCommand", map[string]interface{}{"command": lspRunBlock, "arguments": []interface{}{uri, 9}})
+	session.send(2, "workspace/executeCommand", map[string]interface{}{"command": lspRunDocument, "arguments": []interface{}{uri}})
+	session.send(3, "textDocument/hover", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}, "position": map[string]int{"line": 5, "character": 6}})
+	messages := session.run()
+	require.Len(t, messages, 6, "There are three responses and three diagnostics notifications.")
+	require.NotContains(t, string(messages[1].Params), "FAIL", "The variables are expanded when executing a code block.")
+	require.NotContains(t, string(messages[3].Params), "FAIL", "The variables are expanded when executing the document.")
+	hover, _ := json.Marshal(messages[5].Result)
+	require.Contains(t, string(hover), "Hello World", "The results of the interactions with variables are shown.")
+}
+
+func TestLanguageServerHeaders(t *testing.T) {
+	for _, header := range []string{"Content-Length: -1\r\n\r\n{}", "Content-Length: many\r\n\r\n{}", "Content-Type: text/plain\r\n\r\n{}"} {
+		_, err := newLSPServer(strings.NewReader(header), ioutil.Discard).read()
+		require.Error(t, err, "The header %q is rejected.", header)
+		require.Contains(t, err.Error(), "Content-Length", "The error names the invalid header.")
+	}
+	message, err := newLSPServer(strings.NewReader("Content-Length: 2\r\n\r\n{}"), ioutil.Discard).read()
+	require.NoError(t, err, "A valid header is accepted.")
+	require.Empty(t, message.Method, "The message is read.")
+}
+
+// lspSession collects the messages a client sends to the language server
+type lspSession struct {
+	t     *testing.T
+	input bytes.Buffer
+}
+
+func newLSPSession(t *testing.T) *lspSession {
+	return &lspSession{t: t}
+}
+
+// send adds a request, or a notification if the id is zero
+func (session *lspSession) send(id int, method string, params interface{}) {
+	message := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		message["id"] = id
+	}
+	data, err := json.Marshal(message)
+	require.NoError(session.t, err, "Encoding the message should work.")
+	fmt.Fprintf(&session.input, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// run lets the language server handle the messages and exit, and returns the messages it sent
+func (session *lspSession) run() []lspMessage {
+	session.send(0, "exit", nil)
+	var output bytes.Buffer
+	require.NoError(session.t, newLSPServer(&session.input, &output).run(), "The language server should handle the session.")
+	client := newLSPServer(&output, ioutil.Discard)
+	var messages []lspMessage
+	for {
+		message, err := client.read()
+		if err != nil {
+			break
+		}
+		messages = append(messages, message)
+	}
+	return messages
 }
@@ -0,0 +1,40 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	shuffleOff = "off"
+	shuffleOn  = "on"
+)
+
+// shuffleSeed returns the seed for the --shuffle value, a new one based on the current time if it is "on"
+func shuffleSeed(value string) (int64, error) {
+	if value == shuffleOn {
+		return time.Now().UnixNano(), nil
+	}
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value \"%s\" for --shuffle, use %s, %s or an integer seed", value, shuffleOn, shuffleOff)
+	}
+	return seed, nil
+}
+
+// shuffle returns the files in a random order determined by the seed
+// Only the order of the documents is randomized. The interactions in a document share a shell and depend on each other.
+func shuffle(files []string, seed int64) []string {
+	shuffled := append([]string(nil), files...)
+	random := rand.New(rand.NewSource(seed))
+	random.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
@@ -0,0 +1,67 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// snapshotObserver writes the actual output of every executed interaction to a file in directory
+// Reviewers can inspect or diff the full outputs, and other tools post-process them. Problems writing the snapshots
+// are logged, they do not fail the run.
+type snapshotObserver struct {
+	runner.NopObserver
+	directory string
+}
+
+// snapshotPath returns the path of the snapshot of the output of an interaction in a document
+// Every document has a directory named like its transcript, the snapshots in it are named by the IDs of the
+// interactions, which do not change when lines are added to or removed from the document. The error output is written
+// next to it, with the .stderr extension instead of .out.
+func snapshotPath(directory, document string, interaction *tokenizer.Interaction) string {
+	name := interaction.ID
+	if len(name) == 0 {
+		name = fmt.Sprintf("line-%d", interaction.Line)
+	}
+	return filepath.Join(directory, documentFileName(document), name+".out")
+}
+
+// snapshotContent returns the lines of output as the content of a snapshot file
+func snapshotContent(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// OnInteractionDone writes the output of the interaction, and its error output if there is any
+func (observer *snapshotObserver) OnInteractionDone(file string, interaction *tokenizer.Interaction) {
+	if interaction.ResultCode == tokenizer.ResultSkipped {
+		return
+	}
+	path := snapshotPath(observer.directory, file, interaction)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Unable to create the snapshot directory: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, snapshotContent(interaction.Output), 0644); err != nil {
+		log.Printf("Unable to write the snapshot of %s:%d: %v", file, interaction.Line, err)
+		return
+	}
+	stderr := strings.TrimSuffix(path, ".out") + ".stderr"
+	if len(interaction.Stderr) == 0 {
+		os.Remove(stderr) // written by an earlier run
+	} else if err := ioutil.WriteFile(stderr, snapshotContent(interaction.Stderr), 0644); err != nil {
+		log.Printf("Unable to write the snapshot of %s:%d: %v", file, interaction.Line, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// stepper executes the interactions of a document one at a time, as confirmed by the user
+type stepper struct {
+	input  *bufio.Scanner
+	output io.Writer
+	// edited contains the interactions whose command was changed and should be written back
+	edited []*tokenizer.Interaction
+	// accepted contains the interactions whose actual output should be written back as the expected response
+	accepted []*tokenizer.Interaction
+}
+
+// step executes the interactions in the document interactively, reading the choices of the user from in
+// Commands can be edited before they are executed, and the actual output of mismatching interactions can be accepted
+// as the new expected response. The corrections are written back to the document if the user confirms it at the end.
+func step(in io.Reader, out io.Writer, file string) error {
+	r := newRunner()
+	shell, err := r.StartShell()
+	if err != nil {
+		return err
+	}
+	defer shell.Close()
+	interactions, err := r.Discover(file)
+	if err != nil {
+		return err
+	}
+
+	s := stepper{input: bufio.NewScanner(in), output: out}
+	for index, interaction := range interactions {
+		fmt.Fprintf(out, "\n[%d/%d] %s:%d %s\n", index+1, len(interactions), file, interaction.Line, interaction.Describe())
+		fmt.Fprintf(out, "  $ %s\n", interaction.Cmd)
+		for _, line := range interaction.Response {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+		choice, ok := s.ask("[Enter] run, (e)dit, (s)kip, (q)uit? ")
+		if !ok || choice == "q" {
+			break
+		}
+		if choice == "s" {
+			continue
+		}
+		if choice == "e" {
+			command, ok := s.ask("command: ")
+			if !ok {
+				break
+			}
+			if len(command) > 0 && command != interaction.Cmd {
+				interaction.Cmd = command
+				s.edited = append(s.edited, interaction)
+			}
+		}
+		interaction.Timeout = options.timeout
+		interaction.KeepCarriageReturns = options.keepCR
+		if err := interaction.Execute(shell); err != nil {
+			fmt.Fprintf(out, "ERROR: %v\n", err)
+		}
+		for _, line := range interaction.ComparedOutput() {
+			fmt.Fprintf(out, "> %s\n", line)
+		}
+		if interaction.NoFinalNewline {
+			fmt.Fprintf(out, "%s\n", tokenizer.NoNewlineMarker)
+		}
+		fmt.Fprintf(out, "%s\n", interaction.Result())
+		if interaction.TerminatedShell() {
+			fmt.Fprintf(out, "the shell was terminated (%s), stopping\n", interaction.ResultCode)
+			break
+		}
+		if interaction.ResultCode == tokenizer.ResultMismatch && !isGeneral(interaction.Response) {
+			if choice, ok := s.ask("accept the output as the expected response? [y/N] "); ok && choice == "y" {
+				s.accepted = append(s.accepted, interaction)
+			}
+		}
+	}
+	return s.writeCorrections(file)
+}
+
+// ask prints the prompt and returns the trimmed line entered by the user, or false at the end of the input
+func (s *stepper) ask(prompt string) (string, bool) {
+	fmt.Fprint(s.output, prompt)
+	if !s.input.Scan() {
+		fmt.Fprintln(s.output)
+		return "", false
+	}
+	return strings.TrimSpace(s.input.Text()), true
+}
+
+// writeCorrections writes the edited commands and the accepted responses back to the document, if the user confirms it
+func (s *stepper) writeCorrections(file string) error {
+	count := len(s.edited) + len(s.accepted)
+	if count == 0 {
+		return nil
+	}
+	choice, ok := s.ask(fmt.Sprintf("write %d corrections to %s? [y/N] ", count, file))
+	if !ok || choice != "y" {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("unable to update %s: %v", file, err)
+	}
+	data, format, err := readDocument(file)
+	if err != nil {
+		return fmt.Errorf("unable to update %s: %v", file, err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, interaction := range s.edited {
+		if interaction.Line < 1 || interaction.Line > len(lines) {
+			continue
+		}
+		command := interaction.Line - 1
+		lines[command] = indentationOf(lines[command]) + "$ " + interaction.Cmd
+	}
+	updated, _ := updateResponses([]byte(strings.Join(lines, "\n")), s.accepted)
+	if err := writeDocument(file, updated, format, info.Mode()); err != nil {
+		return fmt.Errorf("unable to update %s: %v", file, err)
+	}
+	fmt.Fprintf(s.output, "SHELLDOC: updated %d interactions in \"%s\"\n", count, file)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// initializeTracing installs an OTLP/HTTP exporter for the given endpoint URL.
+// Without an endpoint, the global no-op tracer remains in place and spans are discarded.
+// The returned function flushes pending spans and needs to be called before the program exits.
+func initializeTracing(endpoint string) (func(), error) {
+	if len(endpoint) == 0 {
+		return func() {}, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up trace exporter for %s: %v", endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("shelldoc"))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Sending traces to %s.", endpoint)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Printf("unable to flush traces: %v", err)
+		}
+	}, nil
+}
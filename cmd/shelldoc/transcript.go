@@ -0,0 +1,97 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// transcriptTimeFormat is the format of the timestamps in transcripts, with milliseconds
+const transcriptTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// transcriptObserver writes a transcript of the shell session of every document to a file in directory
+// The transcript contains every command with its full output, error output, exit code and timestamps. Problems
+// writing it are logged, they do not fail the run.
+type transcriptObserver struct {
+	runner.NopObserver
+	directory string
+	file      *os.File
+	started   time.Time
+}
+
+// transcriptPath returns the path of the transcript of a document in the directory
+func transcriptPath(directory, document string) string {
+	return filepath.Join(directory, documentFileName(document)+".transcript")
+}
+
+// documentFileName flattens the path of a document into a file name
+// Documents with the same name in different directories get different file names, so that they do not overwrite each
+// other's transcripts or snapshots.
+func documentFileName(document string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(filepath.Clean(document))
+	return strings.TrimLeft(name, "._")
+}
+
+// OnDocumentStart creates the transcript of the document
+func (observer *transcriptObserver) OnDocumentStart(file string, interactions []*tokenizer.Interaction) {
+	if err := os.MkdirAll(observer.directory, 0755); err != nil {
+		log.Printf("Unable to create the transcript directory: %v", err)
+		return
+	}
+	transcript, err := os.Create(transcriptPath(observer.directory, file))
+	if err != nil {
+		log.Printf("Unable to create the transcript of %s: %v", file, err)
+		return
+	}
+	observer.file = transcript
+	fmt.Fprintf(observer.file, "# shelldoc transcript of %s, started %s\n", file, time.Now().Format(transcriptTimeFormat))
+}
+
+// OnInteractionStart records the time the command is started
+func (observer *transcriptObserver) OnInteractionStart(file string, interaction *tokenizer.Interaction) {
+	observer.started = time.Now()
+}
+
+// OnInteractionDone writes the command, its output, error output, exit code and result to the transcript
+func (observer *transcriptObserver) OnInteractionDone(file string, interaction *tokenizer.Interaction) {
+	if observer.file == nil {
+		return
+	}
+	fmt.Fprintf(observer.file, "\n[%s] %s:%d\n$ %s\n", observer.started.Format(transcriptTimeFormat), file, interaction.Line, interaction.Cmd)
+	if interaction.ResultCode == tokenizer.ResultSkipped {
+		fmt.Fprintf(observer.file, "%s\n", interaction.Result())
+		return
+	}
+	for _, line := range interaction.Output {
+		fmt.Fprintf(observer.file, "stdout: %s\n", line)
+	}
+	for _, line := range interaction.Stderr {
+		fmt.Fprintf(observer.file, "stderr: %s\n", line)
+	}
+	fmt.Fprintf(observer.file, "[%s] exit code %d after %v: %s\n", time.Now().Format(transcriptTimeFormat), interaction.ExitCode, interaction.Duration, interaction.Result())
+	if len(interaction.Comment) > 0 {
+		fmt.Fprintf(observer.file, "%s\n", interaction.Comment)
+	}
+}
+
+// OnDocumentDone writes the result of the document and closes the transcript
+func (observer *transcriptObserver) OnDocumentDone(result runner.DocumentResult) {
+	if observer.file == nil {
+		return
+	}
+	fmt.Fprintf(observer.file, "\n# %s\n", tokenizer.Translate(tokenizer.MessageSummary, runner.Verdict(result.ReturnCode), result.TestCount, result.SuccessCount, result.FailureCount, result.ErrorCount, result.SkipCount))
+	if err := observer.file.Close(); err != nil {
+		log.Printf("Unable to write the transcript of %s: %v", result.File, err)
+	}
+	observer.file = nil
+}
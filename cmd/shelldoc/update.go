@@ -0,0 +1,122 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// update executes the documents and replaces the mismatching expected responses with the actual output
+// It returns the overall return code of the execution, before the documents have been updated.
+func update(files []string) (int, error) {
+	returnCode := returnSuccess
+	for _, file := range files {
+		if runner.IsExcluded(file, options.excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		results, err := performInteractions(context.Background(), file)
+		if err != nil {
+			return returnError, err
+		}
+		returnCode = max(results.ReturnCode, returnCode)
+		info, err := os.Stat(file)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to update %s: %v", file, err)
+		}
+		data, format, err := readDocument(file)
+		if err != nil {
+			return returnError, fmt.Errorf("unable to update %s: %v", file, err)
+		}
+		updated, count := updateResponses(data, results.Interactions)
+		if count == 0 {
+			continue
+		}
+		if err := writeDocument(file, updated, format, info.Mode()); err != nil {
+			return returnError, fmt.Errorf("unable to update %s: %v", file, err)
+		}
+		fmt.Fprintf(console(), "SHELLDOC: updated %d interactions in \"%s\"\n", count, file)
+	}
+	return returnCode, nil
+}
+
+// updateResponses replaces the expected responses of the mismatching interactions in the document with their actual output
+// The replacement uses the indentation of the command. It returns the updated document and the number of updated interactions.
+func updateResponses(data []byte, interactions []*tokenizer.Interaction) ([]byte, int) {
+	lines := strings.Split(string(data), "\n")
+	count := 0
+	// update from the end of the document, so that the line numbers of the remaining interactions stay valid
+	for index := len(interactions) - 1; index >= 0; index-- {
+		interaction := interactions[index]
+		if interaction.ResultCode != tokenizer.ResultMismatch || interaction.Line < 1 || interaction.Line > len(lines) {
+			continue
+		}
+		if isGeneral(interaction.Response) {
+			log.Printf("not updating \"%s\" in line %d, the expected response contains an ellipsis or a pattern", interaction.Cmd, interaction.Line)
+			continue
+		}
+		command := interaction.Line - 1
+		end, found := locateResponse(lines, interaction)
+		if !found {
+			log.Printf("not updating \"%s\" in line %d, unable to locate the expected response", interaction.Cmd, interaction.Line)
+			continue
+		}
+		indentation := indentationOf(lines[command])
+		var replacement []string
+		for _, line := range interaction.ComparedOutput() {
+			replacement = append(replacement, indentation+line)
+		}
+		if interaction.NoFinalNewline {
+			replacement = append(replacement, indentation+tokenizer.NoNewlineMarker)
+		}
+		lines = append(lines[:command+1], append(replacement, lines[end:]...)...)
+		count++
+	}
+	return []byte(strings.Join(lines, "\n")), count
+}
+
+// locateResponse finds the lines of the expected response of the interaction in the document
+// The response lines are matched in order after the line of the command, skipping empty lines. It returns the
+// index of the line after the response, and false if the response could not be located.
+func locateResponse(lines []string, interaction *tokenizer.Interaction) (int, bool) {
+	command := interaction.Line - 1
+	end := command + 1
+	matched := 0
+	for position := command + 1; position < len(lines) && matched < len(interaction.Response); position++ {
+		text := strings.TrimSpace(lines[position])
+		if len(text) == 0 {
+			continue
+		}
+		if text != interaction.Response[matched] {
+			break
+		}
+		matched++
+		end = position + 1
+	}
+	return end, matched == len(interaction.Response)
+}
+
+// isGeneral returns true if the response accepts any output after a certain line, or contains a line that is a
+// pattern, so it cannot be replaced with the output without losing that
+func isGeneral(response []string) bool {
+	for _, line := range response {
+		if strings.TrimSpace(line) == "..." || strings.HasPrefix(line, tokenizer.PatternPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// indentationOf returns the leading whitespace of the line
+func indentationOf(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
@@ -0,0 +1,104 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleTime is the time to wait after a change before the documents are executed again
+// Editors often write a file in several steps when saving it.
+const settleTime = 200 * time.Millisecond
+
+// watch executes the documents, and then again whenever one of them changes, until the program is interrupted
+// The directories containing the documents are watched instead of the files, because many editors replace the
+// file when saving it.
+func watch(files []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch the documents: %v", err)
+	}
+	defer watcher.Close()
+	watched, err := watchDocuments(watcher, files)
+	if err != nil {
+		return err
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	run(files)
+	fmt.Fprintf(console(), "SHELLDOC: watching %d documents for changes, press Ctrl-C to stop ...\n", len(watched))
+
+	changed := make(map[string]bool)
+	var settled <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if file, ok := changedDocument(watched, event); ok {
+				log.Printf("Document %s changed.", file)
+				changed[file] = true
+				settled = time.After(settleTime)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("error watching the documents: %v", err)
+		case <-settled:
+			var documents []string
+			for file := range changed {
+				documents = append(documents, file)
+			}
+			sort.Strings(documents)
+			changed = make(map[string]bool)
+			settled = nil
+			run(documents)
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+// watchDocuments adds the directories containing the documents to the watcher, and returns the documents by their
+// cleaned paths, mapped to the paths as specified
+func watchDocuments(watcher *fsnotify.Watcher, files []string) (map[string]string, error) {
+	watched := make(map[string]string)
+	for _, file := range files {
+		watched[filepath.Clean(file)] = file
+	}
+	directories := make(map[string]bool)
+	for file := range watched {
+		directories[filepath.Dir(file)] = true
+	}
+	for directory := range directories {
+		if err := watcher.Add(directory); err != nil {
+			return nil, fmt.Errorf("unable to watch directory %s: %v", directory, err)
+		}
+	}
+	return watched, nil
+}
+
+// changedDocument returns the document, as specified, whose content an event of the watcher changed, and false if
+// the event does not change a watched document
+// Documents that are replaced, like by editors that write a new file and rename it, are created.
+func changedDocument(watched map[string]string, event fsnotify.Event) (string, bool) {
+	file, ok := watched[filepath.Clean(event.Name)]
+	if !ok || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+		return "", false
+	}
+	return file, true
+}
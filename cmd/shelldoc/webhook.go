@@ -0,0 +1,138 @@
+package main
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: GPL-3.0
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+// The kinds of webhooks that are notified when a run fails
+const (
+	webhookSlack = "slack"
+	webhookTeams = "teams"
+	webhookJSON  = "json"
+)
+
+// webhookKinds lists the supported kinds of webhooks
+var webhookKinds = []string{webhookSlack, webhookTeams, webhookJSON}
+
+// webhookTimeout is the time a webhook may take to accept a notification
+const webhookTimeout = 10 * time.Second
+
+// maxWebhookFailures limits the failing interactions listed in chat messages, the JSON payload contains all of them
+const maxWebhookFailures = 10
+
+// webhookFailure describes a failing interaction in a notification
+type webhookFailure struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+	Result  string `json:"result"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// webhookPayload is the notification sent to generic JSON webhooks
+type webhookPayload struct {
+	Verdict    string           `json:"verdict"`
+	ReturnCode int              `json:"return_code"`
+	Documents  int              `json:"documents"`
+	Tests      int              `json:"tests"`
+	Failures   int              `json:"failures"`
+	Errors     int              `json:"errors"`
+	Failing    []webhookFailure `json:"failing"`
+}
+
+// webhookReporter notifies a webhook when a run fails, successful runs are not reported
+type webhookReporter struct {
+	kind string
+	url  string
+}
+
+// parseWebhook parses a --webhook specification in KIND=URL form
+func parseWebhook(spec string) (webhookReporter, error) {
+	elements := strings.SplitN(spec, "=", 2)
+	if len(elements) != 2 || len(elements[1]) == 0 {
+		return webhookReporter{}, fmt.Errorf("invalid webhook \"%s\", use KIND=URL", spec)
+	}
+	for _, kind := range webhookKinds {
+		if elements[0] == kind {
+			return webhookReporter{kind: kind, url: elements[1]}, nil
+		}
+	}
+	return webhookReporter{}, fmt.Errorf("unknown webhook kind \"%s\", supported kinds are %s", elements[0], strings.Join(webhookKinds, ", "))
+}
+
+// Report sends the summary of a failed run and its failing interactions to the webhook
+func (reporter webhookReporter) Report(result runner.Result) error {
+	if result.ReturnCode == runner.ReturnSuccess {
+		return nil
+	}
+	summary := summarize(result)
+	var payload interface{} = summary
+	switch reporter.kind {
+	case webhookSlack:
+		payload = map[string]string{"text": webhookMessage(summary, "*%s*", "`%s`")}
+	case webhookTeams:
+		// Teams needs empty lines to break lines in Markdown
+		payload = map[string]string{"title": "shelldoc: " + summary.Verdict, "text": strings.Replace(webhookMessage(summary, "**%s**", "`%s`"), "\n", "\n\n", -1)}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to notify the %s webhook: %v", reporter.kind, err)
+	}
+	client := http.Client{Timeout: webhookTimeout}
+	response, err := client.Post(reporter.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to notify the %s webhook: %v", reporter.kind, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unable to notify the %s webhook: %s", reporter.kind, response.Status)
+	}
+	return nil
+}
+
+// summarize counts the results of a run and collects the failing interactions
+func summarize(result runner.Result) webhookPayload {
+	summary := webhookPayload{Verdict: runner.Verdict(result.ReturnCode), ReturnCode: result.ReturnCode, Documents: len(result.Documents), Failing: []webhookFailure{}}
+	for _, document := range result.Documents {
+		summary.Tests += document.TestCount
+		summary.Failures += document.FailureCount
+		summary.Errors += document.ErrorCount
+		for _, interaction := range document.Interactions {
+			if isReported(interaction) {
+				summary.Failing = append(summary.Failing, webhookFailure{
+					File:    document.File,
+					Line:    interaction.Line,
+					Command: interaction.Cmd,
+					Result:  interaction.Result(),
+					Comment: interaction.Comment,
+				})
+			}
+		}
+	}
+	return summary
+}
+
+// webhookMessage formats the summary as a chat message, using the markup for bold text and code of the chat service
+func webhookMessage(summary webhookPayload, bold, code string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, bold+": %d documents, %d tests, %d failures, %d execution errors\n", "shelldoc "+summary.Verdict, summary.Documents, summary.Tests, summary.Failures, summary.Errors)
+	for index, failure := range summary.Failing {
+		if index == maxWebhookFailures {
+			fmt.Fprintf(&builder, "... and %d more\n", len(summary.Failing)-index)
+			break
+		}
+		fmt.Fprintf(&builder, "- %s:%d "+code+": %s\n", failure.File, failure.Line, failure.Command, failure.Result)
+	}
+	return builder.String()
+}
@@ -0,0 +1,138 @@
+package plugin
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+)
+
+// executeRequest is sent to a backend plugin for every command
+type executeRequest struct {
+	Command string `json:"command"`
+}
+
+// executeResponse is the answer of a backend plugin to a command
+type executeResponse struct {
+	Stdout   []string `json:"stdout"`
+	Stderr   []string `json:"stderr"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Backend is a shell.Backend that executes the commands using a backend plugin
+type Backend struct {
+	// Name is the name of the plugin
+	Name string
+	// Env contains additional environment variables in KEY=value form that are set for the plugin
+	Env []string
+
+	cmd     *exec.Cmd
+	encoder *json.Encoder
+	stdin   io.WriteCloser
+	scanner *bufio.Scanner
+}
+
+// NewBackend returns a function that creates the backend plugin with the given name, for runner.Options.NewBackend
+func NewBackend(name string, env ...string) func() shell.Backend {
+	return func() shell.Backend {
+		return &Backend{Name: name, Env: env}
+	}
+}
+
+// Start launches the plugin
+func (backend *Backend) Start() error {
+	path, err := Find(KindBackend, backend.Name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), backend.Env...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("unable to set up input stream for backend plugin %s: %v", backend.Name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to set up output stream for backend plugin %s: %v", backend.Name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start backend plugin %s: %v", backend.Name, err)
+	}
+	backend.cmd, backend.stdin, backend.encoder = cmd, stdin, json.NewEncoder(stdin)
+	backend.scanner = bufio.NewScanner(stdout)
+	backend.scanner.Buffer(nil, 16*1024*1024)
+	return nil
+}
+
+// Execute sends a command to the plugin and waits for the answer
+func (backend *Backend) Execute(ctx context.Context, command string) ([]string, []string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, -1, contextError(err)
+	}
+	if err := backend.encoder.Encode(executeRequest{command}); err != nil {
+		return nil, nil, -1, fmt.Errorf("unable to send the command to backend plugin %s: %v", backend.Name, err)
+	}
+	type result struct {
+		response executeResponse
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var response executeResponse
+		if !backend.scanner.Scan() {
+			if err := backend.scanner.Err(); err != nil {
+				done <- result{err: fmt.Errorf("unable to read the answer of backend plugin %s: %v", backend.Name, err)}
+			} else {
+				done <- result{err: shell.ErrShellCrashed}
+			}
+			return
+		}
+		err := json.Unmarshal(backend.scanner.Bytes(), &response)
+		if err != nil {
+			err = fmt.Errorf("invalid answer from backend plugin %s: %v", backend.Name, err)
+		}
+		done <- result{response, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, nil, -1, r.err
+		}
+		if len(r.response.Error) > 0 {
+			return nil, nil, -1, errors.New(r.response.Error)
+		}
+		return r.response.Stdout, r.response.Stderr, r.response.ExitCode, nil
+	case <-ctx.Done():
+		backend.cmd.Process.Kill()
+		return nil, nil, -1, contextError(ctx.Err())
+	}
+}
+
+// Close closes the input of the plugin and waits for it to exit
+func (backend *Backend) Close() error {
+	if backend.cmd == nil {
+		return nil
+	}
+	backend.stdin.Close()
+	return backend.cmd.Wait()
+}
+
+// contextError returns shell.ErrTimeout if the deadline of a context expired, and the error of the context otherwise
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return shell.ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package plugin
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// matchRequest is sent to a matcher plugin
+type matchRequest struct {
+	Expected []string `json:"expected"`
+	Actual   []string `json:"actual"`
+}
+
+// matchResponse is the answer of a matcher plugin
+type matchResponse struct {
+	Match bool   `json:"match"`
+	Error string `json:"error,omitempty"`
+}
+
+// Matcher is a tokenizer.Matcher that executes a matcher plugin for every comparison
+type Matcher struct {
+	// Name is the name of the plugin
+	Name string
+}
+
+// NewMatcher returns the matcher plugin with the given name, for runner.Options.Matchers
+// The plugin is looked up when it is used, so that a missing plugin only fails the interactions that select it.
+func NewMatcher(name string) tokenizer.Matcher {
+	return Matcher{Name: name}
+}
+
+// Match executes the plugin to compare the output with the expected response
+func (matcher Matcher) Match(expected, actual []string) (bool, error) {
+	path, err := Find(KindMatcher, matcher.Name)
+	if err != nil {
+		return false, err
+	}
+	input, err := json.Marshal(matchRequest{nonNil(expected), nonNil(actual)})
+	if err != nil {
+		return false, err
+	}
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("matcher plugin %s failed: %v", matcher.Name, err)
+	}
+	var response matchResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return false, fmt.Errorf("invalid answer from matcher plugin %s: %v", matcher.Name, err)
+	}
+	if len(response.Error) > 0 {
+		return false, errors.New(response.Error)
+	}
+	return response.Match, nil
+}
+
+// nonNil returns an empty slice instead of nil, so that it is serialized as an empty JSON array
+func nonNil(lines []string) []string {
+	if lines == nil {
+		return []string{}
+	}
+	return lines
+}
@@ -0,0 +1,77 @@
+// Package plugin runs external executables that extend shelldoc without recompiling it.
+//
+// A plugin is an executable named shelldoc-<kind>-<name> that is found in $PATH. Plugins exchange JSON messages
+// with shelldoc on their standard input and output, their error output is passed through. The kinds are:
+//
+// A reporter (shelldoc-reporter-<name>) receives the results of the run in the schema of runner.WriteJSON on its
+// standard input, and writes its report to the standard output.
+//
+// A matcher (shelldoc-matcher-<name>) is executed for every interaction in a code block that selects it using the
+// shelldocmatcher=<name> option. It receives {"expected": [...], "actual": [...]} with the lines of the expected
+// response and of the output, and answers {"match": true} or {"match": false}. A non-empty "error" field in the
+// answer reports that the comparison was not possible.
+//
+// A backend (shelldoc-backend-<name>) is started for every document and executes its commands in order. Every
+// command is sent as a line {"command": "..."}, and answered by a line {"stdout": [...], "stderr": [...],
+// "exit_code": 0}, or by a line with a non-empty "error" field if the command could not be executed. The backend
+// should exit when its standard input is closed.
+package plugin
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The kinds of plugins
+const (
+	// KindReporter writes the results of a run
+	KindReporter = "reporter"
+	// KindMatcher compares the output of a command with the expected response
+	KindMatcher = "matcher"
+	// KindBackend executes the commands of a document
+	KindBackend = "backend"
+)
+
+// prefix starts the names of all plugin executables
+const prefix = "shelldoc-"
+
+// executable returns the name of the executable of a plugin
+func executable(kind, name string) string {
+	return fmt.Sprintf("%s%s-%s", prefix, kind, name)
+}
+
+// Find returns the path of the plugin of the given kind and name
+func Find(kind, name string) (string, error) {
+	path, err := exec.LookPath(executable(kind, name))
+	if err != nil {
+		return "", fmt.Errorf("no %s plugin named %s found: %v", kind, name, err)
+	}
+	return path, nil
+}
+
+// List returns the names of the plugins of the given kind that are found in $PATH, in sorted order
+func List(kind string) []string {
+	found := make(map[string]bool)
+	for _, directory := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(directory, executable(kind, "*")))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				found[strings.TrimPrefix(filepath.Base(match), executable(kind, ""))] = true
+			}
+		}
+	}
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
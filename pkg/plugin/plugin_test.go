@@ -0,0 +1,107 @@
+package plugin
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/stretchr/testify/require"
+)
+
+// plugins contains the scripts of the test plugins by executable name
+var plugins = map[string]string{
+	"shelldoc-reporter-echo": "#!/bin/sh\ncat\n",
+	"shelldoc-matcher-yes": `#!/bin/sh
+read -r line
+case "$line" in
+	*'"actual":["yes"'*) echo '{"match": true}' ;;
+	*) echo '{"match": false}' ;;
+esac
+`,
+	"shelldoc-backend-ok": `#!/bin/sh
+while read -r line; do
+	echo '{"stdout": ["ok"], "stderr": [], "exit_code": 0}'
+done
+`,
+}
+
+// installPlugins writes the test plugins to a temporary directory and adds it to $PATH
+func installPlugins(t *testing.T) func() {
+	directory, err := ioutil.TempDir("", "shelldoc")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	for name, script := range plugins {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(directory, name), []byte(script), 0755), "Writing the plugin should work")
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", directory+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(directory)
+	}
+}
+
+func TestFindAndList(t *testing.T) {
+	defer installPlugins(t)()
+	_, err := Find(KindReporter, "echo")
+	require.NoError(t, err, "The reporter plugin is found")
+	_, err = Find(KindReporter, "does-not-exist")
+	require.Error(t, err, "Missing plugins are reported")
+	require.Equal(t, []string{"ok"}, List(KindBackend), "The backend plugin is listed")
+}
+
+func TestReporter(t *testing.T) {
+	defer installPlugins(t)()
+	var output bytes.Buffer
+	result := runner.Result{Documents: []runner.DocumentResult{{File: "README.md"}}}
+	require.NoError(t, Reporter{Name: "echo", Output: &output}.Report(result), "The reporter plugin should run")
+	restored, err := runner.ReadJSON(&output)
+	require.NoError(t, err, "The plugin received the results as JSON")
+	require.Equal(t, "README.md", restored.Documents[0].File, "The plugin received the results")
+}
+
+func TestMatcher(t *testing.T) {
+	defer installPlugins(t)()
+	matched, err := NewMatcher("yes").Match([]string{"whatever"}, []string{"yes"})
+	require.NoError(t, err, "The matcher plugin should run")
+	require.True(t, matched, "The matcher plugin decides about the match")
+	matched, err = NewMatcher("yes").Match([]string{"yes"}, []string{"no"})
+	require.NoError(t, err, "The matcher plugin should run")
+	require.False(t, matched, "The matcher plugin decides about the mismatch")
+	_, err = NewMatcher("does-not-exist").Match(nil, nil)
+	require.Error(t, err, "Missing matcher plugins are reported")
+}
+
+func TestBackend(t *testing.T) {
+	defer installPlugins(t)()
+	backend := NewBackend("ok")()
+	require.NoError(t, backend.Start(), "The backend plugin should start")
+	stdout, _, rc, err := backend.Execute(context.Background(), "echo Hello")
+	require.NoError(t, err, "The backend plugin should execute the command")
+	require.Equal(t, 0, rc, "The exit code is returned")
+	require.Equal(t, []string{"ok"}, stdout, "The output is returned")
+	require.NoError(t, backend.Close(), "The backend plugin exits when its input is closed")
+}
+
+func TestRunWithPlugins(t *testing.T) {
+	defer installPlugins(t)()
+	directory, err := ioutil.TempDir("", "shelldoc")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "matcher.md")
+	data := "# Matcher\n\n```shell {shelldocmatcher=yes}\n$ echo yes\nanything\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(data), 0644), "Writing the document should work")
+	result, err := runner.New(runner.Options{Matchers: NewMatcher}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute")
+	require.Equal(t, runner.ReturnSuccess, result.ReturnCode, "The matcher plugin accepted the output")
+	result, err = runner.New(runner.Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute")
+	require.Equal(t, runner.ReturnError, result.ReturnCode, "Without matchers, the interaction cannot be evaluated")
+}
@@ -0,0 +1,43 @@
+package plugin
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+)
+
+// Reporter is a runner.Reporter that passes the results to a reporter plugin
+type Reporter struct {
+	// Name is the name of the plugin
+	Name string
+	// Output receives the report written by the plugin
+	Output io.Writer
+}
+
+// Report executes the plugin with the results of the run
+func (reporter Reporter) Report(result runner.Result) error {
+	path, err := Find(KindReporter, reporter.Name)
+	if err != nil {
+		return err
+	}
+	var input bytes.Buffer
+	if err := runner.WriteJSON(&input, result); err != nil {
+		return err
+	}
+	cmd := exec.Command(path)
+	cmd.Stdin = &input
+	cmd.Stdout = reporter.Output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("reporter plugin %s failed: %v", reporter.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Cache stores the results of documents that passed, so that unchanged documents are not executed again
+// A result is used if the content of the document, the options of the runner, the Version and the Fingerprint are the
+// same as when it was stored. The cache cannot know about changes to the programs the commands execute, the Fingerprint
+// should describe the parts of the environment that matter.
+type Cache struct {
+	// Directory contains the cached results, one file per document
+	Directory string
+	// Version identifies the version of shelldoc, results stored by other versions are not used
+	Version string
+	// Fingerprint identifies the environment the documents are executed in, like the backend or the search path
+	Fingerprint string
+}
+
+// key returns the key of the result of a document with the given content, executed by the runner
+func (cache *Cache) key(runner *Runner, content []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q\n%q\n%s\n", cache.Version, cache.Fingerprint, runner.optionsKey())
+	hash.Write(content)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// optionsKey describes the options of the runner that influence the results of a document
+func (runner *Runner) optionsKey() string {
+	options := runner.options
+	run := ""
+	if options.Run != nil {
+		run = options.Run.String()
+	}
+	return fmt.Sprintf("%q %q %q %q %q %d %v %v %v %v %v %d %v %v %v %v %q", options.Shell, options.ShellCommand, options.Env, options.Languages, run, options.AtLine, options.Strict, options.Coverage, options.NoSkips, options.Timeout, options.FileTimeout, options.MaxOutput, options.FailOnOutputLimit, options.KeepCarriageReturns, options.InputGrace, options.ToolVersions, options.Variables)
+}
+
+// path returns the file the result with the key is stored in
+func (cache *Cache) path(key string) string {
+	return filepath.Join(cache.Directory, key[:2], key+".json")
+}
+
+// lookup returns the cached result of the document with the given content, and whether it was found
+func (cache *Cache) lookup(runner *Runner, file string, content []byte) (DocumentResult, bool) {
+	data, err := ioutil.ReadFile(cache.path(cache.key(runner, content)))
+	if err != nil {
+		return DocumentResult{}, false
+	}
+	result, err := ReadJSON(bytes.NewReader(data))
+	if err != nil || len(result.Documents) != 1 || result.Documents[0].ReturnCode != ReturnSuccess {
+		log.Printf("Ignoring the invalid cached result of %s.", file)
+		return DocumentResult{}, false
+	}
+	document := result.Documents[0]
+	document.File = file
+	document.Cached = true
+	return document, true
+}
+
+// store caches the result of the document with the given content, if it passed
+func (cache *Cache) store(runner *Runner, content []byte, document DocumentResult) error {
+	if document.ReturnCode != ReturnSuccess {
+		return nil
+	}
+	path := cache.path(cache.key(runner, content))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create the cache directory: %v", err)
+	}
+	var buffer bytes.Buffer
+	if err := WriteJSON(&buffer, Result{ReturnCode: document.ReturnCode, Documents: []DocumentResult{document}}); err != nil {
+		return err
+	}
+	// write to a temporary file first, so that concurrent runs never read a partial result
+	temporary, err := ioutil.TempFile(filepath.Dir(path), "result")
+	if err != nil {
+		return fmt.Errorf("unable to store the result in the cache: %v", err)
+	}
+	if _, err := temporary.Write(buffer.Bytes()); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return fmt.Errorf("unable to store the result in the cache: %v", err)
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return fmt.Errorf("unable to store the result in the cache: %v", err)
+	}
+	return os.Rename(temporary.Name(), path)
+}
@@ -0,0 +1,68 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "github.com/endocode/shelldoc/pkg/tokenizer"
+
+// Change is an interaction whose result differs between two runs
+type Change struct {
+	// File is the path of the document of the interaction
+	File string
+	// Before is the interaction in the earlier run, or nil if it is new
+	Before *tokenizer.Interaction
+	// After is the interaction in the later run, or nil if it was removed
+	After *tokenizer.Interaction
+}
+
+// Regression returns true if the interaction fails in the later run, but did not fail or did not exist in the earlier
+// one
+func (change Change) Regression() bool {
+	return change.After != nil && failed(change.After) && (change.Before == nil || !failed(change.Before))
+}
+
+// Fix returns true if the interaction failed in the earlier run, and does not fail or was removed in the later one
+func (change Change) Fix() bool {
+	return change.Before != nil && failed(change.Before) && (change.After == nil || !failed(change.After))
+}
+
+// Compare returns the interactions whose result code differs between the results of two runs, like those written by
+// WriteJSON, and those that are only contained in one of them
+// Documents are identified by their path, and interactions like in Merge. The changes are ordered by document and
+// line, documents and interactions that were removed are listed after the others of the later run.
+func Compare(before, after Result) []Change {
+	earlier := make(map[string]map[interactionKey]*tokenizer.Interaction)
+	for _, document := range before.Documents {
+		earlier[document.File] = make(map[interactionKey]*tokenizer.Interaction)
+		for _, interaction := range document.Interactions {
+			earlier[document.File][keyOf(interaction)] = interaction
+		}
+	}
+	var changes []Change
+	compared := make(map[string]map[interactionKey]bool)
+	for _, document := range after.Documents {
+		compared[document.File] = make(map[interactionKey]bool)
+		for _, interaction := range document.Interactions {
+			key := keyOf(interaction)
+			compared[document.File][key] = true
+			previous := earlier[document.File][key]
+			if previous == nil || previous.ResultCode != interaction.ResultCode {
+				changes = append(changes, Change{File: document.File, Before: previous, After: interaction})
+			}
+		}
+	}
+	for _, document := range before.Documents {
+		for _, interaction := range document.Interactions {
+			if !compared[document.File][keyOf(interaction)] {
+				changes = append(changes, Change{File: document.File, Before: interaction})
+			}
+		}
+	}
+	return changes
+}
+
+// failed returns true if an interaction failed or could not be executed, quarantined failures do not count
+func failed(interaction *tokenizer.Interaction) bool {
+	return interaction.ResultCode == tokenizer.ResultExecutionError || (interaction.HasFailure() && !interaction.Quarantined())
+}
@@ -0,0 +1,168 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// IsExcluded returns true if the file matches one of the exclude patterns
+// The patterns are matched against both the path as specified and the file name.
+func IsExcluded(file string, patterns []string) bool {
+	for _, pattern := range patterns {
+		for _, candidate := range []string{file, filepath.Base(file)} {
+			if matched, _ := filepath.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Discover tokenizes a document and returns the interactions that would be executed
+// If AtLine is set, only the code block at this line and the setup code blocks before it are considered.
+func (runner *Runner) Discover(file string) ([]*tokenizer.Interaction, error) {
+	discovered := runner.discover(file)
+	return discovered.interactions, discovered.err
+}
+
+// discovery contains the interactions discovered in a document and the document they are part of, or the error that
+// prevented it
+type discovery struct {
+	document     *tokenizer.Document
+	interactions []*tokenizer.Interaction
+	problems     []tokenizer.Diagnostic // the violations of the coverage policy, if it is enforced
+	checkpoints  map[string]shell.State // the checkpoints recorded in earlier runs, and those recorded when executing it
+	resume       string                 // the checkpoint restored before the first interaction is executed
+	priority     int                    // the priority of the document from its front matter
+	err          error
+}
+
+// DiscoverData tokenizes the content of a document like Discover, for documents that have not been saved, like those
+// open in an editor, and also returns the parsed document
+// The variables and template functions in the document are expanded, they have the same values in every call until
+// the runner is closed.
+func (runner *Runner) DiscoverData(file string, data []byte) (*tokenizer.Document, []*tokenizer.Interaction, error) {
+	discovered := runner.discoverData(file, data)
+	return discovered.document, discovered.interactions, discovered.err
+}
+
+// discover tokenizes a document like Discover, and also returns the parsed document
+func (runner *Runner) discover(file string) discovery {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return discovery{err: fmt.Errorf("unable to read input data: %v", err)}
+	}
+	if data, _, err = tokenizer.Decode(data, runner.options.Encoding); err != nil {
+		return discovery{err: fmt.Errorf("unable to read %s: %v", file, err)}
+	}
+	return runner.discoverData(file, data)
+}
+
+// discoverData tokenizes the decoded content of a document for discover and DiscoverData
+func (runner *Runner) discoverData(file string, data []byte) discovery {
+	if !runner.options.KeepCarriageReturns {
+		data = tokenizer.NormalizeLineEndings(data)
+	}
+	document, err := tokenizer.ParseDocumentFiltered(data, tokenizer.Filter{Run: runner.options.Run, EndLine: runner.options.AtLine})
+	if err != nil {
+		return discovery{err: fmt.Errorf("unable to parse %s: %v", file, err)}
+	}
+	document.AssignIDs(file)
+	if err := document.ExpandVariables(runner.options.Variables, runner.templates); err != nil {
+		return discovery{err: fmt.Errorf("%s: %v", file, err)}
+	}
+	priority, err := document.Priority()
+	if err != nil {
+		return discovery{err: fmt.Errorf("%s: %v", file, err)}
+	}
+	interactions := document.Interactions()
+	if runner.options.AtLine > 0 {
+		if interactions = document.InteractionsAt(runner.options.AtLine); interactions == nil {
+			return discovery{err: fmt.Errorf("%s:%d: there is no code block with commands at this line", file, runner.options.AtLine)}
+		}
+	}
+	interactions = runner.Select(interactions)
+	runner.selectVariants(interactions)
+	var problems []tokenizer.Diagnostic
+	if runner.options.Coverage {
+		problems = CoverageProblems(document, runner.options.Languages)
+	}
+	return discovery{document: document, interactions: interactions, problems: problems, priority: priority}
+}
+
+// discoverAll tokenizes the documents concurrently and returns the results in the order of the files
+// At most one document per CPU is tokenized at the same time. Excluded documents are not tokenized.
+func (runner *Runner) discoverAll(files []string) []discovery {
+	results := make([]discovery, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU() && worker < len(files); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = runner.discover(files[index])
+			}
+		}()
+	}
+	for index, file := range files {
+		if !IsExcluded(file, runner.options.Excludes) {
+			indexes <- index
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// schedule returns the indexes of the documents in the order they are executed, by descending priority
+// Documents with the same priority keep their order. The order of the documents is kept if ShareSession is set, since
+// every document continues the session of the one before it.
+func (runner *Runner) schedule(discovered []discovery) []int {
+	order := make([]int, len(discovered))
+	for index := range order {
+		order[index] = index
+	}
+	if !runner.options.ShareSession {
+		sort.SliceStable(order, func(i, j int) bool {
+			return discovered[order[i]].priority > discovered[order[j]].priority
+		})
+	}
+	return order
+}
+
+// Select returns the interactions that match the Run pattern and are in code blocks of the selected languages
+func (runner *Runner) Select(interactions []*tokenizer.Interaction) []*tokenizer.Interaction {
+	var selected []*tokenizer.Interaction
+	for _, interaction := range interactions {
+		if runner.matchesLanguage(interaction) && runner.matchesRunPattern(interaction) {
+			selected = append(selected, interaction)
+		}
+	}
+	return selected
+}
+
+// matchesLanguage returns true if no languages have been selected, or if the interaction is in a code block of a selected language
+// Interactions from code blocks that do not specify a language are always selected.
+func (runner *Runner) matchesLanguage(interaction *tokenizer.Interaction) bool {
+	return len(interaction.Language) == 0 || selectsLanguage(runner.options.Languages, interaction.Language)
+}
+
+// matchesRunPattern returns true if no pattern was specified, or if the pattern matches the name or the heading of the interaction
+func (runner *Runner) matchesRunPattern(interaction *tokenizer.Interaction) bool {
+	if runner.options.Run == nil {
+		return true
+	}
+	return runner.options.Run.MatchString(interaction.Name()) || runner.options.Run.MatchString(interaction.Heading)
+}
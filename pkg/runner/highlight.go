@@ -0,0 +1,73 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// The ANSI escape sequences that highlight the differing characters in color mode
+const (
+	highlightStart = "\x1b[7m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// printDifference prints the first expected line and line of output that differ if the interaction failed as a
+// mismatch, with the differing characters highlighted
+// In color mode, they are shown in reverse video, otherwise they are marked with carets in the line below. If only the
+// line break at the end of the output differs, the mismatch is explained instead.
+func printDifference(out io.Writer, interaction *tokenizer.Interaction, color bool) {
+	mismatch, ok := interaction.Err.(*tokenizer.MismatchError)
+	if !ok {
+		return
+	}
+	if mismatch.NewlineOnly {
+		fmt.Fprintf(out, " --  %v\n", mismatch)
+		return
+	}
+	expected, actual, ok := mismatch.FirstDifference()
+	if !ok {
+		return
+	}
+	expectedSegments, actualSegments := tokenizer.DiffLine(expected, actual)
+	printSegments(out, " --  "+tokenizer.Translate(tokenizer.MessageExpectedLine)+": ", expectedSegments, color)
+	printSegments(out, " --  "+tokenizer.Translate(tokenizer.MessageReceivedLine)+": ", actualSegments, color)
+}
+
+// printSegments prints the label and the segments of a line, and the markers of the changed characters, which are
+// indented by the length of the label
+func printSegments(out io.Writer, label string, segments []tokenizer.Segment, color bool) {
+	var line, markers strings.Builder
+	changed := false
+	for _, segment := range segments {
+		if segment.Changed {
+			changed = true
+		}
+		if color && segment.Changed {
+			line.WriteString(highlightStart + segment.Text + highlightEnd)
+		} else {
+			line.WriteString(segment.Text)
+		}
+		for _, character := range segment.Text {
+			switch {
+			case character == '\t':
+				markers.WriteRune('\t') // keeps the markers aligned with the characters after a tab
+			case segment.Changed:
+				markers.WriteRune('^')
+			default:
+				markers.WriteRune(' ')
+			}
+		}
+	}
+	fmt.Fprintf(out, "%s%s\n", label, line.String())
+	if changed && !color {
+		fmt.Fprintf(out, "%s%s\n", strings.Repeat(" ", utf8.RuneCountInString(label)), strings.TrimRight(markers.String(), " \t"))
+	}
+}
@@ -0,0 +1,254 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// Incremental records the fingerprints of the code blocks that passed, so that unchanged code blocks are not executed
+// again
+// The fingerprint of a code block covers its info string, its commands and their expected responses, the setup code
+// blocks before it (see tokenizer.SetupOption), the options of the runner, the Version and the Fingerprint. A code
+// block that changed is executed after the setup code blocks before it, like with Options.AtLine, so code blocks
+// should only depend on the setup code blocks and not on each other. The checkpoints of code blocks that passed (see
+// tokenizer.CheckpointOption) are recorded as well, the execution resumes from the last one before the first code block
+// that changed, instead of executing the setup code blocks before it.
+type Incremental struct {
+	// File stores the fingerprints between runs
+	File string
+	// Version identifies the version of shelldoc, code blocks that passed with other versions are executed again
+	Version string
+	// Fingerprint identifies the environment the documents are executed in, like the backend or the search path
+	Fingerprint string
+	// documents contains the fingerprints of the code blocks that passed, by document
+	documents map[string][]string
+	// checkpoints contains the checkpoints recorded in the documents, by document and name
+	checkpoints map[string]map[string]recordedCheckpoint
+}
+
+// incrementalState is the content of the state file
+type incrementalState struct {
+	Documents   map[string][]string                      `json:"documents"`
+	Checkpoints map[string]map[string]recordedCheckpoint `json:"checkpoints,omitempty"`
+}
+
+// recordedCheckpoint is the state of the shell after a code block with a checkpoint passed
+// It can only be restored as long as the code block has the same fingerprint.
+type recordedCheckpoint struct {
+	Fingerprint string      `json:"fingerprint"`
+	State       shell.State `json:"state"`
+}
+
+// fingerprintedBlock describes the code block an interaction is part of
+type fingerprintedBlock struct {
+	fingerprint string
+	setup       bool
+}
+
+// blocks returns the code block of every interaction in the document, identified by its fingerprint
+func (incremental *Incremental) blocks(runner *Runner, document *tokenizer.Document) map[*tokenizer.Interaction]fingerprintedBlock {
+	blocks := make(map[*tokenizer.Interaction]fingerprintedBlock)
+	setup := sha256.New()
+	fmt.Fprintf(setup, "%q\n%q\n%s\n", incremental.Version, incremental.Fingerprint, runner.optionsKey())
+	for _, block := range document.Blocks {
+		hash := sha256.New()
+		hash.Write(setup.Sum(nil))
+		fmt.Fprintf(hash, "%q\n", block.InfoString)
+		for _, interaction := range block.Interactions {
+			fmt.Fprintf(hash, "%q %q\n", interaction.Cmd, interaction.Response)
+		}
+		_, isSetup := block.Options[tokenizer.SetupOption]
+		if isSetup {
+			setup.Write(hash.Sum(nil))
+		}
+		info := fingerprintedBlock{fingerprint: hex.EncodeToString(hash.Sum(nil)), setup: isSetup}
+		for _, interaction := range block.Interactions {
+			blocks[interaction] = info
+		}
+	}
+	return blocks
+}
+
+// passed returns the fingerprints of the code blocks of the document that passed in earlier runs
+// A state file that cannot be read is ignored, all code blocks are executed then.
+func (incremental *Incremental) passed(file string) map[string]bool {
+	if incremental.documents == nil {
+		incremental.documents = make(map[string][]string)
+		incremental.checkpoints = make(map[string]map[string]recordedCheckpoint)
+		if data, err := ioutil.ReadFile(incremental.File); err == nil {
+			var state incrementalState
+			if err := json.Unmarshal(data, &state); err != nil {
+				log.Printf("Ignoring the invalid state file %s: %v", incremental.File, err)
+			} else if state.Documents != nil {
+				incremental.documents = state.Documents
+				if state.Checkpoints != nil {
+					incremental.checkpoints = state.Checkpoints
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			log.Printf("Ignoring the state file %s: %v", incremental.File, err)
+		}
+	}
+	passed := make(map[string]bool)
+	for _, fingerprint := range incremental.documents[filepath.Clean(file)] {
+		passed[fingerprint] = true
+	}
+	return passed
+}
+
+// update records the fingerprints of the code blocks of the document that passed and its checkpoints, and writes the
+// state file
+func (incremental *Incremental) update(file string, passed map[string]bool, checkpoints map[string]recordedCheckpoint) error {
+	var fingerprints []string
+	for fingerprint := range passed {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Strings(fingerprints)
+	incremental.documents[filepath.Clean(file)] = fingerprints
+	if len(checkpoints) > 0 {
+		incremental.checkpoints[filepath.Clean(file)] = checkpoints
+	} else {
+		delete(incremental.checkpoints, filepath.Clean(file))
+	}
+	data, err := json.MarshalIndent(incrementalState{Documents: incremental.documents, Checkpoints: incremental.checkpoints}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to write the state file: %v", err)
+	}
+	// write to a temporary file first, so that an interrupted run does not leave a partial state file
+	temporary, err := ioutil.TempFile(filepath.Dir(incremental.File), filepath.Base(incremental.File))
+	if err != nil {
+		return fmt.Errorf("unable to write the state file: %v", err)
+	}
+	if _, err := temporary.Write(append(data, '\n')); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return fmt.Errorf("unable to write the state file: %v", err)
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return fmt.Errorf("unable to write the state file: %v", err)
+	}
+	if err := os.Rename(temporary.Name(), incremental.File); err != nil {
+		os.Remove(temporary.Name())
+		return fmt.Errorf("unable to write the state file: %v", err)
+	}
+	return nil
+}
+
+// hasPassed returns true if the interaction was executed and passed
+func (runner *Runner) hasPassed(interaction *tokenizer.Interaction) bool {
+	switch interaction.ResultCode {
+	case tokenizer.NewInteraction, tokenizer.ResultExecutionError:
+		return false
+	case tokenizer.ResultSkipped:
+		return !runner.options.NoSkips
+	}
+	return !interaction.HasFailure()
+}
+
+// runChangedDocument executes the interactions of the code blocks that changed since they passed, and the setup code
+// blocks before them, if Incremental is set, and all interactions otherwise
+// Observers are not notified about documents that are not executed because none of their code blocks changed.
+func (runner *Runner) runChangedDocument(ctx context.Context, file string, discovered discovery) (DocumentResult, error) {
+	incremental := runner.options.Incremental
+	if incremental == nil {
+		return runner.runDocument(ctx, file, discovered)
+	}
+	blocks := incremental.blocks(runner, discovered.document)
+	passed := incremental.passed(file)
+	first, last := -1, -1
+	for index, interaction := range discovered.interactions {
+		if !passed[blocks[interaction].fingerprint] {
+			if first < 0 {
+				first = index
+			}
+			last = index
+		}
+	}
+	// keep the checkpoints of code blocks that did not change, and resume from the last one before the first code
+	// block that did
+	checkpoints := incremental.checkpoints[filepath.Clean(file)]
+	discovered.checkpoints = make(map[string]shell.State)
+	resumed := -1
+	blockOf := codeBlocks(discovered.document)
+	for index, interaction := range discovered.interactions {
+		name, ok := interaction.Attributes[tokenizer.CheckpointOption]
+		if !ok || (index+1 < len(discovered.interactions) && blockOf[discovered.interactions[index+1]] == blockOf[interaction]) {
+			continue
+		}
+		if checkpoint, ok := checkpoints[name]; ok && checkpoint.Fingerprint == blocks[interaction].fingerprint {
+			discovered.checkpoints[name] = checkpoint.State
+			if index < first {
+				resumed, discovered.resume = index, name
+			}
+		}
+	}
+	var interactions []*tokenizer.Interaction
+	for index, interaction := range discovered.interactions {
+		if index > resumed && index <= last && (blocks[interaction].setup || !passed[blocks[interaction].fingerprint]) {
+			interactions = append(interactions, interaction)
+		}
+	}
+	unchanged := len(discovered.interactions) - len(interactions)
+	if len(interactions) == 0 && len(discovered.problems) == 0 {
+		fmt.Fprintf(runner.options.Output, "SHELLDOC: \"%s\" did not change since it passed, %d interactions not executed\n", file, unchanged)
+		return DocumentResult{ReturnCode: ReturnSuccess, File: file, Unchanged: unchanged}, nil
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(runner.options.Output, "SHELLDOC: %d interactions in \"%s\" did not change since they passed, they are not executed\n", unchanged, file)
+	}
+	all := discovered.interactions
+	discovered.interactions = interactions
+	document, err := runner.runDocument(ctx, file, discovered)
+	document.Unchanged = unchanged
+	if err != nil {
+		return document, err
+	}
+	// forget the code blocks that are gone or failed now, and remember those that passed
+	state := make(map[string]bool)
+	for _, block := range blocks {
+		if passed[block.fingerprint] {
+			state[block.fingerprint] = true
+		}
+	}
+	failed := make(map[string]bool)
+	for _, interaction := range interactions {
+		if !runner.hasPassed(interaction) {
+			failed[blocks[interaction].fingerprint] = true
+		}
+	}
+	for _, interaction := range interactions {
+		if fingerprint := blocks[interaction].fingerprint; failed[fingerprint] {
+			delete(state, fingerprint)
+		} else {
+			state[fingerprint] = true
+		}
+	}
+	// the checkpoints cannot be trusted anymore if restoring one of them failed
+	checkpoints = make(map[string]recordedCheckpoint)
+	for _, interaction := range all {
+		name, ok := interaction.Attributes[tokenizer.CheckpointOption]
+		if recorded, found := discovered.checkpoints[name]; ok && found && document.ReturnCode != ReturnError {
+			checkpoints[name] = recordedCheckpoint{Fingerprint: blocks[interaction].fingerprint, State: recorded}
+		}
+	}
+	if err := incremental.update(file, state, checkpoints); err != nil {
+		log.Printf("Unable to record the code blocks of %s that passed: %v", file, err)
+	}
+	return document, nil
+}
@@ -0,0 +1,66 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+)
+
+// The values of Options.Leaks
+const (
+	// LeaksWarn reports the state a document leaked
+	LeaksWarn = "warn"
+	// LeaksFail reports the state a document leaked and fails the document
+	LeaksFail = "fail"
+)
+
+// jobsCommand lists the process IDs of the background jobs of the shell
+const jobsCommand = "jobs -p"
+
+// leakCheck is the state of the shell before a document, which is compared with its state after the document
+type leakCheck struct {
+	state shell.State
+	jobs  int
+}
+
+// startLeakCheck records the state of the shell before a document
+// Backends that do not implement shell.StateKeeper cannot be checked.
+func startLeakCheck(ctx context.Context, backend shell.Backend) (*leakCheck, error) {
+	keeper, ok := backend.(shell.StateKeeper)
+	if !ok {
+		return nil, fmt.Errorf("unable to check for leaked state: the backend cannot save its state")
+	}
+	state, err := keeper.SaveState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for leaked state: %v", err)
+	}
+	return &leakCheck{state: state, jobs: countJobs(ctx, backend)}, nil
+}
+
+// leaks describes how the state of the shell after the document differs from the state before it
+func (check *leakCheck) leaks(ctx context.Context, backend shell.Backend) ([]string, error) {
+	state, err := backend.(shell.StateKeeper).SaveState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for leaked state: %v", err)
+	}
+	leaks := check.state.Changes(state)
+	if jobs := countJobs(ctx, backend); jobs > check.jobs {
+		leaks = append(leaks, fmt.Sprintf("%d background jobs are still running", jobs-check.jobs))
+	}
+	return leaks, nil
+}
+
+// countJobs returns the number of background jobs of the shell, or zero if they cannot be listed
+func countJobs(ctx context.Context, backend shell.Backend) int {
+	stdout, _, rc, err := backend.Execute(ctx, jobsCommand)
+	if err != nil || rc != 0 {
+		return 0
+	}
+	return len(strings.Fields(strings.Join(stdout, "\n")))
+}
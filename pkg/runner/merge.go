@@ -0,0 +1,122 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"sort"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// Merge combines the results of several runs, like those of sharded or matrix CI jobs, into one
+// Documents are identified by their path, and interactions by their ID, or their line and command if they have none,
+// like results written by older versions. If an interaction is contained in several results, its most severe result
+// is kept, so that a failure in one run is not hidden by a success in another. The counts are computed again from the merged interactions, the return code of a document is the most
+// severe one of the merged runs.
+func Merge(results ...Result) Result {
+	merged := Result{ReturnCode: ReturnSuccess}
+	positions := make(map[string]int)
+	for _, result := range results {
+		for _, document := range result.Documents {
+			position, ok := positions[document.File]
+			if !ok {
+				positions[document.File] = len(merged.Documents)
+				merged.Documents = append(merged.Documents, DocumentResult{File: document.File, ReturnCode: ReturnSuccess, Cached: true})
+				position = len(merged.Documents) - 1
+			}
+			target := &merged.Documents[position]
+			target.ReturnCode = max(target.ReturnCode, document.ReturnCode)
+			target.Cached = target.Cached && document.Cached
+			target.Unchanged = max(target.Unchanged, document.Unchanged)
+			target.Interactions = mergeInteractions(target.Interactions, document.Interactions)
+		}
+	}
+	for index := range merged.Documents {
+		document := &merged.Documents[index]
+		document.count()
+		merged.ReturnCode = max(merged.ReturnCode, document.ReturnCode)
+	}
+	return merged
+}
+
+// mergeInteractions adds the interactions to the merged ones, replacing those with a less severe result
+func mergeInteractions(merged, interactions []*tokenizer.Interaction) []*tokenizer.Interaction {
+	positions := make(map[interactionKey]int)
+	for index, interaction := range merged {
+		positions[keyOf(interaction)] = index
+	}
+	for _, interaction := range interactions {
+		position, ok := positions[keyOf(interaction)]
+		if !ok {
+			positions[keyOf(interaction)] = len(merged)
+			merged = append(merged, interaction)
+		} else if severity(interaction) > severity(merged[position]) {
+			merged[position] = interaction
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Line < merged[j].Line })
+	return merged
+}
+
+// interactionKey identifies an interaction in the results of different runs of its document
+type interactionKey struct {
+	id   string
+	line int
+	cmd  string
+}
+
+// keyOf returns the key of an interaction, its ID, or its line and command if it has none
+func keyOf(interaction *tokenizer.Interaction) interactionKey {
+	if len(interaction.ID) > 0 {
+		return interactionKey{id: interaction.ID}
+	}
+	return interactionKey{line: interaction.Line, cmd: interaction.Cmd}
+}
+
+// severity orders the results of interactions, interactions that were not executed have the lowest severity
+func severity(interaction *tokenizer.Interaction) int {
+	switch {
+	case interaction.ResultCode == tokenizer.NewInteraction:
+		return 0
+	case interaction.ResultCode == tokenizer.ResultSkipped:
+		return 1
+	case interaction.ResultCode == tokenizer.ResultExecutionError:
+		return 5
+	case interaction.Quarantined():
+		return 3
+	case interaction.HasFailure():
+		return 4
+	default:
+		return 2
+	}
+}
+
+// count computes the counts of the document from the results of its interactions
+// The return code is raised if interactions failed, but not lowered, since documents may also fail for other reasons,
+// like timeouts or violations of the naming policy.
+func (document *DocumentResult) count() {
+	document.TestCount, document.SuccessCount, document.FailureCount, document.ErrorCount = 0, 0, 0, 0
+	document.SkipCount, document.QuarantineCount = 0, 0
+	for _, interaction := range document.Interactions {
+		if interaction.ResultCode == tokenizer.NewInteraction {
+			continue
+		}
+		document.TestCount++
+		switch {
+		case interaction.ResultCode == tokenizer.ResultSkipped:
+			document.SkipCount++
+		case interaction.ResultCode == tokenizer.ResultExecutionError:
+			document.ErrorCount++
+			document.ReturnCode = max(document.ReturnCode, ReturnError)
+		case interaction.Quarantined():
+			document.QuarantineCount++
+		case interaction.HasFailure():
+			document.FailureCount++
+			document.ReturnCode = max(document.ReturnCode, ReturnFailure)
+		default:
+			document.SuccessCount++
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "github.com/endocode/shelldoc/pkg/tokenizer"
+
+// Observer is notified by the Runner about the progress of a run
+// Custom reporters, progress displays or metrics exporters implement it and are registered in Options.Observers.
+// The methods are called synchronously from the goroutine executing the run, in the order of the events. Embed
+// NopObserver to implement only some of them.
+type Observer interface {
+	// OnRunStart is called before the documents of a run are executed
+	OnRunStart(files []string)
+	// OnDocumentStart is called after the interactions of a document have been discovered, before they are executed
+	OnDocumentStart(file string, interactions []*tokenizer.Interaction)
+	// OnInteractionStart is called before an interaction is executed
+	OnInteractionStart(file string, interaction *tokenizer.Interaction)
+	// OnInteractionDone is called after an interaction has been executed, or skipped
+	OnInteractionDone(file string, interaction *tokenizer.Interaction)
+	// OnDocumentDone is called after the interactions of a document have been executed
+	OnDocumentDone(result DocumentResult)
+	// OnRunFinished is called after the documents of a run have been executed
+	OnRunFinished(result Result)
+}
+
+// NopObserver implements Observer with methods that do nothing
+type NopObserver struct{}
+
+// OnRunStart does nothing
+func (NopObserver) OnRunStart(files []string) {}
+
+// OnDocumentStart does nothing
+func (NopObserver) OnDocumentStart(file string, interactions []*tokenizer.Interaction) {}
+
+// OnInteractionStart does nothing
+func (NopObserver) OnInteractionStart(file string, interaction *tokenizer.Interaction) {}
+
+// OnInteractionDone does nothing
+func (NopObserver) OnInteractionDone(file string, interaction *tokenizer.Interaction) {}
+
+// OnDocumentDone does nothing
+func (NopObserver) OnDocumentDone(result DocumentResult) {}
+
+// OnRunFinished does nothing
+func (NopObserver) OnRunFinished(result Result) {}
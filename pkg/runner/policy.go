@@ -0,0 +1,92 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// qualifiedName returns the name of the interaction, prefixed with the heading of its section if it has one
+func qualifiedName(interaction *tokenizer.Interaction) string {
+	if len(interaction.Heading) == 0 {
+		return interaction.Name()
+	}
+	return fmt.Sprintf("%s: %s", interaction.Heading, interaction.Name())
+}
+
+// shellLanguages are the languages of fenced code blocks that contain shell commands, see CoverageProblems
+var shellLanguages = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "ksh": true, "shell": true, "console": true, "shell-session": true, "shellsession": true,
+}
+
+// CoverageProblems checks the coverage policy and returns the fenced shell code blocks that are not tested
+// A code block in one of the shell languages needs to have commands that are executed, which excludes code blocks
+// without a $ or > prompt and those in languages that are not selected, unless it is marked with the
+// tokenizer.SkipOption or the tokenizer.NoTestOption. This guarantees that documentation does not silently drift out
+// of the tests.
+func CoverageProblems(document *tokenizer.Document, languages []string) []tokenizer.Diagnostic {
+	var problems []tokenizer.Diagnostic
+	for _, block := range document.Blocks {
+		_, skipped := block.Options[tokenizer.SkipOption]
+		_, noTest := block.Options[tokenizer.NoTestOption]
+		if !block.Fenced || !shellLanguages[block.Language] || skipped || noTest {
+			continue
+		}
+		switch {
+		case len(block.Interactions) == 0:
+			problems = append(problems, tokenizer.Diagnostic{
+				Line:    block.Line,
+				Message: fmt.Sprintf("the %s code block has no commands ($ or >), mark it with %s if it is not meant to be tested", block.Language, tokenizer.NoTestOption),
+			})
+		case !selectsLanguage(languages, block.Language):
+			problems = append(problems, tokenizer.Diagnostic{
+				Line:    block.Line,
+				Message: fmt.Sprintf("the %s code block is not executed because its language is not selected", block.Language),
+			})
+		}
+	}
+	return problems
+}
+
+// selectsLanguage returns true if no languages are selected, or the language is one of them
+func selectsLanguage(languages []string, language string) bool {
+	if len(languages) == 0 {
+		return true
+	}
+	for _, selected := range languages {
+		if language == selected {
+			return true
+		}
+	}
+	return false
+}
+
+// NamingProblems checks the naming policy enforced in strict mode and returns the violations
+// Every interaction needs a caption or a heading, and no two interactions in a document may have the same qualified
+// name, so that the interactions of large suites can be told apart in the results.
+func NamingProblems(interactions []*tokenizer.Interaction) []tokenizer.Diagnostic {
+	var problems []tokenizer.Diagnostic
+	names := make(map[string]*tokenizer.Interaction)
+	for _, interaction := range interactions {
+		if len(interaction.Caption) == 0 && len(interaction.Heading) == 0 {
+			problems = append(problems, tokenizer.Diagnostic{
+				Line:    interaction.Line,
+				Message: fmt.Sprintf("interaction \"%s\" has neither a caption nor a heading", interaction.Cmd),
+			})
+		}
+		name := qualifiedName(interaction)
+		if previous, ok := names[name]; ok {
+			problems = append(problems, tokenizer.Diagnostic{
+				Line:    interaction.Line,
+				Message: fmt.Sprintf("interaction \"%s\" has the same name as the interaction in line %d", name, previous.Line),
+			})
+			continue
+		}
+		names[name] = interaction
+	}
+	return problems
+}
@@ -0,0 +1,26 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+// Reporter consumes the results of a run, for example to write them in a machine readable format
+// Unlike an Observer, a reporter is called once, after the run has finished.
+type Reporter interface {
+	Report(result Result) error
+}
+
+// Reporters forwards the results to several reporters
+type Reporters []Reporter
+
+// Report passes the results to all reporters in order
+// All reporters are called even if one of them fails, the first error is returned.
+func (reporters Reporters) Report(result Result) error {
+	var first error
+	for _, reporter := range reporters {
+		if err := reporter.Report(result); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
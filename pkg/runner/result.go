@@ -0,0 +1,57 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "github.com/endocode/shelldoc/pkg/tokenizer"
+
+// DocumentResult contains the results of executing the interactions in a document
+type DocumentResult struct {
+	// File is the path of the document
+	File string `json:"file" yaml:"file"`
+	// ReturnCode is the overall result of the document, one of ReturnSuccess, ReturnFailure or ReturnError
+	ReturnCode int `json:"return_code" yaml:"return_code"`
+	// TestCount counts the interactions that have been executed or skipped
+	TestCount int `json:"tests" yaml:"tests"`
+	// SuccessCount counts the interactions that passed
+	SuccessCount int `json:"successes" yaml:"successes"`
+	// FailureCount counts the interactions that failed
+	FailureCount int `json:"failures" yaml:"failures"`
+	// ErrorCount counts the interactions that could not be executed
+	ErrorCount int `json:"errors" yaml:"errors"`
+	// SkipCount counts the interactions that were skipped
+	SkipCount int `json:"skipped" yaml:"skipped"`
+	// QuarantineCount counts the interactions that failed in code blocks with the shelldocflaky option, they are not
+	// counted as failures
+	QuarantineCount int `json:"quarantined,omitempty" yaml:"quarantined,omitempty"`
+	// Interactions contains all selected interactions of the document, including those that were not executed
+	Interactions []*tokenizer.Interaction `json:"interactions" yaml:"interactions"`
+	// Cached is true if the document was not executed because it is unchanged, the results are those of an earlier run
+	Cached bool `json:"cached,omitempty" yaml:"cached,omitempty"`
+	// Unchanged counts the interactions that were not executed because their code blocks did not change since they
+	// passed, see Options.Incremental
+	Unchanged int `json:"unchanged,omitempty" yaml:"unchanged,omitempty"`
+	// Continues is the document whose session the document continued, see Options.ShareSession
+	Continues string `json:"continues,omitempty" yaml:"continues,omitempty"`
+}
+
+// Result contains the results of a run over several documents
+type Result struct {
+	// ReturnCode is the most severe return code of the documents
+	ReturnCode int `json:"return_code" yaml:"return_code"`
+	// Documents contains the results of the documents that have been executed, in order
+	Documents []DocumentResult `json:"documents" yaml:"documents"`
+}
+
+// Verdict returns a human readable description of a return code
+func Verdict(code int) string {
+	switch code {
+	case ReturnFailure:
+		return "FAILURE"
+	case ReturnError:
+		return "ERROR"
+	default:
+		return "SUCCESS"
+	}
+}
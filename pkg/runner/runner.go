@@ -0,0 +1,716 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// The return codes of a document or a run, in increasing order of severity
+const (
+	// ReturnSuccess indicates that all interactions passed
+	ReturnSuccess = iota
+	// ReturnFailure indicates that at least one interaction failed
+	ReturnFailure
+	// ReturnError indicates that at least one interaction could not be executed
+	ReturnError
+)
+
+// The values of Options.FailFast
+const (
+	// FailFastDocument stops executing a document after its first failure
+	FailFastDocument = "document"
+	// FailFastRun stops the whole run after the first failure
+	FailFastRun = "run"
+)
+
+// Options configures a Runner
+// The zero value executes all interactions in the shell specified in $SHELL, without timeouts and progress output.
+type Options struct {
+	// Shell is the path of the shell to invoke, $SHELL is used if it is empty
+	Shell string
+	// ShellCommand is the command line that launches the shell, it overrides Shell if it is not empty
+	ShellCommand string
+	// Env contains additional environment variables for the shell in KEY=value form
+	Env []string
+	// Languages selects the fenced code blocks to execute by their language, all are executed if it is empty
+	// Code blocks that do not specify a language are always executed.
+	Languages []string
+	// Encoding is the encoding of the documents, see tokenizer.Decode, it is detected if it is empty
+	Encoding string
+	// Variables override the variables defined in the front matter of the documents, see
+	// tokenizer.Document.ExpandVariables
+	Variables map[string]string
+	// KeepCarriageReturns keeps Windows line breaks in the documents and carriage returns at the end of the lines of
+	// output, they are normalized to Unix line breaks otherwise
+	KeepCarriageReturns bool
+	// Run selects the interactions whose name or heading it matches, all are executed if it is nil
+	Run *regexp.Regexp
+	// AtLine selects the code block that contains this line, and the setup code blocks before it, zero selects all
+	AtLine int
+	// Excludes contains file name patterns of documents that are skipped
+	Excludes []string
+	// FailFast stops the execution after the first failure, in the document (FailFastDocument) or the run (FailFastRun)
+	FailFast string
+	// MaxFailures aborts the run after this many failed interactions, zero means unlimited
+	MaxFailures int
+	// NoSkips treats skipped interactions as failures
+	NoSkips bool
+	// Strict fails documents that violate the naming policy, see NamingProblems
+	Strict bool
+	// Coverage fails documents with shell code blocks that are neither executed nor marked as not tested, see
+	// CoverageProblems
+	Coverage bool
+	// Timeout is the time a command may take, zero means no timeout
+	Timeout time.Duration
+	// FileTimeout is the time all commands in a document may take, zero means no timeout
+	FileTimeout time.Duration
+	// Delay is the pause between the interactions of a document, the tokenizer.DelayOption of a code block overrides it
+	Delay time.Duration
+	// CaptionTemplate formats the descriptions of interactions without a caption in the output, see
+	// tokenizer.Interaction.DescribeAs
+	CaptionTemplate string
+	// MaxOutput is the number of bytes of the output of a command that is kept, shell.DefaultMaxOutput if it is zero
+	MaxOutput int
+	// SpillDirectory receives the whole output of commands that exceed MaxOutput, it is not kept if it is empty
+	SpillDirectory string
+	// FailOnOutputLimit terminates commands whose output exceeds MaxOutput and fails them as exceeding the output limit,
+	// instead of keeping the first and last lines of their output
+	FailOnOutputLimit bool
+	// InputGrace is the time a command may be blocked reading its standard input, which is empty, before it fails as
+	// waiting for input, shell.DefaultInputGrace if it is zero, a negative grace disables it
+	InputGrace time.Duration
+	// Output receives the human readable progress output, it is discarded if Output is nil
+	Output io.Writer
+	// Verbose prints every command before it is executed
+	Verbose bool
+	// Color highlights the differing characters of mismatched lines in the Output with ANSI escape sequences, instead
+	// of marking them with carets
+	Color bool
+	// ReuseSessions keeps the shells of finished documents and reuses them for the following documents, after
+	// resetting their working directory and environment variables
+	// Only backends that implement shell.Resetter are reused.
+	ReuseSessions bool
+	// ShareSession executes the documents of a run in the same shell, in the order they are given, so that every
+	// document continues the session of the one before it, like the parts of a tutorial series.
+	// A document whose shell was terminated, for example after a timeout, ends the chain, the next document starts a
+	// new one. Since every document depends on the ones before it, it is not meant to be combined with ReuseSessions,
+	// Cache or Incremental.
+	ShareSession bool
+	// Leaks compares the working directory, the environment variables and the background jobs of the shell after
+	// every document with its state before it, and reports the differences (LeaksWarn) or also fails the document
+	// (LeaksFail), since later documents in a shared or reused session would depend on them
+	// The state is not checked if it is empty, or if the backend does not implement shell.StateKeeper.
+	Leaks string
+	// Cache contains the results of documents that passed earlier, unchanged documents are not executed again if it is
+	// set
+	Cache *Cache
+	// Incremental records the code blocks that passed, only code blocks that changed since are executed if it is set
+	Incremental *Incremental
+	// Observers are notified about the progress of the run
+	Observers []Observer
+	// NewBackend creates the backend that executes the interactions of a document, a local shell is used if it is nil
+	// The Shell, ShellCommand, Env, MaxOutput, SpillDirectory, FailOnOutputLimit and InputGrace options only apply to the
+	// local shell.
+	NewBackend func() shell.Backend
+	// ToolVersions contains the versions of tools for the variants of expected responses, like expect[kubectl>=1.28]
+	// The versions of the tools that are not listed are probed by executing them with --version.
+	ToolVersions map[string]string
+	// Matchers returns the matcher for a name specified using the shelldocmatcher option of a code block
+	// Interactions that specify a matcher fail with an execution error if it is nil.
+	Matchers func(name string) tokenizer.Matcher
+}
+
+// Runner executes the interactions in Markdown documents and collects the results
+type Runner struct {
+	options Options
+	// failedInteractions counts the failed interactions of all documents executed by the runner, for MaxFailures
+	failedInteractions int
+	// sessions contains the backends that can be reused for the next document, if ReuseSessions is set
+	sessions []shell.Backend
+	// shared is the backend the next document continues with, and sharedBy the document it was used for last, if
+	// ShareSession is set
+	shared   shell.Backend
+	sharedBy string
+	// tools caches the versions of the tools that have been probed
+	tools toolVersions
+	// templates evaluates the template functions in the documents, once per run
+	templates *tokenizer.Templates
+}
+
+// New creates a Runner with the given options
+func New(options Options) *Runner {
+	if options.Output == nil {
+		options.Output = ioutil.Discard
+	}
+	return &Runner{options: options, templates: tokenizer.NewTemplates()}
+}
+
+// FailedInteractions returns the number of failed interactions in all documents executed by the runner
+func (runner *Runner) FailedInteractions() int {
+	return runner.failedInteractions
+}
+
+// MaxFailuresReached returns true if the run should be aborted because too many interactions failed
+func (runner *Runner) MaxFailuresReached() bool {
+	return runner.options.MaxFailures > 0 && runner.failedInteractions >= runner.options.MaxFailures
+}
+
+// ShellCommand returns the program and arguments that launch the shell
+// The shell command is split into arguments, otherwise the detected shell is launched without arguments. A
+// shell.NotFoundError is returned if the program does not exist.
+func (runner *Runner) ShellCommand() ([]string, error) {
+	if len(runner.options.ShellCommand) > 0 {
+		args, err := shell.SplitCommandLine(runner.options.ShellCommand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shell command: %v", err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("invalid shell command: \"%s\" is empty", runner.options.ShellCommand)
+		}
+		log.Printf("Using user-specified shell command %s.", runner.options.ShellCommand)
+		if _, err := shell.FindShell(args[0]); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+	shellpath, err := shell.DetectShell(runner.options.Shell)
+	if err != nil {
+		return nil, err
+	}
+	return []string{shellpath}, nil
+}
+
+// StartShell starts the backend that executes the interactions of a document
+// Unless Options.NewBackend is set, this is a local shell with the configured environment.
+func (runner *Runner) StartShell() (shell.Backend, error) {
+	var backend shell.Backend
+	if runner.options.NewBackend != nil {
+		backend = runner.options.NewBackend()
+	} else {
+		args, err := runner.ShellCommand()
+		if err != nil {
+			return nil, err
+		}
+		local := shell.NewShell(args, runner.options.Env...)
+		local.LimitOutput(runner.options.MaxOutput, runner.options.SpillDirectory)
+		local.FailOnOutputLimit(runner.options.FailOnOutputLimit)
+		local.KeepCarriageReturns(runner.options.KeepCarriageReturns)
+		if runner.options.InputGrace != 0 {
+			local.GuardInput(runner.options.InputGrace)
+		}
+		backend = local
+	}
+	if err := backend.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start shell: %v", err)
+	}
+	return backend, nil
+}
+
+// acquireShell returns a backend for a document, a reused one if possible
+func (runner *Runner) acquireShell(ctx context.Context) (shell.Backend, error) {
+	if backend := runner.shared; backend != nil {
+		runner.shared = nil
+		return backend, nil
+	}
+	if count := len(runner.sessions); count > 0 {
+		backend := runner.sessions[count-1]
+		runner.sessions = runner.sessions[:count-1]
+		return backend, nil
+	}
+	backend, err := runner.StartShell()
+	if err != nil {
+		return nil, err
+	}
+	if resetter, ok := backend.(shell.Resetter); ok && runner.options.ReuseSessions {
+		if err := resetter.Checkpoint(ctx); err != nil {
+			log.Printf("The shell will not be reused: %v", err)
+		}
+	}
+	return backend, nil
+}
+
+// releaseShell keeps the backend of a finished document for the next one if ShareSession is set, resets it for reuse
+// if ReuseSessions is set, and closes it otherwise
+// Backends that cannot be reset, for example because the shell was terminated after a timeout, are closed.
+func (runner *Runner) releaseShell(ctx context.Context, backend shell.Backend, file string, usable bool) {
+	if runner.options.ShareSession {
+		runner.sharedBy = file
+		if usable && ctx.Err() == nil {
+			runner.shared = backend
+			return
+		}
+	}
+	if resetter, ok := backend.(shell.Resetter); ok && runner.options.ReuseSessions && usable && ctx.Err() == nil {
+		err := resetter.Reset(ctx)
+		if err == nil {
+			runner.sessions = append(runner.sessions, backend)
+			return
+		}
+		log.Printf("The shell will not be reused: %v", err)
+	}
+	backend.Close()
+}
+
+// closeSessions closes the backends kept for reuse
+func (runner *Runner) closeSessions() {
+	for _, backend := range runner.sessions {
+		backend.Close()
+	}
+	runner.sessions = nil
+	if runner.shared != nil {
+		runner.shared.Close()
+	}
+	runner.shared, runner.sharedBy = nil, ""
+}
+
+// Run executes the documents and returns their results
+// The documents are tokenized concurrently before the first one is executed, and executed by descending priority as
+// defined in their front matter, otherwise in order. Excluded documents are skipped. The run
+// stops early if FailFast is FailFastRun and a document failed, if MaxFailures has been reached, or if the context is
+// done.
+func (runner *Runner) Run(ctx context.Context, files []string) (Result, error) {
+	result := Result{ReturnCode: ReturnSuccess}
+	defer runner.closeSessions()
+	defer runner.templates.Close()
+	for _, observer := range runner.options.Observers {
+		observer.OnRunStart(files)
+	}
+	defer func() {
+		for _, observer := range runner.options.Observers {
+			observer.OnRunFinished(result)
+		}
+	}()
+	discovered := runner.discoverAll(files)
+	for _, index := range runner.schedule(discovered) {
+		file := files[index]
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if IsExcluded(file, runner.options.Excludes) {
+			log.Printf("Skipping excluded document %s.", file)
+			continue
+		}
+		if err := discovered[index].err; err != nil {
+			return result, err
+		}
+		document, err := runner.runCachedDocument(ctx, file, discovered[index])
+		if err != nil {
+			return result, err
+		}
+		result.Documents = append(result.Documents, document)
+		result.ReturnCode = max(document.ReturnCode, result.ReturnCode)
+		if runner.options.FailFast == FailFastRun && result.ReturnCode != ReturnSuccess {
+			log.Printf("Stopping the run after the first failure.")
+			break
+		}
+		if runner.MaxFailuresReached() {
+			fmt.Fprintf(runner.options.Output, "SHELLDOC: aborting the run after %d failed interactions\n", runner.failedInteractions)
+			break
+		}
+	}
+	return result, nil
+}
+
+// Close removes the temporary directories created by the template functions in the documents discovered using
+// DiscoverData
+// Run and RunDocument remove them when they finish.
+func (runner *Runner) Close() error {
+	return runner.templates.Close()
+}
+
+// runCachedDocument returns the cached result of a document if it is unchanged, and executes it otherwise
+// Observers are not notified about documents that are not executed.
+func (runner *Runner) runCachedDocument(ctx context.Context, file string, discovered discovery) (DocumentResult, error) {
+	cache := runner.options.Cache
+	if cache == nil {
+		return runner.runChangedDocument(ctx, file, discovered)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return DocumentResult{}, fmt.Errorf("unable to read input data: %v", err)
+	}
+	if document, ok := cache.lookup(runner, file, content); ok {
+		fmt.Fprintln(runner.options.Output, tokenizer.Translate(tokenizer.MessageCachedSummary, file, Verdict(document.ReturnCode), document.TestCount, document.SuccessCount, document.SkipCount))
+		return document, nil
+	}
+	document, err := runner.runChangedDocument(ctx, file, discovered)
+	if err == nil {
+		if err := cache.store(runner, content, document); err != nil {
+			log.Printf("Unable to cache the result of %s: %v", file, err)
+		}
+	}
+	return document, err
+}
+
+// RunDocument executes the selected interactions of a document in a new shell and returns the results
+// If the context is cancelled, the shell is terminated and the error of the context is returned. An expired deadline
+// of the context is reported as a timeout of the interaction that was executing.
+func (runner *Runner) RunDocument(ctx context.Context, file string) (DocumentResult, error) {
+	// read input data and run it through the tokenizer
+	discovered := runner.discover(file)
+	if discovered.err != nil {
+		return DocumentResult{}, discovered.err
+	}
+	defer runner.closeSessions()
+	defer runner.templates.Close()
+	return runner.runDocument(ctx, file, discovered)
+}
+
+// runDocument executes the interactions discovered in a document in a new shell and returns the results
+// The violations of the policies found when the document was discovered fail it. The checkpoints recorded while
+// executing it are added to the checkpoints of the discovery, if it has a map for them.
+func (runner *Runner) runDocument(ctx context.Context, file string, discovered discovery) (DocumentResult, error) {
+	interactions, problems := discovered.interactions, discovered.problems
+	if discovered.checkpoints == nil {
+		discovered.checkpoints = make(map[string]shell.State)
+	}
+	ctx, span := Tracer().Start(ctx, "document")
+	defer span.End()
+	span.SetAttributes(attribute.String("shelldoc.file", file))
+
+	// start a background shell (or reuse one), it will run until the function ends
+	continues, ended := runner.sharedBy, runner.shared == nil
+	shell, err := runner.acquireShell(ctx)
+	if err != nil {
+		return DocumentResult{}, err
+	}
+	usable := true // the shell was not terminated
+	defer func() {
+		runner.releaseShell(ctx, shell, file, usable)
+	}()
+
+	// execute the interactions and verify the results:
+	out := runner.options.Output
+	fmt.Fprintf(out, "SHELLDOC: doc-testing \"%s\" ...\n", file)
+	results := DocumentResult{ReturnCode: ReturnSuccess, File: file, Interactions: interactions}
+	if len(continues) > 0 && ended {
+		fmt.Fprintf(out, " --  starting a new session, the session of \"%s\" was terminated\n", continues)
+	} else if len(continues) > 0 {
+		fmt.Fprintf(out, " --  continuing the session of \"%s\"\n", continues)
+		results.Continues = continues
+	}
+	for _, observer := range runner.options.Observers {
+		observer.OnDocumentStart(file, interactions)
+	}
+	defer func() {
+		for _, observer := range runner.options.Observers {
+			observer.OnDocumentDone(results)
+		}
+	}()
+	if runner.options.Strict {
+		problems = append(problems, NamingProblems(interactions)...)
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, " --  %s:%d: %s\n", file, problem.Line, problem.Message)
+		results.ReturnCode = ReturnFailure
+	}
+	var leaks *leakCheck
+	if len(runner.options.Leaks) > 0 {
+		if leaks, err = startLeakCheck(ctx, shell); err != nil {
+			log.Printf("%s: %v", file, err)
+		}
+	}
+	if len(discovered.resume) > 0 {
+		if err := restoreCheckpoint(ctx, shell, discovered.checkpoints, discovered.resume); err != nil {
+			fmt.Fprintf(out, " --  %v, %d interactions not executed\n", err, len(interactions))
+			results.ReturnCode = ReturnError
+			interactions = nil
+		} else {
+			fmt.Fprintf(out, " --  resuming from checkpoint %s\n", discovered.resume)
+		}
+	}
+	// construct the opener and closer format strings, since they depend on verbose mode
+	magnitude := int(math.Log10(float64(max(len(interactions), 1)))) + 1
+	openerLineEnding := "  : "
+	resultString := " "
+	if runner.options.Verbose {
+		openerLineEnding = "\n"
+		resultString = " <-- "
+	}
+	counterFormat := fmt.Sprintf("%%%ds", magnitude+2)
+	opener := fmt.Sprintf(" CMD %s: %%s%s", counterFormat, openerLineEnding)
+	closer := fmt.Sprintf("%s%%s\n", resultString)
+
+	// release unlocks the code block that is executing, if it has the SerialOption
+	release := func() {}
+	defer func() {
+		release()
+	}()
+	documentStart := time.Now()
+	fileTimeout := runner.options.FileTimeout
+	blocks := codeBlocks(discovered.document)
+	blockPassed := true // all interactions of the code block with a checkpoint passed so far
+	skipReason := ""    // the reason to skip the code block given by its guard, empty if it is executed
+	for index, interaction := range interactions {
+		if value, ok := interaction.Attributes[tokenizer.FileTimeoutOption]; ok {
+			if fileTimeout, err = time.ParseDuration(value); err != nil {
+				return results, fmt.Errorf("argument to %s needs to be a duration, got \"%s\"", tokenizer.FileTimeoutOption, value)
+			}
+		}
+		if index > 0 {
+			if err := runner.pause(ctx, interaction); err != nil {
+				return results, err
+			}
+		}
+		firstOfBlock := index == 0 || blocks[interactions[index-1]] != blocks[interaction]
+		if firstOfBlock {
+			release()
+			release = func() {}
+			if _, serial := interaction.Attributes[tokenizer.SerialOption]; serial {
+				unlock, err := lockSerial(ctx, out)
+				if err != nil && err == ctx.Err() {
+					return results, err
+				} else if err != nil {
+					fmt.Fprintf(out, " --  %v, %d interactions not executed\n", err, len(interactions)-index)
+					results.ReturnCode = ReturnError
+					break
+				}
+				release = unlock
+			}
+		}
+		if name, ok := interaction.Attributes[tokenizer.RestoreOption]; ok && firstOfBlock {
+			if err := restoreCheckpoint(ctx, shell, discovered.checkpoints, name); err != nil {
+				fmt.Fprintf(out, " --  %v, %d interactions not executed\n", err, len(interactions)-index)
+				results.ReturnCode = ReturnError
+				break
+			}
+		}
+		if firstOfBlock {
+			skipReason = ""
+			if _, skipped := interaction.Attributes[tokenizer.SkipOption]; !skipped {
+				if skipReason, err = runner.evaluateGuard(ctx, shell, interaction); err != nil {
+					fmt.Fprintf(out, " --  %v, %d interactions not executed\n", err, len(interactions)-index)
+					results.ReturnCode = ReturnError
+					break
+				}
+			}
+		}
+		interaction.Timeout = runner.options.Timeout
+		interaction.KeepCarriageReturns = runner.options.KeepCarriageReturns
+		if name, ok := interaction.Attributes[tokenizer.MatcherOption]; ok && runner.options.Matchers != nil {
+			interaction.Matcher = runner.options.Matchers(name)
+		}
+		if fileTimeout > 0 {
+			remaining := fileTimeout - time.Since(documentStart)
+			if remaining <= 0 {
+				fmt.Fprintf(out, " --  the document did not finish within %v, %d interactions not executed\n", fileTimeout, len(interactions)-index)
+				results.ReturnCode = max(results.ReturnCode, ReturnFailure)
+				break
+			}
+			if interaction.Timeout == 0 || remaining < interaction.Timeout {
+				interaction.Timeout = remaining
+			}
+		}
+		results.TestCount++
+		fmt.Fprintf(out, opener, fmt.Sprintf("(%d)", index+1), interaction.DescribeAs(runner.options.CaptionTemplate, file, index+1))
+
+		if runner.options.Verbose {
+			fmt.Fprintf(out, " --> %s\n", interaction.Cmd)
+		}
+		for _, observer := range runner.options.Observers {
+			observer.OnInteractionStart(file, interaction)
+		}
+		interactionContext, interactionSpan := Tracer().Start(ctx, "interaction")
+		var executionErr error
+		if len(skipReason) > 0 {
+			interaction.ResultCode, interaction.Comment, interaction.SkipReason = tokenizer.ResultSkipped, skipReason, tokenizer.SkipMissingDependency
+		} else {
+			executionErr = interaction.ExecuteContext(interactionContext, shell)
+		}
+		annotateInteractionSpan(interactionSpan, interaction)
+		interactionSpan.End()
+		for _, observer := range runner.options.Observers {
+			observer.OnInteractionDone(file, interaction)
+		}
+		fmt.Fprintf(out, closer, interaction.Result())
+		if executionErr != nil {
+			fmt.Fprintf(out, " --  ERROR: %v\n", executionErr)
+		}
+		printDifference(out, interaction, runner.options.Color)
+		switch {
+		case interaction.ResultCode == tokenizer.ResultSkipped:
+			results.SkipCount++
+			if runner.options.NoSkips {
+				fmt.Fprintf(out, " --  skipping interactions is not allowed (--no-skips)\n")
+				results.ReturnCode = max(results.ReturnCode, ReturnFailure)
+				results.FailureCount++
+				runner.failedInteractions++
+			}
+		case interaction.ResultCode == tokenizer.ResultExecutionError:
+			results.ReturnCode = max(results.ReturnCode, ReturnError)
+			results.ErrorCount++
+			runner.failedInteractions++
+		case interaction.Quarantined():
+			fmt.Fprintf(out, " --  quarantined as flaky (%s), the failure does not fail the run\n", interaction.Attributes[tokenizer.FlakyOption])
+			results.QuarantineCount++
+		case interaction.HasFailure():
+			results.ReturnCode = max(results.ReturnCode, ReturnFailure)
+			results.FailureCount++
+			runner.failedInteractions++
+		default:
+			results.SuccessCount++
+		}
+		if name, ok := interaction.Attributes[tokenizer.CheckpointOption]; ok {
+			blockPassed = blockPassed && runner.hasPassed(interaction)
+			if index+1 == len(interactions) || blocks[interactions[index+1]] != blocks[interaction] {
+				if !blockPassed {
+					delete(discovered.checkpoints, name)
+				} else if err := recordCheckpoint(ctx, shell, discovered.checkpoints, name); err != nil {
+					fmt.Fprintf(out, " --  %v\n", err)
+				}
+				blockPassed = true
+			}
+		}
+		if err := ctx.Err(); err != nil && err != context.DeadlineExceeded {
+			fmt.Fprintf(out, " --  the run was cancelled, %d interactions not executed\n", len(interactions)-index-1)
+			return results, err
+		}
+		if interaction.TerminatedShell() {
+			fmt.Fprintf(out, " --  the shell was terminated (%s), %d interactions not executed\n", interaction.ResultCode, len(interactions)-index-1)
+			usable = false
+			break
+		}
+		if len(runner.options.FailFast) > 0 && results.ReturnCode != ReturnSuccess {
+			fmt.Fprintf(out, " --  stopping after the first failure, %d interactions not executed\n", len(interactions)-index-1)
+			break
+		}
+		if runner.MaxFailuresReached() {
+			fmt.Fprintf(out, " --  aborting after %d failures, %d interactions not executed\n", runner.failedInteractions, len(interactions)-index-1)
+			break
+		}
+	}
+	if leaks != nil && usable {
+		leaked, err := leaks.leaks(ctx, shell)
+		if err != nil {
+			log.Printf("%s: %v", file, err)
+		}
+		for _, leak := range leaked {
+			fmt.Fprintf(out, " --  leaked state: %s\n", leak)
+		}
+		if len(leaked) > 0 && runner.options.Leaks == LeaksFail {
+			results.ReturnCode = max(results.ReturnCode, ReturnFailure)
+		}
+	}
+	fmt.Fprintln(out, tokenizer.Translate(tokenizer.MessageSummary, Verdict(results.ReturnCode), results.TestCount, results.SuccessCount, results.FailureCount, results.ErrorCount, results.SkipCount))
+	if results.ReturnCode != ReturnSuccess {
+		span.SetStatus(codes.Error, Verdict(results.ReturnCode))
+	}
+	return results, nil
+}
+
+// pause waits for the delay before the interaction, which is the Delay option unless its code block specifies one
+// Skipped interactions are not delayed. The pause ends early if the context is cancelled.
+func (runner *Runner) pause(ctx context.Context, interaction *tokenizer.Interaction) error {
+	delay := runner.options.Delay
+	if value, ok := interaction.Attributes[tokenizer.DelayOption]; ok {
+		var err error
+		if delay, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("argument to %s needs to be a duration, got \"%s\"", tokenizer.DelayOption, value)
+		}
+	}
+	if _, skipped := interaction.Attributes[tokenizer.SkipOption]; skipped || delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// evaluateGuard executes the guard command of the code block of the interaction, if it has the tokenizer.SkipIfOption,
+// and returns the reason to skip the code block
+// The reason is empty if the guard fails, and the code block is executed. Otherwise it is the output of the guard, or
+// the guard itself if it printed nothing. The guard may take as long as a command.
+func (runner *Runner) evaluateGuard(ctx context.Context, backend shell.Backend, interaction *tokenizer.Interaction) (string, error) {
+	guard, ok := interaction.Attributes[tokenizer.SkipIfOption]
+	if !ok {
+		return "", nil
+	}
+	if runner.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runner.options.Timeout)
+		defer cancel()
+	}
+	stdout, stderr, rc, err := backend.Execute(ctx, guard)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute the guard \"%s\": %v", guard, err)
+	}
+	if rc != 0 {
+		return "", nil
+	}
+	if output := strings.TrimSpace(strings.Join(append(stdout, stderr...), " ")); len(output) > 0 {
+		return output, nil
+	}
+	return fmt.Sprintf("%s succeeded", guard), nil
+}
+
+// codeBlocks maps the interactions of the document to the code blocks they are part of
+// The first and the last interaction of a code block that are executed restore and record its checkpoints.
+func codeBlocks(document *tokenizer.Document) map[*tokenizer.Interaction]*tokenizer.Block {
+	blocks := make(map[*tokenizer.Interaction]*tokenizer.Block)
+	if document == nil {
+		return blocks
+	}
+	for _, block := range document.Blocks {
+		for _, interaction := range block.Interactions {
+			blocks[interaction] = block
+		}
+	}
+	return blocks
+}
+
+// recordCheckpoint saves the state of the backend as the checkpoint with the name, see tokenizer.CheckpointOption
+func recordCheckpoint(ctx context.Context, backend shell.Backend, checkpoints map[string]shell.State, name string) error {
+	keeper, ok := backend.(shell.StateKeeper)
+	if !ok {
+		return fmt.Errorf("unable to record checkpoint %s: the backend cannot save its state", name)
+	}
+	state, err := keeper.SaveState(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to record checkpoint %s: %v", name, err)
+	}
+	checkpoints[name] = state
+	return nil
+}
+
+// restoreCheckpoint restores the state of the backend recorded as the checkpoint with the name
+func restoreCheckpoint(ctx context.Context, backend shell.Backend, checkpoints map[string]shell.State, name string) error {
+	state, ok := checkpoints[name]
+	if !ok {
+		return fmt.Errorf("unable to restore checkpoint %s: it was not recorded", name)
+	}
+	keeper, ok := backend.(shell.StateKeeper)
+	if !ok {
+		return fmt.Errorf("unable to restore checkpoint %s: the backend cannot restore its state", name)
+	}
+	if err := keeper.RestoreState(ctx, state); err != nil {
+		return fmt.Errorf("unable to restore checkpoint %s: %v", name, err)
+	}
+	return nil
+}
+
+func max(a, b int) int { // really, golang?
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,831 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDocument(t *testing.T) {
+	var output bytes.Buffer
+	runner := New(Options{Output: &output})
+	result, err := runner.RunDocument(context.Background(), "../tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "The sample should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The sample passes")
+	require.Equal(t, 4, result.SuccessCount, "All interactions in the sample pass")
+	require.Equal(t, "../tokenizer/samples/helloworld.md", result.File, "The result refers to the document")
+	require.Contains(t, output.String(), "SUCCESS: 4 tests", "The progress is written to the output")
+}
+
+func TestFailureKinds(t *testing.T) {
+	result, err := New(Options{}).RunDocument(context.Background(), "../tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The sample should execute without errors")
+	var mismatch *tokenizer.MismatchError
+	require.True(t, errors.As(result.Interactions[0].Err, &mismatch), "The failure is a mismatch")
+	require.Equal(t, []string{"Yes"}, mismatch.Expected, "The mismatch contains the expected response")
+	require.Equal(t, []string{"No"}, mismatch.Actual, "The mismatch contains the actual output")
+	require.Equal(t, 1, mismatch.Line, "The mismatch contains the first differing line")
+}
+
+func TestInvalidOptions(t *testing.T) {
+	var output bytes.Buffer
+	result, err := New(Options{Output: &output}).RunDocument(context.Background(), "../tokenizer/samples/lint.md")
+	require.NoError(t, err, "The sample should execute without errors")
+	require.Equal(t, ReturnError, result.ReturnCode, "An invalid option is an execution error")
+	require.Equal(t, tokenizer.ResultExecutionError, result.Interactions[1].ResultCode, "The interaction with the invalid option could not be executed")
+	require.Equal(t, 4, result.TestCount, "All interactions are counted")
+	require.Equal(t, 2, result.SuccessCount, "Execution errors are not counted as successes")
+	require.Equal(t, 1, result.FailureCount, "The failing command is counted")
+	require.Equal(t, 1, result.ErrorCount, "The execution error is counted once")
+	require.Contains(t, output.String(), " --  ERROR: argument to shelldocexitcode needs to be an integer, got \"two\"\n", "The error is written on its own line")
+}
+
+func TestShellCrashed(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-crash")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "    $ echo Hello\n    Hello\n    $ exit 3\n    $ echo World\n    World\n\n    $ true\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	var output bytes.Buffer
+	result, err := New(Options{Output: &output}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnError, result.ReturnCode, "A crashed shell is an execution error")
+	require.Len(t, result.Interactions, 4, "All interactions are reported")
+	require.Equal(t, tokenizer.ResultExecutionError, result.Interactions[1].ResultCode, "The shell exited with the command")
+	require.Equal(t, tokenizer.ErrShellCrashed, result.Interactions[1].Err, "The cause is recorded")
+	for _, interaction := range result.Interactions[2:] {
+		require.Equal(t, tokenizer.NewInteraction, interaction.ResultCode, "The interactions after the crash are not executed")
+	}
+	require.Contains(t, output.String(), "2 interactions not executed", "The interactions that were not executed are reported")
+}
+
+func TestSignal(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-signal")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "    $ sh -c 'kill -SEGV $$'\n\n```shell {shelldocexitcode=139}\n$ sh -c 'kill -SEGV $$'\n```\n\n    $ (exit 3)\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	crashed := result.Interactions[0]
+	require.Equal(t, tokenizer.ResultSignal, crashed.ResultCode, "Commands terminated by a signal are a distinct result")
+	require.Equal(t, "SIGSEGV", crashed.Signal, "The name of the signal is recorded")
+	require.Equal(t, "FAIL (terminated by SIGSEGV)", crashed.Result(), "The signal is reported")
+	var signal *tokenizer.SignalError
+	require.True(t, errors.As(crashed.Err, &signal), "The failure is a signal")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[1].ResultCode, "An expected exit code is not a signal")
+	require.Equal(t, tokenizer.ResultError, result.Interactions[2].ResultCode, "Other exit codes are not signals")
+	require.Equal(t, 2, result.FailureCount, "Signals are failures")
+}
+
+func TestScript(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-script")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocscript}\n$ greet() {\n> local greeting=Hello\n> echo $greeting $1\n> }\n$ greet World\nHello World\n```\n\n```shell {shelldocscript}\n$ echo partial\npartial\n$ false\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 2, "Each script is one interaction")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[0].ResultCode, "Commands spanning several lines can be executed as a script")
+	require.Equal(t, tokenizer.ResultError, result.Interactions[1].ResultCode, "The commands of a script fail together")
+}
+
+func TestExpectedFailure(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-xfail")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocxfail=issue-42}\n$ echo broken\nfixed\n$ false\n```\n\n```shell {shelldocxfail}\n$ echo fixed\nfixed\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 3, "All commands are executed")
+	require.Equal(t, tokenizer.ResultExpectedFailure, result.Interactions[0].ResultCode, "A mismatch is an expected failure")
+	require.Equal(t, "XFAIL (issue-42)", result.Interactions[0].Result(), "The reason is reported")
+	require.Equal(t, tokenizer.ResultExpectedFailure, result.Interactions[1].ResultCode, "An exit code mismatch is an expected failure")
+	require.Equal(t, tokenizer.ResultUnexpectedPass, result.Interactions[2].ResultCode, "Passing commands are flagged")
+	require.Equal(t, 2, result.SuccessCount, "Expected failures count as passing")
+	require.Equal(t, 1, result.FailureCount, "Unexpectedly passing commands count as failures")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "Unexpectedly passing commands fail the document")
+}
+
+func TestWaitFor(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-waitfor")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	counter := filepath.Join(directory, "counter")
+	document := filepath.Join(directory, "document.md")
+	content := fmt.Sprintf("    $ echo 0 > %[1]s\n\n```shell {shelldocwaitfor=10s shelldocwaitinterval=10ms}\n$ n=$(($(cat %[1]s) + 1)); echo $n > %[1]s; echo $n\n3\n```\n\n"+
+		"```shell {shelldocwaitfor=100ms shelldocwaitinterval=30ms}\n$ echo never\nalways\n```\n", counter)
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[1].ResultCode, "The command is executed again until it passes")
+	data, err := ioutil.ReadFile(counter)
+	require.NoError(t, err, "The counter was written")
+	require.Equal(t, "3\n", string(data), "The command is not executed again after it passed")
+	require.Equal(t, tokenizer.ResultMismatch, result.Interactions[2].ResultCode, "The command fails if it does not pass in time")
+	require.Contains(t, result.Interactions[2].Comment, "still failing after", "The attempts are reported")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "The document fails")
+}
+
+func TestStreams(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-streams")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	command := "echo one; echo warning >&2; echo two"
+	content := fmt.Sprintf("```shell {shelldocstream=combined}\n$ %[1]s\none\nwarning\ntwo\n```\n\n"+
+		"```shell {shelldocstream=stderr}\n$ %[1]s\nwarning\n```\n\n```shell\n$ %[1]s\none\ntwo\n```\n\n"+
+		"```shell {shelldocstream=combined}\n$ export STREAMS=kept\n$ echo $STREAMS\nkept\n```\n", command)
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The expected responses are compared with the selected streams")
+	require.Equal(t, []string{"one", "warning", "two"}, result.Interactions[0].Output, "The combined output is interleaved in order")
+	require.Equal(t, []string{"warning"}, result.Interactions[1].ComparedOutput(), "The error output is compared")
+	require.Equal(t, []string{"one", "two"}, result.Interactions[1].Output, "The standard output is still recorded")
+	require.Equal(t, []string{"warning"}, result.Interactions[2].Stderr, "The streams are separated by default")
+}
+
+func TestToolVersions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-tools")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	tool := filepath.Join(directory, "tool")
+	require.NoError(t, ioutil.WriteFile(tool, []byte("#!/bin/sh\necho \"tool version v2.3.1\"\n"), 0755), "Writing the tool should work")
+	require.Equal(t, "2.3.1", probeVersion(tool), "The version is probed from the output of the tool")
+	require.Empty(t, probeVersion(filepath.Join(directory, "missing")), "Missing tools have no version")
+	document := filepath.Join(directory, "document.md")
+	content := "```shell\n$ echo 1.28\nexpect[kubectl<1.28]\n1.27\nexpect[kubectl>=1.28]\n1.28\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{ToolVersions: map[string]string{"kubectl": "1.29.0"}}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[0].ResultCode, "The variant for the version of the tool is expected")
+}
+
+func TestQuarantine(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-flaky")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocflaky=https://example.com/issues/42}\n$ echo unreliable\nreliable\n$ true\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	var output bytes.Buffer
+	result, err := New(Options{Output: &output}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, tokenizer.ResultMismatch, result.Interactions[0].ResultCode, "The failure is recorded")
+	require.True(t, result.Interactions[0].Quarantined(), "The failure is quarantined")
+	require.False(t, result.Interactions[1].Quarantined(), "Passing interactions are not quarantined")
+	require.Equal(t, 1, result.QuarantineCount, "Quarantined failures are counted separately")
+	require.Zero(t, result.FailureCount, "Quarantined failures are not counted as failures")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Quarantined failures do not fail the document")
+	require.Contains(t, output.String(), "quarantined as flaky (https://example.com/issues/42)", "The quarantine is reported")
+}
+
+func TestStateAssertions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-state")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocexpectenv=GREETING=Hello,NAME=World shelldocexpectcwd=work shelldocexpectfunction=greet}\n$ export GREETING=Hello NAME=World\n$ mkdir -p work && cd work\n$ greet() { echo $GREETING; }\n```\n\n```shell {shelldocexpectenv=GREETING=Hi shelldocexpectcwd=/ shelldocexpectfunction=farewell}\n$ true\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 11, "Every assertion is an interaction")
+	for _, interaction := range result.Interactions[:8] {
+		require.Equal(t, tokenizer.ResultMatch, interaction.ResultCode, "The state is as documented: %s", interaction.Describe())
+	}
+	require.Equal(t, "GREETING is \"Hello\"", result.Interactions[3].Caption, "Assertions are named after what they check")
+	require.Equal(t, tokenizer.ResultMismatch, result.Interactions[8].ResultCode, "A variable with another value fails")
+	require.Equal(t, tokenizer.ResultMismatch, result.Interactions[9].ResultCode, "Another working directory fails")
+	require.Equal(t, tokenizer.ResultError, result.Interactions[10].ResultCode, "A missing function fails")
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-env")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell\n$ export GREETING=Hello\n```\n\n```shell {shelldocenv=\"GREETING=Hi NAME='World'\"}\n$ echo $GREETING $NAME\nHi 'World'\n$ sh -c 'echo $NAME'\n'World'\n```\n\n```shell {shelldocenv=NAME=x shelldocexitcode=3}\n$ sh -c 'exit 3'\n```\n\n```shell\n$ echo \"$GREETING ${NAME-unset}\"\nHello unset\n```\n\n```shell {shelldoccolumns=120}\n$ echo $COLUMNS\n120\n```\n\n```shell {shelldoccolumns=120 shelldocenv=COLUMNS=60}\n$ echo $COLUMNS\n60\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 7, "Every command is an interaction")
+	for _, interaction := range result.Interactions {
+		require.Equal(t, tokenizer.ResultMatch, interaction.ResultCode, "The variables apply to the commands of their code block only: %s", interaction.Describe())
+	}
+	require.Equal(t, "echo $GREETING $NAME", result.Interactions[1].Cmd, "The command is reported as documented")
+}
+
+func TestNiceness(t *testing.T) {
+	output, err := exec.Command("nice").Output()
+	require.NoError(t, err, "The niceness of the test should be readable")
+	niceness, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	require.NoError(t, err, "The niceness should be a number")
+	expected := niceness + 5
+	if expected > 19 {
+		expected = 19 // the highest niceness
+	}
+	directory, err := ioutil.TempDir("", "shelldoc-nice")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := fmt.Sprintf("```shell {shelldocnice=5}\n$ nice\n%d\n$ sh -c 'exit 3'\n```\n\n```shell\n$ nice\n%d\n```\n", expected, niceness)
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 3, "Every command is an interaction")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[0].ResultCode, "The commands of the code block run with a higher niceness")
+	require.Equal(t, 3, result.Interactions[1].ExitCode, "The exit code of the command is kept")
+	require.Equal(t, tokenizer.ResultMatch, result.Interactions[2].ResultCode, "The niceness of the shell is not changed")
+}
+
+func TestSerial(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-serial")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocserial}\n$ echo first\nfirst\n```\n\n```shell\n$ echo second\nsecond\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	unlock, err := lockSerial(context.Background(), ioutil.Discard)
+	require.NoError(t, err, "Locking the serial code blocks should work")
+	var output bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = New(Options{Output: &output}).RunDocument(ctx, document)
+	require.Equal(t, context.DeadlineExceeded, err, "A serial code block waits while another run holds the lock")
+	require.Contains(t, output.String(), "waiting for another run", "Waiting for the lock is reported")
+	unlock()
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The serial code block runs once the lock is released")
+	unlock, err = lockSerial(context.Background(), ioutil.Discard)
+	require.NoError(t, err, "The lock is released after the document")
+	unlock()
+}
+
+func TestFileAssertions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-files")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocexpectfile=out/report.txt,out shelldocexpectfilecontains=out/report.txt:Total:3 shelldocexpectdirempty=cache}\n$ cd " + directory + " && mkdir -p out cache && echo Total:3 > out/report.txt\n```\n\n```shell {shelldocexpectfile=missing.txt shelldocexpectfilecontains=out/report.txt:Total:4 shelldocexpectdirempty=out}\n$ true\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Len(t, result.Interactions, 9, "Every assertion is an interaction")
+	for _, interaction := range result.Interactions[:6] {
+		require.Equal(t, tokenizer.ResultMatch, interaction.ResultCode, "The files are as documented: %s", interaction.Describe())
+	}
+	require.Equal(t, "out/report.txt contains \"Total:3\"", result.Interactions[3].Caption, "Assertions are named after what they check")
+	for _, interaction := range result.Interactions[6:] {
+		require.Equal(t, tokenizer.ResultError, interaction.ResultCode, "The files are not as documented: %s", interaction.Describe())
+	}
+}
+
+func TestSerialization(t *testing.T) {
+	document, err := New(Options{}).RunDocument(context.Background(), "../tokenizer/samples/failnomatch.md")
+	require.NoError(t, err, "The sample should execute without errors")
+	result := Result{ReturnCode: document.ReturnCode, Documents: []DocumentResult{document}}
+	result.Documents[0].Interactions[0].Err = nil // errors are not serialized
+	for name, codec := range map[string]struct {
+		write func(io.Writer, Result) error
+		read  func(io.Reader) (Result, error)
+	}{"json": {WriteJSON, ReadJSON}, "yaml": {WriteYAML, ReadYAML}} {
+		var buffer bytes.Buffer
+		require.NoError(t, codec.write(&buffer, result), "Writing the results as %s should work", name)
+		require.Contains(t, buffer.String(), "mismatch", "The result code is written by name")
+		restored, err := codec.read(&buffer)
+		require.NoError(t, err, "Reading the results as %s should work", name)
+		require.Equal(t, result, restored, "The results are restored from %s", name)
+	}
+	_, err = ReadJSON(strings.NewReader(`{"version": 2}`))
+	require.Error(t, err, "Unknown schema versions are rejected")
+}
+
+func TestMerge(t *testing.T) {
+	shard := func(file string, results ...tokenizer.ResultCode) DocumentResult {
+		document := DocumentResult{File: file, ReturnCode: ReturnSuccess}
+		for index, result := range results {
+			document.Interactions = append(document.Interactions, &tokenizer.Interaction{Cmd: "true", Line: index + 1, ResultCode: result})
+		}
+		return document
+	}
+	first := Result{Documents: []DocumentResult{shard("a.md", tokenizer.ResultMatch, tokenizer.NewInteraction), shard("b.md", tokenizer.ResultMatch)}}
+	second := Result{Documents: []DocumentResult{shard("a.md", tokenizer.ResultMatch, tokenizer.ResultMismatch), shard("c.md", tokenizer.ResultSkipped)}}
+	merged := Merge(first, second)
+	require.Len(t, merged.Documents, 3, "Every document is reported once")
+	require.Equal(t, "a.md", merged.Documents[0].File, "The documents keep their order")
+	require.Len(t, merged.Documents[0].Interactions, 2, "Interactions in several results are reported once")
+	require.Equal(t, tokenizer.ResultMismatch, merged.Documents[0].Interactions[1].ResultCode, "The most severe result is kept")
+	require.Equal(t, 2, merged.Documents[0].TestCount, "The counts are computed from the merged interactions")
+	require.Equal(t, 1, merged.Documents[0].FailureCount, "The failure is counted")
+	require.Equal(t, ReturnFailure, merged.Documents[0].ReturnCode, "The document fails")
+	require.Equal(t, 1, merged.Documents[2].SkipCount, "Skipped interactions are counted")
+	require.Equal(t, ReturnFailure, merged.ReturnCode, "The merged result fails")
+	require.Equal(t, ReturnSuccess, Merge(first).ReturnCode, "A single result is returned as it is")
+}
+
+func TestCompare(t *testing.T) {
+	document := func(file string, results ...tokenizer.ResultCode) DocumentResult {
+		document := DocumentResult{File: file}
+		for index, result := range results {
+			document.Interactions = append(document.Interactions, &tokenizer.Interaction{Cmd: "true", Line: index + 1, ResultCode: result})
+		}
+		return document
+	}
+	before := Result{Documents: []DocumentResult{document("a.md", tokenizer.ResultMatch, tokenizer.ResultMismatch, tokenizer.ResultMatch), document("b.md", tokenizer.ResultError)}}
+	after := Result{Documents: []DocumentResult{document("a.md", tokenizer.ResultMismatch, tokenizer.ResultMatch, tokenizer.ResultMatch, tokenizer.ResultSkipped)}}
+	changes := Compare(before, after)
+	require.Len(t, changes, 4, "Only interactions with different results are listed")
+	require.True(t, changes[0].Regression(), "An interaction that fails now regressed")
+	require.True(t, changes[1].Fix(), "An interaction that passes now was fixed")
+	require.Nil(t, changes[2].Before, "New interactions have no earlier result")
+	require.False(t, changes[2].Regression(), "A skipped new interaction is not a regression")
+	require.Equal(t, "b.md", changes[3].File, "Removed interactions are listed last")
+	require.True(t, changes[3].Fix(), "A failing interaction that was removed does not fail anymore")
+	require.Empty(t, Compare(before, before), "Identical results do not differ")
+}
+
+func TestRun(t *testing.T) {
+	runner := New(Options{Excludes: []string{"failnomatch.md"}})
+	result, err := runner.Run(context.Background(), []string{"../tokenizer/samples/helloworld.md", "../tokenizer/samples/failnomatch.md"})
+	require.NoError(t, err, "The samples should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The failing document is excluded")
+	require.Len(t, result.Documents, 1, "Excluded documents have no results")
+}
+
+func TestPriority(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-priority")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	files := []string{filepath.Join(directory, "first.md"), filepath.Join(directory, "build.md"), filepath.Join(directory, "last.md")}
+	require.NoError(t, ioutil.WriteFile(files[0], []byte("    $ true\n"), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(files[1], []byte("---\npriority: 10\n---\n\n    $ true\n"), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(files[2], []byte("---\npriority: -1\n---\n\n    $ true\n"), 0644), "Writing the document should work")
+	result, err := New(Options{}).Run(context.Background(), files)
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Len(t, result.Documents, 3, "All documents are executed")
+	require.Equal(t, []string{files[1], files[0], files[2]}, []string{result.Documents[0].File, result.Documents[1].File, result.Documents[2].File}, "Documents are executed by priority")
+	result, err = New(Options{ShareSession: true}).Run(context.Background(), files)
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, files[0], result.Documents[0].File, "Shared sessions keep the order of the documents")
+}
+
+func TestMaxFailures(t *testing.T) {
+	runner := New(Options{MaxFailures: 1})
+	result, err := runner.Run(context.Background(), []string{"../tokenizer/samples/failfast.md", "../tokenizer/samples/helloworld.md"})
+	require.NoError(t, err, "The examples should execute without errors")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "The expected return code is ReturnFailure")
+	require.Len(t, result.Documents, 1, "The run is aborted after the first document")
+	require.Equal(t, 2, result.Documents[0].TestCount, "The execution stops after the first failure")
+	require.True(t, runner.MaxFailuresReached(), "The runner reports that the maximum number of failures was reached")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := New(Options{}).Run(ctx, []string{"../tokenizer/samples/helloworld.md"})
+	require.Equal(t, context.Canceled, err, "A cancelled run returns the error of the context")
+	require.Empty(t, result.Documents, "No documents are executed after the run was cancelled")
+}
+
+// recordingBackend is a backend that records the commands instead of executing them
+type recordingBackend struct {
+	started, closed bool
+	commands        []string
+}
+
+func (backend *recordingBackend) Start() error {
+	backend.started = true
+	return nil
+}
+
+func (backend *recordingBackend) Execute(ctx context.Context, command string) ([]string, []string, int, error) {
+	backend.commands = append(backend.commands, command)
+	return nil, nil, 0, nil
+}
+
+func (backend *recordingBackend) Close() error {
+	backend.closed = true
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	backend := &recordingBackend{}
+	options := Options{NewBackend: func() shell.Backend { return backend }}
+	result, err := New(options).RunDocument(context.Background(), "../tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "Running the document with a custom backend should work")
+	require.True(t, backend.started && backend.closed, "The runner starts and closes the backend")
+	require.Equal(t, 4, result.TestCount, "All interactions are executed")
+	require.Equal(t, []string{"export HELLOVAR=Hello", "echo $HELLOVAR", "echo World", "echo Hello; echo World"}, backend.commands, "The commands are executed by the custom backend")
+}
+
+func TestReuseSessions(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-sessions")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	first := filepath.Join(directory, "first.md")
+	second := filepath.Join(directory, "second.md")
+	require.NoError(t, ioutil.WriteFile(first, []byte("    $ export SHELLDOC_STATE=first; echo $$ > pid\n"), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(second, []byte("    $ echo ${SHELLDOC_STATE:-unset}\n    unset\n    $ test \"$(cat pid)\" = $$ && echo same\n    same\n"), 0644), "Writing the document should work")
+	runner := New(Options{ReuseSessions: true, ShellCommand: "sh"})
+	cwd, err := os.Getwd()
+	require.NoError(t, err, "The working directory should be known")
+	require.NoError(t, os.Chdir(directory), "Changing the directory should work")
+	defer os.Chdir(cwd)
+	result, err := runner.Run(context.Background(), []string{first, second})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The second document runs in the same, reset shell")
+	require.Empty(t, runner.sessions, "The shells are closed after the run")
+}
+
+func TestShareSession(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-share")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	first := filepath.Join(directory, "part1.md")
+	second := filepath.Join(directory, "part2.md")
+	third := filepath.Join(directory, "part3.md")
+	require.NoError(t, ioutil.WriteFile(first, []byte(fmt.Sprintf("    $ export PART=one; cd %s\n", directory)), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(third, []byte("    $ echo ${PART:-unset}\n    unset\n"), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(second, []byte("    $ echo ${PART:-unset} $(basename $PWD)\n    one "+filepath.Base(directory)+"\n"), 0644), "Writing the document should work")
+
+	runner := New(Options{ShareSession: true})
+	result, err := runner.Run(context.Background(), []string{first, second})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The second document continues the session of the first")
+	require.Equal(t, first, result.Documents[1].Continues, "The result reports the chain of documents")
+	require.Empty(t, result.Documents[0].Continues, "The first document starts the session")
+	require.Nil(t, runner.shared, "The shared shell is closed after the run")
+
+	result, err = New(Options{}).Run(context.Background(), []string{first, second})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "Documents are isolated by default")
+	require.Empty(t, result.Documents[1].Continues, "Isolated documents do not continue a session")
+
+	timeout := filepath.Join(directory, "timeout.md")
+	require.NoError(t, ioutil.WriteFile(timeout, []byte("    $ export PART=one\n    $ sleep 10\n"), 0644), "Writing the document should work")
+	result, err = New(Options{ShareSession: true, Timeout: 100 * time.Millisecond}).Run(context.Background(), []string{timeout, third})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnSuccess, result.Documents[1].ReturnCode, "A terminated shell is not continued")
+	require.Empty(t, result.Documents[1].Continues, "The chain ends with the terminated shell")
+}
+
+func TestCache(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-cache")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	executions := filepath.Join(directory, "executions")
+	document := filepath.Join(directory, "document.md")
+	content := fmt.Sprintf("    $ echo >> %s; echo hello\n    hello\n", executions)
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	cache := &Cache{Directory: filepath.Join(directory, "cache"), Version: "test"}
+	executed := func() int {
+		data, err := ioutil.ReadFile(executions)
+		require.NoError(t, err, "The document should have been executed")
+		return len(data)
+	}
+	for _, cached := range []bool{false, true} {
+		result, err := New(Options{Cache: cache}).Run(context.Background(), []string{document})
+		require.NoError(t, err, "The document should execute without errors")
+		require.Equal(t, ReturnSuccess, result.ReturnCode, "The document passes, also when cached")
+		require.Equal(t, cached, result.Documents[0].Cached, "The result is only cached the second time")
+		require.Equal(t, 1, result.Documents[0].SuccessCount, "The cached result contains the counts")
+		require.Equal(t, 1, executed(), "The unchanged document is not executed again")
+	}
+	_, err = New(Options{Cache: cache, NoSkips: true}).Run(context.Background(), []string{document})
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, 2, executed(), "Results are not shared between runs with different options")
+	require.NoError(t, ioutil.WriteFile(document, []byte(content+"    $ false\n"), 0644), "Writing the document should work")
+	for run := 3; run <= 4; run++ {
+		result, err := New(Options{Cache: cache}).Run(context.Background(), []string{document})
+		require.NoError(t, err, "The document should execute without errors")
+		require.Equal(t, ReturnFailure, result.ReturnCode, "The changed document fails")
+		require.Equal(t, run, executed(), "Failing documents are not cached")
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-incremental")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	executions := filepath.Join(directory, "executions")
+	document := filepath.Join(directory, "document.md")
+	write := func(second, exitCode string) {
+		content := fmt.Sprintf("```shell {shelldocsetup}\n$ echo setup >> %[1]s\n```\n\n```shell\n$ echo first >> %[1]s\n```\n\n```shell\n$ echo %[2]s >> %[1]s; (exit %[3]s)\n```\n", executions, second, exitCode)
+		require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	}
+	run := func() (DocumentResult, []string) {
+		os.Remove(executions)
+		incremental := &Incremental{File: filepath.Join(directory, "state.json"), Version: "test"}
+		result, err := New(Options{Incremental: incremental}).Run(context.Background(), []string{document})
+		require.NoError(t, err, "The document should execute without errors")
+		data, _ := ioutil.ReadFile(executions)
+		return result.Documents[0], strings.Fields(string(data))
+	}
+	write("second", "0")
+	result, executed := run()
+	require.Equal(t, []string{"setup", "first", "second"}, executed, "All code blocks are executed the first time")
+	require.Equal(t, 0, result.Unchanged, "No interactions are unchanged the first time")
+	result, executed = run()
+	require.Empty(t, executed, "Unchanged code blocks are not executed")
+	require.Equal(t, 3, result.Unchanged, "The unchanged interactions are counted")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "An unchanged document passes")
+	write("changed", "1")
+	result, executed = run()
+	require.Equal(t, []string{"setup", "changed"}, executed, "Changed code blocks are executed after the setup code blocks")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "The changed code block fails")
+	_, executed = run()
+	require.Equal(t, []string{"setup", "changed"}, executed, "Failing code blocks are executed again")
+}
+
+func TestCheckpoints(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-checkpoints")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	executions := filepath.Join(directory, "executions")
+	workspace := filepath.Join(directory, "workspace")
+	document := filepath.Join(directory, "document.md")
+	write := func(second, exitCode string) {
+		content := fmt.Sprintf("```shell {shelldocsetup shelldoccheckpoint=workspace}\n$ echo setup >> %[1]s\n$ mkdir -p %[2]s && cd %[2]s\n$ export STEP=one\n```\n\n"+
+			"```shell {shelldocrestore=workspace}\n$ export STEP=two && cd /\n$ echo %[3]s >> %[1]s; (exit %[4]s)\n```\n\n"+
+			"```shell {shelldocrestore=workspace}\n$ echo $STEP $(basename $PWD)\none workspace\n```\n", executions, workspace, second, exitCode)
+		require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	}
+	run := func() (DocumentResult, []string) {
+		os.Remove(executions)
+		incremental := &Incremental{File: filepath.Join(directory, "state.json"), Version: "test"}
+		result, err := New(Options{Incremental: incremental}).Run(context.Background(), []string{document})
+		require.NoError(t, err, "The document should execute without errors")
+		data, _ := ioutil.ReadFile(executions)
+		return result.Documents[0], strings.Fields(string(data))
+	}
+	write("second", "0")
+	result, executed := run()
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Later code blocks restore the state of the checkpoint")
+	require.Equal(t, []string{"setup", "second"}, executed, "All code blocks are executed the first time")
+	write("changed", "1")
+	result, executed = run()
+	require.Equal(t, []string{"changed"}, executed, "The execution resumes from the checkpoint before the changed code block")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "The changed code block fails")
+	_, executed = run()
+	require.Equal(t, []string{"changed"}, executed, "The checkpoint is kept while later code blocks fail")
+	require.NoError(t, os.RemoveAll(workspace), "Removing the workspace should work")
+	result, executed = run()
+	require.Equal(t, ReturnError, result.ReturnCode, "A checkpoint whose directory is gone cannot be restored")
+	require.Empty(t, executed, "No interactions are executed if the checkpoint cannot be restored")
+	_, executed = run()
+	require.Equal(t, []string{"setup", "changed"}, executed, "Checkpoints that cannot be restored are forgotten")
+
+	require.NoError(t, ioutil.WriteFile(document, []byte("```shell {shelldocrestore=missing}\n$ true\n```\n"), 0644), "Writing the document should work")
+	result, err = New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnError, result.ReturnCode, "Checkpoints that were not recorded cannot be restored")
+}
+
+func TestSkipIf(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-skipif")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell {shelldocskipif=\"! command -v shelldoc-missing-tool && echo tool missing\"}\n$ shelldoc-missing-tool --version\n$ false\n```\n\n" +
+		"```shell {shelldocskipif=false}\n$ echo executed\nexecuted\n```\n\n" +
+		"```shell {shelldocskipif=true}\n$ false\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Code blocks whose guard succeeds are skipped")
+	require.Equal(t, 3, result.SkipCount, "All interactions of guarded code blocks are skipped")
+	require.Equal(t, 1, result.SuccessCount, "Code blocks whose guard fails are executed")
+	require.Equal(t, "tool missing", result.Interactions[0].Comment, "The output of the guard is the reason")
+	require.Equal(t, tokenizer.SkipMissingDependency, result.Interactions[0].SkipReason, "Guarded skips are distinguished from requested ones")
+	require.Equal(t, "SKIPPED (missing dependency: tool missing)", result.Interactions[0].Result(), "The kind of skip is shown")
+	require.Equal(t, "true succeeded", result.Interactions[3].Comment, "A guard without output is the reason itself")
+}
+
+func TestLeaks(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-leaks")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	leaking := filepath.Join(directory, "leaking.md")
+	clean := filepath.Join(directory, "clean.md")
+	require.NoError(t, ioutil.WriteFile(leaking, []byte(fmt.Sprintf("    $ export SHELLDOC_LEAK=1; cd %s\n    $ sleep 5 &\n", directory)), 0644), "Writing the document should work")
+	require.NoError(t, ioutil.WriteFile(clean, []byte("    $ (cd / && export SHELLDOC_LEAK=1)\n"), 0644), "Writing the document should work")
+	var output bytes.Buffer
+	result, err := New(Options{Leaks: LeaksWarn, ShellCommand: "sh", Output: &output}).Run(context.Background(), []string{leaking, clean})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Leaked state is only reported by default")
+	require.Contains(t, output.String(), "leaked state: the working directory changed from", "The directory change is reported")
+	require.Contains(t, output.String(), "leaked state: the variable SHELLDOC_LEAK was set", "The variable is reported")
+	require.Contains(t, output.String(), "leaked state: 1 background jobs are still running", "The background job is reported")
+	require.Equal(t, 3, strings.Count(output.String(), "leaked state"), "Documents that do not change the state leak nothing")
+	result, err = New(Options{Leaks: LeaksFail, ShellCommand: "sh"}).Run(context.Background(), []string{leaking, clean})
+	require.NoError(t, err, "The documents should execute without errors")
+	require.Equal(t, ReturnFailure, result.Documents[0].ReturnCode, "Leaked state fails the document if requested")
+	require.Equal(t, ReturnSuccess, result.Documents[1].ReturnCode, "Documents that do not change the state pass")
+}
+
+func TestHighlightDifference(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-highlight")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	require.NoError(t, ioutil.WriteFile(document, []byte("    $ echo version 1.2.3\n    version 1.2.4\n"), 0644), "Writing the document should work")
+	var output bytes.Buffer
+	_, err = New(Options{Output: &output}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Contains(t, output.String(), " --  expected: version 1.2.4\n                           ^\n", "The changed character of the expected line is marked")
+	require.Contains(t, output.String(), " --  received: version 1.2.3\n                           ^\n", "The changed character of the output is marked")
+	output.Reset()
+	_, err = New(Options{Output: &output, Color: true}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Contains(t, output.String(), " --  received: version 1.2.\x1b[7m3\x1b[0m\n", "The changed character is highlighted in color mode")
+	require.NoError(t, ioutil.WriteFile(document, []byte("    $ printf Hello\n    Hello\n"), 0644), "Writing the document should work")
+	output.Reset()
+	_, err = New(Options{Output: &output}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.NotContains(t, output.String(), "received:", "Identical lines are not shown as a difference")
+	require.Contains(t, output.String(), " --  the output did not end with a line break", "The missing line break is explained")
+}
+
+func TestCarriageReturns(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-crlf")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "# Windows\r\n\r\n    $ printf 'hello\\r\\n'\r\n    hello\r\n\r\n```shell\r\n$ echo world\r\nworld\r\n```\r\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Windows line breaks are normalized in documents and output")
+	require.Equal(t, 2, result.SuccessCount, "All code blocks of a document with Windows line breaks are found")
+	content = "    $ printf 'hello\\r\\n'\n    hello\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err = New(Options{KeepCarriageReturns: true}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "Carriage returns in the output can be kept")
+}
+
+func TestDelay(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-delay")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "    $ true\n\n    $ true\n\n```shell {shelldocdelay=0s}\n$ true\n$ true\n```\n\n```shell {shelldocskip}\n$ true\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	start := time.Now()
+	result, err := New(Options{Delay: 300 * time.Millisecond}).RunDocument(context.Background(), document)
+	elapsed := time.Since(start)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The document should pass")
+	require.True(t, elapsed >= 300*time.Millisecond, "The interactions after the first one are delayed")
+	require.True(t, elapsed < 600*time.Millisecond, "Code blocks and skipped interactions can disable the delay")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	_, err = New(Options{Delay: time.Hour}).RunDocument(ctx, document)
+	require.Error(t, err, "The run is cancelled")
+	require.True(t, time.Since(start) < time.Minute, "Cancelling the run ends the delay")
+}
+
+func TestCoverage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-coverage")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell\n$ echo Hello\nHello\n```\n\n```shell {shelldocnotest}\n$ make install\n```\n\n" +
+		"```shell {shelldocskip}\n$ curl https://example.com\n```\n\n```go\nfunc main() {}\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{Coverage: true}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "Code blocks that are executed, skipped or not tested are covered")
+
+	content += "\n```shell\nmake\n```\n\n```console {shelldoctimeout=10s}\n$ echo Hello\nHello\n```\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	parsed, err := tokenizer.ParseDocument([]byte(content))
+	require.NoError(t, err, "The document should parse")
+	problems := CoverageProblems(parsed, []string{"shell"})
+	require.Len(t, problems, 2, "The code block without commands and the one in an unselected language are reported")
+	require.Equal(t, 19, problems[0].Line, "The code block without commands is reported")
+	require.Equal(t, 23, problems[1].Line, "The code block in an unselected language is reported")
+	require.Empty(t, CoverageProblems(parsed, nil)[1:], "All languages are selected if none are")
+	result, err = New(Options{Coverage: true, Languages: []string{"shell"}}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnFailure, result.ReturnCode, "Untested shell code blocks fail the document")
+	result, err = New(Options{Languages: []string{"shell"}}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The coverage policy is only enforced if enabled")
+}
+
+func TestLanguages(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-languages")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	document := filepath.Join(directory, "document.md")
+	content := "```shell\n$ echo Hello\nHello\n```\n\n```console\n$ echo Goodbye\nHello\n```\n\n    $ echo World\n    World\n"
+	require.NoError(t, ioutil.WriteFile(document, []byte(content), 0644), "Writing the document should work")
+	result, err := New(Options{Languages: []string{"shell"}}).RunDocument(context.Background(), document)
+	require.NoError(t, err, "The document should execute without errors")
+	require.Equal(t, ReturnSuccess, result.ReturnCode, "The code block in an excluded language is skipped")
+	require.Len(t, result.Interactions, 2, "The code blocks in the selected language and without a language are executed")
+	require.Equal(t, "echo World", result.Interactions[1].Cmd, "Regular code blocks are always executed")
+}
+
+func TestDiscover(t *testing.T) {
+	interactions, err := New(Options{Run: regexp.MustCompile("^Farewell$")}).Discover("../tokenizer/samples/headings.md")
+	require.NoError(t, err, "The sample should be tokenized")
+	require.Len(t, interactions, 1, "Only the interaction in the Farewell section is selected")
+	require.NotEmpty(t, interactions[0].ID, "Discovered interactions have an ID")
+	interactions, err = New(Options{Languages: []string{"console"}}).Discover("../tokenizer/samples/options.md")
+	require.NoError(t, err, "The sample should be tokenized")
+	require.Empty(t, interactions, "Fenced code blocks in other languages are not selected")
+	interactions, err = New(Options{AtLine: 18}).Discover("../tokenizer/samples/setup.md")
+	require.NoError(t, err, "The sample should be tokenized")
+	require.Len(t, interactions, 2, "The code block at the line is selected after the setup code block")
+	require.Equal(t, "echo $GREETING", interactions[1].Cmd, "The code block at the line is selected")
+	_, err = New(Options{AtLine: 1}).Discover("../tokenizer/samples/setup.md")
+	require.Error(t, err, "A line outside of a code block is an error")
+	_, err = New(Options{}).Discover("does-not-exist.md")
+	require.Error(t, err, "Missing documents are an error")
+}
+
+func TestDiscoverAll(t *testing.T) {
+	var files []string
+	for index := 0; index < 20; index++ {
+		files = append(files, "../tokenizer/samples/helloworld.md", "../tokenizer/samples/headings.md")
+	}
+	files = append(files, "does-not-exist.md", "../tokenizer/samples/failnomatch.md")
+	discovered := New(Options{Excludes: []string{"failnomatch.md"}}).discoverAll(files)
+	require.Len(t, discovered, len(files), "There is a result for every document")
+	for index := 0; index < 40; index += 2 {
+		require.Len(t, discovered[index].interactions, 4, "The results are in the order of the documents")
+		require.Equal(t, "echo Hello", discovered[index+1].interactions[0].Cmd, "The results are in the order of the documents")
+	}
+	require.Error(t, discovered[40].err, "Errors are reported for the document")
+	require.Nil(t, discovered[41].interactions, "Excluded documents are not tokenized")
+}
+
+func TestIsExcluded(t *testing.T) {
+	require.True(t, IsExcluded("docs/CHANGELOG.md", []string{"CHANGELOG.md"}), "Patterns match the file name")
+	require.True(t, IsExcluded("vendor/README.md", []string{"vendor/*"}), "Patterns match the path")
+	require.False(t, IsExcluded("README.md", []string{"CHANGELOG.md"}), "Other documents are not excluded")
+}
+
+// recorder records the events it is notified about
+type recorder struct {
+	NopObserver
+	events []string
+}
+
+func (r *recorder) OnRunStart(files []string) {
+	r.events = append(r.events, "run")
+}
+
+func (r *recorder) OnDocumentStart(file string, interactions []*tokenizer.Interaction) {
+	r.events = append(r.events, fmt.Sprintf("document %d", len(interactions)))
+}
+
+func (r *recorder) OnInteractionDone(file string, interaction *tokenizer.Interaction) {
+	r.events = append(r.events, interaction.Result())
+}
+
+func (r *recorder) OnDocumentDone(result DocumentResult) {
+	r.events = append(r.events, fmt.Sprintf("done %d", result.FailureCount))
+}
+
+func (r *recorder) OnRunFinished(result Result) {
+	r.events = append(r.events, Verdict(result.ReturnCode))
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recorder{}
+	runner := New(Options{Observers: []Observer{observer}})
+	_, err := runner.Run(context.Background(), []string{"../tokenizer/samples/failnomatch.md"})
+	require.NoError(t, err, "The sample should execute without errors")
+	require.Equal(t, []string{"run", "document 1", "FAIL (mismatch)", "done 1", "FAILURE"}, observer.events, "The observer is notified about every event in order")
+}
@@ -0,0 +1,18 @@
+//go:build windows || js
+
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"io"
+)
+
+// lockSerial returns immediately, code blocks with the SerialOption are not locked against other runs on this
+// platform
+func lockSerial(ctx context.Context, out io.Writer) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,58 @@
+//go:build !windows && !js
+
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// serialInterval is the time between two attempts to lock the serialLock
+const serialInterval = 100 * time.Millisecond
+
+// serialLock is the file that is locked while a code block with the SerialOption is executed, by all runs of shelldoc
+// on the machine
+var serialLock = filepath.Join(os.TempDir(), "shelldoc-serial.lock")
+
+// lockSerial waits until no other run executes a code block with the SerialOption, and returns the function that
+// releases the lock
+// A message is written to out if another run holds the lock. The error of the context is returned if it is done
+// before the lock was acquired.
+func lockSerial(ctx context.Context, out io.Writer) (func(), error) {
+	file, err := os.Open(serialLock)
+	if os.IsNotExist(err) {
+		file, err = os.OpenFile(serialLock, os.O_RDONLY|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the lock file for serial code blocks: %v", err)
+	}
+	for waiting := false; ; waiting = true {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// closing the file releases the lock
+			return func() { file.Close() }, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("unable to lock %s: %v", serialLock, err)
+		}
+		if !waiting {
+			fmt.Fprintf(out, " --  waiting for another run to finish its serial code block\n")
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(serialInterval):
+		}
+	}
+}
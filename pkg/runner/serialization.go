@@ -0,0 +1,74 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SchemaVersion is the version of the format results are serialized in
+// It is increased whenever fields are renamed or their meaning changes, adding fields does not change the version.
+const SchemaVersion = 1
+
+// serializedResult is the serialized form of a Result, with the schema version
+type serializedResult struct {
+	Version int `json:"version" yaml:"version"`
+	Result  `yaml:",inline"`
+}
+
+// WriteJSON writes the result of a run as JSON
+func WriteJSON(w io.Writer, result Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(serializedResult{SchemaVersion, result}); err != nil {
+		return fmt.Errorf("unable to serialize the results: %v", err)
+	}
+	return nil
+}
+
+// ReadJSON reads the result of a run written by WriteJSON
+func ReadJSON(r io.Reader) (Result, error) {
+	var serialized serializedResult
+	if err := json.NewDecoder(r).Decode(&serialized); err != nil {
+		return Result{}, fmt.Errorf("unable to read the results: %v", err)
+	}
+	return serialized.Result, checkVersion(serialized.Version)
+}
+
+// WriteYAML writes the result of a run as YAML
+func WriteYAML(w io.Writer, result Result) error {
+	data, err := yaml.Marshal(serializedResult{SchemaVersion, result})
+	if err != nil {
+		return fmt.Errorf("unable to serialize the results: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadYAML reads the result of a run written by WriteYAML
+func ReadYAML(r io.Reader) (Result, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to read the results: %v", err)
+	}
+	var serialized serializedResult
+	if err := yaml.Unmarshal(data, &serialized); err != nil {
+		return Result{}, fmt.Errorf("unable to read the results: %v", err)
+	}
+	return serialized.Result, checkVersion(serialized.Version)
+}
+
+// checkVersion returns an error if results in the schema version cannot be read
+func checkVersion(version int) error {
+	if version < 1 || version > SchemaVersion {
+		return fmt.Errorf("unsupported schema version %d of the results, expected %d", version, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// probeTimeout is the time a tool may take to report its version
+const probeTimeout = 10 * time.Second
+
+// versionRx matches the first version number in the output of a tool, like 1.28.2 in "Client Version: v1.28.2"
+var versionRx = regexp.MustCompile(`[0-9]+(\.[0-9]+)+`)
+
+// probeArguments are tried in order to make a tool print its version
+var probeArguments = [][]string{{"--version"}, {"version", "--client"}, {"version"}}
+
+// toolVersions caches the versions of the tools named in the variants of expected responses
+// Documents are discovered concurrently, so the cache is synchronized.
+type toolVersions struct {
+	mutex    sync.Mutex
+	versions map[string]string
+}
+
+// selectVariants selects the expected responses of the interactions for the versions of the tools
+// The versions in ToolVersions are used as specified, other tools are probed once per runner.
+func (runner *Runner) selectVariants(interactions []*tokenizer.Interaction) {
+	for _, interaction := range interactions {
+		if len(interaction.Variants) > 0 {
+			interaction.SelectVariant(runner.toolVersion)
+		}
+	}
+}
+
+// toolVersion returns the version of the tool, or an empty string if it is unknown
+func (runner *Runner) toolVersion(tool string) string {
+	if version, ok := runner.options.ToolVersions[tool]; ok {
+		return version
+	}
+	runner.tools.mutex.Lock()
+	defer runner.tools.mutex.Unlock()
+	if version, ok := runner.tools.versions[tool]; ok {
+		return version
+	}
+	version := probeVersion(tool)
+	if len(version) == 0 {
+		log.Printf("Unable to determine the version of %s, its variants of expected responses are not used.", tool)
+	}
+	if runner.tools.versions == nil {
+		runner.tools.versions = make(map[string]string)
+	}
+	runner.tools.versions[tool] = version
+	return version
+}
+
+// probeVersion executes the tool to find out its version
+// The output is accepted even if the tool fails, since some tools print their version and then fail to contact a
+// server, like kubectl version.
+func probeVersion(tool string) string {
+	if _, err := exec.LookPath(tool); err != nil {
+		return ""
+	}
+	for _, arguments := range probeArguments {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		output, _ := exec.CommandContext(ctx, tool, arguments...).CombinedOutput()
+		cancel()
+		if version := versionRx.FindString(string(output)); len(version) > 0 {
+			return version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package runner
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName identifies the spans created by shelldoc
+// The spans are sent to the global tracer provider, which discards them unless the program installs an exporter.
+const TracerName = "github.com/endocode/shelldoc"
+
+// Tracer returns the tracer used for all shelldoc spans, like the spans of the documents and interactions executed by
+// a Runner
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// annotateInteractionSpan records the command, the result and the exit code of an executed interaction on its span
+// The result is recorded by the name of its result code, which does not depend on the locale of the messages.
+func annotateInteractionSpan(span trace.Span, interaction *tokenizer.Interaction) {
+	span.SetAttributes(
+		attribute.String("shelldoc.command", interaction.Cmd),
+		attribute.String("shelldoc.result", interaction.ResultCode.String()),
+		attribute.Int("shelldoc.rc", interaction.ExitCode),
+	)
+	if interaction.HasFailure() || interaction.ResultCode == tokenizer.ResultExecutionError {
+		span.SetStatus(codes.Error, interaction.ResultCode.String())
+	}
+}
@@ -0,0 +1,120 @@
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrTimeout is returned if a command does not finish within its timeout
+	ErrTimeout = errors.New("timeout expired")
+	// ErrShellCrashed is returned if the shell exits before the command finished, for example because the command
+	// was exit
+	ErrShellCrashed = errors.New("the shell exited unexpectedly")
+	// ErrWaitingForInput is returned with the output of a command that was blocked reading its standard input, which
+	// is empty, and received the end of its input after a grace period
+	ErrWaitingForInput = errors.New("the command appears to be waiting for input")
+	// ErrOutputLimit is returned if the standard output of a command exceeded the limit and the shell was terminated,
+	// see Shell.FailOnOutputLimit
+	ErrOutputLimit = errors.New("output limit exceeded")
+)
+
+// Backend executes the commands of a document
+// A backend is started once per document, executes the commands in order in the same environment, so that later
+// commands see the effects of earlier ones, and is closed after the last command. Shell is the default backend that
+// runs the commands in a local shell process. Other backends can execute the commands in a container, on a remote
+// machine or in a different kind of shell. Shell is not available in WebAssembly builds (GOOS=js), which can parse and
+// validate documents, but need a different Backend to execute them.
+type Backend interface {
+	// Start prepares the backend for executing commands
+	Start() error
+	// Execute runs a command and returns its standard output and error output as lines, and its exit code
+	// If the context is done before the command finishes, the backend terminates the command and returns ErrTimeout if
+	// the deadline expired, and the error of the context otherwise. The backend cannot be used after that.
+	Execute(ctx context.Context, command string) (stdout []string, stderr []string, rc int, err error)
+	// Close ends the backend and releases its resources
+	Close() error
+}
+
+// OutputInfo describes the raw standard output of a command, which its lines do not fully describe
+type OutputInfo struct {
+	// Bytes is the number of bytes the command wrote, including the line breaks
+	Bytes int64
+	// FinalNewline is true if the output ended with a line break, or if it was empty
+	FinalNewline bool
+}
+
+// OutputInspector is implemented by backends that record the raw standard output of the last command they executed
+// The lines returned by Execute do not tell if the last line ended with a line break, like the output of printf often
+// does not.
+type OutputInspector interface {
+	// LastOutput describes the standard output of the last command
+	LastOutput() OutputInfo
+}
+
+// Resetter is implemented by backends that can be reused for another document
+// Checkpoint records the state of a freshly started backend, and Reset restores it after a document was executed, so
+// that the next document does not see the effects of the previous one. If Reset fails, the backend cannot be reused.
+type Resetter interface {
+	// Checkpoint records the state the backend is reset to
+	Checkpoint(ctx context.Context) error
+	// Reset restores the state recorded by Checkpoint
+	Reset(ctx context.Context) error
+}
+
+// State is the working directory and the environment variables of a backend
+type State struct {
+	Directory   string            `json:"directory"`
+	Environment map[string]string `json:"environment"`
+}
+
+// managedVariables are maintained by the shell itself, they are not reset or compared
+var managedVariables = map[string]bool{"_": true, "PWD": true, "OLDPWD": true, "SHLVL": true}
+
+// Changes describes how a later state differs from this one, sorted by variable name after the working directory
+// Only the names of the variables are described, their values may be secrets.
+func (state State) Changes(later State) []string {
+	var changes []string
+	if state.Directory != later.Directory {
+		changes = append(changes, fmt.Sprintf("the working directory changed from %s to %s", state.Directory, later.Directory))
+	}
+	var names []string
+	for name := range state.Environment {
+		names = append(names, name)
+	}
+	for name := range later.Environment {
+		if _, ok := state.Environment[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		before, wasSet := state.Environment[name]
+		after, isSet := later.Environment[name]
+		switch {
+		case managedVariables[name]:
+		case !wasSet:
+			changes = append(changes, fmt.Sprintf("the variable %s was set", name))
+		case !isSet:
+			changes = append(changes, fmt.Sprintf("the variable %s was unset", name))
+		case before != after:
+			changes = append(changes, fmt.Sprintf("the variable %s was changed", name))
+		}
+	}
+	return changes
+}
+
+// StateKeeper is implemented by backends whose state can be saved and restored later, also in another backend
+// The runner uses it for the checkpoints of a document, see tokenizer.CheckpointOption.
+type StateKeeper interface {
+	// SaveState returns the current state of the backend
+	SaveState(ctx context.Context) (State, error)
+	// RestoreState changes the state of the backend to a saved one
+	RestoreState(ctx context.Context, state State) error
+}
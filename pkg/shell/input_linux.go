@@ -0,0 +1,111 @@
+//go:build linux
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// inputGuard provides the standard input of the commands, a named pipe that stays empty
+// Shelldoc keeps the pipe open for writing, so that a command reading from it blocks instead of reading the commands
+// that follow it from the input of the shell. A command that is blocked reading from it is found by looking at the
+// processes of the shell in /proc, and receives the end of its input once release closes the pipe.
+type inputGuard struct {
+	directory string
+	path      string
+	info      os.FileInfo
+	writer    *os.File
+}
+
+// newInputGuard creates the named pipe the commands read from
+func newInputGuard() (*inputGuard, error) {
+	directory, err := ioutil.TempDir("", "shelldoc-input")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the input of the commands: %v", err)
+	}
+	guard := &inputGuard{directory: directory, path: filepath.Join(directory, "stdin")}
+	if err := syscall.Mkfifo(guard.path, 0600); err != nil {
+		os.RemoveAll(directory)
+		return nil, fmt.Errorf("unable to create the input of the commands: %v", err)
+	}
+	if guard.info, err = os.Stat(guard.path); err != nil {
+		os.RemoveAll(directory)
+		return nil, fmt.Errorf("unable to create the input of the commands: %v", err)
+	}
+	return guard, nil
+}
+
+// prepare opens the pipe for writing before a command is executed, if release closed it
+// Opening it for reading and writing does not block, and the shell opening it for reading does not block either then.
+func (guard *inputGuard) prepare() error {
+	if guard.writer != nil {
+		return nil
+	}
+	writer, err := os.OpenFile(guard.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open the input of the commands: %v", err)
+	}
+	guard.writer = writer
+	return nil
+}
+
+// waiting returns true if a process in the process group of the shell is blocked reading from the pipe
+func (guard *inputGuard) waiting(group int) bool {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !guard.inGroup(pid, group) {
+			continue
+		}
+		input, err := os.Stat(fmt.Sprintf("/proc/%d/fd/0", pid))
+		if err != nil || !os.SameFile(input, guard.info) {
+			continue
+		}
+		// the kernel function a process sleeps in is pipe_read or pipe_wait, or anon_pipe_read in newer kernels
+		if wchan, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/wchan", pid)); err == nil && strings.Contains(string(wchan), "pipe") {
+			return true
+		}
+	}
+	return false
+}
+
+// inGroup returns true if the process belongs to the process group
+func (guard *inputGuard) inGroup(pid, group int) bool {
+	if pid == group {
+		return true
+	}
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// the command name in parentheses may contain spaces, the fields after it are the state, the parent and the group
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) > 2 && fields[2] == strconv.Itoa(group)
+}
+
+// release closes the pipe, so that the commands reading from it reach the end of their input
+func (guard *inputGuard) release() {
+	if guard.writer != nil {
+		guard.writer.Close()
+		guard.writer = nil
+	}
+}
+
+// close releases the pipe and removes it
+func (guard *inputGuard) close() {
+	guard.release()
+	os.RemoveAll(guard.directory)
+}
@@ -0,0 +1,33 @@
+//go:build !linux && !js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+// inputGuard detects commands waiting for input, which is only supported on Linux
+type inputGuard struct {
+	path string
+}
+
+// newInputGuard returns nil, the commands read from the input of the shell
+func newInputGuard() (*inputGuard, error) {
+	return nil, nil
+}
+
+// prepare does nothing
+func (guard *inputGuard) prepare() error {
+	return nil
+}
+
+// waiting returns false, commands waiting for input cannot be detected
+func (guard *inputGuard) waiting(group int) bool {
+	return false
+}
+
+// release does nothing
+func (guard *inputGuard) release() {}
+
+// close does nothing
+func (guard *inputGuard) close() {}
@@ -0,0 +1,22 @@
+//go:build !windows && !js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the shell in its own process group, so that it can be terminated with the commands it runs
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate kills the process group of the shell
+func terminate(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
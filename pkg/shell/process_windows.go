@@ -0,0 +1,15 @@
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import "os/exec"
+
+// setProcessGroup does nothing, process groups are a Unix concept
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminate kills the shell, commands started by it may keep running
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,110 @@
+//go:build !js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variableNameRx matches the names of environment variables that can be set in the shell
+var variableNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Checkpoint records the working directory and the environment variables of the shell
+func (shell *Shell) Checkpoint(ctx context.Context) error {
+	state, err := shell.SaveState(ctx)
+	if err != nil {
+		return err
+	}
+	shell.checkpoint = &state
+	return nil
+}
+
+// Reset restores the working directory and the environment variables recorded by Checkpoint
+func (shell *Shell) Reset(ctx context.Context) error {
+	if shell.checkpoint == nil {
+		return fmt.Errorf("unable to reset the shell: no checkpoint recorded")
+	}
+	if err := shell.RestoreState(ctx, *shell.checkpoint); err != nil {
+		return fmt.Errorf("unable to reset the shell: %v", err)
+	}
+	return nil
+}
+
+// SaveState returns the working directory and the environment variables of the shell
+func (shell *Shell) SaveState(ctx context.Context) (State, error) {
+	directory, environment, err := shell.state(ctx)
+	if err != nil {
+		return State{}, fmt.Errorf("unable to record the state of the shell: %v", err)
+	}
+	return State{Directory: directory, Environment: environment}, nil
+}
+
+// RestoreState changes to the working directory of the state and restores its environment variables
+// Variables that were added are unset, variables that were changed or unset are exported with their recorded value.
+// Shell variables that are not exported, functions, aliases, shell options and background jobs are not restored. It
+// fails if the directory does not exist anymore.
+func (shell *Shell) RestoreState(ctx context.Context, state State) error {
+	_, environment, err := shell.state(ctx)
+	if err != nil {
+		return err
+	}
+	commands := []string{"cd -- " + quote(state.Directory)}
+	var names []string
+	for name := range environment {
+		names = append(names, name)
+	}
+	for name := range state.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for index, name := range names {
+		if managedVariables[name] || !variableNameRx.MatchString(name) || (index > 0 && names[index-1] == name) {
+			continue
+		}
+		recorded, wasSet := state.Environment[name]
+		current, isSet := environment[name]
+		switch {
+		case !wasSet:
+			commands = append(commands, "unset "+name)
+		case !isSet || current != recorded:
+			commands = append(commands, fmt.Sprintf("export %s=%s", name, quote(recorded)))
+		}
+	}
+	_, rc, err := shell.ExecuteCommandContext(ctx, strings.Join(commands, "; "))
+	if err != nil {
+		return err
+	}
+	if rc != 0 {
+		return fmt.Errorf("the commands restoring the state failed with exit code %d", rc)
+	}
+	return nil
+}
+
+// state returns the working directory and the environment variables of the shell
+// The variables are separated by NUL characters, so that values that span several lines are read correctly. The
+// output is not limited, since the whole environment is needed.
+func (shell *Shell) state(ctx context.Context) (string, map[string]string, error) {
+	output, _, rc, err := shell.execute(ctx, "pwd; env -0", 0)
+	if err != nil {
+		return "", nil, err
+	}
+	if rc != 0 || len(output) == 0 {
+		return "", nil, fmt.Errorf("reading the state failed with exit code %d", rc)
+	}
+	environment := make(map[string]string)
+	for _, variable := range strings.Split(strings.Join(output[1:], "\n"), "\x00") {
+		elements := strings.SplitN(variable, "=", 2)
+		if len(elements) == 2 {
+			environment[elements[0]] = elements[1]
+		}
+	}
+	return output[0], environment, nil
+}
@@ -1,3 +1,5 @@
+//go:build !js
+
 package shell
 
 // This file is part of shelldoc.
@@ -6,24 +8,110 @@ package shell
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Shell represents the shell process that runs in the background and executes the commands.
 type Shell struct {
+	args   []string
+	env    []string
 	cmd    *exec.Cmd
 	stdin  io.WriteCloser
 	stdout io.ReadCloser
+	// stderrFile receives the error output of the command that is executing
+	stderrFile string
+	// checkpoint is the state the shell is reset to, if it is reused
+	checkpoint *State
+	// maxOutput is the number of bytes of the output of a command that is kept, see LimitOutput
+	maxOutput int
+	// spillDirectory receives the whole output of commands that exceed maxOutput, if it is set
+	spillDirectory string
+	// failOnLimit terminates commands whose standard output exceeds maxOutput, see FailOnOutputLimit
+	failOnLimit bool
+	// keepCarriageReturns keeps the carriage returns at the end of the lines of output, see KeepCarriageReturns
+	keepCarriageReturns bool
+	// lastOutput describes the standard output of the last command, see LastOutput
+	lastOutput OutputInfo
+	// inputGrace is the time a command may wait for input before it receives the end of its input, see GuardInput
+	inputGrace time.Duration
+	// input is the standard input of the commands, nil if commands waiting for input are not detected
+	input *inputGuard
+}
+
+// NewShell creates a Backend that executes the commands in a local shell, launched using the program and arguments in
+// args
+// env contains additional environment variables in KEY=value form that are set for the shell.
+func NewShell(args []string, env ...string) *Shell {
+	return &Shell{args: args, env: env, maxOutput: DefaultMaxOutput, inputGrace: DefaultInputGrace}
+}
+
+// DefaultInputGrace is the time a command may be blocked reading its standard input before it is considered to be
+// waiting for input
+const DefaultInputGrace = 5 * time.Second
+
+// GuardInput sets the time a command may be blocked reading its standard input, which is empty, before it receives
+// the end of its input and Execute returns ErrWaitingForInput with its output, a grace of zero or less disables it
+// The shell stays usable after that. It has to be called before the shell is started. Commands waiting for input are
+// only detected on Linux, elsewhere they read from the input of the shell like before. Shells created by NewShell and
+// StartShellCommand use DefaultInputGrace.
+func (shell *Shell) GuardInput(grace time.Duration) {
+	shell.inputGrace = grace
+}
+
+// LimitOutput sets the number of bytes of the standard output and the error output of a command that are kept in
+// memory, DefaultMaxOutput if max is zero
+// The first and the last lines of a longer output are kept, with a line in between that tells how many lines were
+// omitted. If spillDirectory is not empty, the whole output of such commands is written to a file in it, which is
+// named in that line. Shells created by NewShell and StartShellCommand use DefaultMaxOutput.
+func (shell *Shell) LimitOutput(max int, spillDirectory string) {
+	if max <= 0 {
+		max = DefaultMaxOutput
+	}
+	shell.maxOutput, shell.spillDirectory = max, spillDirectory
+}
+
+// FailOnOutputLimit terminates the shell when the standard output of a command exceeds the limit set by LimitOutput,
+// instead of keeping its first and last lines, and Execute returns ErrOutputLimit with the output read so far
+// This stops commands that flood their output, like a runaway loop, early. The shell cannot be used afterwards.
+func (shell *Shell) FailOnOutputLimit(fail bool) {
+	shell.failOnLimit = fail
+}
+
+// KeepCarriageReturns keeps the carriage returns at the end of the lines of output, which programs writing Windows line
+// breaks leave there
+// They are removed by default, like the line breaks.
+func (shell *Shell) KeepCarriageReturns(keep bool) {
+	shell.keepCarriageReturns = keep
+}
+
+// Execute runs a command in the shell and returns its output, its error output and its exit code
+func (shell *Shell) Execute(ctx context.Context, command string) ([]string, []string, int, error) {
+	return shell.execute(ctx, command, shell.maxOutput)
+}
+
+// LastOutput describes the standard output of the last command, it implements OutputInspector
+func (shell *Shell) LastOutput() OutputInfo {
+	return shell.lastOutput
+}
+
+// Close tells the shell to exit and waits for it
+func (shell *Shell) Close() error {
+	return shell.Exit()
 }
 
 // DetectShell returns the path to the selected shell or the content of $SHELL
+// A shell given by its name, like bash, is looked up in the PATH. A NotFoundError is returned if the shell does not
+// exist.
 func DetectShell(selected string) (string, error) {
 	if len(selected) > 0 {
 		// accept what the user said
@@ -32,76 +120,467 @@ func DetectShell(selected string) (string, error) {
 		selected = os.Getenv("SHELL")
 		log.Printf("Using shell %s (according to $SHELL).", selected)
 	}
-	if _, err := os.Stat(selected); os.IsNotExist(err) {
-		return "", fmt.Errorf("the selected shell does not exist: %v", err)
+	return FindShell(selected)
+}
+
+// FindShell returns the path to a shell given by its path or its name, or a NotFoundError if it does not exist
+func FindShell(shell string) (string, error) {
+	if len(shell) == 0 {
+		return "", &NotFoundError{Installed: InstalledShells()}
+	}
+	if !strings.ContainsRune(shell, os.PathSeparator) && !strings.ContainsRune(shell, '/') {
+		if path, err := exec.LookPath(shell); err == nil {
+			return path, nil
+		}
+		return "", &NotFoundError{Shell: shell, Installed: InstalledShells()}
+	}
+	if _, err := os.Stat(shell); os.IsNotExist(err) {
+		return "", &NotFoundError{Shell: shell, Installed: InstalledShells()}
+	}
+	return shell, nil
+}
+
+// NotFoundError is returned if the shell that should execute the commands does not exist, like bash on a minimal
+// container image
+type NotFoundError struct {
+	// Shell is the path or the name of the shell, empty if no shell was selected and $SHELL is not set
+	Shell string
+	// Installed contains the paths of the shells that were found instead, see InstalledShells
+	Installed []string
+}
+
+func (err *NotFoundError) Error() string {
+	message := fmt.Sprintf("the shell \"%s\" does not exist", err.Shell)
+	if len(err.Shell) == 0 {
+		message = "no shell was selected and $SHELL is not set"
+	}
+	if len(err.Installed) == 0 {
+		return message + ", and no other shell was found in the PATH"
 	}
-	return selected, nil
+	return fmt.Sprintf("%s, installed shells are %s", message, strings.Join(err.Installed, ", "))
 }
 
+// knownShells are the names of the shells InstalledShells looks for, in the order they are suggested
+var knownShells = []string{"bash", "zsh", "ksh", "dash", "ash", "sh"}
+
+// InstalledShells returns the paths of the known POSIX shells that are found in the PATH
+func InstalledShells() []string {
+	var installed []string
+	for _, name := range knownShells {
+		if path, err := exec.LookPath(name); err == nil {
+			installed = append(installed, path)
+		}
+	}
+	return installed
+}
+
+// The commands that keep interactive shells, like bash -i, and shells launched through a wrapper that provides a
+// terminal, like script, from mixing their prompts and the echo of the commands into the output of the commands
+const (
+	// clearPrompts clears the prompts and the commands bash and zsh execute before showing them, it is repeated after
+	// every command, since commands like the activate script of a Python virtual environment change them
+	clearPrompts = "PS1=''; PS2=''; unset PROMPT_COMMAND precmd_functions 2>/dev/null; unset -f precmd 2>/dev/null"
+	// quietTerminal stops the terminal from echoing the commands, and readline and zsh from marking them as pasted
+	// bind is only used in interactive shells, since it initializes readline, which sets COLUMNS and LINES.
+	quietTerminal = "stty -echo 2>/dev/null; case $- in *i*) bind 'set enable-bracketed-paste off' 2>/dev/null;; esac; unset zle_bracketed_paste"
+	// statusVariable keeps the exit code of the command while the prompts are cleared
+	statusVariable = "__shelldoc_status"
+)
+
+// startTimeout is the time the shell may take to start and to execute the commands that keep it quiet, including its
+// startup files
+const startTimeout = time.Minute
+
 // StartShell starts a shell as a background process
-func StartShell(shell string) (Shell, error) {
-	cmd := exec.Command(shell)
+// env contains additional environment variables in KEY=value form that are set for the shell.
+func StartShell(shell string, env ...string) (Shell, error) {
+	return StartShellCommand([]string{shell}, env...)
+}
+
+// StartShellCommand starts a shell as a background process using the program and arguments in args
+// This allows to pass arguments to the shell, or to launch it through a wrapper like env or stdbuf.
+// env contains additional environment variables in KEY=value form that are set for the shell.
+func StartShellCommand(args []string, env ...string) (Shell, error) {
+	shell := NewShell(args, env...)
+	if err := shell.Start(); err != nil {
+		return Shell{}, err
+	}
+	return *shell, nil
+}
+
+// Start launches the shell as a background process
+func (shell *Shell) Start() error {
+	if len(shell.args) == 0 {
+		return fmt.Errorf("no shell command specified")
+	}
+	if shell.cmd != nil {
+		return fmt.Errorf("the shell has already been started")
+	}
+	command := strings.Join(shell.args, " ")
+	cmd := exec.Command(shell.args[0], shell.args[1:]...)
+	setProcessGroup(cmd)
+	if len(shell.env) > 0 {
+		cmd.Env = append(os.Environ(), shell.env...)
+	}
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to set up input stream for shell %s: %v", shell, err)
+		return fmt.Errorf("Unable to set up input stream for shell %s: %v", command, err)
 	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to set up output stream for shell %s: %v", shell, err)
+		return fmt.Errorf("Unable to set up output stream for shell %s: %v", command, err)
+	}
+	stderrFile, err := ioutil.TempFile("", "shelldoc-stderr")
+	if err != nil {
+		return fmt.Errorf("Unable to set up error output for shell %s: %v", command, err)
+	}
+	stderrFile.Close()
+	var input *inputGuard
+	if shell.inputGrace > 0 {
+		if input, err = newInputGuard(); err != nil {
+			log.Printf("Commands waiting for input are not detected: %v", err)
+		}
 	}
 	err = cmd.Start()
 	if err != nil {
-		return Shell{}, fmt.Errorf("Unable to start shell %s: %v", shell, err)
+		os.Remove(stderrFile.Name())
+		if input != nil {
+			input.close()
+		}
+		return fmt.Errorf("Unable to start shell %s: %v", command, err)
 	}
-	return Shell{cmd, stdin, stdout}, nil
+	shell.cmd, shell.stdin, shell.stdout, shell.stderrFile, shell.input = cmd, stdin, stdout, stderrFile.Name(), input
+	// the output of this line is discarded, since it is written before the marker of the first command
+	io.WriteString(stdin, fmt.Sprintf("%s; %s%s\n", quietTerminal, clearPrompts, unsetTerminalSize(cmd.Env)))
+	// wait until the shell executed it, a terminal echoes the commands written before
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	if _, _, _, err := shell.execute(ctx, ":", 0); err != nil {
+		shell.Exit()
+		return fmt.Errorf("Unable to start shell %s: %v", command, err)
+	}
+	return nil
+}
+
+// unsetTerminalSize returns the command that unsets COLUMNS and LINES if they are not in the environment the shell was
+// started with, or an empty string
+// Interactive shells set them when readline starts, to 80 and 24 columns and lines if there is no terminal, which
+// would change the output of commands that adapt to the width of the terminal. If env is nil, the shell inherited the
+// environment of the process.
+func unsetTerminalSize(env []string) string {
+	if env == nil {
+		env = os.Environ()
+	}
+	var names []string
+	for _, name := range []string{"COLUMNS", "LINES"} {
+		inherited := false
+		for _, variable := range env {
+			inherited = inherited || strings.HasPrefix(variable, name+"=")
+		}
+		if !inherited {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "; unset " + strings.Join(names, " ")
+}
+
+// SplitCommandLine splits a command line into the program and its arguments
+// Arguments are separated by whitespace. Single and double quotes group words into one argument, and a backslash
+// escapes the following character outside of single quotes. Variables and other shell syntax are not expanded.
+func SplitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArgument := false
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			current.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inArgument = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inArgument = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArgument {
+				args = append(args, current.String())
+				current.Reset()
+				inArgument = false
+			}
+		default:
+			current.WriteRune(char)
+			inArgument = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in \"%s\"", line)
+	}
+	if inArgument {
+		args = append(args, current.String())
+	}
+	return args, nil
 }
 
 // ExecuteCommand runs a command in the shell and returns its output and exit code
 func (shell *Shell) ExecuteCommand(command string) ([]string, int, error) {
+	return shell.ExecuteCommandContext(context.Background(), command)
+}
+
+// ExecuteCommandWithTimeout runs a command in the shell and returns its output and exit code
+// If the command does not finish within the timeout, the shell and the command are terminated and ErrTimeout is
+// returned. The shell cannot be used after that. A timeout of zero waits for the command indefinitely.
+func (shell *Shell) ExecuteCommandWithTimeout(command string, timeout time.Duration) ([]string, int, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return shell.ExecuteCommandContext(ctx, command)
+}
+
+// ExecuteCommandContext runs a command in the shell and returns its output and exit code
+// If the context is done before the command finishes, the shell and the command are terminated. ErrTimeout is
+// returned if the deadline of the context expired, the error of the context otherwise. The shell cannot be used
+// after that.
+func (shell *Shell) ExecuteCommandContext(ctx context.Context, command string) ([]string, int, error) {
+	output, _, rc, err := shell.execute(ctx, command, shell.maxOutput)
+	return output, rc, err
+}
+
+// execute runs a command in the shell like ExecuteCommandContext, and also returns its error output
+// The error output of the command is redirected to a file, and read after the command finished. At most limit bytes
+// of each are kept, all of it if limit is zero.
+func (shell *Shell) execute(ctx context.Context, command string, limit int) ([]string, []string, int, error) {
 	const (
 		beginMarker = ">>>>>>>>>>SHELLDOC_MARKER>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>"
 		endMarker   = "<<<<<<<<<<SHELLDOC_MARKER"
 	)
-	instruction := fmt.Sprintf("%s\n", strings.TrimSpace(command))
+	if err := ctx.Err(); err != nil {
+		return nil, nil, -1, contextError(err)
+	}
+	var redirections []string
+	if shell.input != nil {
+		if err := shell.input.prepare(); err != nil {
+			return nil, nil, -1, err
+		}
+		redirections = append(redirections, "<"+quote(shell.input.path))
+	}
+	if len(shell.stderrFile) > 0 {
+		redirections = append(redirections, "2>"+quote(shell.stderrFile))
+	}
+	// the newline before the closing brace ends commands that end in a comment or with &, the prompts are cleared on
+	// the same line, before the shell shows the prompt for the end marker
+	instruction := fmt.Sprintf("{ %s\n} %s; %s=$?; %s\n", strings.TrimSpace(command), strings.Join(redirections, " "), statusVariable, clearPrompts)
 	io.WriteString(shell.stdin, fmt.Sprintf("echo \"%s\"\n", beginMarker))
 	io.WriteString(shell.stdin, instruction)
-	io.WriteString(shell.stdin, fmt.Sprintf("echo \"%s $?\"\n", endMarker))
+	// the line break before the end marker ends the last line of output if the command did not, readOutput removes it
+	// The exit code is $? if the line of the command was not executed, like after a syntax error in an interactive
+	// shell.
+	io.WriteString(shell.stdin, fmt.Sprintf("printf '\\n%%s %%d\\n' \"%s\" \"${%s:-$?}\"; unset %s\n", endMarker, statusVariable, statusVariable))
 
-	// read output (TODO: with timeout), watch for markers:
+	shell.lastOutput = OutputInfo{FinalNewline: true}
+	if ctx.Done() == nil && shell.input == nil {
+		// the context can never be cancelled
+		output, info, rc, err := shell.readOutput(beginMarker, endMarker, limit)
+		shell.lastOutput = info
+		if err != nil {
+			return output, nil, rc, err
+		}
+		return output, shell.readErrorOutput(limit), rc, nil
+	}
+	type result struct {
+		output []string
+		info   OutputInfo
+		rc     int
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, info, rc, err := shell.readOutput(beginMarker, endMarker, limit)
+		done <- result{output, info, rc, err}
+	}()
+	// check if the command is blocked reading its input, it has to be for the whole grace period
+	var poll <-chan time.Time
+	if shell.input != nil {
+		interval := shell.inputGrace / 5
+		if interval < time.Millisecond {
+			interval = time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	var waitingSince time.Time
+	waitingForInput := false
+	for {
+		select {
+		case r := <-done:
+			shell.lastOutput = r.info
+			if r.err != nil {
+				return r.output, nil, r.rc, r.err
+			}
+			if waitingForInput {
+				return r.output, shell.readErrorOutput(limit), r.rc, ErrWaitingForInput
+			}
+			return r.output, shell.readErrorOutput(limit), r.rc, nil
+		case <-ctx.Done():
+			if err := terminate(shell.cmd); err != nil {
+				log.Printf("unable to terminate the shell: %v", err)
+			}
+			return nil, nil, -1, contextError(ctx.Err())
+		case now := <-poll:
+			if !shell.input.waiting(shell.cmd.Process.Pid) {
+				waitingSince = time.Time{}
+			} else if waitingSince.IsZero() {
+				waitingSince = now
+			} else if now.Sub(waitingSince) >= shell.inputGrace {
+				// the end of the input lets the command finish, the shell stays usable
+				shell.input.release()
+				waitingForInput = true
+				poll = nil
+			}
+		}
+	}
+}
+
+// quote quotes text as a single word for a POSIX shell
+func quote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+// readErrorOutput returns the lines of error output of the last command, keeping at most limit bytes of them
+func (shell *Shell) readErrorOutput(limit int) []string {
+	if len(shell.stderrFile) == 0 {
+		return nil
+	}
+	file, err := os.Open(shell.stderrFile)
+	if err != nil {
+		log.Printf("unable to read the error output of the command: %v", err)
+		return nil
+	}
+	defer file.Close()
+	stderr := newSpool(limit, shell.spillDirectory)
+	reader := bufio.NewReader(file)
+	for {
+		line, dropped, _, err := readLine(reader, stderr.lineLimit(), shell.keepCarriageReturns)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("unable to read the error output of the command: %v", err)
+			break
+		}
+		stderr.add(line, dropped)
+	}
+	return stderr.close()
+}
+
+// contextError returns ErrTimeout if the deadline of a context expired, and the error of the context otherwise
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
+// readOutput reads the output of a command, watching for the markers, and keeps at most limit bytes of it
+// The output is streamed through a spool, so that commands with a lot of output do not exhaust the memory. The line
+// before the end marker is held back: it is the empty line that execute adds if the output ended with a line break,
+// and the unterminated last line of the output otherwise.
+func (shell *Shell) readOutput(beginMarker, endMarker string, limit int) ([]string, OutputInfo, int, error) {
 	beginEx := fmt.Sprintf("^%s$", beginMarker)
 	beginRx := regexp.MustCompile(beginEx)
 	endEx := fmt.Sprintf("^%s (.+)$", endMarker)
 	endRx := regexp.MustCompile(endEx)
 
-	var output []string
-	var rc int
+	output := newSpool(limit, shell.spillDirectory)
+	info := OutputInfo{FinalNewline: true}
 	beginFound := false
-	scanner := bufio.NewScanner(shell.stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if beginRx.MatchString(line) {
+	var pending *pendingLine
+	reader := bufio.NewReader(shell.stdout)
+	for {
+		line, dropped, size, err := readLine(reader, output.lineLimit(), shell.keepCarriageReturns)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return output.close(), info, -1, fmt.Errorf("unable to read the output of the shell: %v", err)
+		}
+		// a terminal overwrites the text before a carriage return, like the control sequences readline writes before
+		// the output of a command
+		marker := strings.TrimSuffix(line, "\r")
+		marker = marker[strings.LastIndex(marker, "\r")+1:]
+		if beginRx.MatchString(marker) {
 			beginFound = true
 			continue
 		}
 		if beginFound == false {
 			continue
 		}
-		match := endRx.FindStringSubmatch(line)
+		match := endRx.FindStringSubmatch(marker)
 		if len(match) > 1 {
 			value, err := strconv.Atoi(match[1])
 			if err != nil {
-				return nil, -1, fmt.Errorf("unable to read exit code for shell command: %v", err)
+				output.close()
+				return nil, info, -1, fmt.Errorf("unable to read exit code for shell command: %v", err)
 			}
-			rc = value
-			break
+			if pending != nil && (len(pending.text) > 0 || pending.dropped > 0) {
+				// the line break that ends this line was added by execute
+				output.add(pending.text, pending.dropped)
+				info.Bytes += int64(pending.size - 1)
+				info.FinalNewline = false
+			}
+			return output.close(), info, value, nil
+		}
+		if pending != nil {
+			output.add(pending.text, pending.dropped)
+			info.Bytes += int64(pending.size)
+		}
+		pending = &pendingLine{text: line, dropped: dropped, size: size}
+		if shell.failOnLimit && limit > 0 && info.Bytes+int64(size) > int64(limit) {
+			if err := terminate(shell.cmd); err != nil {
+				log.Printf("unable to terminate the shell: %v", err)
+			}
+			output.add(pending.text, pending.dropped)
+			info.Bytes += int64(pending.size)
+			return output.close(), info, -1, ErrOutputLimit
 		}
-		output = append(output, line)
 	}
-	return output, rc, nil
+	if pending != nil {
+		output.add(pending.text, pending.dropped)
+		info.Bytes += int64(pending.size)
+	}
+	return output.close(), info, -1, ErrShellCrashed
+}
+
+// pendingLine is a line of output that readOutput holds back until it knows whether it is the last one
+type pendingLine struct {
+	text    string
+	dropped int
+	size    int
 }
 
 // Exit tells a running shell to exit and waits for it
 func (shell *Shell) Exit() error {
+	if len(shell.stderrFile) > 0 {
+		defer os.Remove(shell.stderrFile)
+	}
+	if shell.input != nil {
+		defer shell.input.close()
+	}
 	io.WriteString(shell.stdin, "exit\n")
 	return shell.cmd.Wait()
 }
@@ -1,3 +1,5 @@
+//go:build !js
+
 package shell
 
 // This file is part of shelldoc.
@@ -5,9 +7,16 @@ package shell
 // SPDX-License-Identifier: Apache-2.0
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -71,3 +80,334 @@ func TestCaptureOutput(t *testing.T) {
 		require.Equal(t, output[1], world, "actually, two")
 	}
 }
+
+func TestFinalNewline(t *testing.T) {
+	shell := NewShell([]string{shellpath})
+	require.NoError(t, shell.Start(), "Starting the shell backend should work")
+	defer shell.Close()
+	for _, test := range []struct {
+		command string
+		output  []string
+		info    OutputInfo
+	}{
+		{"printf 'Hello\\n'", []string{"Hello"}, OutputInfo{Bytes: 6, FinalNewline: true}},
+		{"printf Hello", []string{"Hello"}, OutputInfo{Bytes: 5}},
+		{"printf 'Hello\\nWorld'", []string{"Hello", "World"}, OutputInfo{Bytes: 11}},
+		{"printf 'Hello\\n\\n'", []string{"Hello", ""}, OutputInfo{Bytes: 7, FinalNewline: true}},
+		{"true", nil, OutputInfo{FinalNewline: true}},
+	} {
+		stdout, _, rc, err := shell.Execute(context.Background(), test.command)
+		require.NoError(t, err, "The command should execute")
+		require.Equal(t, 0, rc, "The command succeeds")
+		require.Equal(t, test.output, stdout, "The lines of the output are returned")
+		require.Equal(t, test.info, shell.LastOutput(), "The raw output is described")
+	}
+}
+
+func TestGuardInput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("commands waiting for input are only detected on Linux")
+	}
+	shell := NewShell([]string{shellpath})
+	shell.GuardInput(200 * time.Millisecond)
+	require.NoError(t, shell.Start(), "Starting the shell backend should work")
+	defer shell.Close()
+	stdout, _, _, err := shell.Execute(context.Background(), "echo Hello; read answer; echo \"[$answer]\"")
+	require.Equal(t, ErrWaitingForInput, err, "The command waiting for input is detected")
+	require.Equal(t, []string{"Hello", "[]"}, stdout, "The command receives the end of its input and finishes")
+	stdout, _, _, err = shell.Execute(context.Background(), "echo World | cat; sleep 0.5")
+	require.NoError(t, err, "Commands that do not read their input are not affected")
+	require.Equal(t, []string{"World"}, stdout, "The shell is still usable after a command waited for input")
+}
+
+func TestEnvironment(t *testing.T) {
+	// Are additional environment variables passed on to the shell?
+	shell, err := StartShell(shellpath, "SHELLDOC_GREETING=Hello")
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	output, rc, err := shell.ExecuteCommand("echo $SHELLDOC_GREETING")
+	require.NoError(t, err, "The echo command is a builtin and should always work")
+	require.Equal(t, 0, rc, "The exit code of echo should be zero")
+	require.Equal(t, []string{"Hello"}, output, "The variable was set in the environment of the shell")
+}
+
+func TestTimeout(t *testing.T) {
+	// Are commands that take too long terminated?
+	shell, err := StartShell(shellpath)
+	require.NoError(t, err, "Starting a shell should work")
+	defer shell.Exit()
+	output, _, err := shell.ExecuteCommandWithTimeout("echo Hello", time.Second)
+	require.NoError(t, err, "Commands that finish in time are not affected")
+	require.Equal(t, []string{"Hello"}, output, "The output is captured as usual")
+	start := time.Now()
+	_, _, err = shell.ExecuteCommandWithTimeout("sleep 10", 100*time.Millisecond)
+	require.Equal(t, ErrTimeout, err, "The command did not finish within the timeout")
+	require.True(t, time.Since(start) < 5*time.Second, "The command was terminated")
+}
+
+func TestPrompts(t *testing.T) {
+	// Are the prompts of an interactive shell kept out of the output?
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	shell, err := StartShellCommand([]string{bash, "--norc", "--noprofile", "+m", "-i"}, "PS1=prompt> ", "PROMPT_COMMAND=echo prompted")
+	require.NoError(t, err, "Starting an interactive shell should work")
+	defer shell.Exit()
+	output, rc, err := shell.ExecuteCommand("printf 'a\\nb'")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command should succeed")
+	require.Equal(t, []string{"a", "b"}, output, "The prompt and the prompt command are not part of the output")
+	output, rc, err = shell.ExecuteCommand("if true; then\n  echo multi\nfi")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{"multi"}, output, "The continuation prompts are not part of the output")
+	_, rc, err = shell.ExecuteCommand("PROMPT_COMMAND='echo again'; PS1='venv> '; (exit 3)")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 3, rc, "The exit code is kept while the prompts are cleared")
+	output, _, err = shell.ExecuteCommand("echo next")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{"next"}, output, "Prompts set by a command are cleared")
+	_, rc, err = shell.ExecuteCommand("fi")
+	require.NoError(t, err, "An interactive shell survives a syntax error")
+	require.Equal(t, 2, rc, "The exit code of the syntax error is reported")
+	output, _, err = shell.ExecuteCommand("echo ${COLUMNS-unset} ${LINES-unset}")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{inheritedTerminalSize()}, output, "Readline does not set the terminal size")
+}
+
+// inheritedTerminalSize returns the values of COLUMNS and LINES a shell inherits, like echo ${COLUMNS-unset} ${LINES-unset}
+func inheritedTerminalSize() string {
+	var values []string
+	for _, name := range []string{"COLUMNS", "LINES"} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			value = "unset"
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, " ")
+}
+
+func TestTerminalSize(t *testing.T) {
+	// Is the terminal size of a non-interactive shell left as it is?
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	shell, err := StartShell(bash)
+	require.NoError(t, err, "Starting the shell should work")
+	defer shell.Exit()
+	output, _, err := shell.ExecuteCommand("echo ${COLUMNS-unset} ${LINES-unset}")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{inheritedTerminalSize()}, output, "Readline does not set the terminal size")
+	sized, err := StartShellCommand([]string{bash, "--norc", "--noprofile", "-i"}, "COLUMNS=120", "LINES=40")
+	require.NoError(t, err, "Starting an interactive shell should work")
+	defer sized.Exit()
+	output, _, err = sized.ExecuteCommand("echo $COLUMNS $LINES")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{"120 40"}, output, "The terminal size in the environment is kept")
+}
+
+func TestTerminal(t *testing.T) {
+	// Is the echo of a terminal kept out of the output?
+	script, err := exec.LookPath("script")
+	if err != nil || runtime.GOOS != "linux" {
+		t.Skip("script from util-linux is not installed")
+	}
+	shell, err := StartShellCommand([]string{script, "-qfc", shellpath, "/dev/null"})
+	require.NoError(t, err, "Starting a shell in a terminal should work")
+	defer shell.Exit()
+	tests := []struct {
+		command string
+		output  []string
+	}{
+		{"echo Hello", []string{"Hello"}},
+		{"printf 'a\\nb\\n'", []string{"a", "b"}},
+		{"for word in x y; do\n  echo $word\ndone", []string{"x", "y"}},
+	}
+	for _, test := range tests {
+		output, rc, err := shell.ExecuteCommand(test.command)
+		require.NoError(t, err, "The command should execute")
+		require.Equal(t, 0, rc, "The command should succeed")
+		require.Equal(t, test.output, output, "The commands are not echoed and no control sequences are written")
+	}
+}
+
+func TestFindShell(t *testing.T) {
+	path, err := FindShell("sh")
+	require.NoError(t, err, "Shells are looked up in the PATH")
+	require.True(t, filepath.IsAbs(path), "The path of the shell is returned")
+	_, err = FindShell("/nonexistent/bash")
+	notFound, ok := err.(*NotFoundError)
+	require.True(t, ok, "A missing shell is reported as a NotFoundError")
+	require.Equal(t, "/nonexistent/bash", notFound.Shell, "The missing shell is named")
+	require.Contains(t, notFound.Installed, path, "The installed shells are suggested")
+	require.Contains(t, notFound.Error(), "installed shells are", "The installed shells are part of the message")
+	_, err = FindShell("nonexistent-shell")
+	require.IsType(t, &NotFoundError{}, err, "Shells that are not in the PATH are missing")
+	require.Contains(t, (&NotFoundError{}).Error(), "$SHELL is not set", "A missing $SHELL is explained")
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	args, err := SplitCommandLine(`env -i  "PATH=/usr/bin:/bin" /bin/sh 'a b' c\ d`)
+	require.NoError(t, err, "Splitting a valid command line should work")
+	require.Equal(t, []string{"env", "-i", "PATH=/usr/bin:/bin", "/bin/sh", "a b", "c d"}, args, "Quotes and escapes group words into arguments")
+	args, err = SplitCommandLine(`sh -c ""`)
+	require.NoError(t, err, "Splitting a valid command line should work")
+	require.Equal(t, []string{"sh", "-c", ""}, args, "Empty quotes are an empty argument")
+	_, err = SplitCommandLine(`sh "unterminated`)
+	require.Error(t, err, "Unterminated quotes are an error")
+}
+
+func TestStartShellCommand(t *testing.T) {
+	shell, err := StartShellCommand([]string{"env", "GREETING=Hello", shellpath})
+	require.NoError(t, err, "Starting a shell through a wrapper should work")
+	output, rc, err := shell.ExecuteCommand("echo $GREETING")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command should succeed")
+	require.Equal(t, []string{"Hello"}, output, "The wrapper sets up the environment of the shell")
+	require.NoError(t, shell.Exit(), "Exiting the shell should work")
+	_, err = StartShellCommand(nil)
+	require.Error(t, err, "A shell command is required")
+}
+
+func TestExecuteCommandContext(t *testing.T) {
+	shell, err := StartShell(shellpath)
+	require.NoError(t, err, "Starting a shell should work")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, _, err = shell.ExecuteCommandContext(ctx, "sleep 10")
+	require.Equal(t, context.Canceled, err, "Cancelling the context terminates the command")
+	require.True(t, time.Since(start) < 5*time.Second, "The command does not run to completion")
+	shell.Exit()
+
+	shell, err = StartShell(shellpath)
+	require.NoError(t, err, "Starting a shell should work")
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err = shell.ExecuteCommandContext(ctx, "sleep 10")
+	require.Equal(t, ErrTimeout, err, "An expired deadline is reported as a timeout")
+	shell.Exit()
+}
+
+func TestBackend(t *testing.T) {
+	var backend Backend = NewShell([]string{shellpath}, "SHELLDOC_GREETING=Hello")
+	require.NoError(t, backend.Start(), "Starting the shell backend should work")
+	require.Error(t, backend.Start(), "The shell backend can only be started once")
+	stdout, stderr, rc, err := backend.Execute(context.Background(), "echo $SHELLDOC_GREETING")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command succeeds")
+	require.Equal(t, []string{"Hello"}, stdout, "The output of the command is returned")
+	require.Empty(t, stderr, "The command writes no error output")
+	stdout, stderr, rc, err = backend.Execute(context.Background(), "echo out; echo err >&2; (exit 2)")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 2, rc, "The exit code is returned with the error output")
+	require.Equal(t, []string{"out"}, stdout, "The output is returned separately")
+	require.Equal(t, []string{"err"}, stderr, "The error output of the command is returned")
+	stdout, _, _, err = backend.Execute(context.Background(), "export SHELLDOC_GREETING=Hi # a comment")
+	require.NoError(t, err, "Commands ending in a comment should execute")
+	stdout, _, _, err = backend.Execute(context.Background(), "echo $SHELLDOC_GREETING")
+	require.Equal(t, []string{"Hi"}, stdout, "Commands are executed in the shell, not in a subshell")
+	require.NoError(t, backend.Close(), "Closing the shell backend should work")
+	require.Error(t, NewShell(nil).Start(), "A shell without a command cannot be started")
+}
+
+func TestShellCrashed(t *testing.T) {
+	shell, err := StartShell(shellpath)
+	require.NoError(t, err, "Starting a shell should work")
+	_, _, err = shell.ExecuteCommand("exit 3")
+	require.Equal(t, ErrShellCrashed, err, "A shell that exits during a command is reported")
+	shell.Exit()
+}
+
+func TestReset(t *testing.T) {
+	shell := NewShell([]string{shellpath}, "SHELLDOC_KEPT=kept")
+	require.NoError(t, shell.Start(), "Starting a shell should work")
+	defer shell.Close()
+	require.NoError(t, shell.Checkpoint(context.Background()), "Recording the state should work")
+	directory, _, _ := shell.ExecuteCommand("pwd")
+	_, rc, err := shell.ExecuteCommand("cd / && export SHELLDOC_ADDED=added SHELLDOC_KEPT='changed\nvalue'")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command succeeds")
+	require.NoError(t, shell.Reset(context.Background()), "Resetting the shell should work")
+	output, _, err := shell.ExecuteCommand("pwd; echo \"${SHELLDOC_ADDED:-unset} $SHELLDOC_KEPT\"")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{directory[0], "unset kept"}, output, "The working directory and the environment are restored")
+	require.Error(t, NewShell([]string{shellpath}).Reset(context.Background()), "A shell without a checkpoint cannot be reset")
+}
+
+func TestRestoreState(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-state")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	first := NewShell([]string{shellpath})
+	require.NoError(t, first.Start(), "Starting a shell should work")
+	defer first.Close()
+	_, rc, err := first.ExecuteCommand(fmt.Sprintf("cd %s && export SHELLDOC_WORKSPACE=%s", directory, directory))
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command succeeds")
+	state, err := first.SaveState(context.Background())
+	require.NoError(t, err, "Saving the state should work")
+	second := NewShell([]string{shellpath})
+	require.NoError(t, second.Start(), "Starting a shell should work")
+	defer second.Close()
+	require.NoError(t, second.RestoreState(context.Background(), state), "Restoring the state in another shell should work")
+	output, _, err := second.ExecuteCommand("pwd; echo $SHELLDOC_WORKSPACE")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{state.Directory, state.Directory}, output, "The working directory and the environment are restored")
+	state.Directory = filepath.Join(directory, "removed")
+	require.Error(t, second.RestoreState(context.Background(), state), "A directory that does not exist anymore cannot be restored")
+}
+
+func TestLimitOutput(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shelldoc-spill")
+	require.NoError(t, err, "Creating a temporary directory should work")
+	defer os.RemoveAll(directory)
+	shell := NewShell([]string{shellpath})
+	shell.LimitOutput(1024, "")
+	require.NoError(t, shell.Start(), "Starting a shell should work")
+	defer shell.Close()
+	stdout, stderr, rc, err := shell.Execute(context.Background(), "seq 100000; seq 100000 >&2")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, 0, rc, "The command succeeds")
+	for _, output := range [][]string{stdout, stderr} {
+		require.Less(t, len(output), 400, "Only the first and last lines are kept")
+		require.Equal(t, "1", output[0], "The first lines are kept")
+		require.Equal(t, "100000", output[len(output)-1], "The last lines are kept")
+		require.Contains(t, strings.Join(output, "\n"), "lines omitted", "The omitted lines are reported")
+	}
+	stdout, _, _, err = shell.Execute(context.Background(), "head -c 100000 /dev/zero | tr '\\0' x; echo; echo done")
+	require.NoError(t, err, "The command should execute")
+	require.Len(t, stdout, 3, "The long line is kept, with a note")
+	require.Len(t, stdout[0], 512, "Long lines are truncated")
+	require.Equal(t, "done", stdout[2], "The output continues after the long line")
+	shell.LimitOutput(1024, directory)
+	stdout, _, _, err = shell.Execute(context.Background(), "seq 100000")
+	require.NoError(t, err, "The command should execute")
+	spilled, err := ioutil.ReadDir(directory)
+	require.NoError(t, err, "The spill directory should be readable")
+	require.Len(t, spilled, 1, "The whole output is spilled to a file")
+	require.Contains(t, strings.Join(stdout, "\n"), spilled[0].Name(), "The spill file is named in the output")
+	data, err := ioutil.ReadFile(filepath.Join(directory, spilled[0].Name()))
+	require.NoError(t, err, "The spill file should be readable")
+	require.Equal(t, 100000, strings.Count(string(data), "\n"), "The spill file contains the whole output")
+	stdout, _, _, err = shell.Execute(context.Background(), "echo short")
+	require.NoError(t, err, "The command should execute")
+	require.Equal(t, []string{"short"}, stdout, "Short output is kept completely")
+}
+
+func TestFailOnOutputLimit(t *testing.T) {
+	shell := NewShell([]string{shellpath})
+	shell.LimitOutput(1024, "")
+	shell.FailOnOutputLimit(true)
+	require.NoError(t, shell.Start(), "Starting a shell should work")
+	defer shell.Close()
+	stdout, _, _, err := shell.Execute(context.Background(), "yes")
+	require.Equal(t, ErrOutputLimit, err, "A command flooding its output is terminated")
+	require.NotEmpty(t, stdout, "The output read so far is returned")
+	require.Equal(t, "y", stdout[0], "The output read so far is returned")
+}
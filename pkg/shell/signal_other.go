@@ -0,0 +1,12 @@
+//go:build windows || js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+// SignalName returns an empty string, commands are not terminated by signals on this platform
+func SignalName(rc int) string {
+	return ""
+}
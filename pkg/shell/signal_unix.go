@@ -0,0 +1,24 @@
+//go:build !windows && !js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SignalName returns the name of the signal that terminated a command, like SIGSEGV, if its exit code indicates one
+// POSIX shells report the exit code of a command that was terminated by a signal as 128 plus the number of the
+// signal. Commands can exit with such codes themselves, so this is a guess. An empty string is returned for other exit
+// codes.
+func SignalName(rc int) string {
+	if rc <= 128 || rc > 128+64 {
+		return ""
+	}
+	return unix.SignalName(syscall.Signal(rc - 128))
+}
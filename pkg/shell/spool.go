@@ -0,0 +1,160 @@
+//go:build !js
+
+package shell
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// DefaultMaxOutput is the number of bytes of the output of a command that is kept in memory, for the standard output
+// and the error output each
+const DefaultMaxOutput = 1 << 20
+
+// minOutputLimit is the smallest limit for the output, so that the markers around the output are always read
+const minOutputLimit = 1 << 10
+
+// spool collects the lines of output of a command, keeping at most limit bytes of them in memory
+// The first half of the limit is filled with the first lines of the output, the rest with the last lines, the lines
+// in between are dropped. Lines longer than half of the limit are truncated. If a directory is set, the whole output
+// is spilled to a file in it once the limit is exceeded, with the long lines truncated as well. A limit of zero keeps
+// all of the output.
+type spool struct {
+	limit     int
+	directory string
+	head      []string
+	headSize  int
+	// tail is used as a ring, the oldest lines are dropped from its front
+	tail      []string
+	tailSize  int
+	omitted   int
+	truncated int
+	file      *os.File
+	err       error
+}
+
+// newSpool creates a spool for the output of a command
+func newSpool(limit int, directory string) *spool {
+	if limit > 0 && limit < minOutputLimit {
+		limit = minOutputLimit
+	}
+	return &spool{limit: limit, directory: directory}
+}
+
+// lineLimit returns the number of bytes of a line that are kept, zero if lines are not truncated
+func (spool *spool) lineLimit() int {
+	return spool.limit / 2
+}
+
+// add appends a line of output, dropped is the number of bytes the reader already truncated from it
+func (spool *spool) add(line string, dropped int) {
+	if spool.limit <= 0 {
+		spool.head = append(spool.head, line)
+		return
+	}
+	if spool.file == nil && len(spool.directory) > 0 && (dropped > 0 || spool.headSize+spool.tailSize+len(line) > spool.limit) {
+		spool.spill()
+	}
+	if spool.file != nil && spool.err == nil {
+		_, spool.err = fmt.Fprintln(spool.file, line)
+	}
+	if len(line) > spool.lineLimit() {
+		line = line[:spool.lineLimit()]
+		dropped++
+	}
+	if dropped > 0 {
+		spool.truncated++
+	}
+	if len(spool.tail) == 0 && spool.headSize+len(line) <= spool.lineLimit() {
+		spool.head = append(spool.head, line)
+		spool.headSize += len(line)
+		return
+	}
+	spool.tail = append(spool.tail, line)
+	spool.tailSize += len(line)
+	for spool.headSize+spool.tailSize > spool.limit && len(spool.tail) > 1 {
+		spool.tailSize -= len(spool.tail[0])
+		spool.tail = spool.tail[1:]
+		spool.omitted++
+	}
+}
+
+// spill creates the file the whole output is written to, starting with the lines collected so far
+func (spool *spool) spill() {
+	file, err := ioutil.TempFile(spool.directory, "shelldoc-output")
+	if err != nil {
+		spool.err = fmt.Errorf("unable to spill the output to a file: %v", err)
+		return
+	}
+	spool.file = file
+	for _, line := range append(spool.head, spool.tail...) {
+		if spool.err == nil {
+			_, spool.err = fmt.Fprintln(file, line)
+		}
+	}
+}
+
+// close closes the spill file and returns the lines kept in memory
+// If lines were dropped or truncated, a line describing that is inserted after the first lines, with the name of the
+// spill file if there is one.
+func (spool *spool) close() []string {
+	if spool.file != nil {
+		if err := spool.file.Close(); err != nil && spool.err == nil {
+			spool.err = err
+		}
+	}
+	if spool.omitted == 0 && spool.truncated == 0 {
+		return append(spool.head, spool.tail...)
+	}
+	note := fmt.Sprintf("[shelldoc: %d lines omitted and %d lines truncated, %d bytes of output are kept", spool.omitted, spool.truncated, spool.limit)
+	switch {
+	case spool.err != nil:
+		note += fmt.Sprintf(", %v]", spool.err)
+	case spool.file != nil:
+		note += fmt.Sprintf(", the full output is in %s]", spool.file.Name())
+	default:
+		note += "]"
+	}
+	lines := append([]string{}, spool.head...)
+	lines = append(lines, note)
+	return append(lines, spool.tail...)
+}
+
+// readLine reads a line without the line break, keeping at most limit bytes of it if limit is not zero
+// The number of bytes that were dropped and the number of bytes that were read, including the line break, are
+// returned with the line. A last line that is not terminated by a line break is returned without an error, io.EOF is
+// returned after it. A carriage return before the line break is removed, unless keepCR is set.
+func readLine(reader *bufio.Reader, limit int, keepCR bool) (string, int, int, error) {
+	var line []byte
+	dropped, size := 0, 0
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		size += len(fragment)
+		if err == nil {
+			fragment = fragment[:len(fragment)-1]
+		}
+		if keep := limit - len(line); limit > 0 && len(fragment) > keep {
+			dropped += len(fragment) - keep
+			fragment = fragment[:keep]
+		}
+		line = append(line, fragment...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || (len(line) == 0 && dropped == 0)) {
+			return "", 0, 0, err
+		}
+		break
+	}
+	if !keepCR && dropped == 0 && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), dropped, size, nil
+}
@@ -0,0 +1,176 @@
+// Package shelldoctest verifies documentation as part of go test.
+//
+// Every document becomes a subtest, and every interaction in it a nested subtest, so that the usual go test flags
+// like -run and -v apply:
+//
+//	func TestDocumentation(t *testing.T) {
+//		shelldoctest.Run(t, "README.md", "docs/...")
+//	}
+package shelldoctest
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/endocode/shelldoc/pkg/runner"
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+)
+
+// documentExtensions are the file extensions of the documents found by recursive patterns
+var documentExtensions = []string{".md", ".markdown"}
+
+// Run executes the documents matching the patterns as subtests of t, using the default options
+func Run(t *testing.T, patterns ...string) {
+	t.Helper()
+	RunWithOptions(t, runner.Options{}, patterns...)
+}
+
+// RunWithOptions executes the documents matching the patterns as subtests of t
+// A pattern is a file name, a glob pattern, or a directory followed by /... to select all Markdown documents in it
+// and its subdirectories. Each document is executed in its own shell. The Output and FailFast options are ignored,
+// failures are reported through t.
+func RunWithOptions(t *testing.T, options runner.Options, patterns ...string) {
+	t.Helper()
+	files, err := Documents(patterns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no documents match %s", strings.Join(patterns, ", "))
+	}
+	options.Output = nil
+	options.FailFast = ""
+	r := runner.New(options)
+	for _, file := range files {
+		file := file
+		if runner.IsExcluded(file, options.Excludes) {
+			continue
+		}
+		t.Run(file, func(t *testing.T) {
+			runDocument(t, r, options, file)
+		})
+	}
+}
+
+// runDocument executes the interactions of a document in order, each as a subtest
+func runDocument(t *testing.T, r *runner.Runner, options runner.Options, file string) {
+	interactions, err := r.Discover(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell, err := r.StartShell()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shell.Close()
+	terminated := false
+	for _, interaction := range interactions {
+		interaction := interaction
+		t.Run(fmt.Sprintf("line %d: %s", interaction.Line, interaction.Name()), func(t *testing.T) {
+			if terminated {
+				t.Skip("the shell was terminated after a timeout")
+			}
+			interaction.Timeout = options.Timeout
+			if err := interaction.Execute(shell); err != nil {
+				t.Fatalf("%s:%d: unable to execute \"%s\": %v", file, interaction.Line, interaction.Cmd, err)
+			}
+			switch {
+			case interaction.ResultCode == tokenizer.ResultSkipped:
+				if options.NoSkips {
+					t.Fatalf("%s:%d: %s, skipping interactions is not allowed", file, interaction.Line, interaction.Result())
+				}
+				t.Skip(interaction.Result())
+			case interaction.ResultCode == tokenizer.ResultTimeout:
+				terminated = true
+				t.Fatalf("%s:%d: \"%s\" did not finish within %v", file, interaction.Line, interaction.Cmd, interaction.Timeout)
+			case interaction.ResultCode == tokenizer.ResultOutputLimit:
+				terminated = true
+				t.Fatalf("%s:%d: \"%s\" exceeded the output limit and was terminated", file, interaction.Line, interaction.Cmd)
+			case interaction.Quarantined():
+				t.Skipf("quarantined as flaky (%s)\n%s", interaction.Attributes[tokenizer.FlakyOption], failureMessage(file, interaction))
+			case interaction.HasFailure():
+				t.Fatal(failureMessage(file, interaction))
+			}
+		})
+	}
+}
+
+// failureMessage describes a failed interaction with its expected and actual output
+func failureMessage(file string, interaction *tokenizer.Interaction) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "%s:%d: %s\n$ %s\n", file, interaction.Line, interaction.Result(), interaction.Cmd)
+	fmt.Fprintf(&message, "expected:\n%s\n", indent(interaction.Response))
+	fmt.Fprintf(&message, "actual (exit code %d):\n%s", interaction.ExitCode, indent(interaction.ComparedOutput()))
+	return message.String()
+}
+
+// indent returns the lines indented for the test output
+func indent(lines []string) string {
+	if len(lines) == 0 {
+		return "    (nothing)"
+	}
+	return "    " + strings.Join(lines, "\n    ")
+}
+
+// Documents returns the files matching the patterns, in sorted order and without duplicates
+// A pattern ending in /... matches all Markdown documents in the directory and its subdirectories, other patterns
+// are glob patterns as understood by filepath.Glob. A pattern that matches nothing is an error.
+func Documents(patterns ...string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, pattern := range patterns {
+		var matches []string
+		var err error
+		if strings.HasSuffix(pattern, "/...") || pattern == "..." {
+			matches, err = walk(strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/"))
+		} else {
+			matches, err = filepath.Glob(pattern)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no documents match %s", pattern)
+		}
+		for _, match := range matches {
+			found[match] = true
+		}
+	}
+	var files []string
+	for file := range found {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// walk returns the Markdown documents in the directory and its subdirectories
+func walk(directory string) ([]string, error) {
+	if len(directory) == 0 {
+		directory = "."
+	}
+	var files []string
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for _, extension := range documentExtensions {
+			if strings.HasSuffix(path, extension) {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
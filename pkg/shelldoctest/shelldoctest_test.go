@@ -0,0 +1,34 @@
+package shelldoctest
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: Apache-2.0
+
+import (
+	"testing"
+
+	"github.com/endocode/shelldoc/pkg/tokenizer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	Run(t, "../tokenizer/samples/helloworld.md", "../tokenizer/samples/skip.md")
+}
+
+func TestDocuments(t *testing.T) {
+	files, err := Documents("../tokenizer/samples/...")
+	require.NoError(t, err, "Recursive patterns should work")
+	require.Contains(t, files, "../tokenizer/samples/helloworld.md", "All documents in the directory are found")
+	files, err = Documents("../tokenizer/samples/hello*.md", "../tokenizer/samples/helloworld.md")
+	require.NoError(t, err, "Glob patterns should work")
+	require.Equal(t, []string{"../tokenizer/samples/helloworld.md"}, files, "Duplicates are removed")
+	_, err = Documents("does-not-exist/*.md")
+	require.Error(t, err, "Patterns that match nothing are an error")
+}
+
+func TestFailureMessage(t *testing.T) {
+	interaction := &tokenizer.Interaction{Cmd: "echo No", Line: 5, Response: []string{"Yes"}, Output: []string{"No"}, ResultCode: tokenizer.ResultMismatch}
+	message := failureMessage("README.md", interaction)
+	require.Contains(t, message, "README.md:5: FAIL (mismatch)\n$ echo No\n", "The message names the location and the command")
+	require.Contains(t, message, "expected:\n    Yes\nactual (exit code 0):\n    No", "The message shows the expected and the actual output")
+}
@@ -0,0 +1,93 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// stateAssertions creates the interactions that check the state of the shell session and of the filesystem after a
+// code block, as specified with the ExpectEnvOption, ExpectCwdOption, ExpectFunctionOption, ExpectFileOption,
+// ExpectFileContainsOption and ExpectDirEmptyOption
+// They keep the options of the code block that decide if and how long they run, but not those about the exit code or
+// the output of its commands. Invalid arguments are reported by ValidateOptions and skipped here.
+func stateAssertions(attributes map[string]string, line int, heading, language string) []*Interaction {
+	var assertions []*Interaction
+	add := func(caption, cmd string, response ...string) {
+		interaction := New(caption)
+		interaction.Line = line
+		interaction.Heading = heading
+		interaction.Language = language
+		interaction.Attributes = map[string]string{}
+		for _, key := range []string{SkipOption, TimeoutOption, FileTimeoutOption} {
+			if value, ok := attributes[key]; ok {
+				interaction.Attributes[key] = value
+			}
+		}
+		interaction.Cmd = cmd
+		interaction.Response = response
+		assertions = append(assertions, interaction)
+	}
+	if value, ok := attributes[ExpectEnvOption]; ok {
+		for _, assignment := range strings.Split(value, ",") {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 || !nameRx.MatchString(parts[0]) {
+				continue
+			}
+			add(fmt.Sprintf("%s is \"%s\"", parts[0], parts[1]), fmt.Sprintf("printf '%%s\\n' \"$%s\"", parts[0]), parts[1])
+		}
+	}
+	if value, ok := attributes[ExpectCwdOption]; ok && len(value) > 0 {
+		directory := path.Clean(value)
+		pattern := quoteWord(directory)
+		if !path.IsAbs(directory) {
+			pattern = "*/" + pattern
+		}
+		add(fmt.Sprintf("the working directory is \"%s\"", directory),
+			fmt.Sprintf("case \"$PWD\" in %s) printf '%%s\\n' %s;; *) pwd;; esac", pattern, quoteWord(directory)), directory)
+	}
+	if value, ok := attributes[ExpectFunctionOption]; ok {
+		for _, name := range strings.Split(value, ",") {
+			if !nameRx.MatchString(name) {
+				continue
+			}
+			add(fmt.Sprintf("%s is a shell function", name), fmt.Sprintf("type %s 2>/dev/null | grep -q function", name))
+		}
+	}
+	if value, ok := attributes[ExpectFileOption]; ok {
+		for _, name := range strings.Split(value, ",") {
+			if len(name) == 0 {
+				continue
+			}
+			add(fmt.Sprintf("%s exists", name), fmt.Sprintf("test -e %s", quoteWord(name)))
+		}
+	}
+	if value, ok := attributes[ExpectFileContainsOption]; ok {
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+				continue
+			}
+			add(fmt.Sprintf("%s contains \"%s\"", parts[0], parts[1]), fmt.Sprintf("grep -qF -e %s %s", quoteWord(parts[1]), quoteWord(parts[0])))
+		}
+	}
+	if value, ok := attributes[ExpectDirEmptyOption]; ok {
+		for _, name := range strings.Split(value, ",") {
+			if len(name) == 0 {
+				continue
+			}
+			add(fmt.Sprintf("%s is an empty directory", name),
+				fmt.Sprintf("test -d %[1]s && test -z \"$(ls -A %[1]s)\"", quoteWord(name)))
+		}
+	}
+	return assertions
+}
+
+// quoteWord quotes a word for the shell, so that it is neither split nor expanded
+func quoteWord(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,55 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CaptionPlaceholders lists the placeholders supported in caption templates
+var CaptionPlaceholders = []string{"file", "heading", "index", "line", "id", "cmd", "response"}
+
+// captionPlaceholderRx matches a placeholder in a caption template, like {cmd}
+var captionPlaceholderRx = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// ValidateCaptionTemplate returns an error if the template contains placeholders that are not supported
+func ValidateCaptionTemplate(template string) error {
+	for _, match := range captionPlaceholderRx.FindAllStringSubmatch(template, -1) {
+		if !contains(CaptionPlaceholders, match[1]) {
+			return fmt.Errorf("unknown placeholder {%s} in the caption template, supported are {%s}", match[1], strings.Join(CaptionPlaceholders, "}, {"))
+		}
+	}
+	return nil
+}
+
+// DescribeAs returns a human-readable description of the interaction, formatted using the caption template if the
+// interaction has no caption
+// The placeholders {file}, {heading}, {index}, {line}, {id}, {cmd} and {response} are replaced with the document, the
+// heading of the section, the position of the interaction in the document starting at 1, its line, its ID, its
+// command and its expected response. Nothing is elided. If the template is empty, or the interaction has a caption,
+// the description is that of Describe.
+func (interaction *Interaction) DescribeAs(template, file string, index int) string {
+	if len(template) == 0 || len(interaction.Caption) > 0 {
+		return interaction.Describe()
+	}
+	values := map[string]string{
+		"file":     file,
+		"heading":  interaction.Heading,
+		"index":    strconv.Itoa(index),
+		"line":     strconv.Itoa(interaction.Line),
+		"id":       interaction.ID,
+		"cmd":      strings.Replace(interaction.Cmd, "\n", "; ", -1),
+		"response": interaction.expectation(0),
+	}
+	return captionPlaceholderRx.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value, ok := values[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
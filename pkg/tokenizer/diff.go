@@ -0,0 +1,70 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Segment is a part of a line that is compared character by character with another line
+type Segment struct {
+	// Text contains the characters of the segment
+	Text string
+	// Changed is true if the characters differ from the other line, and false if both lines contain them
+	Changed bool
+}
+
+// DiffLine compares the expected line with the actual line character by character
+// It returns the segments of both lines, in which the characters that were changed, added or removed are marked, so
+// that a single changed digit or an extra space is easy to spot.
+func DiffLine(expected, actual string) (expectedSegments, actualSegments []Segment) {
+	a, b := characters(expected), characters(actual)
+	// autojunk would ignore frequent characters like spaces, which need to be compared like all others
+	matcher := difflib.NewMatcherWithJunk(a, b, false, nil)
+	for _, opcode := range matcher.GetOpCodes() {
+		changed := opcode.Tag != 'e'
+		expectedSegments = appendSegment(expectedSegments, strings.Join(a[opcode.I1:opcode.I2], ""), changed)
+		actualSegments = appendSegment(actualSegments, strings.Join(b[opcode.J1:opcode.J2], ""), changed)
+	}
+	return expectedSegments, actualSegments
+}
+
+// characters splits the line into its characters
+func characters(line string) []string {
+	var result []string
+	for _, character := range line {
+		result = append(result, string(character))
+	}
+	return result
+}
+
+// appendSegment appends the text to the segments, merging it with the last segment if that is changed the same way
+func appendSegment(segments []Segment, text string, changed bool) []Segment {
+	if len(text) == 0 {
+		return segments
+	}
+	if last := len(segments) - 1; last >= 0 && segments[last].Changed == changed {
+		segments[last].Text += text
+		return segments
+	}
+	return append(segments, Segment{Text: text, Changed: changed})
+}
+
+// FirstDifference returns the first expected line and the line of output that differ, and false if they are not
+// known, for example if a matcher plugin compared the output, or if a line is missing or the expected line is a
+// pattern, which cannot be compared character by character
+// It also returns false if only the line break at the end of the output differs, since the lines are identical.
+func (err *MismatchError) FirstDifference() (expected, actual string, ok bool) {
+	index := err.Line - 1
+	if err.NewlineOnly || index < 0 || index >= len(err.Expected) || index >= len(err.Actual) {
+		return "", "", false
+	}
+	if strings.HasPrefix(err.Expected[index], PatternPrefix) || err.Expected[index] == err.Actual[index] {
+		return "", "", false
+	}
+	return err.Expected[index], err.Actual[index], true
+}
@@ -0,0 +1,378 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/russross/blackfriday.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// frontMatterMarker starts and ends the YAML front matter at the beginning of a document
+const frontMatterMarker = "---"
+
+// Document is the structure of a Markdown document as far as shelldoc is concerned
+// It is shared by the tools that work on documents, like the runner, the linter and the formatter.
+type Document struct {
+	// Title is the title from the front matter, or the text of the first top-level heading
+	Title string
+	// FrontMatter contains the YAML front matter of the document, it is nil if the document has none
+	FrontMatter map[string]interface{}
+	// Headings contains the top-level headings, the headings of the subsections are their children
+	Headings []*Heading
+	// Blocks contains the code blocks of the document in order, including those without interactions
+	Blocks []*Block
+	// Diagnostics contains the problems found in the code blocks
+	Diagnostics []Diagnostic
+}
+
+// Heading is a heading in a document, with the headings of its subsections
+type Heading struct {
+	// Level is the level of the heading, 1 for the title
+	Level int
+	// Text is the text of the heading without markup
+	Text string
+	// Line is the line number of the heading, or zero if unknown
+	Line int
+	// Children contains the headings of the subsections
+	Children []*Heading
+}
+
+// Block is a code block in a document
+type Block struct {
+	// Line is the line number of the first command in the code block, or of its first line if it has none
+	// It is zero if unknown.
+	Line int
+	// EndLine is the line number of the last line of the code block, excluding the closing fence
+	// It is zero if unknown.
+	EndLine int
+	// Fenced is true for fenced code blocks, and false for indented ones
+	Fenced bool
+	// InfoString contains the info string of a fenced code block
+	InfoString string
+	// Language contains the language specified in the info string
+	Language string
+	// Options contains the shelldoc options specified in the info string
+	Options map[string]string
+	// Heading is the text of the heading of the section the code block is in
+	Heading string
+	// Interactions contains the interactions in the code block
+	Interactions []*Interaction
+}
+
+// Interactions returns the interactions of all code blocks in the document, in order
+func (document *Document) Interactions() []*Interaction {
+	var interactions []*Interaction
+	for _, block := range document.Blocks {
+		interactions = append(interactions, block.Interactions...)
+	}
+	return interactions
+}
+
+// HeadingPath returns the texts of the headings of the sections that contain the line, the top-level heading first
+func (document *Document) HeadingPath(line int) []string {
+	var path []string
+	headings := document.Headings
+	for {
+		var current *Heading
+		for _, heading := range headings {
+			if heading.Line < line {
+				current = heading
+			}
+		}
+		if current == nil {
+			return path
+		}
+		path = append(path, current.Text)
+		headings = current.Children
+	}
+}
+
+// AssignIDs sets the ID of the interactions in the document, which is read from the file
+// The ID is derived from the path of the file, the headings of the sections the interaction is in, and its command
+// with normalized whitespace, so that it stays the same if lines are added or removed elsewhere in the document.
+// Interactions with the same command in the same section are numbered in order.
+func (document *Document) AssignIDs(file string) {
+	occurrences := make(map[string]int)
+	for _, block := range document.Blocks {
+		path := strings.Join(document.HeadingPath(block.Line), "\n")
+		for _, interaction := range block.Interactions {
+			hash := sha256.New()
+			fmt.Fprintf(hash, "%s\n%s\n%s", filepath.ToSlash(filepath.Clean(file)), path, strings.Join(strings.Fields(interaction.Cmd), " "))
+			id := hex.EncodeToString(hash.Sum(nil))[:16]
+			occurrences[id]++
+			if count := occurrences[id]; count > 1 {
+				id = fmt.Sprintf("%s-%d", id, count)
+			}
+			interaction.ID = id
+		}
+	}
+}
+
+// InteractionsAt returns the interactions of the code block that contains the line, preceded by those of the setup
+// code blocks before it
+// Setup code blocks are marked with the shelldocsetup option. It returns nil if no code block with interactions
+// contains the line.
+func (document *Document) InteractionsAt(line int) []*Interaction {
+	var setup []*Interaction
+	for _, block := range document.Blocks {
+		if block.Contains(line) {
+			if len(block.Interactions) == 0 {
+				return nil
+			}
+			return append(setup, block.Interactions...)
+		}
+		if _, ok := block.Options[SetupOption]; ok {
+			setup = append(setup, block.Interactions...)
+		}
+	}
+	return nil
+}
+
+// Redundancies returns the code blocks that repeat the commands of an earlier code block in the document, and the
+// setup code blocks that are not followed by a code block that needs them
+// Both are common copy and paste errors in long documents.
+func (document *Document) Redundancies() []Diagnostic {
+	var problems []Diagnostic
+	seen := make(map[string]int)
+	for index, block := range document.Blocks {
+		if len(block.Interactions) == 0 {
+			continue
+		}
+		var commands []string
+		for _, interaction := range block.Interactions {
+			commands = append(commands, interaction.Cmd)
+		}
+		key := strings.Join(commands, "\x00")
+		if line, ok := seen[key]; ok {
+			problems = append(problems, Diagnostic{block.Line, fmt.Sprintf("the commands of this code block are identical to those of the code block in line %d", line)})
+		} else {
+			seen[key] = block.Line
+		}
+		if _, isSetup := block.Options[SetupOption]; isSetup && !document.needsSetup(index+1) {
+			problems = append(problems, Diagnostic{block.Line, fmt.Sprintf("no code block with commands follows this %s code block, it is never needed", SetupOption)})
+		}
+	}
+	return problems
+}
+
+// needsSetup returns true if a code block with commands that is not a setup code block starts at or after the index
+func (document *Document) needsSetup(index int) bool {
+	for _, block := range document.Blocks[index:] {
+		if _, isSetup := block.Options[SetupOption]; !isSetup && len(block.Interactions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects the code blocks of a document that are parsed
+// Code blocks that are not selected are left out of the Document, which makes parsing large documents faster when
+// only a few code blocks are executed.
+type Filter struct {
+	// Run selects the code blocks that contain a command or are in a section with a heading it matches, all code
+	// blocks are selected if it is nil
+	Run *regexp.Regexp
+	// EndLine stops parsing after the code block that contains this line, the whole document is parsed if it is zero
+	EndLine int
+}
+
+// selects returns true if the code block with the literal text, in a section with the heading, is selected
+func (filter Filter) selects(heading string, literal []byte, fenced bool) bool {
+	if filter.Run == nil || filter.Run.MatchString(heading) {
+		return true
+	}
+	lines := strings.Split(string(literal), "\n")
+	if fenced {
+		lines = lines[1:]
+	}
+	for _, line := range lines {
+		if match := cmdRx.FindStringSubmatch(strings.TrimSpace(line)); len(match) > 1 && filter.Run.MatchString(match[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDocument tokenizes the document and returns its structure
+func ParseDocument(data []byte) (*Document, error) {
+	return ParseDocumentFiltered(data, Filter{})
+}
+
+// ParseDocumentFiltered tokenizes the document like ParseDocument, but only parses the code blocks selected by the
+// filter
+// The headings before the EndLine of the filter are all parsed. Diagnostics are only reported for the selected code
+// blocks.
+func ParseDocumentFiltered(data []byte, filter Filter) (*Document, error) {
+	document := &Document{}
+	frontMatter, err := parseFrontMatter(bytes.TrimPrefix(data, []byte(byteOrderMark)))
+	if err != nil {
+		return nil, err
+	}
+	document.FrontMatter = frontMatter
+	if title, ok := frontMatter["title"].(string); ok {
+		document.Title = title
+	}
+	visitor := NewInteractionVisitor()
+	var parents []*Heading
+	visitor.Heading = func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
+		heading := &Heading{Level: node.HeadingData.Level, Text: visitor.heading, Line: visitor.lineOf(visitor.heading)}
+		if len(document.Title) == 0 && heading.Level == 1 {
+			document.Title = heading.Text
+		}
+		for len(parents) > 0 && parents[len(parents)-1].Level >= heading.Level {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) == 0 {
+			document.Headings = append(document.Headings, heading)
+		} else {
+			parent := parents[len(parents)-1]
+			parent.Children = append(parent.Children, heading)
+		}
+		parents = append(parents, heading)
+		return blackfriday.GoToNext
+	}
+	visitor.CodeBlock = func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
+		return document.addBlock(visitor, node, false, handleCodeBlock, filter)
+	}
+	visitor.FencedCodeBlock = func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
+		if bytes.Count(node.Literal, []byte("\n")) == 0 {
+			// inline code, there is no info string and no closer
+			return handleFencedCodeBlock(visitor, node)
+		}
+		return document.addBlock(visitor, node, true, handleFencedCodeBlock, filter)
+	}
+	Tokenize(data, visitor)
+	document.Diagnostics = visitor.Diagnostics
+	return document, nil
+}
+
+// addBlock parses a code block using handler and adds it to the document, if the filter selects it
+// It stops the walk through the document once a code block starts after the EndLine of the filter.
+func (document *Document) addBlock(visitor *Visitor, node *blackfriday.Node, fenced bool, handler func(*Visitor, *blackfriday.Node) blackfriday.WalkStatus, filter Filter) blackfriday.WalkStatus {
+	if !filter.selects(visitor.heading, node.Literal, fenced) {
+		visitor.skip(node)
+		return blackfriday.GoToNext
+	}
+	block := visitor.block(node, fenced, handler)
+	if filter.EndLine > 0 && block.Line > 0 && !block.Contains(filter.EndLine) && block.Line > filter.EndLine {
+		return blackfriday.Terminate
+	}
+	document.Blocks = append(document.Blocks, block)
+	return blackfriday.GoToNext
+}
+
+// skip advances over the lines of a code block that is not parsed, so that the lines of the following code blocks
+// are located correctly
+func (visitor *Visitor) skip(node *blackfriday.Node) {
+	for _, line := range strings.Split(string(node.Literal), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			visitor.lineOf(line)
+		}
+	}
+}
+
+// block parses a code block using handler and describes it
+func (visitor *Visitor) block(node *blackfriday.Node, fenced bool, handler func(*Visitor, *blackfriday.Node) blackfriday.WalkStatus) *Block {
+	count, start := len(visitor.Interactions), visitor.offset
+	handler(visitor, node)
+	block := &Block{Fenced: fenced, Heading: visitor.heading, Interactions: visitor.Interactions[count:]}
+	lines := strings.Split(string(node.Literal), "\n")
+	if fenced {
+		// the first line is the info string, the last one the closer
+		block.InfoString = strings.TrimSpace(lines[0])
+		block.Language, block.Options = parseCodeBlockInfoString(lines[0])
+		lines = lines[1 : len(lines)-1]
+	}
+	first, last := -1, -1
+	for index, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if first < 0 && (len(block.Interactions) == 0 || cmdRx.MatchString(line)) {
+			first = index
+		}
+		last = index
+	}
+	if len(block.Interactions) > 0 {
+		block.Line = block.Interactions[0].Line
+	} else if first >= 0 {
+		// the handler may have located the lines of the code block already, search them again from where it started
+		end := visitor.offset
+		visitor.offset = start
+		block.Line = visitor.lineOf(strings.TrimSpace(lines[first]))
+		if visitor.offset < end {
+			visitor.offset = end
+		}
+	}
+	if block.Line > 0 && first >= 0 {
+		block.EndLine = block.Line + last - first
+	}
+	return block
+}
+
+// Contains returns true if the line is part of the code block, including the fences of a fenced code block
+func (block *Block) Contains(line int) bool {
+	if block.Line < 1 {
+		return false
+	}
+	start, end := block.Line, block.EndLine
+	if block.Fenced {
+		start, end = start-1, end+1
+	}
+	return line >= start && line <= end
+}
+
+// parseFrontMatter returns the YAML front matter at the beginning of the document, or nil if there is none
+func parseFrontMatter(data []byte) (map[string]interface{}, error) {
+	end := frontMatterEnd(data)
+	if end < 0 {
+		return nil, nil
+	}
+	lines := bytes.SplitN(data[:end], []byte("\n"), 2)
+	frontMatter := make(map[string]interface{})
+	if len(lines) > 1 {
+		content := lines[1][:bytes.LastIndex(lines[1], []byte(frontMatterMarker))]
+		if err := yaml.Unmarshal(content, &frontMatter); err != nil {
+			return nil, fmt.Errorf("invalid front matter: %v", err)
+		}
+	}
+	return frontMatter, nil
+}
+
+// frontMatterEnd returns the position after the closing marker of the front matter, or -1 if there is none
+func frontMatterEnd(data []byte) int {
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterMarker {
+		return -1
+	}
+	position := len(lines[0])
+	for _, line := range lines[1:] {
+		position += len(line)
+		if strings.TrimSpace(line) == frontMatterMarker {
+			return position
+		}
+	}
+	return -1
+}
+
+// blankFrontMatter replaces the front matter with empty lines, so that it is not parsed as Markdown, but the line
+// numbers of the rest of the document remain the same
+func blankFrontMatter(data []byte) []byte {
+	end := frontMatterEnd(data)
+	if end < 0 {
+		return data
+	}
+	blanked := append(bytes.Repeat([]byte("\n"), bytes.Count(data[:end], []byte("\n"))), data[end:]...)
+	return blanked
+}
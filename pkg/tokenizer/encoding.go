@@ -0,0 +1,148 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// byteOrderMark is the UTF-8 byte order mark some editors write at the beginning of a document, it is ignored
+const byteOrderMark = "\xef\xbb\xbf"
+
+// The encodings of documents that are detected by their byte order mark
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+)
+
+// replacementCharacter replaces invalid UTF-8 in commands, expected responses and the output of commands
+const replacementCharacter = "�"
+
+// lookupEncoding returns the encoding with the name, which is one of the names used on the web like latin1,
+// windows-1252 or shift_jis
+// UTF-16 documents are written with a byte order mark, and read in the byte order of the byte order mark if there is
+// one.
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding \"%s\"", name)
+	}
+	return enc, nil
+}
+
+// DetectEncoding returns the encoding of a document, UTF-16 if it starts with a UTF-16 byte order mark and UTF-8
+// otherwise
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+	return EncodingUTF8
+}
+
+// Decode converts a document in the named encoding to UTF-8, the encoding is detected if the name is empty
+// UTF-8 documents are returned unchanged, including a byte order mark, which the tokenizer ignores. Invalid UTF-8 is
+// kept as well, so that the lines of the document stay where they are. The encoding that was used is returned.
+func Decode(data []byte, name string) ([]byte, string, error) {
+	if len(name) == 0 {
+		name = DetectEncoding(data)
+	}
+	if strings.EqualFold(name, EncodingUTF8) || strings.EqualFold(name, "utf8") {
+		return data, EncodingUTF8, nil
+	}
+	enc, err := lookupEncoding(name)
+	if err != nil {
+		return nil, name, err
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, name, fmt.Errorf("unable to decode the document as %s: %v", name, err)
+	}
+	return decoded, name, nil
+}
+
+// Encode converts a document from UTF-8 back to the named encoding, like it was read by Decode
+// Characters that cannot be represented in the encoding are an error.
+func Encode(data []byte, name string) ([]byte, error) {
+	if len(name) == 0 || strings.EqualFold(name, EncodingUTF8) || strings.EqualFold(name, "utf8") {
+		return data, nil
+	}
+	enc, err := lookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := enc.NewEncoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the document as %s: %v", name, err)
+	}
+	return encoded, nil
+}
+
+// NormalizeLineEndings replaces the Windows line breaks in a document with Unix line breaks
+// The markdown parser does not recognize some code blocks in documents with Windows line breaks, and the carriage
+// returns would be part of the expected responses. Documents without them are returned as they are.
+func NormalizeLineEndings(data []byte) []byte {
+	if !bytes.Contains(data, []byte("\r\n")) {
+		return data
+	}
+	return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+}
+
+// trimCarriageReturns removes the carriage returns at the end of the lines, which programs writing Windows line breaks
+// leave in the output
+// The lines are returned as they are if none of them ends with a carriage return.
+func trimCarriageReturns(lines []string) []string {
+	for index, line := range lines {
+		if strings.HasSuffix(line, "\r") {
+			trimmed := make([]string, len(lines))
+			copy(trimmed, lines[:index])
+			for position := index; position < len(lines); position++ {
+				trimmed[position] = strings.TrimSuffix(lines[position], "\r")
+			}
+			return trimmed
+		}
+	}
+	return lines
+}
+
+// validUTF8 replaces invalid UTF-8 in the text with the replacement character
+func validUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return strings.ToValidUTF8(text, replacementCharacter)
+}
+
+// validLines replaces invalid UTF-8 in the lines with the replacement character
+// The lines are returned as they are if they are valid, which is the common case.
+func validLines(lines []string) []string {
+	for index, line := range lines {
+		if !utf8.ValidString(line) {
+			valid := make([]string, len(lines))
+			copy(valid, lines[:index])
+			for position := index; position < len(lines); position++ {
+				valid[position] = validUTF8(lines[position])
+			}
+			return valid
+		}
+	}
+	return lines
+}
@@ -0,0 +1,57 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The shell variables used by withEnvironment, which keep the values of the overridden variables and the exit code
+// of the command
+const (
+	environmentPrefix = "__shelldoc_saved_"
+	environmentStatus = "__shelldoc_status"
+)
+
+// parseEnvironment splits the value of the EnvOption into the names and values of the variables
+// A variable that is assigned more than once gets the last value. It returns false if an assignment is not like
+// NAME=value.
+func parseEnvironment(value string) ([][2]string, bool) {
+	var assignments [][2]string
+	positions := make(map[string]int)
+	for _, assignment := range strings.Fields(value) {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || !nameRx.MatchString(parts[0]) {
+			return nil, false
+		}
+		if position, ok := positions[parts[0]]; ok {
+			assignments[position][1] = parts[1]
+			continue
+		}
+		positions[parts[0]] = len(assignments)
+		assignments = append(assignments, [2]string{parts[0], parts[1]})
+	}
+	return assignments, len(assignments) > 0
+}
+
+// withEnvironment wraps a command so that the variables of the EnvOption are exported while it runs, and restored
+// afterwards
+// Variables that were not set before are unset again. The exit code of the command is kept.
+func withEnvironment(command, value string) string {
+	assignments, ok := parseEnvironment(value)
+	if !ok {
+		return command
+	}
+	var before, after []string
+	for _, assignment := range assignments {
+		name, saved := assignment[0], environmentPrefix+assignment[0]
+		before = append(before, fmt.Sprintf("%[2]s_set=${%[1]s+x}; %[2]s=${%[1]s-}; export %[1]s=%[3]s", name, saved, quoteWord(assignment[1])))
+		after = append(after, fmt.Sprintf("if [ -n \"$%[2]s_set\" ]; then %[1]s=$%[2]s; else unset %[1]s; fi; unset %[2]s_set %[2]s", name, saved))
+	}
+	// eval expands the exit code before the variable that holds it is unset
+	return fmt.Sprintf("%s\n{ %s\n}\n%s=$?\n%s\neval \"unset %[3]s; (exit $%[3]s)\"",
+		strings.Join(before, "\n"), strings.TrimSpace(command), environmentStatus, strings.Join(after, "\n"))
+}
@@ -0,0 +1,95 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+
+	"github.com/endocode/shelldoc/pkg/shell"
+)
+
+var (
+	// ErrTimeout indicates that the command did not finish within its timeout and was terminated
+	ErrTimeout = shell.ErrTimeout
+	// ErrShellCrashed indicates that the shell exited before the command finished
+	ErrShellCrashed = shell.ErrShellCrashed
+	// ErrWaitingForInput indicates that the command was blocked reading its standard input until it was closed
+	ErrWaitingForInput = shell.ErrWaitingForInput
+	// ErrOutputLimit indicates that the output of the command exceeded the limit and the shell was terminated
+	ErrOutputLimit = shell.ErrOutputLimit
+)
+
+// MismatchError indicates that the output of the command did not match the expected response
+type MismatchError struct {
+	// Expected contains the expected response, as written in the document
+	Expected []string
+	// Actual contains the output of the command
+	Actual []string
+	// Line is the number of the first line of the output that differs from the expected response, counting from 1
+	// It is zero if it is not known, for example if a matcher plugin compared the output.
+	Line int
+	// ExpectEmpty is true if the command was expected to produce no output at all
+	ExpectEmpty bool
+	// NewlineOnly is true if the output only differs from the expected response in the line break at its end
+	NewlineOnly bool
+	// NoFinalNewline is true if the output did not end with a line break
+	NoFinalNewline bool
+}
+
+func (err *MismatchError) Error() string {
+	if err.ExpectEmpty {
+		return fmt.Sprintf("the command was expected to produce no output, but printed %d lines", len(err.Actual))
+	}
+	if err.NewlineOnly && err.NoFinalNewline {
+		return fmt.Sprintf("the output did not end with a line break, the expected response needs to end with \"%s\"", NoNewlineMarker)
+	}
+	if err.NewlineOnly {
+		return fmt.Sprintf("the output ended with a line break, but the expected response ends with \"%s\"", NoNewlineMarker)
+	}
+	if err.Line > 0 {
+		return fmt.Sprintf("the output did not match the expected response in line %d (%d lines expected, %d lines received)", err.Line, len(err.Expected), len(err.Actual))
+	}
+	return fmt.Sprintf("the output did not match the expected response (%d lines expected, %d lines received)", len(err.Expected), len(err.Actual))
+}
+
+// ExitCodeError indicates that the command returned a different exit code than expected
+type ExitCodeError struct {
+	// Expected contains the exit code the command was expected to return, usually zero
+	Expected int
+	// Actual contains the exit code the command returned
+	Actual int
+}
+
+func (err *ExitCodeError) Error() string {
+	if err.Expected == 0 {
+		return fmt.Sprintf("command exited with non-zero exit code %d", err.Actual)
+	}
+	return fmt.Sprintf("command exited with exit code %d, expected %d", err.Actual, err.Expected)
+}
+
+// SignalError indicates that the command was terminated by a signal
+type SignalError struct {
+	// Signal contains the name of the signal, like SIGSEGV
+	Signal string
+	// ExitCode contains the exit code the shell reported for the command
+	ExitCode int
+}
+
+func (err *SignalError) Error() string {
+	return fmt.Sprintf("command was terminated by %s (exit code %d)", err.Signal, err.ExitCode)
+}
+
+// UnexpectedPassError indicates that a command in a code block with the shelldocxfail option passed
+type UnexpectedPassError struct {
+	// Reason contains the reason the command was expected to fail, if any
+	Reason string
+}
+
+func (err *UnexpectedPassError) Error() string {
+	if len(err.Reason) == 0 {
+		return "command was expected to fail, but passed"
+	}
+	return fmt.Sprintf("command was expected to fail (%s), but passed", err.Reason)
+}
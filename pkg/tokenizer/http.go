@@ -0,0 +1,166 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// HTTPMatcherName is the name of the HTTPMatcher for the shelldocmatcher option
+const HTTPMatcherName = "http"
+
+var (
+	statusLineRx = regexp.MustCompile(`^HTTP/[0-9.]+\s+([0-9]{3})\b`)
+	headerLineRx = regexp.MustCompile(`^([A-Za-z0-9!#$%&'*+.^_|~-]+):\s*(.*)$`)
+)
+
+// BuiltinMatcher returns the matcher that is built into shelldoc with the given name, or nil if there is none
+// Built-in matchers take precedence over the matchers provided by the runner, like plugins.
+func BuiltinMatcher(name string) Matcher {
+	switch name {
+	case HTTPMatcherName:
+		return HTTPMatcher{}
+	case LocaleMatcherName:
+		return LocaleMatcher{}
+	}
+	return nil
+}
+
+// HTTPMatcher compares HTTP responses, as printed by curl -i or httpie, by their meaning instead of their text
+// The status codes need to be equal, the headers of the expected response need to be present with the same value,
+// in any order, and additional headers are ignored. A header value of ... accepts any value. If the expected body is
+// JSON, the actual body needs to be JSON that contains all its fields with the same values, otherwise the bodies are
+// compared line by line. An expected response without a status line or a body does not check them.
+type HTTPMatcher struct{}
+
+// httpMessage is a parsed HTTP response
+type httpMessage struct {
+	status  string
+	headers map[string][]string
+	body    []string
+}
+
+// Match compares the output with the expected response
+func (HTTPMatcher) Match(expected, actual []string) (bool, error) {
+	want := parseHTTPMessage(expected, false)
+	got := parseHTTPMessage(actual, true)
+	if len(got.status) == 0 {
+		return false, errors.New("the output is not an HTTP response, use curl -i to print the status line and the headers")
+	}
+	if len(want.status) > 0 && want.status != got.status {
+		return false, nil
+	}
+	for name, values := range want.headers {
+		for _, value := range values {
+			if !hasHeader(got.headers[name], value) {
+				return false, nil
+			}
+		}
+	}
+	if len(want.body) == 0 {
+		return true, nil
+	}
+	var wantJSON interface{}
+	if err := json.Unmarshal([]byte(strings.Join(want.body, "\n")), &wantJSON); err == nil {
+		var gotJSON interface{}
+		if err := json.Unmarshal([]byte(strings.Join(got.body, "\n")), &gotJSON); err != nil {
+			return false, nil
+		}
+		return containsJSON(wantJSON, gotJSON), nil
+	}
+	body := Interaction{Response: want.body}
+	return body.compareResponse(got.body) < 0, nil
+}
+
+// parseHTTPMessage splits an HTTP response into its status code, headers and body
+// The headers of the output end with an empty line. Empty lines are not part of expected responses, so there the
+// headers end with the first line that is not a header, and the status line may be left out. The header blocks of
+// informational responses like 100 Continue that precede the final response in the output are skipped.
+func parseHTTPMessage(lines []string, output bool) httpMessage {
+	message := httpMessage{headers: map[string][]string{}}
+	for {
+		match := statusLineRx.FindStringSubmatch(firstLine(lines))
+		if match != nil {
+			message.status = match[1]
+			message.headers = map[string][]string{}
+			lines = lines[1:]
+		} else if output || len(message.status) > 0 {
+			break
+		}
+		for len(lines) > 0 {
+			line := firstLine(lines)
+			if output && len(line) == 0 {
+				lines = lines[1:]
+				break
+			}
+			header := headerLineRx.FindStringSubmatch(line)
+			if header == nil {
+				break
+			}
+			name := strings.ToLower(header[1])
+			message.headers[name] = append(message.headers[name], strings.TrimSpace(header[2]))
+			lines = lines[1:]
+		}
+		if match == nil || !strings.HasPrefix(message.status, "1") {
+			break
+		}
+	}
+	message.body = lines
+	return message
+}
+
+// firstLine returns the first line without a trailing carriage return, or an empty string if there are no lines
+func firstLine(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.TrimRight(lines[0], "\r")
+}
+
+// hasHeader returns true if one of the values matches the expected value, ... matches any value
+func hasHeader(values []string, expected string) bool {
+	for _, value := range values {
+		if expected == "..." || strings.EqualFold(value, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsJSON returns true if the actual JSON value contains all fields of the expected one with the same values
+// Arrays need to have the same length, their elements are compared in order.
+func containsJSON(expected, actual interface{}) bool {
+	switch want := expected.(type) {
+	case map[string]interface{}:
+		got, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			field, ok := got[key]
+			if !ok || !containsJSON(value, field) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		got, ok := actual.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for index := range want {
+			if !containsJSON(want[index], got[index]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(expected, actual)
+	}
+}
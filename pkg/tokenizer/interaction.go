@@ -5,17 +5,21 @@ package tokenizer
 // SPDX-License-Identifier: LGPL-3.0
 
 import (
+	"context"
 	"fmt"
-	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/endocode/shelldoc/pkg/shell"
 )
 
+// ResultCode describes the outcome of executing an interaction
+type ResultCode int
+
 const (
 	// NewInteraction indicates that the interaction has not been executed yet
-	NewInteraction = iota
+	NewInteraction ResultCode = iota
 	// ResultExecutionError indicates that there has been an error in executing the command, not with the command itself
 	ResultExecutionError
 	// ResultError indicates that the command exited with an non-zero exit code
@@ -26,25 +30,154 @@ const (
 	ResultRegexMatch
 	// ResultMismatch indicates that the output from the command did not match expectations in any way
 	ResultMismatch
+	// ResultSkipped indicates that the interaction was not executed because the code block asked to skip it
+	ResultSkipped
+	// ResultTimeout indicates that the command did not finish within its timeout and was terminated
+	ResultTimeout
+	// ResultWaitingForInput indicates that the command was blocked reading its standard input, which is empty
+	ResultWaitingForInput
+	// ResultSignal indicates that the command was terminated by a signal, like a crash
+	ResultSignal
+	// ResultExpectedFailure indicates that the command failed in a code block with the shelldocxfail option, which
+	// counts as passing
+	ResultExpectedFailure
+	// ResultUnexpectedPass indicates that the command passed in a code block with the shelldocxfail option, which
+	// counts as a failure
+	ResultUnexpectedPass
+	// ResultOutputLimit indicates that the output of the command exceeded the limit, and the command was terminated
+	// with the shell
+	ResultOutputLimit
+)
+
+// String returns a short name of the result code
+func (code ResultCode) String() string {
+	switch code {
+	case NewInteraction:
+		return "not executed"
+	case ResultExecutionError:
+		return "execution error"
+	case ResultError:
+		return "exit code mismatch"
+	case ResultMatch:
+		return "match"
+	case ResultRegexMatch:
+		return "regex match"
+	case ResultMismatch:
+		return "mismatch"
+	case ResultSkipped:
+		return "skipped"
+	case ResultTimeout:
+		return "timeout"
+	case ResultWaitingForInput:
+		return "waiting for input"
+	case ResultSignal:
+		return "terminated by signal"
+	case ResultExpectedFailure:
+		return "expected failure"
+	case ResultUnexpectedPass:
+		return "unexpected pass"
+	case ResultOutputLimit:
+		return "output limit exceeded"
+	default:
+		return fmt.Sprintf("ResultCode(%d)", int(code))
+	}
+}
+
+// MarshalText returns the name of the result code, so that it is serialized in a readable and stable form
+func (code ResultCode) MarshalText() ([]byte, error) {
+	if code < NewInteraction || code > ResultOutputLimit {
+		return nil, fmt.Errorf("unknown result code %d", int(code))
+	}
+	return []byte(code.String()), nil
+}
+
+// UnmarshalText parses the name of a result code
+func (code *ResultCode) UnmarshalText(text []byte) error {
+	for candidate := NewInteraction; candidate <= ResultOutputLimit; candidate++ {
+		if candidate.String() == string(text) {
+			*code = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown result code \"%s\"", string(text))
+}
+
+// SkipReason categorizes why an interaction was skipped, so that tools and reporters can tell skips apart
+type SkipReason string
+
+const (
+	// SkipRequested means that the code block asked to skip the interaction using the SkipOption
+	SkipRequested SkipReason = "requested"
+	// SkipMissingDependency means that the guard of the SkipIfOption succeeded, usually because something the
+	// commands need is not available
+	SkipMissingDependency SkipReason = "missing-dependency"
 )
 
+// Matcher decides if the output of a command matches the expected response
+// Matchers are selected using the shelldocmatcher option of a code block, and replace the default comparison.
+type Matcher interface {
+	Match(expected, actual []string) (bool, error)
+}
+
 // Interaction represents one interaction with the shell
+// Interactions can be marshaled to JSON and YAML using stable field names. Durations are serialized in nanoseconds.
 type Interaction struct {
+	// ID identifies the interaction across runs, even if its line changes, see Document.AssignIDs
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
 	// Cmd contains exactly the command the shell is supposed to execute
-	Cmd string
+	Cmd string `json:"command" yaml:"command"`
 	// Response contains the exected response from the shell, in plain text
-	Response []string
+	// If it is empty, any output is accepted, unless ExpectEmpty is set.
+	Response []string `json:"response" yaml:"response"`
+	// ExpectEmpty is true if the command is expected to produce no output at all, see EmptyOption
+	ExpectEmpty bool `json:"expect_empty,omitempty" yaml:"expect_empty,omitempty"`
 	//AlternativeRegEx string
 	// Language contains the language specified if the interaction was extracted from a fenced code block
-	Language string
+	Language string `json:"language,omitempty" yaml:"language,omitempty"`
+	// Variants contains the alternative expected responses for versions of tools, see SelectVariant
+	Variants []Variant `json:"variants,omitempty" yaml:"variants,omitempty"`
 	// Attributes contains the shelldoc attributes specified in a fenced code block
-	Attributes map[string]string
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
 	// Caption contains a descriptive name for the interaction
-	Caption string
-	// Result contains a human readable description of the result after the interaction has been executed
-	ResultCode int
+	Caption string `json:"caption,omitempty" yaml:"caption,omitempty"`
+	// Heading contains the text of the heading of the section the interaction is in
+	Heading string `json:"heading,omitempty" yaml:"heading,omitempty"`
+	// ResultCode contains the outcome of the interaction after it has been executed
+	ResultCode ResultCode `json:"result" yaml:"result"`
 	// Comment contains an explanation of the ResultCode after execution
-	Comment string
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
+	// SkipReason categorizes why the interaction was skipped if its ResultCode is ResultSkipped, the Comment explains
+	// it
+	SkipReason SkipReason `json:"skip_reason,omitempty" yaml:"skip_reason,omitempty"`
+	// Err contains the cause of a failure or an execution error after execution, and nil otherwise
+	// It is ErrTimeout, ErrOutputLimit, ErrWaitingForInput, ErrShellCrashed, a *MismatchError, an *ExitCodeError, a
+	// *SignalError, or another error if the command could not be executed. Err is not serialized, Comment describes it.
+	Err error `json:"-" yaml:"-"`
+	// ExitCode contains the exit code the command returned when it was executed
+	ExitCode int `json:"exit_code" yaml:"exit_code"`
+	// Signal contains the name of the signal that terminated the command, like SIGSEGV, if its exit code was
+	// unexpected and indicates one (see shell.SignalName)
+	Signal string `json:"signal,omitempty" yaml:"signal,omitempty"`
+	// Line contains the line number of the command in the document, or zero if unknown
+	Line int `json:"line" yaml:"line"`
+	// Duration contains the time it took to execute the command
+	Duration time.Duration `json:"duration" yaml:"duration"`
+	// Output contains the output of the command after the interaction has been executed
+	Output []string `json:"output" yaml:"output"`
+	// NoFinalNewline is true if the output of the command did not end with a line break, if the backend can tell (see
+	// shell.OutputInspector)
+	NoFinalNewline bool `json:"no_final_newline,omitempty" yaml:"no_final_newline,omitempty"`
+	// Stderr contains the error output of the command after the interaction has been executed
+	// It is not compared with the expected response, and empty if the backend does not capture it.
+	Stderr []string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	// Timeout is the time the command may take before it is terminated, zero means no timeout
+	// It can be overridden using the shelldoctimeout option of the code block.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Matcher compares the output with the expected response if the code block specifies the shelldocmatcher option
+	Matcher Matcher `json:"-" yaml:"-"`
+	// KeepCarriageReturns keeps the carriage returns at the end of the lines of output, they are removed before the
+	// output is compared otherwise
+	KeepCarriageReturns bool `json:"-" yaml:"-"`
 }
 
 // Describe returns a human-readable description of the interaction
@@ -52,44 +185,108 @@ func (interaction *Interaction) Describe() string {
 	const elideCmdAt = 40
 	const elideResponseAt = 25
 	format := fmt.Sprintf("%%-%ds  ?  %%-%ds", elideCmdAt, elideResponseAt)
-	name := interaction.Cmd
-	if len(interaction.Caption) != 0 {
-		name = interaction.Caption
-	}
-	expect := elideString(strings.Join(interaction.Response, ", "), elideResponseAt)
-	if len(expect) == 0 {
-		expect = "(no response expected)"
-	}
+	// the commands of a script are shown on one line
+	name := strings.Replace(interaction.Name(), "\n", "; ", -1)
+	expect := interaction.expectation(elideResponseAt)
 	result := fmt.Sprintf(format, elideString(name, elideCmdAt), expect)
 	return result
 }
 
-// Result returns a human readable description of the result of the interaction
+// expectation describes the expected response of the interaction on one line, elided at length unless it is zero
+func (interaction *Interaction) expectation(length int) string {
+	expect := elideString(strings.Join(interaction.Response, ", "), length)
+	if interaction.ExpectEmpty {
+		expect = "(no output expected)"
+	} else if len(expect) == 0 {
+		expect = "(no response expected)"
+	}
+	return expect
+}
+
+// Result returns a human readable description of the result of the interaction, in the locale selected by SetLocale
 func (interaction *Interaction) Result() string {
 	switch interaction.ResultCode {
 	case NewInteraction:
-		return "not executed"
+		return Translate(MessageNotExecuted)
 	case ResultExecutionError:
-		return "ERROR (result not evaluated)"
+		return Translate(MessageExecutionError)
 	case ResultMatch:
 		if len(interaction.Response) == 0 {
-			return "PASS (execution successful)"
+			return Translate(MessageExecutionSuccessful)
 		}
-		return "PASS (match)"
+		return Translate(MessageMatch)
 	case ResultRegexMatch:
-		return "PASS (regex match)"
+		return Translate(MessageRegexMatch)
 	case ResultMismatch:
-		return "FAIL (mismatch)"
+		return Translate(MessageMismatch)
 	case ResultError:
-		return "FAIL (execution failed)"
+		return Translate(MessageExecutionFailed)
+	case ResultTimeout:
+		return Translate(MessageTimeout)
+	case ResultWaitingForInput:
+		return Translate(MessageWaitingForInput)
+	case ResultSignal:
+		return Translate(MessageSignal, interaction.Signal)
+	case ResultExpectedFailure:
+		if len(interaction.Comment) > 0 {
+			return Translate(MessageExpectedFailureAs, interaction.Comment)
+		}
+		return Translate(MessageExpectedFailure)
+	case ResultUnexpectedPass:
+		return Translate(MessageUnexpectedPass)
+	case ResultOutputLimit:
+		return Translate(MessageOutputLimit)
+	case ResultSkipped:
+		reason := interaction.Comment
+		if interaction.SkipReason == SkipMissingDependency {
+			reason = strings.TrimSuffix(Translate(MessageMissingDependency)+": "+reason, ": ")
+		}
+		if len(reason) > 0 {
+			return Translate(MessageSkippedAs, reason)
+		}
+		return Translate(MessageSkipped)
 	default:
-		return "YOU FOUND A BUG!!11!1!"
+		return Translate(MessageUnknownResult, interaction.ResultCode)
+	}
+}
+
+// Name returns the caption of the interaction, or the command if there is no caption
+func (interaction *Interaction) Name() string {
+	if len(interaction.Caption) != 0 {
+		return interaction.Caption
+	}
+	return interaction.Cmd
+}
+
+// ComparedOutput returns the output that was compared with the expected response, which is the error output if the
+// code block selects it using the StreamOption, and the standard output otherwise
+func (interaction *Interaction) ComparedOutput() []string {
+	if interaction.Attributes[StreamOption] == StreamStderr {
+		return interaction.Stderr
 	}
+	return interaction.Output
 }
 
 // HasFailure returns true if the interaction failed (not on execution errors)
 func (interaction *Interaction) HasFailure() bool {
-	return interaction.ResultCode == ResultError || interaction.ResultCode == ResultMismatch
+	switch interaction.ResultCode {
+	case ResultError, ResultMismatch, ResultTimeout, ResultWaitingForInput, ResultSignal, ResultUnexpectedPass, ResultOutputLimit:
+		return true
+	}
+	return false
+}
+
+// TerminatedShell returns true if the shell was terminated with the command, because it timed out, its output
+// exceeded the limit, or the shell exited with it, the following interactions cannot be executed in it
+func (interaction *Interaction) TerminatedShell() bool {
+	return interaction.ResultCode == ResultTimeout || interaction.ResultCode == ResultOutputLimit || interaction.Err == ErrShellCrashed
+}
+
+// Quarantined returns true if the interaction failed in a code block with the shelldocflaky option
+// The failure of a quarantined interaction is reported, but does not fail the run.
+func (interaction *Interaction) Quarantined() bool {
+	_, flaky := interaction.Attributes[FlakyOption]
+	return flaky && interaction.HasFailure()
 }
 
 // New creates an empty interaction with a Caption
@@ -99,30 +296,258 @@ func New(caption string) *Interaction {
 	return interaction
 }
 
+// NoNewlineMarker ends an expected response if the output of the command does not end with a line break, like in a
+// unified diff
+const NoNewlineMarker = "\\ No newline at end of output"
+
 // evaluateResponse compares the output to the expected response, and respects "ellipsis" (don't care from here on forward)
 func (interaction *Interaction) evaluateResponse(response []string) bool {
-	output := response
-	expected := interaction.Response
-	for index, line := range interaction.Response {
+	return interaction.compareResponse(response) < 0
+}
+
+// compareResponse compares the output to the expected response like evaluateResponse, and returns the index of the
+// first line that differs, or -1 if the output matches
+func (interaction *Interaction) compareResponse(response []string) int {
+	if len(interaction.Response) == 0 {
+		// without a response, any output is accepted, unless no output is expected at all
+		if interaction.ExpectEmpty && len(response) > 0 {
+			return 0
+		}
+		return -1
+	}
+	expected, noNewline := interaction.expectedLines()
+	for index, line := range expected {
 		if strings.TrimSpace(line) == "..." {
-			output = response[:index]
-			expected = interaction.Response[:index]
-			break
+			output := response
+			if len(output) > index {
+				output = response[:index]
+			}
+			return compareLines(expected[:index], output)
 		}
 	}
-	if len(output) == 0 && len(expected) == 0 {
-		return true
+	if divergence := compareLines(expected, response); divergence >= 0 {
+		return divergence
+	}
+	if noNewline != interaction.NoFinalNewline {
+		// only the line break at the end of the output differs, which is part of its last line
+		if len(response) == 0 {
+			return 0
+		}
+		return len(response) - 1
+	}
+	return -1
+}
+
+// expectedLines returns the lines of the expected response without the NoNewlineMarker, and whether it ends with it
+func (interaction *Interaction) expectedLines() ([]string, bool) {
+	last := len(interaction.Response) - 1
+	if last >= 0 && strings.TrimSpace(interaction.Response[last]) == NoNewlineMarker {
+		return interaction.Response[:last], true
+	}
+	return interaction.Response, false
+}
+
+// compareLines returns the index of the first line that differs between expected and actual, or -1 if they are equal
+// If one is a prefix of the other, the index is the length of the shorter one. Nil and empty slices are equal. Expected
+// lines starting with PatternPrefix are regular expressions.
+func compareLines(expected, actual []string) int {
+	for index := range expected {
+		if index == len(actual) || !lineMatches(expected[index], actual[index]) {
+			return index
+		}
+	}
+	if len(actual) > len(expected) {
+		return len(expected)
 	}
-	return reflect.DeepEqual(output, expected)
+	return -1
 }
 
 // Execute the interaction and store the result
-func (interaction *Interaction) Execute(shell *shell.Shell) error {
-	// execute the command in the shell
-	output, rc, err := shell.ExecuteCommand(interaction.Cmd)
+// Interactions in code blocks with the shelldocskip option are not executed, the value of the option is recorded as
+// the reason for skipping it.
+func (interaction *Interaction) Execute(backend shell.Backend) error {
+	return interaction.ExecuteContext(context.Background(), backend)
+}
+
+// ExecuteContext executes the interaction like Execute, and terminates the command if the context is done before it
+// finished
+// An expired deadline is reported as a timeout, like the Timeout of the interaction. If the context is cancelled, the
+// interaction is an execution error. If an error is returned, like for invalid options, the result of the interaction
+// is always ResultExecutionError.
+func (interaction *Interaction) ExecuteContext(ctx context.Context, backend shell.Backend) error {
+	if err := interaction.poll(ctx, backend); err != nil {
+		if interaction.ResultCode != ResultExecutionError {
+			interaction.ResultCode = ResultExecutionError
+			interaction.Comment = err.Error()
+			interaction.Err = err
+		}
+		return err
+	}
+	if reason, ok := interaction.Attributes[XFailOption]; ok {
+		interaction.expectFailure(reason)
+	}
+	return nil
+}
+
+// expectFailure inverts the result of an interaction in a code block with the shelldocxfail option
+// Failures count as passing, and passing counts as a failure. Timeouts remain failures, since the shell was terminated
+// with the command, and execution errors remain errors.
+func (interaction *Interaction) expectFailure(reason string) {
+	switch interaction.ResultCode {
+	case ResultError, ResultMismatch, ResultWaitingForInput, ResultSignal:
+		interaction.ResultCode = ResultExpectedFailure
+		if len(reason) > 0 {
+			interaction.Comment = reason
+		} else if interaction.Err != nil {
+			interaction.Comment = interaction.Err.Error()
+		}
+		interaction.Err = nil
+	case ResultMatch, ResultRegexMatch:
+		interaction.ResultCode = ResultUnexpectedPass
+		interaction.Err = &UnexpectedPassError{Reason: reason}
+		interaction.Comment = interaction.Err.Error()
+	}
+}
+
+// DefaultWaitInterval is the pause between the attempts of commands with the WaitForOption, if the code block does not
+// specify the WaitIntervalOption
+const DefaultWaitInterval = time.Second
+
+// poll executes the interaction, and if the code block has the WaitForOption, executes it again after the wait
+// interval as long as it fails, until the time of the option expired
+// Only failing exit codes, mismatching output and signals are retried, a command that timed out is not. The result of
+// the last attempt is recorded, the duration covers all attempts.
+func (interaction *Interaction) poll(ctx context.Context, backend shell.Backend) error {
+	value, ok := interaction.Attributes[WaitForOption]
+	if !ok {
+		return interaction.execute(ctx, backend)
+	}
+	limit, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("argument to %s needs to be a duration, got \"%s\"", WaitForOption, value)
+	}
+	interval := DefaultWaitInterval
+	if value, ok := interaction.Attributes[WaitIntervalOption]; ok {
+		if interval, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("argument to %s needs to be a duration, got \"%s\"", WaitIntervalOption, value)
+		}
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if err := interaction.execute(ctx, backend); err != nil {
+			return err
+		}
+		switch interaction.ResultCode {
+		case ResultError, ResultMismatch, ResultSignal:
+		default:
+			interaction.Duration = time.Since(start)
+			return nil
+		}
+		if time.Since(start)+interval > limit {
+			interaction.Duration = time.Since(start)
+			gaveUp := fmt.Sprintf("still failing after %d attempts within %v", attempt, limit)
+			if len(interaction.Comment) > 0 {
+				gaveUp = interaction.Comment + ", " + gaveUp
+			}
+			interaction.Comment = gaveUp
+			return nil
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			interaction.Duration = time.Since(start)
+			return nil
+		}
+	}
+}
+
+// execute executes the interaction for ExecuteContext, without the shelldocxfail option
+func (interaction *Interaction) execute(ctx context.Context, backend shell.Backend) error {
+	interaction.Err = nil
+	if reason, ok := interaction.Attributes[SkipOption]; ok {
+		interaction.ResultCode = ResultSkipped
+		interaction.Comment = reason
+		interaction.SkipReason = SkipRequested
+		return nil
+	}
+	timeout := interaction.Timeout
+	if timeoutOption, ok := interaction.Attributes[TimeoutOption]; ok {
+		value, err := time.ParseDuration(timeoutOption)
+		if err != nil {
+			return fmt.Errorf("argument to %s needs to be a duration, got \"%s\"", TimeoutOption, timeoutOption)
+		}
+		timeout = value
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// execute the command in the backend, only the standard output is compared unless the code block selects a stream
+	command := interaction.Cmd
+	stream := interaction.Attributes[StreamOption]
+	if stream == StreamCombined {
+		// both streams are written to the same file descriptor, which keeps their order
+		command = fmt.Sprintf("{ %s\n} 2>&1", strings.TrimSpace(command))
+	}
+	if niceness, ok := interaction.Attributes[NiceOption]; ok {
+		command = withNiceness(command, niceness)
+	}
+	environment := interaction.Attributes[EnvOption]
+	if columns, ok := interaction.Attributes[ColumnsOption]; ok {
+		// a COLUMNS variable in the EnvOption takes precedence
+		environment = strings.TrimSpace(fmt.Sprintf("COLUMNS=%s %s", columns, environment))
+	}
+	if len(environment) > 0 {
+		command = withEnvironment(command, environment)
+	}
+	start := time.Now()
+	output, stderr, rc, err := backend.Execute(ctx, command)
+	interaction.Duration = time.Since(start)
+	interaction.ExitCode = rc
+	interaction.Signal = ""
+	interaction.NoFinalNewline = false
+	if inspector, ok := backend.(shell.OutputInspector); ok && err == nil && stream != StreamStderr {
+		interaction.NoFinalNewline = !inspector.LastOutput().FinalNewline
+	}
+	// invalid UTF-8 is replaced, like in the expected response, so that it is compared and reported consistently
+	output = validLines(output)
+	stderr = validLines(stderr)
+	if !interaction.KeepCarriageReturns {
+		output = trimCarriageReturns(output)
+		stderr = trimCarriageReturns(stderr)
+	}
+	interaction.Output = output
+	interaction.Stderr = stderr
+	if stream == StreamStderr {
+		output = stderr
+	}
+	if isTimeout(err) {
+		interaction.ResultCode = ResultTimeout
+		interaction.Err = ErrTimeout
+		if timeout > 0 {
+			interaction.Comment = fmt.Sprintf("command did not finish within %v", timeout)
+		} else {
+			interaction.Comment = "command did not finish before the deadline"
+		}
+		return nil
+	}
+	if err == ErrOutputLimit {
+		interaction.ResultCode = ResultOutputLimit
+		interaction.Err = ErrOutputLimit
+		interaction.Comment = "output limit exceeded, the command was terminated"
+		return nil
+	}
+	if err == ErrWaitingForInput {
+		// the command received the end of its input and finished, the shell is still usable
+		interaction.ResultCode = ResultWaitingForInput
+		interaction.Err = ErrWaitingForInput
+		interaction.Comment = "command appears to be waiting for input, which is empty"
+		return nil
+	}
 	// compare the results
-	const ExitCodeOption = "shelldocexitcode"
-	const ExitCodeWhatever = "shelldocwhatever"
 	var expectedExitCode int
 	if expectedExitCodeOption, ok := interaction.Attributes[ExitCodeOption]; ok {
 		if value, err := strconv.Atoi(expectedExitCodeOption); err == nil {
@@ -132,17 +557,29 @@ func (interaction *Interaction) Execute(shell *shell.Shell) error {
 		}
 	}
 	expectedWhatever := false
-	if _, ok := interaction.Attributes[ExitCodeWhatever]; ok {
+	if _, ok := interaction.Attributes[WhateverOption]; ok {
 		expectedWhatever = true
 	}
+	if name, ok := interaction.Attributes[MatcherOption]; ok && err == nil && (expectedWhatever || rc == expectedExitCode) {
+		if err = interaction.match(name, output); err == nil {
+			return nil
+		}
+	}
 	if err != nil {
 		interaction.ResultCode = ResultExecutionError
 		interaction.Comment = err.Error()
+		interaction.Err = err
 		return fmt.Errorf("unable to execute command: %v", err)
+	} else if signal := shell.SignalName(rc); expectedWhatever == false && rc != expectedExitCode && len(signal) > 0 {
+		interaction.ResultCode = ResultSignal
+		interaction.Signal = signal
+		interaction.Err = &SignalError{Signal: signal, ExitCode: rc}
+		interaction.Comment = interaction.Err.Error()
 	} else if expectedWhatever == false && rc != expectedExitCode {
 		interaction.ResultCode = ResultError
-		interaction.Comment = fmt.Sprintf("command exited with non-zero exit code %d", rc)
-	} else if interaction.evaluateResponse(output) {
+		interaction.Err = &ExitCodeError{Expected: expectedExitCode, Actual: rc}
+		interaction.Comment = interaction.Err.Error()
+	} else if divergence := interaction.compareResponse(output); divergence < 0 {
 		interaction.ResultCode = ResultMatch
 		interaction.Comment = ""
 	} else if interaction.compareRegex(output) {
@@ -150,10 +587,48 @@ func (interaction *Interaction) Execute(shell *shell.Shell) error {
 	} else {
 		interaction.ResultCode = ResultMismatch
 		interaction.Comment = ""
+		expected, _ := interaction.expectedLines()
+		interaction.Err = &MismatchError{
+			Expected:       interaction.Response,
+			Actual:         output,
+			Line:           divergence + 1,
+			ExpectEmpty:    interaction.ExpectEmpty,
+			NewlineOnly:    len(expected) > 0 && compareLines(expected, output) < 0,
+			NoFinalNewline: interaction.NoFinalNewline,
+		}
+	}
+	return nil
+}
+
+// match compares the output using the matcher selected by the shelldocmatcher option, and records the result
+func (interaction *Interaction) match(name string, output []string) error {
+	matcher := BuiltinMatcher(name)
+	if matcher == nil {
+		matcher = interaction.Matcher
+	}
+	if matcher == nil {
+		return fmt.Errorf("no matcher available for %s=%s", MatcherOption, name)
+	}
+	matched, err := matcher.Match(interaction.Response, output)
+	if err != nil {
+		return fmt.Errorf("matcher %s failed: %v", name, err)
+	}
+	if matched {
+		interaction.ResultCode = ResultMatch
+		interaction.Comment = ""
+	} else {
+		interaction.ResultCode = ResultMismatch
+		interaction.Comment = ""
+		interaction.Err = &MismatchError{Expected: interaction.Response, Actual: output}
 	}
 	return nil
 }
 
+// isTimeout returns true if the error indicates that the command timed out
+func isTimeout(err error) bool {
+	return err == ErrTimeout
+}
+
 func (interaction *Interaction) compareRegex(output []string) bool {
 	// match, err := regexp.MatchString(interaction.AlternativeRegEx, output); err
 	return false
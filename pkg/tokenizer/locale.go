@@ -0,0 +1,212 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LocaleMatcherName is the name of the LocaleMatcher for the shelldocmatcher option
+const LocaleMatcherName = "locale"
+
+var (
+	// dateRx matches the candidates for dates with numeric components, like 2026-10-15, 15.10.2026 or 10/15/2026
+	dateRx = regexp.MustCompile(`\b([0-9]{1,4})([./-])([0-9]{1,2})([./-])([0-9]{1,4})\b`)
+	// numberRx matches numbers with separators for decimals and groups of digits, like 1,234.5 or 1.234,5
+	numberRx = regexp.MustCompile(`[0-9]+(?:[.,'\x{a0}\x{202f}][0-9]+)*`)
+)
+
+// LocaleMatcher compares the output with the expected response like the default comparison, but accepts numbers and
+// dates formatted for another locale
+// Numbers may use a decimal point or a decimal comma, and group their digits with commas, points, apostrophes or
+// non-breaking spaces. Dates may be written as 2026-10-15, 15.10.2026, 15/10/2026 or 10/15/2026. Numbers and dates
+// match if they can be read as the same value, all other text needs to be equal. An ellipsis accepts any output from
+// its line on.
+type LocaleMatcher struct{}
+
+// localeToken is a part of a line, either literal text or a number or date that can be read as one of the values
+type localeToken struct {
+	text   string
+	values []string
+}
+
+// Match compares the output with the expected response
+func (LocaleMatcher) Match(expected, actual []string) (bool, error) {
+	for index, line := range expected {
+		if strings.TrimSpace(line) == "..." {
+			return true, nil
+		}
+		if index >= len(actual) || !localeEqual(line, actual[index]) {
+			return false, nil
+		}
+	}
+	return len(expected) == len(actual), nil
+}
+
+// localeEqual returns true if the lines are equal, except for the formatting of their numbers and dates
+func localeEqual(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	want, got := localeTokens(expected), localeTokens(actual)
+	if len(want) != len(got) {
+		return false
+	}
+	for index := range want {
+		if want[index].values == nil || got[index].values == nil {
+			if want[index].values != nil || got[index].values != nil || want[index].text != got[index].text {
+				return false
+			}
+		} else if !intersects(want[index].values, got[index].values) {
+			return false
+		}
+	}
+	return true
+}
+
+// localeTokens splits a line into literal text, dates and numbers
+func localeTokens(line string) []localeToken {
+	var tokens []localeToken
+	for len(line) > 0 {
+		start, end, values := nextValue(line)
+		if start < 0 {
+			tokens = append(tokens, localeToken{text: line})
+			break
+		}
+		if start > 0 {
+			tokens = append(tokens, localeToken{text: line[:start]})
+		}
+		tokens = append(tokens, localeToken{text: line[start:end], values: values})
+		line = line[end:]
+	}
+	return tokens
+}
+
+// nextValue finds the first date or number in the text and returns its position and the values it can be read as,
+// or -1 if there is none
+func nextValue(text string) (int, int, []string) {
+	numberStart, numberEnd := -1, -1
+	if match := numberRx.FindStringIndex(text); match != nil {
+		numberStart, numberEnd = match[0], match[1]
+	}
+	for _, match := range dateRx.FindAllStringSubmatchIndex(text, -1) {
+		if numberStart >= 0 && match[0] > numberStart {
+			break
+		}
+		if dates := readDate(text, match); len(dates) > 0 {
+			return match[0], match[1], dates
+		}
+	}
+	if numberStart < 0 {
+		return -1, -1, nil
+	}
+	return numberStart, numberEnd, readNumber(text[numberStart:numberEnd])
+}
+
+// readDate returns the dates a match of dateRx can be read as, in the form 2006-01-02
+// Components separated by points are read day first, those separated by slashes or dashes both day and month first,
+// unless they start with the year.
+func readDate(text string, match []int) []string {
+	first, separator, second, other, third := text[match[2]:match[3]], text[match[4]:match[5]], text[match[6]:match[7]], text[match[8]:match[9]], text[match[10]:match[11]]
+	if separator != other {
+		return nil
+	}
+	number := func(component string) int {
+		value, _ := strconv.Atoi(component)
+		return value
+	}
+	var candidates [][3]int
+	switch {
+	case len(first) == 4 && len(third) <= 2:
+		candidates = append(candidates, [3]int{number(first), number(second), number(third)})
+	case len(third) == 4 && len(first) <= 2:
+		candidates = append(candidates, [3]int{number(third), number(second), number(first)})
+		if separator != "." {
+			candidates = append(candidates, [3]int{number(third), number(first), number(second)})
+		}
+	}
+	var dates []string
+	for _, date := range candidates {
+		if date[1] >= 1 && date[1] <= 12 && date[2] >= 1 && date[2] <= 31 {
+			dates = append(dates, fmt.Sprintf("%04d-%02d-%02d", date[0], date[1], date[2]))
+		}
+	}
+	return dates
+}
+
+// readNumber returns the values a number can be read as, with a decimal point and without grouping
+// Numbers that cannot be read with either a decimal point or a decimal comma, like version numbers, are only equal
+// to themselves.
+func readNumber(text string) []string {
+	var values []string
+	for _, decimal := range []rune{'.', ','} {
+		if value, ok := normalizeNumber(text, decimal); ok && !contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		values = append(values, text)
+	}
+	return values
+}
+
+// normalizeNumber reads the number with the decimal separator, all other separators need to group three digits
+// Trailing zeros of the fraction are removed, since they do not change the value, so that 1.50 equals 1.5.
+func normalizeNumber(text string, decimal rune) (string, bool) {
+	var digits strings.Builder
+	group := -1 // the number of digits since the last group separator, or -1 before the first one
+	fraction := false
+	for _, character := range text {
+		switch {
+		case character >= '0' && character <= '9':
+			digits.WriteRune(character)
+			if group >= 0 {
+				group++
+			}
+		case character == decimal:
+			if fraction || (group >= 0 && group != 3) {
+				return "", false
+			}
+			fraction = true
+			group = -1
+			digits.WriteRune('.')
+		default:
+			if fraction || (group >= 0 && group != 3) {
+				return "", false
+			}
+			group = 0
+		}
+	}
+	if group >= 0 && group != 3 {
+		return "", false
+	}
+	if !fraction {
+		return digits.String(), true
+	}
+	return strings.TrimSuffix(strings.TrimRight(digits.String(), "0"), "."), true
+}
+
+// intersects returns true if the lists have a value in common
+func intersects(a, b []string) bool {
+	for _, value := range a {
+		if contains(b, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if the list contains the value
+func contains(list []string, value string) bool {
+	for _, candidate := range list {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
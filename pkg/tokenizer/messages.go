@@ -0,0 +1,150 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultLocale is the locale of the messages if no other locale is selected
+const DefaultLocale = "en"
+
+// Message identifies a translatable message in the results and summaries of a run
+type Message string
+
+// The messages in the catalogs
+// The keywords PASS, FAIL, XFAIL, XPASS, SKIPPED, ERROR, SUCCESS and FAILURE are the same in all locales, so that
+// scripts reading the output do not depend on the locale, the explanations after them are translated.
+const (
+	MessageNotExecuted         Message = "not-executed"
+	MessageExecutionError      Message = "execution-error"
+	MessageExecutionSuccessful Message = "execution-successful"
+	MessageMatch               Message = "match"
+	MessageRegexMatch          Message = "regex-match"
+	MessageMismatch            Message = "mismatch"
+	MessageExecutionFailed     Message = "execution-failed"
+	MessageTimeout             Message = "timeout"
+	MessageWaitingForInput     Message = "waiting-for-input"
+	MessageSignal              Message = "signal"
+	MessageExpectedFailure     Message = "expected-failure"
+	MessageExpectedFailureAs   Message = "expected-failure-as"
+	MessageUnexpectedPass      Message = "unexpected-pass"
+	MessageOutputLimit         Message = "output-limit"
+	MessageSkipped             Message = "skipped"
+	MessageSkippedAs           Message = "skipped-as"
+	MessageMissingDependency   Message = "missing-dependency"
+	MessageUnknownResult       Message = "unknown-result"
+	MessageExpectedLine        Message = "expected-line"
+	MessageReceivedLine        Message = "received-line"
+	// MessageSummary is formatted with the verdict and the numbers of tests, successful tests, failures, execution
+	// errors and skipped tests
+	MessageSummary Message = "summary"
+	// MessageCachedSummary is formatted with the document, the verdict and the numbers of tests, successful tests and
+	// skipped tests
+	MessageCachedSummary Message = "cached-summary"
+)
+
+// catalog contains the format strings of the messages in one locale, formatted like fmt.Sprintf
+// Arguments can be reordered using explicit indexes, like %[2]d.
+type catalog map[Message]string
+
+// catalogs contains the message catalogs by locale
+var catalogs = map[string]catalog{
+	"en": {
+		MessageNotExecuted:         "not executed",
+		MessageExecutionError:      "ERROR (result not evaluated)",
+		MessageExecutionSuccessful: "PASS (execution successful)",
+		MessageMatch:               "PASS (match)",
+		MessageRegexMatch:          "PASS (regex match)",
+		MessageMismatch:            "FAIL (mismatch)",
+		MessageExecutionFailed:     "FAIL (execution failed)",
+		MessageTimeout:             "FAIL (timeout)",
+		MessageWaitingForInput:     "FAIL (waiting for input)",
+		MessageSignal:              "FAIL (terminated by %s)",
+		MessageExpectedFailure:     "XFAIL",
+		MessageExpectedFailureAs:   "XFAIL (%s)",
+		MessageUnexpectedPass:      "XPASS (unexpectedly passing)",
+		MessageOutputLimit:         "FAIL (output limit exceeded)",
+		MessageSkipped:             "SKIPPED",
+		MessageSkippedAs:           "SKIPPED (%s)",
+		MessageMissingDependency:   "missing dependency",
+		MessageUnknownResult:       "ERROR (unknown result %d)",
+		MessageExpectedLine:        "expected",
+		MessageReceivedLine:        "received",
+		MessageSummary:             "%s: %d tests (%d successful, %d failures, %d execution errors, %d skipped)",
+		MessageCachedSummary:       "SHELLDOC: \"%s\" is unchanged, cached %s: %d tests (%d successful, %d skipped)",
+	},
+	"de": {
+		MessageNotExecuted:         "nicht ausgeführt",
+		MessageExecutionError:      "ERROR (Ergebnis nicht ausgewertet)",
+		MessageExecutionSuccessful: "PASS (erfolgreich ausgeführt)",
+		MessageMatch:               "PASS (stimmt überein)",
+		MessageRegexMatch:          "PASS (stimmt mit dem regulären Ausdruck überein)",
+		MessageMismatch:            "FAIL (weicht ab)",
+		MessageExecutionFailed:     "FAIL (Ausführung fehlgeschlagen)",
+		MessageTimeout:             "FAIL (Zeitüberschreitung)",
+		MessageWaitingForInput:     "FAIL (wartet auf Eingabe)",
+		MessageSignal:              "FAIL (durch %s beendet)",
+		MessageExpectedFailure:     "XFAIL",
+		MessageExpectedFailureAs:   "XFAIL (%s)",
+		MessageUnexpectedPass:      "XPASS (unerwartet erfolgreich)",
+		MessageOutputLimit:         "FAIL (Ausgabelimit überschritten)",
+		MessageSkipped:             "SKIPPED",
+		MessageSkippedAs:           "SKIPPED (%s)",
+		MessageMissingDependency:   "fehlende Abhängigkeit",
+		MessageUnknownResult:       "ERROR (unbekanntes Ergebnis %d)",
+		MessageExpectedLine:        "erwartet",
+		MessageReceivedLine:        "erhalten",
+		MessageSummary:             "%s: %d Tests (%d erfolgreich, %d Fehlschläge, %d Ausführungsfehler, %d übersprungen)",
+		MessageCachedSummary:       "SHELLDOC: \"%s\" ist unverändert, zwischengespeichert %s: %d Tests (%d erfolgreich, %d übersprungen)",
+	},
+}
+
+// locale is the selected locale, DefaultLocale if it is not set
+var locale atomic.Value
+
+// Locales returns the locales that have a message catalog
+func Locales() []string {
+	var result []string
+	for name := range catalogs {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SetLocale selects the catalog of the messages
+// The locale can be given like the LANG environment variable, like de_DE.UTF-8, only its language is used. An empty
+// locale selects the DefaultLocale.
+func SetLocale(name string) error {
+	language := DefaultLocale
+	if fields := strings.FieldsFunc(name, func(character rune) bool {
+		return strings.ContainsRune("_-.@", character)
+	}); len(fields) > 0 {
+		language = strings.ToLower(fields[0])
+	}
+	if language == "c" || language == "posix" {
+		language = DefaultLocale
+	}
+	if _, ok := catalogs[language]; !ok {
+		return fmt.Errorf("unsupported locale \"%s\", supported are %s", name, strings.Join(Locales(), ", "))
+	}
+	locale.Store(language)
+	return nil
+}
+
+// Translate returns the message in the selected locale, formatted with the arguments
+// Messages missing in the catalog of the locale are taken from the catalog of the DefaultLocale.
+func Translate(message Message, args ...interface{}) string {
+	language, _ := locale.Load().(string)
+	format, ok := catalogs[language][message]
+	if !ok {
+		format = catalogs[DefaultLocale][message]
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,225 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nameRx matches the names of shell variables and functions
+var nameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The options that can be specified in the info string of a fenced code block
+const (
+	// ExitCodeOption specifies the exit code the commands are expected to return
+	ExitCodeOption = "shelldocexitcode"
+	// WhateverOption specifies that the exit code of the commands does not matter
+	WhateverOption = "shelldocwhatever"
+	// SkipOption specifies that the commands are not executed, the optional value is the reason
+	SkipOption = "shelldocskip"
+	// SkipIfOption specifies a guard command that is executed before the code block, its commands are skipped if the
+	// guard succeeds, with the output of the guard as the reason
+	// Guards that contain spaces are quoted, like shelldocskipif="! command -v docker".
+	SkipIfOption = "shelldocskipif"
+	// NoTestOption marks a code block that is not meant to be tested, like an example script, its commands are not
+	// executed
+	NoTestOption = "shelldocnotest"
+	// XFailOption specifies that the commands are expected to fail, the optional value is the reason
+	XFailOption = "shelldocxfail"
+	// FlakyOption quarantines commands that are known to be flaky, their failures are reported but do not fail the run
+	// The value refers to the issue that tracks the flakiness, like its URL.
+	FlakyOption = "shelldocflaky"
+	// TimeoutOption specifies the time each command may take
+	TimeoutOption = "shelldoctimeout"
+	// FileTimeoutOption specifies the time all commands in the document may take, measured from its start
+	FileTimeoutOption = "shelldocfiletimeout"
+	// WaitForOption executes the commands again until they pass, for as long as specified by its value, for commands
+	// that wait for something started asynchronously, like a container becoming healthy
+	WaitForOption = "shelldocwaitfor"
+	// WaitIntervalOption specifies the pause between the attempts of commands with the WaitForOption
+	WaitIntervalOption = "shelldocwaitinterval"
+	// DelayOption specifies the pause before each command, for services that need time to settle or APIs with rate
+	// limits
+	DelayOption = "shelldocdelay"
+	// MatcherOption specifies the name of the matcher that compares the output with the expected response
+	MatcherOption = "shelldocmatcher"
+	// SetupOption marks a code block that prepares the following ones, it is executed even if only a later code block
+	// is selected
+	SetupOption = "shelldocsetup"
+	// CheckpointOption records the state of the shell after the code block passed under the name given as its value,
+	// so that later code blocks and later runs can restore it
+	CheckpointOption = "shelldoccheckpoint"
+	// RestoreOption restores the state of the shell recorded by the checkpoint named by its value before the code
+	// block is executed
+	RestoreOption = "shelldocrestore"
+	// StreamOption specifies the output the expected responses are compared with, the standard output (StreamStdout,
+	// the default), the error output (StreamStderr) or both interleaved like in a terminal (StreamCombined)
+	StreamOption = "shelldocstream"
+	// EmptyOption specifies that the commands without a response are expected to produce no output, any output is
+	// accepted from them otherwise
+	EmptyOption = "shelldocempty"
+	// ScriptOption specifies that the commands of the code block are executed together as one script, they pass or
+	// fail as one interaction
+	ScriptOption = "shelldocscript"
+	// ExpectEnvOption specifies environment variables that are expected to be set to a value after the code block,
+	// as a comma separated list like NAME=value,OTHER=value
+	ExpectEnvOption = "shelldocexpectenv"
+	// ExpectCwdOption specifies the working directory the shell is expected to be in after the code block, a relative
+	// path matches the end of the working directory
+	ExpectCwdOption = "shelldocexpectcwd"
+	// ExpectFunctionOption specifies shell functions that are expected to be defined after the code block, as a comma
+	// separated list of names
+	ExpectFunctionOption = "shelldocexpectfunction"
+	// ExpectFileOption specifies files or directories that are expected to exist after the code block, as a comma
+	// separated list of paths
+	ExpectFileOption = "shelldocexpectfile"
+	// ExpectFileContainsOption specifies text that files are expected to contain after the code block, as a comma
+	// separated list like path:text,other:text
+	ExpectFileContainsOption = "shelldocexpectfilecontains"
+	// ExpectDirEmptyOption specifies directories that are expected to exist and to be empty after the code block, as
+	// a comma separated list of paths
+	ExpectDirEmptyOption = "shelldocexpectdirempty"
+	// EnvOption specifies environment variables that are set for each command of the code block only, as a space
+	// separated list like shelldocenv="NAME=value OTHER=value", they are restored after the command
+	EnvOption = "shelldocenv"
+	// ColumnsOption specifies the terminal width the expected responses of the code block were captured at, it is set
+	// in COLUMNS for each of its commands, like with the EnvOption
+	ColumnsOption = "shelldoccolumns"
+	// NiceOption increases the niceness of the commands of the code block by a number between 0 and 19, so that
+	// heavyweight examples like builds compete less with the rest of the system, they run in a subshell
+	NiceOption = "shelldocnice"
+	// SerialOption specifies that the code block is never executed at the same time as a code block with the same
+	// option in another run of shelldoc on the same machine, like parallel CI jobs
+	SerialOption = "shelldocserial"
+)
+
+// The values of the StreamOption
+const (
+	// StreamStdout compares the standard output with the expected response, the error output is recorded separately
+	StreamStdout = "stdout"
+	// StreamStderr compares the error output with the expected response, if the backend captures it
+	StreamStderr = "stderr"
+	// StreamCombined redirects the error output of the commands to their standard output, so that both are compared
+	// with the expected response, interleaved in the order they were written
+	StreamCombined = "combined"
+)
+
+// Diagnostic describes a problem in a document that was found without executing it
+type Diagnostic struct {
+	// Line contains the line number the problem was found in, or zero if unknown
+	Line int
+	// Message describes the problem
+	Message string
+}
+
+// ValidateOptions checks the options of a fenced code block and returns a description of every problem found
+func ValidateOptions(attributes map[string]string) []string {
+	var problems []string
+	var keys []string
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := attributes[key]
+		switch key {
+		case ExitCodeOption:
+			if _, err := strconv.Atoi(value); err != nil {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be an integer, got \"%s\"", key, value))
+			}
+		case WhateverOption, SetupOption, EmptyOption, ScriptOption, NoTestOption, SerialOption:
+			if len(value) > 0 {
+				problems = append(problems, fmt.Sprintf("%s does not take an argument, got \"%s\"", key, value))
+			}
+		case SkipOption, XFailOption:
+			// any reason is fine
+		case TimeoutOption, FileTimeoutOption, WaitForOption, WaitIntervalOption:
+			if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be a positive duration like 30s, got \"%s\"", key, value))
+			}
+		case DelayOption:
+			if duration, err := time.ParseDuration(value); err != nil || duration < 0 {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be a duration like 500ms, got \"%s\"", key, value))
+			}
+		case SkipIfOption:
+			if len(value) == 0 {
+				problems = append(problems, fmt.Sprintf("%s needs a guard command as its argument", key))
+			}
+		case StreamOption:
+			if value != StreamStdout && value != StreamStderr && value != StreamCombined {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be one of %s, %s or %s, got \"%s\"", key, StreamStdout, StreamStderr, StreamCombined, value))
+			}
+		case MatcherOption:
+			if len(value) == 0 {
+				problems = append(problems, fmt.Sprintf("%s needs the name of a matcher as its argument", key))
+			}
+		case CheckpointOption, RestoreOption:
+			if len(value) == 0 {
+				problems = append(problems, fmt.Sprintf("%s needs the name of a checkpoint as its argument", key))
+			}
+		case ExpectEnvOption:
+			for _, assignment := range strings.Split(value, ",") {
+				if name := strings.SplitN(assignment, "=", 2)[0]; !strings.Contains(assignment, "=") || !nameRx.MatchString(name) {
+					problems = append(problems, fmt.Sprintf("argument to %s needs to be a list like NAME=value,OTHER=value, got \"%s\"", key, value))
+					break
+				}
+			}
+		case ColumnsOption:
+			if columns, err := strconv.Atoi(value); err != nil || columns <= 0 {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be a positive number of columns, got \"%s\"", key, value))
+			}
+		case NiceOption:
+			if _, ok := parseNiceness(value); !ok {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be a niceness between 0 and 19, got \"%s\"", key, value))
+			}
+		case EnvOption:
+			if _, ok := parseEnvironment(value); !ok {
+				problems = append(problems, fmt.Sprintf("argument to %s needs to be a list like \"NAME=value OTHER=value\", got \"%s\"", key, value))
+			}
+		case FlakyOption:
+			if len(value) == 0 {
+				problems = append(problems, fmt.Sprintf("%s needs the issue that tracks the flakiness as its argument, like its URL", key))
+			}
+		case ExpectCwdOption:
+			if len(value) == 0 {
+				problems = append(problems, fmt.Sprintf("%s needs a directory as its argument", key))
+			}
+		case ExpectFileOption, ExpectDirEmptyOption:
+			for _, name := range strings.Split(value, ",") {
+				if len(name) == 0 {
+					problems = append(problems, fmt.Sprintf("argument to %s needs to be a list of paths, got \"%s\"", key, value))
+					break
+				}
+			}
+		case ExpectFileContainsOption:
+			for _, entry := range strings.Split(value, ",") {
+				if parts := strings.SplitN(entry, ":", 2); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+					problems = append(problems, fmt.Sprintf("argument to %s needs to be a list like path:text,other:text, got \"%s\"", key, value))
+					break
+				}
+			}
+		case ExpectFunctionOption:
+			for _, name := range strings.Split(value, ",") {
+				if !nameRx.MatchString(name) {
+					problems = append(problems, fmt.Sprintf("argument to %s needs to be a list of function names, got \"%s\"", key, value))
+					break
+				}
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unknown option %s", key))
+		}
+	}
+	_, hasExitCode := attributes[ExitCodeOption]
+	_, hasWhatever := attributes[WhateverOption]
+	if hasExitCode && hasWhatever {
+		problems = append(problems, fmt.Sprintf("%s and %s contradict each other", ExitCodeOption, WhateverOption))
+	}
+	return problems
+}
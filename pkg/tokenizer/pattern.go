@@ -0,0 +1,63 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PatternPrefix marks a line of an expected response as a regular expression, which the whole line of output needs
+// to match
+const PatternPrefix = "re:"
+
+// patterns caches the compiled regular expressions of the expected lines
+var patterns sync.Map
+
+// compiledPattern is the regular expression of an expected line, or the error that prevented compiling it
+type compiledPattern struct {
+	pattern *regexp.Regexp
+	err     error
+}
+
+// lineMatches returns true if the line of output is equal to the expected line, or matches it if it is a pattern
+func lineMatches(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	if !strings.HasPrefix(expected, PatternPrefix) {
+		return false
+	}
+	pattern, _ := linePattern(expected)
+	return pattern != nil && pattern.MatchString(actual)
+}
+
+// linePattern returns the compiled regular expression of an expected line that starts with PatternPrefix
+// If the regular expression is invalid, the pattern is nil and the line only matches itself.
+func linePattern(line string) (*regexp.Regexp, error) {
+	if cached, ok := patterns.Load(line); ok {
+		compiled := cached.(compiledPattern)
+		return compiled.pattern, compiled.err
+	}
+	var compiled compiledPattern
+	compiled.pattern, compiled.err = regexp.Compile(`^(?:` + strings.TrimSpace(strings.TrimPrefix(line, PatternPrefix)) + `)$`)
+	if compiled.err != nil {
+		compiled.err = fmt.Errorf("\"%s\" is not a valid regular expression, the line is compared literally: %v", line, compiled.err)
+	}
+	patterns.Store(line, compiled)
+	return compiled.pattern, compiled.err
+}
+
+// checkPattern reports an expected line that starts with PatternPrefix, but is not a valid regular expression
+func (visitor *Visitor) checkPattern(line string) {
+	if !strings.HasPrefix(line, PatternPrefix) {
+		return
+	}
+	if _, err := linePattern(line); err != nil {
+		visitor.Diagnostics = append(visitor.Diagnostics, Diagnostic{visitor.lineOf(line), err.Error()})
+	}
+}
@@ -0,0 +1,74 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	// sectionRx matches the lines that begin a section of the expected response of a command, like expect[linux] or
+	// expect[kubectl>=1.28]
+	sectionRx = regexp.MustCompile(`^expect\[(.+)\]$`)
+	// platformsRx matches the platforms of a section, like linux or darwin/arm64,windows
+	platformsRx = regexp.MustCompile(`^[A-Za-z0-9_/,]+$`)
+)
+
+// responseSection tracks which section of the expected response of a command the lines of a code block belong to
+// The lines before the first section are the response on the platforms without a section of their own. Only the first
+// section that matches the platform shelldoc runs on is used. Sections with a condition on the version of a tool are
+// recorded as Variants of the interaction, since the versions are only known when it is executed.
+type responseSection struct {
+	// ignored is true if the lines belong to a section for other platforms
+	ignored bool
+	// selected is true if a section for this platform was found
+	selected bool
+	// variant is true if the lines belong to the last variant of the interaction
+	variant bool
+}
+
+// add adds a line of a code block to the expected response of the interaction, or to its last variant, unless it
+// belongs to a section for other platforms, and returns true if it was added to the expected response
+func (section *responseSection) add(interaction *Interaction, line string) bool {
+	if match := sectionRx.FindStringSubmatch(line); len(match) > 1 {
+		switch {
+		case toolConditionRx.MatchString(match[1]):
+			section.variant = true
+			interaction.Variants = append(interaction.Variants, Variant{Condition: match[1]})
+			return false
+		case platformsRx.MatchString(match[1]):
+			section.variant = false
+			section.ignored = section.selected || !matchesPlatform(match[1], runtime.GOOS, runtime.GOARCH)
+			if !section.ignored {
+				section.selected = true
+				interaction.Response = nil
+			}
+			return false
+		}
+	}
+	if section.variant {
+		variant := &interaction.Variants[len(interaction.Variants)-1]
+		variant.Response = append(variant.Response, validUTF8(line))
+		return false
+	}
+	if section.ignored {
+		return false
+	}
+	interaction.Response = append(interaction.Response, validUTF8(line))
+	return true
+}
+
+// matchesPlatform returns true if one of the comma separated platforms is the operating system, the architecture, or
+// both separated by a slash
+func matchesPlatform(platforms, goos, goarch string) bool {
+	for _, platform := range strings.Split(platforms, ",") {
+		if platform == goos || platform == goarch || platform == goos+"/"+goarch {
+			return true
+		}
+	}
+	return false
+}
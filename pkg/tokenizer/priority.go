@@ -0,0 +1,45 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// priorityKey is the key of the priority in the front matter of a document
+const priorityKey = "priority"
+
+// Priority returns the priority defined in the front matter, documents with a higher priority are executed first
+// Documents that do not define a priority have priority 0.
+func (document *Document) Priority() (int, error) {
+	value, ok := document.FrontMatter[priorityKey]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	priority, ok := value.(int)
+	if !ok {
+		return 0, fmt.Errorf("the priority in the front matter needs to be a whole number, got \"%v\"", value)
+	}
+	return priority, nil
+}
+
+// parseNiceness returns the niceness specified by the NiceOption, or false if it is not between 0 and 19
+func parseNiceness(value string) (int, bool) {
+	niceness, err := strconv.Atoi(value)
+	return niceness, err == nil && niceness >= 0 && niceness <= 19
+}
+
+// withNiceness wraps a command so that it runs in a subshell with its niceness increased by the NiceOption
+// The subshell determines its own process ID using a child process, since $$ is the ID of the shell that started it.
+// Changes to the variables or the working directory of the shell made by the command do not persist.
+func withNiceness(command, value string) string {
+	niceness, ok := parseNiceness(value)
+	if !ok {
+		return command
+	}
+	return fmt.Sprintf("( renice -n %d -p \"$(exec sh -c 'echo $PPID')\" >/dev/null 2>&1\n%s\n)", niceness, strings.TrimSpace(command))
+}
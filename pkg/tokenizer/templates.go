@@ -0,0 +1,109 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// functionRx matches the name of the function an expression in double braces starts with
+var functionRx = regexp.MustCompile(`^\s*([A-Za-z]+)`)
+
+// Templates evaluates expressions using template functions in the commands and expected responses of documents, like
+// {{ randAlphaNum 8 }}, {{ now | date "2006" }} or {{ tempdir }}
+// Every expression is evaluated once, so that it has the same value in all commands, responses and documents it is
+// used in, until Close is called. This way, a command can create a resource with a unique name, and later commands
+// and expected responses refer to the same name. Templates is safe for concurrent use.
+type Templates struct {
+	mutex     sync.Mutex
+	values    map[string]string
+	directory string
+	random    *rand.Rand
+}
+
+// NewTemplates creates a Templates that has not evaluated any expressions yet
+func NewTemplates() *Templates {
+	return &Templates{values: make(map[string]string), random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// functions returns the template functions, they are called with the mutex held
+func (templates *Templates) functions() template.FuncMap {
+	randomString := func(characters string) func(int) string {
+		return func(length int) string {
+			result := make([]byte, length)
+			for index := range result {
+				result[index] = characters[templates.random.Intn(len(characters))]
+			}
+			return string(result)
+		}
+	}
+	return template.FuncMap{
+		"randAlphaNum": randomString("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"),
+		"randAlpha":    randomString("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"),
+		"randNumeric":  randomString("0123456789"),
+		"now":          time.Now,
+		"date":         func(layout string, date time.Time) string { return date.Format(layout) },
+		"lower":        strings.ToLower,
+		"upper":        strings.ToUpper,
+		"tempdir": func() (string, error) {
+			if len(templates.directory) == 0 {
+				directory, err := ioutil.TempDir("", "shelldoc-")
+				if err != nil {
+					return "", err
+				}
+				templates.directory = directory
+			}
+			return templates.directory, nil
+		},
+	}
+}
+
+// Evaluate returns the value of the expression between double braces, and false if it does not start with a template
+// function, like references to variables or Go templates in commands
+func (templates *Templates) Evaluate(expression string) (string, bool, error) {
+	functions := templates.functions()
+	match := functionRx.FindStringSubmatch(expression)
+	if match == nil || functions[match[1]] == nil {
+		return "", false, nil
+	}
+	templates.mutex.Lock()
+	defer templates.mutex.Unlock()
+	key := strings.TrimSpace(expression)
+	if value, ok := templates.values[key]; ok {
+		return value, true, nil
+	}
+	parsed, err := template.New("expression").Funcs(functions).Parse("{{" + expression + "}}")
+	if err != nil {
+		return "", true, fmt.Errorf("invalid expression {{%s}}: %v", expression, err)
+	}
+	var value bytes.Buffer
+	if err := parsed.Execute(&value, nil); err != nil {
+		return "", true, fmt.Errorf("unable to evaluate {{%s}}: %v", expression, err)
+	}
+	templates.values[key] = value.String()
+	return value.String(), true, nil
+}
+
+// Close removes the directory created by the tempdir function, and forgets the values of the expressions
+func (templates *Templates) Close() error {
+	templates.mutex.Lock()
+	defer templates.mutex.Unlock()
+	templates.values = make(map[string]string)
+	if len(templates.directory) == 0 {
+		return nil
+	}
+	directory := templates.directory
+	templates.directory = ""
+	return os.RemoveAll(directory)
+}
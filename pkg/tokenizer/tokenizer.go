@@ -5,6 +5,8 @@ package tokenizer
 // SPDX-License-Identifier: LGPL-3.0
 
 import (
+	"bytes"
+	"fmt"
 	"log"
 	"regexp"
 	"strings"
@@ -18,18 +20,46 @@ type Visitor struct {
 	CodeBlock func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
 	// FencedCodeBlock should be assigned a function to be called when a fenced code block is encountered
 	FencedCodeBlock func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
+	// Heading can be assigned a function to be called when a heading is encountered, after its text has been recorded
+	Heading func(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus
 	// After parsing, Interactions will hold the shell interactions found in the file
 	Interactions []*Interaction
+	// After parsing, Diagnostics will hold the problems found in the code blocks
+	Diagnostics []Diagnostic
+	// data is the document being tokenized, offset the position up to which it has been consumed
+	data   []byte
+	offset int
+	// heading is the text of the most recent heading
+	heading string
 }
 
 const cmdEx = "^[\\$>]\\s+(.+)$"
 
+// cmdRx matches the lines that contain commands
+var cmdRx = regexp.MustCompile(cmdEx)
+
+// lineOf locates text in the document, starting after the previous match, and returns its line number.
+// The parser does not record source positions, so the lines are looked up in the order the code blocks are visited.
+// It returns zero if the text cannot be found.
+func (visitor *Visitor) lineOf(text string) int {
+	if visitor.offset > len(visitor.data) {
+		return 0
+	}
+	index := bytes.Index(visitor.data[visitor.offset:], []byte(text))
+	if index < 0 {
+		return 0
+	}
+	position := visitor.offset + index
+	visitor.offset = position + len(text)
+	return bytes.Count(visitor.data[:position], []byte("\n")) + 1
+}
+
 // handleCodeBlock parses the interactions in a code block and adds them to the Visitor
 func handleCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
-	cmdRx := regexp.MustCompile(cmdEx)
-
 	lines := strings.Split(string(node.Literal), "\n")
 	var current *Interaction
+	var skipped *Diagnostic
+	var section responseSection
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if len(line) == 0 {
@@ -39,42 +69,58 @@ func handleCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkS
 		if len(match) > 1 {
 			// begin a new command
 			current = new(Interaction)
+			section = responseSection{}
+			current.Line = visitor.lineOf(line)
+			current.Heading = visitor.heading
 			visitor.Interactions = append(visitor.Interactions, current)
-			cmd := match[1]
-			current.Cmd = cmd
+			current.Cmd = validUTF8(match[1])
 		} else {
 			if current == nil {
 				log.Printf("no trigger prefix ($ or >), skipping line: %s\n", line)
+				if skipped == nil {
+					skipped = visitor.skippedLine(line)
+				}
 				continue
 			}
-			current.Response = append(current.Response, line)
+			if section.add(current, line) {
+				visitor.checkPattern(line)
+			}
 		}
 	}
+	if skipped != nil && current != nil {
+		visitor.Diagnostics = append(visitor.Diagnostics, *skipped)
+	}
 	return blackfriday.GoToNext
 }
 
+// skippedLine describes a line before the first command of a code block, which is ignored
+// It is only reported if the code block contains commands. Code blocks without any commands are examples that
+// are not meant to be tested.
+func (visitor *Visitor) skippedLine(text string) *Diagnostic {
+	return &Diagnostic{visitor.lineOf(text), fmt.Sprintf("\"%s\" is not preceded by a command ($ or >) and is ignored", text)}
+}
+
+// The regular expressions used to parse the info strings of fenced code blocks, compiled once since documents can
+// contain many code blocks
+var (
+	attributesContentRx = regexp.MustCompile("^.*\\{(.+)\\}.*$")
+	elementRx           = regexp.MustCompile("^([A-Za-z0-9]+)=(.+)$")
+)
+
 // parseCodeBlockInfoString "best-faith" parses the info string and returns the language end the attributes
-// if the info string is not written to the shelldoc specifications, both results are empty
+// The language is the first word of the info string, whether or not options follow it. If the rest of the info string
+// is not written to the shelldoc specifications, the attributes are empty.
 func parseCodeBlockInfoString(infostring string) (string, map[string]string) {
-	const infoStringHeaderEx = "^([.\\S]+)\\s+(.+)$"
-	infoStringHeaderRx := regexp.MustCompile(infoStringHeaderEx)
-	const attributesContentEx = "^.*\\{(.+)\\}.*$"
-	attributesContentRx := regexp.MustCompile(attributesContentEx)
-	const elementEx = "^([A-Za-z0-9]+)=(.+)$"
-	elementRx := regexp.MustCompile(elementEx)
-
 	var language string
 	attributes := make(map[string]string)
 
-	infostringmatch := infoStringHeaderRx.FindStringSubmatch(infostring)
-	if infostringmatch != nil {
-		language = infostringmatch[1]
-		attributesString := infostringmatch[2]
+	if fields := strings.Fields(infostring); len(fields) > 0 {
+		language = fields[0]
+		attributesString := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(infostring), language))
 		attributesContentMatch := attributesContentRx.FindStringSubmatch(attributesString)
 		if attributesContentMatch != nil {
 			attributesContent := attributesContentMatch[1]
-			elements := strings.Split(attributesContent, " ")
-			for _, element := range elements {
+			for _, element := range splitAttributes(attributesContent) {
 				if len(element) == 0 || !strings.HasPrefix(element, "shelldoc") {
 					continue
 				}
@@ -84,6 +130,9 @@ func parseCodeBlockInfoString(infostring string) (string, map[string]string) {
 				if elementmatch != nil {
 					key = elementmatch[1]
 					value = elementmatch[2]
+					if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+						value = value[1 : len(value)-1]
+					}
 				}
 				attributes[key] = value
 			}
@@ -93,10 +142,28 @@ func parseCodeBlockInfoString(infostring string) (string, map[string]string) {
 	return language, attributes
 }
 
+// splitAttributes splits the attributes of an info string at the spaces that are not inside double quotes, so that
+// values like shelldocskipif="! command -v docker" can contain spaces
+func splitAttributes(content string) []string {
+	var elements []string
+	var element strings.Builder
+	quoted := false
+	for _, character := range content {
+		switch {
+		case character == '"':
+			quoted = !quoted
+		case character == ' ' && !quoted:
+			elements = append(elements, element.String())
+			element.Reset()
+			continue
+		}
+		element.WriteRune(character)
+	}
+	return append(elements, element.String())
+}
+
 // handleFencedCodeBlock parses the interactions in a fenced code block and adds them to the Visitor
 func handleFencedCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday.WalkStatus {
-	cmdRx := regexp.MustCompile(cmdEx)
-
 	lines := strings.Split(string(node.Literal), "\n")
 	if len(lines) < 2 {
 		// technically, this should not happen, line 0 is the opening line of the code block (```),
@@ -106,10 +173,24 @@ func handleFencedCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday
 	}
 	infostring := lines[0]
 	language, attributes := parseCodeBlockInfoString(infostring) // on error, language and attributes remain empty
+	_, expectEmpty := attributes[EmptyOption]
+	first := len(visitor.Interactions)
+	infoLine := 0
+	if strings.Contains(infostring, "shelldoc") || len(attributes) > 0 {
+		infoLine = visitor.lineOf(infostring)
+		if len(attributes) == 0 {
+			visitor.Diagnostics = append(visitor.Diagnostics, Diagnostic{infoLine, fmt.Sprintf("the info string \"%s\" mentions shelldoc options, but they are not specified as \"language {options}\"", infostring)})
+		}
+		for _, problem := range ValidateOptions(attributes) {
+			visitor.Diagnostics = append(visitor.Diagnostics, Diagnostic{infoLine, problem})
+		}
+	}
 	// closer := lines[len(lines)-1] // closer is not parsed any further
 	lines = lines[1 : len(lines)-1]
 
 	var current *Interaction
+	var skipped *Diagnostic
+	var section responseSection
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if len(line) == 0 {
@@ -119,22 +200,58 @@ func handleFencedCodeBlock(visitor *Visitor, node *blackfriday.Node) blackfriday
 		if len(match) > 1 {
 			// begin a new command
 			current = new(Interaction)
+			section = responseSection{}
+			current.Line = visitor.lineOf(line)
+			current.Heading = visitor.heading
 			current.Language = language
 			current.Attributes = attributes
+			current.ExpectEmpty = expectEmpty
 			visitor.Interactions = append(visitor.Interactions, current)
-			cmd := match[1]
-			current.Cmd = cmd
+			current.Cmd = validUTF8(match[1])
 		} else {
 			if current == nil {
 				log.Printf("no trigger prefix ($ or >), skipping: %s\n", line)
+				if skipped == nil {
+					skipped = visitor.skippedLine(line)
+				}
 				continue
 			}
-			current.Response = append(current.Response, line)
+			if section.add(current, line) {
+				current.ExpectEmpty = false
+				visitor.checkPattern(line)
+			}
 		}
 	}
+	if _, ok := attributes[NoTestOption]; ok {
+		visitor.Interactions = visitor.Interactions[:first]
+		return blackfriday.GoToNext
+	}
+	if skipped != nil && current != nil {
+		visitor.Diagnostics = append(visitor.Diagnostics, *skipped)
+	}
+	if _, ok := attributes[ScriptOption]; ok && len(visitor.Interactions) > first+1 {
+		visitor.Interactions = append(visitor.Interactions[:first], mergeScript(visitor.Interactions[first:]))
+	}
+	visitor.Interactions = append(visitor.Interactions, stateAssertions(attributes, infoLine, visitor.heading, language)...)
 	return blackfriday.GoToNext
 }
 
+// mergeScript combines the interactions of a code block with the ScriptOption into one, which executes their commands
+// as one script and expects their responses in order
+func mergeScript(interactions []*Interaction) *Interaction {
+	script := *interactions[0]
+	var commands []string
+	script.Response = nil
+	for _, interaction := range interactions {
+		commands = append(commands, interaction.Cmd)
+		script.Response = append(script.Response, interaction.Response...)
+	}
+	script.Cmd = strings.Join(commands, "\n")
+	_, script.ExpectEmpty = script.Attributes[EmptyOption]
+	script.ExpectEmpty = script.ExpectEmpty && len(script.Response) == 0
+	return &script
+}
+
 // NewInteractionVisitor creates a visitor configured with the default ineraction parser
 func NewInteractionVisitor() *Visitor {
 	visitor := new(Visitor)
@@ -147,6 +264,12 @@ func NewInteractionVisitor() *Visitor {
 // It checks for code blocks and calls the respective handlers.
 func (visitor *Visitor) visit(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
 	// log.Printf("%v: %s", node.Type, node.Literal)
+	if node.Type == blackfriday.Heading && entering == true {
+		visitor.heading = headingText(node)
+		if visitor.Heading != nil {
+			return visitor.Heading(visitor, node)
+		}
+	}
 	if node.Type == blackfriday.CodeBlock && entering == true {
 		return visitor.CodeBlock(visitor, node)
 	} else if node.Type == blackfriday.Code && entering == true {
@@ -155,8 +278,27 @@ func (visitor *Visitor) visit(node *blackfriday.Node, entering bool) blackfriday
 	return blackfriday.GoToNext
 }
 
+// headingText concatenates the text contained in a heading node
+func headingText(heading *blackfriday.Node) string {
+	var text []string
+	heading.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (node.Type == blackfriday.Text || node.Type == blackfriday.Code) {
+			text = append(text, string(node.Literal))
+		}
+		return blackfriday.GoToNext
+	})
+	return strings.TrimSpace(strings.Join(text, ""))
+}
+
 // Tokenize parses the data and calls the event handlers on visitor
+// A UTF-8 byte order mark and YAML front matter at the beginning of the document are ignored. Invalid UTF-8 in commands
+// and expected responses is replaced with the Unicode replacement character.
 func Tokenize(data []byte, visitor *Visitor) error {
+	data = blankFrontMatter(bytes.TrimPrefix(data, []byte(byteOrderMark)))
+	visitor.data = data
+	visitor.offset = 0
+	visitor.heading = ""
+	visitor.Diagnostics = nil
 	md := blackfriday.New()
 	om := md.Parse(data)
 	om.Walk(visitor.visit)
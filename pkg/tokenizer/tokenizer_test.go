@@ -5,8 +5,15 @@ package tokenizer
 // SPDX-License-Identifier: Apache-2.0
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	blackfriday "gopkg.in/russross/blackfriday.v2"
@@ -22,7 +29,7 @@ func codeBlockHandler(visitor *Visitor, node *blackfriday.Node) blackfriday.Walk
 func TestEchoTrue(t *testing.T) {
 	data, err := ioutil.ReadFile("samples/echotrue.md")
 	require.NoError(t, err, "Unable to read sample data file")
-	visitor := Visitor{codeBlockHandler, codeBlockHandler, nil}
+	visitor := Visitor{CodeBlock: codeBlockHandler, FencedCodeBlock: codeBlockHandler}
 	require.Zero(t, echoTrueCodeBlockCount, "Starting the counter")
 	Tokenize(data, &visitor)
 	require.Equal(t, echoTrueCodeBlockCount, 1, "There is one code block element in the sample file")
@@ -52,6 +59,21 @@ func TestTokenizeHelloWorld(t *testing.T) {
 	require.Equal(t, "...", fourth.Response[1], "The last line of the fourth response is an ellipsis")
 }
 
+func TestTokenizeLineNumbers(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/helloworld.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	Tokenize(data, visitor)
+	require.Equal(t, 4, len(visitor.Interactions), "There are 4 interactions in the sample file")
+	require.Equal(t, 5, visitor.Interactions[0].Line, "The first command is in line 5")
+	for index, interaction := range visitor.Interactions {
+		require.NotZero(t, interaction.Line, "Every interaction should have a line number")
+		if index > 0 {
+			require.True(t, interaction.Line > visitor.Interactions[index-1].Line, "Line numbers should be ascending")
+		}
+	}
+}
+
 func TestTokenizeFenced(t *testing.T) {
 	data, err := ioutil.ReadFile("samples/fenced.md")
 	require.NoError(t, err, "Unable to read sample data file")
@@ -68,3 +90,563 @@ func TestTokenizeFenced(t *testing.T) {
 	require.Empty(t, second.Language, "No language was specified in the second block")
 	require.Empty(t, second.Attributes, "No attributes where specified in the second block")
 }
+
+func TestTokenizeHeadings(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/headings.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	Tokenize(data, visitor)
+	require.Equal(t, 3, len(visitor.Interactions), "There are three interactions in the sample file")
+	require.Equal(t, "Greeting", visitor.Interactions[0].Heading, "The first interaction is in the Greeting section")
+	require.Equal(t, "Farewell", visitor.Interactions[1].Heading, "The second interaction is in the Farewell section")
+	require.Equal(t, "Farewell in French", visitor.Interactions[2].Heading, "Code spans in headings are part of the heading text")
+}
+
+func TestTokenizeDiagnostics(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/lint.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	visitor := NewInteractionVisitor()
+	Tokenize(data, visitor)
+	require.Equal(t, 4, len(visitor.Diagnostics), "There are four problems in the sample file")
+	require.Equal(t, 11, visitor.Diagnostics[0].Line, "The invalid exit code is reported in the line of the info string")
+	require.Contains(t, visitor.Diagnostics[0].Message, "needs to be an integer", "The invalid exit code is reported")
+	require.Equal(t, "unknown option shelldocfoo", visitor.Diagnostics[1].Message, "The unknown option is reported")
+	require.Equal(t, 17, visitor.Diagnostics[2].Line, "Options without a language are reported")
+	require.Equal(t, 23, visitor.Diagnostics[3].Line, "Text before the first command is reported")
+}
+
+func TestValidateOptions(t *testing.T) {
+	require.Empty(t, ValidateOptions(map[string]string{ExitCodeOption: "2", TimeoutOption: "10s", SkipOption: ""}), "Valid options are accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{TimeoutOption: "-1s"})), "Negative timeouts are rejected")
+	require.Empty(t, ValidateOptions(map[string]string{DelayOption: "0s"}), "Delays may be zero")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{WaitForOption: "0s", WaitIntervalOption: "2s"})), "Waiting needs a positive duration")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{CheckpointOption: ""})), "Checkpoints need a name")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{RestoreOption: ""})), "Checkpoints are restored by name")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{DelayOption: "soon"})), "Delays need to be durations")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{SkipIfOption: ""})), "Guards need a command")
+	require.Empty(t, ValidateOptions(map[string]string{StreamOption: StreamCombined}), "The combined stream can be selected")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{StreamOption: "both"})), "Unknown streams are rejected")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{WhateverOption: "yes"})), "shelldocwhatever does not take an argument")
+	problems := ValidateOptions(map[string]string{ExitCodeOption: "1", WhateverOption: ""})
+	require.Equal(t, 1, len(problems), "Contradicting options are reported")
+	require.Contains(t, problems[0], "contradict", "Contradicting options are reported")
+	require.Empty(t, ValidateOptions(map[string]string{ExpectEnvOption: "A=1,B=x=y", ExpectCwdOption: "src", ExpectFunctionOption: "greet"}), "State assertions are accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{ExpectEnvOption: "A=1,B"})), "Variables need a value")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{ExpectFunctionOption: "greet,"})), "Function names cannot be empty")
+	require.Empty(t, ValidateOptions(map[string]string{EnvOption: "A=1 B=x=y C="}), "Environment overrides are accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{EnvOption: "A=1 B"})), "Environment overrides need a value")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{EnvOption: ""})), "Environment overrides cannot be empty")
+	require.Empty(t, ValidateOptions(map[string]string{ColumnsOption: "120"}), "The terminal width is accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{ColumnsOption: "0"})), "The terminal width needs to be positive")
+	require.Empty(t, ValidateOptions(map[string]string{NiceOption: "10", SerialOption: ""}), "Niceness and serial code blocks are accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{NiceOption: "20"})), "The niceness is at most 19")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{SerialOption: "yes"})), "Serial code blocks take no argument")
+	require.Empty(t, ValidateOptions(map[string]string{ExpectFileOption: "a,b", ExpectFileContainsOption: "a:x:y", ExpectDirEmptyOption: "tmp"}), "File assertions are accepted")
+	require.Equal(t, 1, len(ValidateOptions(map[string]string{ExpectFileContainsOption: "a"})), "File contents need a text")
+}
+
+func TestQuotedOptions(t *testing.T) {
+	language, attributes := parseCodeBlockInfoString(`shell {shelldocskipif="! command -v docker" shelldoctimeout=10s}`)
+	require.Equal(t, "shell", language, "The language is parsed")
+	require.Equal(t, "! command -v docker", attributes[SkipIfOption], "Quoted values can contain spaces")
+	require.Equal(t, "10s", attributes[TimeoutOption], "The options after a quoted value are parsed")
+	language, attributes = parseCodeBlockInfoString("console")
+	require.Equal(t, "console", language, "The language is parsed without options")
+	require.Empty(t, attributes, "There are no options")
+}
+
+func TestEvaluateResponseEllipsis(t *testing.T) {
+	interaction := New("ellipsis")
+	interaction.Response = []string{"Hello", "..."}
+	require.True(t, interaction.evaluateResponse([]string{"Hello", "World"}), "Lines after the ellipsis are ignored")
+	require.False(t, interaction.evaluateResponse([]string{"World"}), "Lines before the ellipsis are compared")
+	require.False(t, interaction.evaluateResponse(nil), "Output shorter than the lines before the ellipsis does not match")
+}
+
+func TestExpectEmpty(t *testing.T) {
+	document, err := ParseDocument([]byte("```shell {shelldocempty}\n$ true\n$ echo Hello\nHello\n```\n\n    $ echo Hello\n"))
+	require.NoError(t, err, "The document should parse")
+	interactions := document.Interactions()
+	require.True(t, interactions[0].ExpectEmpty, "Commands without a response in the code block expect no output")
+	require.False(t, interactions[1].ExpectEmpty, "Commands with a response do not expect empty output")
+	require.False(t, interactions[2].ExpectEmpty, "Commands without the option have no expectation")
+	require.True(t, interactions[2].evaluateResponse([]string{"Hello"}), "Any output is accepted without an expectation")
+	require.True(t, interactions[0].evaluateResponse(nil), "Empty output matches if no output is expected")
+	require.False(t, interactions[0].evaluateResponse([]string{""}), "Any output fails if no output is expected")
+	err = &MismatchError{Actual: []string{"Hello"}, Line: 1, ExpectEmpty: true}
+	require.Equal(t, "the command was expected to produce no output, but printed 1 lines", err.Error(), "The mismatch explains that no output was expected")
+	require.Contains(t, interactions[0].Describe(), "(no output expected)", "The description tells that no output is expected")
+	require.Empty(t, ValidateOptions(map[string]string{EmptyOption: ""}), "shelldocempty is a valid option")
+}
+
+func TestVariables(t *testing.T) {
+	data := "---\nvariables:\n  host: example.com\n  version: 1.2\n---\n\n    $ curl -s https://{{host}}/version\n    v{{ version }}\n\n    $ docker inspect --format '{{.State}}' web\n"
+	document, err := ParseDocument([]byte(data))
+	require.NoError(t, err, "The document should parse")
+	require.NoError(t, document.ExpandVariables(map[string]string{"version": "1.3"}, nil), "The variables should be expanded")
+	interactions := document.Interactions()
+	require.Equal(t, "curl -s https://example.com/version", interactions[0].Cmd, "Variables are expanded in commands")
+	require.Equal(t, []string{"v1.3"}, interactions[0].Response, "Variables are expanded in expected responses, and can be overridden")
+	require.Equal(t, "docker inspect --format '{{.State}}' web", interactions[1].Cmd, "References to undefined variables are kept")
+	variables, err := document.Variables(nil)
+	require.NoError(t, err, "The variables should be read")
+	require.Equal(t, map[string]string{"host": "example.com", "version": "1.2"}, variables, "Values that are not strings are formatted")
+	document, err = ParseDocument([]byte("---\nvariables: [host]\n---\n\n    $ true\n"))
+	require.NoError(t, err, "The document should parse")
+	require.Error(t, document.ExpandVariables(nil, nil), "The variables need to be a map")
+}
+
+func TestTemplates(t *testing.T) {
+	templates := NewTemplates()
+	data := "    $ kubectl create namespace demo-{{ randAlphaNum 8 | lower }}\n    namespace/demo-{{ randAlphaNum 8 | lower }} created\n\n" +
+		"    $ echo {{ now | date \"2006\" }} {{ tempdir }}\n\n    $ docker inspect --format '{{ json .State }}' web\n"
+	document, err := ParseDocument([]byte(data))
+	require.NoError(t, err, "The document should parse")
+	require.NoError(t, document.ExpandVariables(nil, templates), "The expressions should be evaluated")
+	interactions := document.Interactions()
+	name := strings.TrimPrefix(interactions[0].Cmd, "kubectl create namespace ")
+	require.Regexp(t, "^demo-[a-z0-9]{8}$", name, "The expressions are evaluated")
+	require.Equal(t, []string{"namespace/" + name + " created"}, interactions[0].Response, "Every expression has the same value in commands and responses")
+	fields := strings.Fields(interactions[1].Cmd)
+	require.Equal(t, strconv.Itoa(time.Now().Year()), fields[1], "Pipelines are supported")
+	info, err := os.Stat(fields[2])
+	require.NoError(t, err, "The temporary directory is created")
+	require.True(t, info.IsDir(), "The temporary directory is created")
+	require.Equal(t, "docker inspect --format '{{ json .State }}' web", interactions[2].Cmd, "Expressions that do not start with a template function are kept")
+	require.NoError(t, templates.Close(), "Closing the templates should work")
+	_, err = os.Stat(fields[2])
+	require.True(t, os.IsNotExist(err), "The temporary directory is removed")
+	value, ok, err := templates.Evaluate(" randAlphaNum 8 | lower ")
+	require.True(t, ok && err == nil, "The expressions can be evaluated again")
+	require.NotEqual(t, strings.TrimPrefix(name, "demo-"), value, "Closing the templates forgets the values")
+	_, _, err = templates.Evaluate(" randAlphaNum \"x\" ")
+	require.Error(t, err, "Invalid arguments are reported")
+}
+
+func TestPriority(t *testing.T) {
+	document, err := ParseDocument([]byte("---\npriority: 10\n---\n\n    $ true\n"))
+	require.NoError(t, err, "The document should parse")
+	priority, err := document.Priority()
+	require.NoError(t, err, "The priority should be read")
+	require.Equal(t, 10, priority, "The priority is taken from the front matter")
+	document, err = ParseDocument([]byte("    $ true\n"))
+	require.NoError(t, err, "The document should parse")
+	priority, err = document.Priority()
+	require.NoError(t, err, "A document without front matter has a priority")
+	require.Equal(t, 0, priority, "The default priority is 0")
+	document, err = ParseDocument([]byte("---\npriority: high\n---\n\n    $ true\n"))
+	require.NoError(t, err, "The document should parse")
+	_, err = document.Priority()
+	require.Error(t, err, "The priority needs to be a number")
+}
+
+func TestNoTest(t *testing.T) {
+	document, err := ParseDocument([]byte("```shell {shelldocnotest}\n$ rm -rf /\n```\n\n```shell\n$ echo Hello\nHello\n```\n"))
+	require.NoError(t, err, "The document should parse")
+	require.Len(t, document.Interactions(), 1, "Code blocks marked with shelldocnotest have no interactions")
+	require.Equal(t, "echo Hello", document.Interactions()[0].Cmd, "Other code blocks are tokenized")
+	require.Empty(t, ValidateOptions(map[string]string{NoTestOption: ""}), "shelldocnotest is a valid option")
+	require.Len(t, ValidateOptions(map[string]string{NoTestOption: "yes"}), 1, "shelldocnotest does not take an argument")
+}
+
+func TestNoFinalNewline(t *testing.T) {
+	interaction := New("printf")
+	interaction.Response = []string{"Hello"}
+	interaction.NoFinalNewline = true
+	require.Equal(t, 0, interaction.compareResponse([]string{"Hello"}), "A missing line break at the end of the output is a mismatch")
+	interaction.Response = []string{"Hello", NoNewlineMarker}
+	require.Equal(t, -1, interaction.compareResponse([]string{"Hello"}), "The marker expects no line break at the end of the output")
+	interaction.NoFinalNewline = false
+	require.Equal(t, 0, interaction.compareResponse([]string{"Hello"}), "The marker does not match output ending with a line break")
+	interaction.Response = []string{"Hello", "..."}
+	interaction.NoFinalNewline = true
+	require.Equal(t, -1, interaction.compareResponse([]string{"Hello", "World"}), "The line break is not compared after an ellipsis")
+	err := &MismatchError{Expected: []string{"Hello"}, Actual: []string{"Hello"}, Line: 1, NewlineOnly: true, NoFinalNewline: true}
+	require.Contains(t, err.Error(), "did not end with a line break", "The mismatch explains the missing line break")
+	_, _, ok := err.FirstDifference()
+	require.False(t, ok, "There are no differing lines if only the line break is missing")
+	err = &MismatchError{Expected: []string{"Hello", "World"}, Actual: []string{"Hello", "World"}, Line: 2, NoFinalNewline: true}
+	_, _, ok = err.FirstDifference()
+	require.False(t, ok, "Identical lines are not a difference")
+}
+
+func TestScript(t *testing.T) {
+	document, err := ParseDocument([]byte("```shell {shelldocscript}\n$ for word in Hello World; do\n> echo $word\n> done\nHello\nWorld\n$ true\n```\n\n```shell\n$ echo Hi\nHi\n$ true\n```\n"))
+	require.NoError(t, err, "The document should parse")
+	require.Len(t, document.Blocks[0].Interactions, 1, "The commands of a script are one interaction")
+	script := document.Blocks[0].Interactions[0]
+	require.Equal(t, "for word in Hello World; do\necho $word\ndone\ntrue", script.Cmd, "The commands are executed as one script")
+	require.Equal(t, []string{"Hello", "World"}, script.Response, "The responses are expected in order")
+	require.Equal(t, 2, script.Line, "The script starts at its first command")
+	require.Len(t, document.Blocks[1].Interactions, 2, "Commands are separate interactions without the option")
+	require.Contains(t, script.Describe(), "for word in Hello World; do; echo", "The script is described on one line")
+}
+
+func TestCaptionTemplate(t *testing.T) {
+	document, err := ParseDocument([]byte("# Install\n\n    $ echo a long command that would be elided in the default description\n    a long command\n"))
+	require.NoError(t, err, "The document should parse")
+	interaction := document.Interactions()[0]
+	description := interaction.DescribeAs("{file} §{heading} #{index}: {cmd}", "install.md", 2)
+	require.Equal(t, "install.md §Install #2: echo a long command that would be elided in the default description", description, "The placeholders are replaced and nothing is elided")
+	require.Equal(t, "3 -> a long command {unknown}", interaction.DescribeAs("{line} -> {response} {unknown}", "install.md", 2), "The line and the response are supported, unknown placeholders are kept")
+	require.Equal(t, interaction.Describe(), interaction.DescribeAs("", "install.md", 2), "Without a template, the default description is used")
+	interaction.Caption = "Installation"
+	require.Equal(t, interaction.Describe(), interaction.DescribeAs("{cmd}", "install.md", 2), "Explicit captions are not replaced")
+	require.NoError(t, ValidateCaptionTemplate("{file}:{line} {cmd}"), "Supported placeholders are valid")
+	require.Error(t, ValidateCaptionTemplate("{command}"), "Unknown placeholders are rejected")
+}
+
+func TestPlatformResponses(t *testing.T) {
+	require.True(t, matchesPlatform("darwin,linux", "linux", "amd64"), "Any of the platforms matches")
+	require.True(t, matchesPlatform("arm64", "darwin", "arm64"), "Architectures match")
+	require.True(t, matchesPlatform("linux/amd64", "linux", "amd64"), "Operating systems and architectures match together")
+	require.False(t, matchesPlatform("linux/arm64", "linux", "amd64"), "Both need to match")
+	document, err := ParseDocument([]byte(fmt.Sprintf("```shell\n$ uname\nUnknown\nexpect[plan9]\nPlan 9\nexpect[%s]\nSelected\nexpect[%s]\nIgnored\n$ uname -m\nexpect[plan9]\nmips\n```\n", runtime.GOOS, runtime.GOARCH)))
+	require.NoError(t, err, "The document should parse")
+	interactions := document.Interactions()
+	require.Equal(t, []string{"Selected"}, interactions[0].Response, "The first section for this platform is selected")
+	require.Empty(t, interactions[1].Response, "Sections for other platforms are ignored")
+	document, err = ParseDocument([]byte("    $ uname\n    Unknown\n    expect[plan9]\n    Plan 9\n"))
+	require.NoError(t, err, "The document should parse")
+	require.Equal(t, []string{"Unknown"}, document.Interactions()[0].Response, "The common response is used on other platforms")
+}
+
+func TestVariants(t *testing.T) {
+	require.True(t, Variant{Condition: "kubectl>=1.28"}.Holds("1.28.3"), "Versions are compared in the precision of the condition")
+	require.False(t, Variant{Condition: "kubectl>1.28"}.Holds("1.28.3"), "Later patch versions are not later minor versions")
+	require.True(t, Variant{Condition: "kubectl < v1.28"}.Holds("v1.9.0"), "Components are compared as numbers")
+	require.True(t, Variant{Condition: "go==1"}.Holds("1.21.0"), "Major versions can be selected")
+	require.False(t, Variant{Condition: "go!=1"}.Holds("1.21.0"), "Versions can be excluded")
+	require.False(t, Variant{Condition: "go>=1"}.Holds(""), "Unknown versions satisfy no condition")
+	require.Equal(t, "docker-compose", Variant{Condition: "docker-compose>=2"}.Tool(), "The tool is named in the condition")
+	document, err := ParseDocument([]byte("```shell {shelldocempty}\n$ kubectl version --client\nexpect[kubectl>=1.28]\nv1.28\nexpect[kubectl<1.28]\nv1.27\n$ true\n```\n"))
+	require.NoError(t, err, "The document should parse")
+	interaction := document.Interactions()[0]
+	require.Empty(t, interaction.Response, "Variants are not part of the expected response")
+	require.Len(t, interaction.Variants, 2, "Every section is a variant")
+	version := map[string]string{"kubectl": "1.27.4"}
+	require.Equal(t, "kubectl<1.28", interaction.SelectVariant(func(tool string) string { return version[tool] }), "The first variant that holds is selected")
+	require.Equal(t, []string{"v1.27"}, interaction.Response, "The response of the variant is expected")
+	require.False(t, interaction.ExpectEmpty, "The response of the variant is not empty")
+	require.Empty(t, interaction.SelectVariant(func(string) string { return "" }), "No variant holds for unknown versions")
+}
+
+func TestHTTPMatcher(t *testing.T) {
+	matcher := BuiltinMatcher(HTTPMatcherName)
+	require.NotNil(t, matcher, "The http matcher is built in")
+	actual := []string{"HTTP/1.1 100 Continue", "", "HTTP/1.1 200 OK\r", "Date: Mon, 12 Oct 2026 10:00:00 GMT\r", "Content-Type: application/json\r", "X-Request-Id: 42\r", "\r", `{"status": "ok",`, ` "items": [1, 2], "version": "1.2"}`}
+	for _, expected := range [][]string{
+		{"HTTP/2 200", "content-type: application/json", "X-Request-Id: ...", `{"status": "ok"}`},
+		{"Content-Type: application/json"},
+		{"HTTP/1.1 200 OK", `{"items": [1, 2]}`},
+		{"HTTP/1.1 200 OK"},
+	} {
+		matched, err := matcher.Match(expected, actual)
+		require.NoError(t, err, "Comparing HTTP responses works")
+		require.True(t, matched, "The responses match: %v", expected)
+	}
+	for _, expected := range [][]string{
+		{"HTTP/1.1 404 Not Found"},
+		{"HTTP/1.1 200 OK", "Content-Type: text/plain"},
+		{"HTTP/1.1 200 OK", "Cache-Control: no-cache"},
+		{"HTTP/1.1 200 OK", `{"status": "failed"}`},
+		{"HTTP/1.1 200 OK", `{"items": [1]}`},
+	} {
+		matched, err := matcher.Match(expected, actual)
+		require.NoError(t, err, "Comparing HTTP responses works")
+		require.False(t, matched, "The responses differ: %v", expected)
+	}
+	matched, err := matcher.Match([]string{"HTTP/1.1 200 OK", "Hello", "..."}, []string{"HTTP/1.0 200 OK", "", "Hello", "World"})
+	require.NoError(t, err, "Comparing HTTP responses works")
+	require.True(t, matched, "Bodies that are not JSON are compared line by line")
+	_, err = matcher.Match([]string{"HTTP/1.1 200 OK"}, []string{"ok"})
+	require.Error(t, err, "Output without a status line is an error")
+	require.Nil(t, BuiltinMatcher("json"), "Other matchers are not built in")
+}
+
+func TestLocaleMatcher(t *testing.T) {
+	matcher := BuiltinMatcher(LocaleMatcherName)
+	require.NotNil(t, matcher, "The locale matcher is built in")
+	for expected, actual := range map[string]string{
+		"Total: 1,234.56 EUR":      "Total: 1.234,56 EUR",
+		"3.5 GB free":              "3,5 GB free",
+		"size 1'234'567 bytes":     "size 1.234.567 bytes",
+		"Created on 2026-10-15":    "Created on 15.10.2026",
+		"Date: 10/15/2026":         "Date: 15/10/2026",
+		"version 1.2.3 at 14:30":   "version 1.2.3 at 14:30",
+		"Speed: 1\u00a0024,5 MB/s": "Speed: 1,024.5 MB/s",
+		"Price: 1.500 EUR":         "Price: 1.5 EUR",
+		"Price: 1,50 EUR":          "Price: 1.5 EUR",
+		"Price: 2.0":               "Price: 2",
+	} {
+		matched, err := matcher.Match([]string{expected}, []string{actual})
+		require.NoError(t, err, "Comparing lines works")
+		require.True(t, matched, "\"%s\" matches \"%s\"", expected, actual)
+	}
+	for expected, actual := range map[string]string{
+		"Total: 1,234.56 EUR":   "Total: 1.234,57 EUR",
+		"3.5 GB free":           "3.5 GiB free",
+		"version 1.2.3":         "version 1,2,3",
+		"Created on 2026-10-15": "Created on 16.10.2026",
+		"Date: 10.11.2026":      "Date: 2026-10-11",
+		"Price: 1.50 EUR":       "Price: 1.05 EUR",
+		"Price: 10 EUR":         "Price: 1.0 EUR",
+	} {
+		matched, err := matcher.Match([]string{expected}, []string{actual})
+		require.NoError(t, err, "Comparing lines works")
+		require.False(t, matched, "\"%s\" does not match \"%s\"", expected, actual)
+	}
+	matched, err := matcher.Match([]string{"Sum: 2,5", "..."}, []string{"Sum: 2.5", "more"})
+	require.NoError(t, err, "Comparing lines works")
+	require.True(t, matched, "An ellipsis accepts any output")
+	matched, err = matcher.Match([]string{"Sum: 2,5"}, []string{"Sum: 2.5", "more"})
+	require.NoError(t, err, "Comparing lines works")
+	require.False(t, matched, "Additional lines do not match")
+}
+
+func TestCompareLines(t *testing.T) {
+	require.Equal(t, -1, compareLines([]string{"a", "b"}, []string{"a", "b"}), "Equal lines match")
+	require.Equal(t, -1, compareLines(nil, []string{}), "Nil and empty output are equal")
+	require.Equal(t, 1, compareLines([]string{"a", "b"}, []string{"a", "c"}), "The first differing line is found")
+	require.Equal(t, 1, compareLines([]string{"a", "b"}, []string{"a"}), "Missing lines differ")
+	require.Equal(t, 2, compareLines([]string{"a", "b"}, []string{"a", "b", "c"}), "Additional lines differ")
+	interaction := New("divergence")
+	interaction.Response = []string{"Hello", "World", "..."}
+	require.Equal(t, 1, interaction.compareResponse([]string{"Hello", "there", "World"}), "The index of the first differing line is returned")
+	expected, actual := []string{"Hello", "World"}, []string{"Hello", "World"}
+	require.Zero(t, testing.AllocsPerRun(100, func() { compareLines(expected, actual) }), "Comparing does not allocate")
+}
+
+func TestLinePatterns(t *testing.T) {
+	interaction := New("patterns")
+	interaction.Response = []string{"Downloading...", `re:Took [0-9.]+s`, "Done"}
+	require.Equal(t, -1, interaction.compareResponse([]string{"Downloading...", "Took 3.25s", "Done"}), "Lines with the prefix are regular expressions")
+	require.Equal(t, 1, interaction.compareResponse([]string{"Downloading...", "Took 3.25s (cached)", "Done"}), "Patterns match the whole line")
+	require.Equal(t, 2, interaction.compareResponse([]string{"Downloading...", "Took 3.25s", "done"}), "Other lines remain literal")
+	require.True(t, lineMatches("re: [a-z]+", "hello"), "Space after the prefix is ignored")
+	document, err := ParseDocument([]byte("```shell\n$ echo [\nre:[\n```\n"))
+	require.NoError(t, err, "The document should parse")
+	require.Len(t, document.Diagnostics, 1, "Invalid patterns are reported")
+	require.Equal(t, 3, document.Diagnostics[0].Line, "Invalid patterns are reported at their line")
+	require.True(t, lineMatches("re:[", "re:["), "Invalid patterns are compared literally")
+}
+
+func TestResultCode(t *testing.T) {
+	require.Equal(t, "mismatch", ResultMismatch.String(), "Result codes have a readable name")
+	require.Equal(t, "ResultCode(42)", ResultCode(42).String(), "Unknown result codes are printed as numbers")
+	require.Equal(t, "command exited with non-zero exit code 1", (&ExitCodeError{Actual: 1}).Error(), "The exit code is reported")
+	require.Equal(t, "command exited with exit code 0, expected 2", (&ExitCodeError{Expected: 2}).Error(), "The expected exit code is reported")
+}
+
+func TestParseDocument(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/document.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	document, err := ParseDocument(data)
+	require.NoError(t, err, "The sample should parse")
+	require.Equal(t, "The document model", document.Title, "The title is taken from the front matter")
+	require.Equal(t, []interface{}{"example"}, document.FrontMatter["tags"], "The front matter is parsed")
+	require.Len(t, document.Headings, 2, "There are two top-level headings")
+	require.Equal(t, "Introduction", document.Headings[0].Text, "The first heading is the introduction")
+	require.Equal(t, 6, document.Headings[0].Line, "The line of the heading is recorded")
+	require.Equal(t, "Usage", document.Headings[0].Children[0].Text, "Subsections are children of their heading")
+	require.Equal(t, "Details", document.Headings[0].Children[0].Children[0].Text, "Headings are nested by level")
+	require.Len(t, document.Blocks, 3, "All code blocks are recorded")
+	require.Empty(t, document.Blocks[0].Interactions, "The first code block has no interactions")
+	require.Equal(t, 10, document.Blocks[0].Line, "The line of a code block without commands is its first line")
+	require.True(t, document.Blocks[1].Fenced, "The second code block is fenced")
+	require.Equal(t, "shell", document.Blocks[1].Language, "The language of the fenced code block is recorded")
+	require.Equal(t, map[string]string{ExitCodeOption: "1"}, document.Blocks[1].Options, "The options of the fenced code block are recorded")
+	require.Equal(t, 15, document.Blocks[1].Line, "The line of a code block is the line of its first command")
+	require.Equal(t, 15, document.Blocks[1].EndLine, "The fenced code block ends before its closing fence")
+	require.True(t, document.Blocks[1].Contains(14), "The opening fence is part of the code block")
+	require.True(t, document.Blocks[1].Contains(16), "The closing fence is part of the code block")
+	require.False(t, document.Blocks[1].Contains(17), "The lines after the code block are not part of it")
+	require.Equal(t, 21, document.Blocks[2].EndLine, "The code block ends with the expected response")
+	require.Equal(t, "Details", document.Blocks[2].Heading, "The heading of the code block is recorded")
+	require.Len(t, document.Interactions(), 2, "The interactions of all code blocks are returned")
+
+	document, err = ParseDocument([]byte("# Title\n\n    $ echo Hello\n    Hello\n"))
+	require.NoError(t, err, "A document without front matter should parse")
+	require.Nil(t, document.FrontMatter, "There is no front matter")
+	require.Equal(t, "Title", document.Title, "The title is taken from the first heading")
+	_, err = ParseDocument([]byte("---\n: invalid\n---\n"))
+	require.Error(t, err, "Invalid front matter is reported")
+}
+
+func TestAssignIDs(t *testing.T) {
+	parse := func(content string) *Document {
+		document, err := ParseDocument([]byte(content))
+		require.NoError(t, err, "The document should parse")
+		document.AssignIDs("./docs/guide.md")
+		return document
+	}
+	document := parse("# Guide\n\n## Install\n\n    $ echo  Hello\n\n    $ echo Hello\n\n## Use\n\n    $ echo Hello\n")
+	require.Equal(t, []string{"Guide", "Install"}, document.HeadingPath(5), "The headings of the sections containing the line are returned")
+	interactions := document.Interactions()
+	require.Len(t, interactions, 3, "There are three interactions")
+	require.Len(t, interactions[0].ID, 16, "The ID is a short hash")
+	require.Equal(t, interactions[0].ID+"-2", interactions[1].ID, "The same command in the same section is numbered")
+	require.NotEqual(t, interactions[0].ID, interactions[2].ID, "The same command in another section has another ID")
+
+	shifted := parse("# Guide\n\nAn introduction.\n\n## Install\n\n    $ echo  Hello\n\n    $ echo Hello\n\n## Use\n\n    $ echo Hello\n").Interactions()
+	for index := range interactions {
+		require.Equal(t, interactions[index].ID, shifted[index].ID, "The IDs do not depend on the lines of the interactions")
+	}
+}
+
+func TestRedundancies(t *testing.T) {
+	document, err := ParseDocument([]byte("```shell {shelldocsetup}\n$ mkdir -p demo\n```\n\n    $ ls demo\n\n```shell\n$ ls demo\nunexpected\n```\n\n    $ ls\n    $ ls demo\n\n```shell {shelldocsetup}\n$ touch demo/file\n```\n"))
+	require.NoError(t, err, "The document should parse")
+	problems := document.Redundancies()
+	require.Len(t, problems, 2, "The repeated code block and the unneeded setup code block are reported")
+	require.Equal(t, 8, problems[0].Line, "The code block that repeats the commands is reported")
+	require.Contains(t, problems[0].Message, "in line 5", "The line of the first code block with the commands is reported")
+	require.Equal(t, 16, problems[1].Line, "The setup code block at the end is reported")
+	require.Contains(t, problems[1].Message, "never needed", "The setup code block is reported as never needed")
+}
+
+func TestParseDocumentFiltered(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/headings.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	document, err := ParseDocumentFiltered(data, Filter{Run: regexp.MustCompile("^Farewell$")})
+	require.NoError(t, err, "The sample should parse")
+	require.Len(t, document.Blocks, 1, "Only the code block in the selected section is parsed")
+	require.Equal(t, 12, document.Blocks[0].Line, "The lines of code blocks after skipped ones are located")
+	require.Len(t, document.Headings[0].Children, 2, "All headings are parsed")
+	document, err = ParseDocumentFiltered(data, Filter{Run: regexp.MustCompile("Au revoir")})
+	require.NoError(t, err, "The sample should parse")
+	require.Len(t, document.Blocks, 1, "Code blocks with a matching command are parsed")
+	require.Equal(t, "Farewell in French", document.Blocks[0].Heading, "The heading of the code block is recorded")
+	document, err = ParseDocumentFiltered([]byte("    $ echo Hello\n\n# Again\n\n    $ echo Hello\n"), Filter{Run: regexp.MustCompile("Again")})
+	require.NoError(t, err, "The document should parse")
+	require.Equal(t, 5, document.Blocks[0].Line, "Skipped code blocks do not confuse the lines of identical ones")
+	data, err = ioutil.ReadFile("samples/document.md")
+	require.NoError(t, err, "Unable to read sample data file")
+	document, err = ParseDocumentFiltered(data, Filter{EndLine: 14})
+	require.NoError(t, err, "The sample should parse")
+	require.Len(t, document.Blocks, 2, "Parsing stops after the code block at the end line")
+	require.NotNil(t, document.InteractionsAt(14), "The code block at the end line is parsed")
+}
+
+func TestEncoding(t *testing.T) {
+	document, err := ParseDocument([]byte(byteOrderMark + "    $ echo Hello\n    Hello\n"))
+	require.NoError(t, err, "A document with a byte order mark should parse")
+	require.Len(t, document.Blocks, 1, "The byte order mark is ignored")
+	require.Equal(t, 1, document.Blocks[0].Line, "The byte order mark does not move the lines")
+	document, err = ParseDocument([]byte("    $ echo Gr\xfc\xdfe\n    Gr\xfc\xdfe\n"))
+	require.NoError(t, err, "A document with invalid UTF-8 should parse")
+	interaction := document.Blocks[0].Interactions[0]
+	require.Equal(t, "echo Gr"+replacementCharacter+"e", interaction.Cmd, "Invalid UTF-8 in commands is replaced")
+	require.Equal(t, []string{"Gr" + replacementCharacter + "e"}, interaction.Response, "Invalid UTF-8 in responses is replaced")
+	decoded, encoding, err := Decode([]byte("    $ echo Gr\xfc\xdfe\n"), "latin1")
+	require.NoError(t, err, "Latin-1 documents can be decoded")
+	require.Equal(t, "latin1", encoding, "The encoding is returned")
+	require.Equal(t, "    $ echo Grüße\n", string(decoded), "Latin-1 documents are decoded")
+	encoded, err := Encode(decoded, encoding)
+	require.NoError(t, err, "Latin-1 documents can be encoded")
+	require.Equal(t, "    $ echo Gr\xfc\xdfe\n", string(encoded), "Documents are encoded like they were read")
+	utf16, err := Encode([]byte("    $ echo Hello\n"), EncodingUTF16LE)
+	require.NoError(t, err, "UTF-16 documents can be encoded")
+	require.Equal(t, EncodingUTF16LE, DetectEncoding(utf16), "UTF-16 documents are detected by their byte order mark")
+	decoded, encoding, err = Decode(utf16, "")
+	require.NoError(t, err, "UTF-16 documents can be decoded")
+	require.Equal(t, EncodingUTF16LE, encoding, "The detected encoding is returned")
+	require.Equal(t, "    $ echo Hello\n", string(decoded), "UTF-16 documents are decoded without the byte order mark")
+	_, _, err = Decode(utf16, "no-such-encoding")
+	require.Error(t, err, "Unknown encodings are an error")
+	lines := []string{"Hello"}
+	require.Equal(t, lines, validLines(lines), "Valid lines are returned as they are")
+	require.Equal(t, []string{"Hello", replacementCharacter}, validLines([]string{"Hello", "\xff"}), "Invalid UTF-8 in output is replaced")
+}
+
+// largeDocument generates a document with the given number of sections, each with a code block
+func largeDocument(sections int) []byte {
+	var builder strings.Builder
+	for index := 0; index < sections; index++ {
+		fmt.Fprintf(&builder, "## Section %d\n\nSome text with *emphasis* and a [link](https://example.com).\n\n```shell {shelldocexitcode=0}\n$ echo %d\n%d\n```\n\n", index, index, index)
+	}
+	return []byte(builder.String())
+}
+
+func TestDiffLine(t *testing.T) {
+	expected, actual := DiffLine("version 1.2.4", "version 1.2.3")
+	require.Equal(t, []Segment{{"version 1.2.", false}, {"4", true}}, expected, "Only the changed digit is marked")
+	require.Equal(t, []Segment{{"version 1.2.", false}, {"3", true}}, actual, "Only the changed digit is marked")
+	expected, actual = DiffLine("a b c", "a  b c")
+	require.Equal(t, []Segment{{"a b c", false}}, expected, "Nothing was removed from the expected line")
+	require.Equal(t, []Segment{{"a", false}, {" ", true}, {" b c", false}}, actual, "The extra space is marked")
+	expected, actual = DiffLine("größe: 1", "größe: 2")
+	require.Equal(t, []Segment{{"größe: ", false}, {"1", true}}, expected, "Characters are compared, not bytes")
+	require.Equal(t, []Segment{{"größe: ", false}, {"2", true}}, actual, "Characters are compared, not bytes")
+	mismatch := &MismatchError{Expected: []string{"a", "re:b+"}, Actual: []string{"a", "c"}, Line: 2}
+	_, _, ok := mismatch.FirstDifference()
+	require.False(t, ok, "Patterns are not compared character by character")
+	mismatch = &MismatchError{Expected: []string{"a", "b"}, Actual: []string{"a", "c"}, Line: 2}
+	first, second, ok := mismatch.FirstDifference()
+	require.True(t, ok, "The first differing lines are known")
+	require.Equal(t, []string{"b", "c"}, []string{first, second}, "The first differing lines are returned")
+}
+
+func TestMessages(t *testing.T) {
+	for _, name := range Locales() {
+		for message := range catalogs[DefaultLocale] {
+			require.Contains(t, catalogs[name], message, "Every message is translated in every locale")
+		}
+	}
+	defer SetLocale(DefaultLocale)
+	interaction := New("messages")
+	interaction.ResultCode = ResultSkipped
+	interaction.SkipReason, interaction.Comment = SkipMissingDependency, "docker"
+	require.Equal(t, "SKIPPED (missing dependency: docker)", interaction.Result(), "The messages are English by default")
+	require.NoError(t, SetLocale("de_DE.UTF-8"), "Locales are accepted like the LANG environment variable")
+	require.Equal(t, "SKIPPED (fehlende Abhängigkeit: docker)", interaction.Result(), "The explanation is translated, the keyword is not")
+	interaction.ResultCode = ResultCode(1000)
+	require.Equal(t, "ERROR (unbekanntes Ergebnis 1000)", interaction.Result(), "Unknown result codes are reported as errors")
+	require.Error(t, SetLocale("xx"), "Locales without a catalog are rejected")
+	require.Equal(t, "FAIL (durch SIGSEGV beendet)", Translate(MessageSignal, "SIGSEGV"), "A rejected locale does not change the selected one")
+	require.NoError(t, SetLocale("C"), "The C locale selects the default locale")
+	require.Equal(t, "FAIL (terminated by SIGSEGV)", Translate(MessageSignal, "SIGSEGV"), "The C locale selects the default locale")
+}
+
+func BenchmarkParseDocument(b *testing.B) {
+	data := largeDocument(1000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		if _, err := ParseDocument(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseDocumentFiltered(b *testing.B) {
+	data := largeDocument(1000)
+	filter := Filter{Run: regexp.MustCompile("^Section 500$")}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		if _, err := ParseDocumentFiltered(data, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluateResponse(b *testing.B) {
+	interaction := New("benchmark")
+	output := make([]string, 1000)
+	for index := range output {
+		output[index] = fmt.Sprintf("line %d", index)
+	}
+	interaction.Response = append([]string{}, output...)
+	b.Run("match", func(b *testing.B) {
+		for index := 0; index < b.N; index++ {
+			interaction.evaluateResponse(output)
+		}
+	})
+	interaction.Response = append(append([]string{}, output[:500]...), "...")
+	b.Run("ellipsis", func(b *testing.B) {
+		for index := 0; index < b.N; index++ {
+			interaction.evaluateResponse(output)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// variablesKey is the key of the variables in the front matter of a document
+const variablesKey = "variables"
+
+// variableRx matches a reference to a variable of the document, like {{version}}
+var variableRx = regexp.MustCompile(`^\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}$`)
+
+// expressionRx matches a reference to a variable or an expression using template functions in double braces
+var expressionRx = regexp.MustCompile(`\{\{(.*?)\}\}`)
+
+// Variables returns the variables defined in the variables section of the front matter, with the overrides applied
+// Values that are not strings, like numbers, are formatted. Overrides may also define variables the document does not.
+func (document *Document) Variables(overrides map[string]string) (map[string]string, error) {
+	variables := make(map[string]string)
+	if section, ok := document.FrontMatter[variablesKey]; ok && section != nil {
+		entries, ok := section.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("the variables in the front matter need to be a map of names to values")
+		}
+		for name, value := range entries {
+			variables[fmt.Sprint(name)] = fmt.Sprint(value)
+		}
+	}
+	for name, value := range overrides {
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// ExpandVariables replaces the references to variables in the commands and expected responses of the document with
+// their values, and the expressions using template functions with their values if templates is not nil
+// References to variables that are not defined are kept, so that other uses of double braces, like Go templates in
+// commands, are not affected.
+func (document *Document) ExpandVariables(overrides map[string]string, templates *Templates) error {
+	variables, err := document.Variables(overrides)
+	if err != nil || (len(variables) == 0 && templates == nil) {
+		return err
+	}
+	expand := func(text string) string {
+		return expressionRx.ReplaceAllStringFunc(text, func(reference string) string {
+			if match := variableRx.FindStringSubmatch(reference); match != nil {
+				if value, ok := variables[match[1]]; ok {
+					return value
+				}
+			}
+			if templates == nil {
+				return reference
+			}
+			value, ok, evaluationErr := templates.Evaluate(expressionRx.FindStringSubmatch(reference)[1])
+			if evaluationErr != nil && err == nil {
+				err = evaluationErr
+			}
+			if !ok {
+				return reference
+			}
+			return value
+		})
+	}
+	expandLines := func(lines []string) {
+		for index, line := range lines {
+			lines[index] = expand(line)
+		}
+	}
+	for _, interaction := range document.Interactions() {
+		interaction.Cmd = expand(interaction.Cmd)
+		expandLines(interaction.Response)
+		for _, variant := range interaction.Variants {
+			expandLines(variant.Response)
+		}
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package tokenizer
+
+// This file is part of shelldoc.
+// © 2018, Mirko Boehm <mirko@endocode.com> and the shelldoc contributors
+// SPDX-License-Identifier: LGPL-3.0
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// toolConditionRx matches a condition on the version of a tool, like kubectl>=1.28
+var toolConditionRx = regexp.MustCompile(`^([A-Za-z0-9_.+-]*[A-Za-z0-9_+])\s*(>=|<=|==|!=|>|<|=)\s*v?([0-9]+(?:\.[0-9]+)*)$`)
+
+// Variant is an alternative expected response of a command, which applies if the version of a tool satisfies its
+// condition
+type Variant struct {
+	// Condition contains the condition on the version of the tool, like kubectl>=1.28
+	Condition string `json:"condition" yaml:"condition"`
+	// Response contains the expected response if the condition holds
+	Response []string `json:"response" yaml:"response"`
+}
+
+// Tool returns the name of the tool the condition of the variant refers to
+func (variant Variant) Tool() string {
+	if match := toolConditionRx.FindStringSubmatch(variant.Condition); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Holds returns true if the version satisfies the condition of the variant
+// Versions are compared in the precision of the condition, so 1.28.3 satisfies kubectl==1.28, but not kubectl>1.28.
+// An unknown (empty) version satisfies no condition.
+func (variant Variant) Holds(version string) bool {
+	match := toolConditionRx.FindStringSubmatch(variant.Condition)
+	if match == nil || len(version) == 0 {
+		return false
+	}
+	order := compareVersions(strings.TrimPrefix(version, "v"), match[3])
+	switch match[2] {
+	case ">=":
+		return order >= 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case "<":
+		return order < 0
+	case "!=":
+		return order != 0
+	default:
+		return order == 0
+	}
+}
+
+// SelectVariant replaces the expected response with that of the first variant whose condition holds, and returns the
+// condition, or an empty string if none holds
+// The version function returns the version of a tool, or an empty string if it is unknown.
+func (interaction *Interaction) SelectVariant(version func(tool string) string) string {
+	for _, variant := range interaction.Variants {
+		if variant.Holds(version(variant.Tool())) {
+			interaction.Response = variant.Response
+			interaction.ExpectEmpty = interaction.ExpectEmpty && len(variant.Response) == 0
+			return variant.Condition
+		}
+	}
+	return ""
+}
+
+// compareVersions compares the numeric components of a version with those of a reference version, in the precision
+// of the reference, and returns -1, 0 or 1 like strings.Compare
+func compareVersions(version, reference string) int {
+	components := strings.Split(version, ".")
+	for index, expected := range strings.Split(reference, ".") {
+		var actual int
+		if index < len(components) {
+			actual, _ = strconv.Atoi(components[index])
+		}
+		wanted, _ := strconv.Atoi(expected)
+		if actual < wanted {
+			return -1
+		} else if actual > wanted {
+			return 1
+		}
+	}
+	return 0
+}